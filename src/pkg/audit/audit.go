@@ -0,0 +1,199 @@
+// Package audit records the mutating calls made to the orchestrator and the
+// controllers: who made them, what they were about and how they ended.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+)
+
+const (
+	recordPrefix = "audit:"
+	defaultLimit = 100
+)
+
+type options struct {
+	retention time.Duration
+	publish   func(*settingsv1.AuditRecord)
+}
+
+type Option func(*options)
+
+// WithRetention drops records once they're older than d. Zero keeps them
+// forever.
+func WithRetention(d time.Duration) Option {
+	return func(o *options) {
+		o.retention = d
+	}
+}
+
+// WithPublisher has every record passed to publish once it's stored, e.g.
+// to send it as an audit event.
+func WithPublisher(publish func(*settingsv1.AuditRecord)) Option {
+	return func(o *options) {
+		o.publish = publish
+	}
+}
+
+// FromConfig returns the options cfg asks for, with publish used when it
+// asks for events. A nil cfg keeps records forever and sends no events.
+func FromConfig(cfg *settingsv1.AuditConfig, publish func(*settingsv1.AuditRecord)) []Option {
+	opts := []Option{WithRetention(time.Duration(cfg.GetRetentionDays()) * 24 * time.Hour)}
+	if cfg.GetStreamEvents() {
+		opts = append(opts, WithPublisher(publish))
+	}
+	return opts
+}
+
+// Log is a durable, append-only log of audit records. A nil Log records
+// nothing.
+type Log struct {
+	db   *badger.DB
+	opts options
+
+	mu   sync.Mutex
+	last int64 // UnixNano of the newest ID handed out
+}
+
+// Open opens the log stored in the directory at path, creating it if needed.
+func Open(path string, opts ...Option) (*Log, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	dbOpts := badger.DefaultOptions(filepath.Clean(path))
+	dbOpts.Logger = nil // Disable badger logging
+	db, err := badger.Open(dbOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{db: db, opts: o}, nil
+}
+
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.db.Close()
+}
+
+// nextID returns an ID ordered after every other one of the log, so that
+// records sort by when they were made.
+func (l *Log) nextID(now time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	nanos := max(now.UnixNano(), l.last+1)
+	l.last = nanos
+	return fmt.Sprintf("%019d", nanos)
+}
+
+// Record stores a record of the call to method by the caller in ctx. A
+// record that can't be stored is logged instead, without failing the call.
+func (l *Log) Record(ctx context.Context, method, summary, outcome string, callErr error) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	rec := &settingsv1.AuditRecord{
+		Id:      l.nextID(now),
+		Time:    now.Unix(),
+		Method:  method,
+		Summary: summary,
+		Outcome: outcome,
+	}
+	if id := auth.FromContext(ctx); id != nil {
+		rec.Subject, rec.Email, rec.IssuedBy = id.Subject, id.Email, id.IssuedBy
+	}
+	if callErr != nil {
+		rec.Error = callErr.Error()
+	}
+
+	if err := l.put(rec); err != nil {
+		slog.ErrorContext(ctx, "Failed to store audit record", "method", method, "summary", summary, "outcome", outcome, "error", err)
+		return
+	}
+	if l.opts.publish != nil {
+		l.opts.publish(rec)
+	}
+}
+
+func (l *Log) put(rec *settingsv1.AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	entry := badger.NewEntry([]byte(recordPrefix+rec.Id), data)
+	if l.opts.retention > 0 {
+		entry = entry.WithTTL(l.opts.retention)
+	}
+	return l.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	})
+}
+
+// List returns the records q selects, newest first.
+func (l *Log) List(q *settingsv1.AuditQuery) ([]*settingsv1.AuditRecord, error) {
+	if l == nil {
+		return nil, nil
+	}
+	limit := Limit(q)
+	var result []*settingsv1.AuditRecord
+	err := l.db.View(func(txn *badger.Txn) error {
+		itOpts := badger.DefaultIteratorOptions
+		itOpts.Reverse = true
+		it := txn.NewIterator(itOpts)
+		defer it.Close()
+		prefix := []byte(recordPrefix)
+		for it.Seek(append(prefix, 0xff)); it.ValidForPrefix(prefix) && len(result) < limit; it.Next() {
+			var rec settingsv1.AuditRecord
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &rec)
+			}); err != nil {
+				return err
+			}
+			if q.GetSince() != 0 && rec.Time < q.GetSince() {
+				break
+			}
+			if Matches(q, &rec) {
+				result = append(result, &rec)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Matches reports whether q selects rec, limit aside.
+func Matches(q *settingsv1.AuditQuery, rec *settingsv1.AuditRecord) bool {
+	switch {
+	case q.GetSubject() != "" && rec.GetSubject() != q.GetSubject():
+		return false
+	case q.GetSince() != 0 && rec.GetTime() < q.GetSince():
+		return false
+	case q.GetUntil() != 0 && rec.GetTime() > q.GetUntil():
+		return false
+	default:
+		return strings.Contains(rec.GetMethod(), q.GetMethod())
+	}
+}
+
+// Limit returns how many records q asks for at most.
+func Limit(q *settingsv1.AuditQuery) int {
+	if q.GetLimit() == 0 {
+		return defaultLimit
+	}
+	return int(q.GetLimit())
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func openLog(t *testing.T, opts ...Option) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit"), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+func as(subject string) context.Context {
+	return auth.WithIdentity(context.Background(), &auth.Identity{Subject: subject, Email: subject + "@example.com", IssuedBy: "oidc:test"})
+}
+
+func TestLog(t *testing.T) {
+	var published []*settingsv1.AuditRecord
+	l := openLog(t, WithPublisher(func(r *settingsv1.AuditRecord) { published = append(published, r) }))
+
+	l.Record(as("alice"), "POST /v1/instances", "name=vm1", "200 OK", nil)
+	l.Record(as("bob"), "DELETE /v1/nodes/a/instances/vm1", "", "403 Forbidden", errors.New("permission denied"))
+	l.Record(context.Background(), "/services.controller.v1.ControllerService/Remove", "name=vm1", "OK", nil)
+
+	all, err := l.List(&settingsv1.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, published[2].Id, all[0].Id, "newest first")
+	assert.Empty(t, all[0].Subject)
+	assert.Equal(t, "bob", all[1].Subject)
+	assert.Equal(t, "bob@example.com", all[1].Email)
+	assert.Equal(t, "permission denied", all[1].Error)
+	assert.Equal(t, "name=vm1", all[2].Summary)
+	assert.Less(t, all[2].Id, all[1].Id)
+
+	for _, tc := range []struct {
+		q    *settingsv1.AuditQuery
+		want int
+	}{
+		{&settingsv1.AuditQuery{Subject: "alice"}, 1},
+		{&settingsv1.AuditQuery{Method: "Remove"}, 1},
+		{&settingsv1.AuditQuery{Method: "/v1/"}, 2},
+		{&settingsv1.AuditQuery{Limit: 2}, 2},
+		{&settingsv1.AuditQuery{Since: all[0].Time + 1}, 0},
+		{&settingsv1.AuditQuery{Until: all[0].Time - 1}, 0},
+	} {
+		records, err := l.List(tc.q)
+		require.NoError(t, err)
+		assert.Len(t, records, tc.want, "%v", tc.q)
+	}
+
+	var nilLog *Log
+	nilLog.Record(as("alice"), "POST /v1/instances", "", "200 OK", nil)
+	records, err := nilLog.List(&settingsv1.AuditQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestMiddleware(t *testing.T) {
+	l := openLog(t)
+	var body string
+	handler := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		if strings.Contains(body, "forbidden") {
+			http.Error(w, `{"code":7,"message":"permission denied"}`, http.StatusForbidden)
+		}
+	}))
+	serve := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(as("alice")))
+	}
+
+	create := `{"node":"auto","name":"vm1","spec":{"image":"ubuntu"},"start":true}`
+	serve(http.MethodPost, "/v1/instances", create)
+	assert.Equal(t, create, body, "the handler still gets the whole body")
+	serve(http.MethodPost, "/v1/nodes/a/instances/vm1/move", `{"targetNode":"b","note":"forbidden"}`)
+	serve(http.MethodGet, "/v1/instances", "")
+
+	records, err := l.List(&settingsv1.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "POST /v1/nodes/a/instances/vm1/move", records[0].Method)
+	assert.Equal(t, "target_node=b", records[0].Summary)
+	assert.Equal(t, "403 Forbidden", records[0].Outcome)
+	assert.Equal(t, "permission denied", records[0].Error)
+	assert.Equal(t, "name=vm1 node=auto", records[1].Summary)
+	assert.Equal(t, "200 OK", records[1].Outcome)
+	assert.Equal(t, "alice", records[1].Subject)
+}
+
+func TestServerInterceptors(t *testing.T) {
+	l := openLog(t)
+	unary := UnaryServerInterceptor(l)
+	call := func(method string, req any, err error) {
+		_, _ = unary(as("alice"), req, &grpc.UnaryServerInfo{FullMethod: method}, func(context.Context, any) (any, error) {
+			return nil, err
+		})
+	}
+	call("/services.controller.v1.ControllerService/Remove", &controllerv1.RemoveRequest{Name: "vm1"}, status.Error(codes.NotFound, "no vm1"))
+	call("/services.controller.v1.ControllerService/Info", &controllerv1.InfoRequest{Name: "vm1"}, nil)
+	call("/services.orchestrator.v1.OrchestratorService/Bulk", &orchestratorv1.BulkRequest{
+		LabelSelector: "team=web", Action: orchestratorv1.BulkRequest_ACTION_STOP,
+	}, nil)
+
+	stream := StreamServerInterceptor(l)
+	ss := &fakeStream{ctx: as("bob"), msgs: []*controllerv1.ImportDiskRequest{{Name: "vm2", Chunk: []byte("disk")}, {Chunk: []byte("more")}}}
+	require.NoError(t, stream(nil, ss, &grpc.StreamServerInfo{FullMethod: "/services.controller.v1.ControllerService/ImportDisk"}, func(_ any, s grpc.ServerStream) error {
+		for {
+			if err := s.RecvMsg(&controllerv1.ImportDiskRequest{}); err != nil {
+				return nil
+			}
+		}
+	}))
+
+	records, err := l.List(&settingsv1.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "bob", records[0].Subject)
+	assert.Equal(t, "name=vm2", records[0].Summary)
+	assert.Equal(t, "label_selector=team=web action=ACTION_STOP", records[1].Summary)
+	assert.Equal(t, "/services.controller.v1.ControllerService/Remove", records[2].Method)
+	assert.Equal(t, "NotFound", records[2].Outcome)
+	assert.Equal(t, "rpc error: code = NotFound desc = no vm1", records[2].Error)
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msgs []*controllerv1.ImportDiskRequest
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStream) RecvMsg(m any) error {
+	if len(s.msgs) == 0 {
+		return io.EOF
+	}
+	m.(*controllerv1.ImportDiskRequest).Name = s.msgs[0].Name
+	m.(*controllerv1.ImportDiskRequest).Chunk = s.msgs[0].Chunk
+	s.msgs = s.msgs[1:]
+	return nil
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// readOnlyPrefixes start the names of the methods that change nothing.
+var readOnlyPrefixes = []string{"Get", "List", "Info", "NodeInfo", "Export", "Subscribe"}
+
+// mutating reports whether the gRPC method, e.g.
+// "/services.controller.v1.ControllerService/Remove", changes anything.
+func mutating(fullMethod string) bool {
+	name := fullMethod[strings.LastIndexByte(fullMethod, '/')+1:]
+	for _, prefix := range readOnlyPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// UnaryServerInterceptor records the mutating calls to l. It needs to run
+// after auth.UnaryServerInterceptor to see who made them.
+func UnaryServerInterceptor(l *Log) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if l == nil || !mutating(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		resp, err := handler(ctx, req)
+		l.Record(ctx, info.FullMethod, protoSummary(req), status.Code(err).String(), err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records the mutating streaming calls to l,
+// summarizing them by their first request message.
+func StreamServerInterceptor(l *Log) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if l == nil || !mutating(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		stream := &summarizingStream{ServerStream: ss}
+		err := handler(srv, stream)
+		l.Record(ss.Context(), info.FullMethod, stream.summary, status.Code(err).String(), err)
+		return err
+	}
+}
+
+type summarizingStream struct {
+	grpc.ServerStream
+	received bool
+	summary  string
+}
+
+func (s *summarizingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && !s.received {
+		s.received = true
+		s.summary = protoSummary(m)
+	}
+	return err
+}
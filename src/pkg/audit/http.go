@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxSummarizedBody is the largest request body that's summarized.
+	maxSummarizedBody = 64 << 10
+	// maxErrorBody is how much of an error response is kept.
+	maxErrorBody = 512
+)
+
+// Middleware records the POST, PUT, PATCH and DELETE requests to l. It
+// needs to run after auth.Middleware to see who made them.
+func Middleware(l *Log) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			summary := peekSummary(r)
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if summary == "" && r.MultipartForm != nil {
+				// Set once the handler parsed it, as the image upload does.
+				summary = summarize(func(field string) string {
+					if vs := r.MultipartForm.Value[field]; len(vs) > 0 {
+						return vs[0]
+					}
+					return ""
+				})
+			}
+
+			var callErr error
+			if rec.status >= http.StatusBadRequest {
+				callErr = rec.err()
+			}
+			outcome := fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status))
+			l.Record(r.Context(), r.Method+" "+r.URL.Path, summary, outcome, callErr)
+		})
+	}
+}
+
+// peekSummary summarizes a JSON request body, leaving it to be read again.
+func peekSummary(r *http.Request) string {
+	if r.Body == nil || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return ""
+	}
+	head, err := io.ReadAll(io.LimitReader(r.Body, maxSummarizedBody+1))
+	r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(head), r.Body), Closer: r.Body}
+	if err != nil || len(head) > maxSummarizedBody {
+		return ""
+	}
+	return jsonSummary(head)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// statusRecorder keeps the response's status, and the start of its body if
+// it's an error.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = status, true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	if s.status >= http.StatusBadRequest && s.body.Len() < maxErrorBody {
+		s.body.Write(b[:min(len(b), maxErrorBody-s.body.Len())])
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// err returns the error the response reported: the message of a gateway
+// error, or the start of the body.
+func (s *statusRecorder) err() error {
+	var gatewayErr struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(s.body.Bytes(), &gatewayErr) == nil && gatewayErr.Message != "" {
+		return errors.New(gatewayErr.Message)
+	}
+	if msg := strings.TrimSpace(s.body.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return errors.New(http.StatusText(s.status))
+}
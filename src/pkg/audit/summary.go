@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// summaryFields are the request fields that make it into summaries. Others,
+// like specs or join tokens, are left out: they can be large or secret.
+var summaryFields = []string{"id", "name", "project", "node", "target_node", "label_selector", "action"}
+
+// summarize joins the summary fields get returns a value for, e.g.
+// "name=vm1 node=a".
+func summarize(get func(field string) string) string {
+	var parts []string
+	for _, field := range summaryFields {
+		if v := get(field); v != "" {
+			parts = append(parts, field+"="+v)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// protoSummary summarizes a request message's top-level string and enum
+// fields.
+func protoSummary(m any) string {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return ""
+	}
+	r := msg.ProtoReflect()
+	return summarize(func(field string) string {
+		fd := r.Descriptor().Fields().ByName(protoreflect.Name(field))
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			return ""
+		}
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			return r.Get(fd).String()
+		case protoreflect.EnumKind:
+			if ev := fd.Enum().Values().ByNumber(r.Get(fd).Enum()); ev != nil && ev.Number() != 0 {
+				return string(ev.Name())
+			}
+		}
+		return ""
+	})
+}
+
+// jsonSummary summarizes a JSON request body's top-level string fields,
+// named in either snake_case or lowerCamelCase.
+func jsonSummary(body []byte) string {
+	var fields map[string]any
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	values := map[string]string{}
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			values[snakeCase(k)] = s
+		}
+	}
+	return summarize(func(field string) string { return values[field] })
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
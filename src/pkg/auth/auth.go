@@ -11,6 +11,10 @@ import (
 // request immediately with 401.
 var ErrUnauthenticated = errors.New("unauthenticated")
 
+// ErrForbidden signals a Verifier recognised the caller but won't let them
+// make this request. The request fails with 403.
+var ErrForbidden = errors.New("forbidden")
+
 // Identity is the result of successfully verifying a request.
 type Identity struct {
 	Subject  string   `json:"subject"`
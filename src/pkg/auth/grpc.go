@@ -12,6 +12,7 @@ const (
 	mdEmail    = "x-qctl-user-email"
 	mdName     = "x-qctl-user-name"
 	mdIssuedBy = "x-qctl-user-issued-by"
+	mdGroups   = "x-qctl-user-groups"
 )
 
 // UnaryClientInterceptor attaches the caller's Identity (from context) to
@@ -21,21 +22,35 @@ const (
 // already present (e.g., trace context from another interceptor).
 func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		if id := FromContext(ctx); id != nil && id.Subject != "" && id.IssuedBy != "anonymous" {
-			pairs := []string{
-				mdSubject, id.Subject,
-				mdIssuedBy, id.IssuedBy,
-			}
-			if id.Email != "" {
-				pairs = append(pairs, mdEmail, id.Email)
-			}
-			if id.Name != "" {
-				pairs = append(pairs, mdName, id.Name)
-			}
-			ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
+		return invoker(withOutgoingIdentity(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streaming calls.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withOutgoingIdentity(ctx), desc, cc, method, opts...)
+	}
+}
+
+func withOutgoingIdentity(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != nil && id.Subject != "" && id.IssuedBy != "anonymous" {
+		pairs := []string{
+			mdSubject, id.Subject,
+			mdIssuedBy, id.IssuedBy,
+		}
+		if id.Email != "" {
+			pairs = append(pairs, mdEmail, id.Email)
+		}
+		if id.Name != "" {
+			pairs = append(pairs, mdName, id.Name)
+		}
+		for _, group := range id.Groups {
+			pairs = append(pairs, mdGroups, group)
 		}
-		return invoker(ctx, method, req, reply, cc, opts...)
+		ctx = metadata.AppendToOutgoingContext(ctx, pairs...)
 	}
+	return ctx
 }
 
 // UnaryServerInterceptor reads the caller's Identity from incoming gRPC
@@ -43,18 +58,37 @@ func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 // and structured slog calls automatically pick up `user`/`issuedBy` attrs.
 func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		if md, ok := metadata.FromIncomingContext(ctx); ok {
-			if sub := mdFirst(md, mdSubject); sub != "" {
-				ctx = WithIdentity(ctx, &Identity{
-					Subject:  sub,
-					Email:    mdFirst(md, mdEmail),
-					Name:     mdFirst(md, mdName),
-					IssuedBy: mdFirst(md, mdIssuedBy),
-				})
-			}
+		return handler(withIncomingIdentity(ctx), req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &identityStream{ServerStream: ss, ctx: withIncomingIdentity(ss.Context())})
+	}
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context { return s.ctx }
+
+func withIncomingIdentity(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if sub := mdFirst(md, mdSubject); sub != "" {
+			ctx = WithIdentity(ctx, &Identity{
+				Subject:  sub,
+				Email:    mdFirst(md, mdEmail),
+				Name:     mdFirst(md, mdName),
+				IssuedBy: mdFirst(md, mdIssuedBy),
+				Groups:   md.Get(mdGroups),
+			})
 		}
-		return handler(ctx, req)
 	}
+	return ctx
 }
 
 func mdFirst(md metadata.MD, key string) string {
@@ -2,11 +2,15 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/url"
+	"slices"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	authv1 "github.com/q-controller/qcontroller/src/generated/services/auth/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -18,14 +22,16 @@ import (
 // AuthServer implements services.auth.v1.AuthServiceServer over the
 // OIDCVerifier. It is registered on the same grpc-gateway runtime mux as
 // OrchestratorService so /auth/config, /auth/me, /auth/logout flow through
-// the same JSON contract pipeline as the rest of the API.
+// the same JSON contract pipeline as the rest of the API. The personal API
+// tokens are managed over the TokenVerifier.
 type AuthServer struct {
 	authv1.UnimplementedAuthServiceServer
-	v *OIDCVerifier // nil when auth is disabled
+	v      *OIDCVerifier  // nil when auth is disabled
+	tokens *TokenVerifier // nil when auth is disabled
 }
 
-func NewAuthServer(v *OIDCVerifier) *AuthServer {
-	return &AuthServer{v: v}
+func NewAuthServer(v *OIDCVerifier, tokens *TokenVerifier) *AuthServer {
+	return &AuthServer{v: v, tokens: tokens}
 }
 
 func (s *AuthServer) GetConfig(_ context.Context, _ *emptypb.Empty) (*authv1.GetConfigResponse, error) {
@@ -106,6 +112,68 @@ func (s *AuthServer) Logout(ctx context.Context, _ *emptypb.Empty) (*authv1.Logo
 	return resp, nil
 }
 
+// tokenOwner returns the caller that may manage their API tokens.
+func (s *AuthServer) tokenOwner(ctx context.Context) (*Identity, error) {
+	if s.tokens == nil {
+		return nil, status.Error(codes.FailedPrecondition, "api tokens need auth to be enabled")
+	}
+	id := FromContext(ctx)
+	if id == nil || id.IssuedBy == "anonymous" {
+		return nil, status.Error(codes.Unauthenticated, "identity missing from context")
+	}
+	return id, nil
+}
+
+func (s *AuthServer) CreateToken(ctx context.Context, req *authv1.CreateTokenRequest) (*authv1.CreateTokenResponse, error) {
+	id, err := s.tokenOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if isTokenIdentity(id) {
+		return nil, status.Error(codes.PermissionDenied, "api tokens can't create other tokens")
+	}
+	if len(req.Scopes) == 0 || slices.Contains(req.Scopes, settingsv1.ApiToken_SCOPE_UNSPECIFIED) {
+		return nil, status.Error(codes.InvalidArgument, "at least one scope is required, and none may be unspecified")
+	}
+	if req.TtlSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl_seconds can't be negative")
+	}
+	if req.TtlSeconds > int64(maxTokenTTL/time.Second) {
+		return nil, status.Errorf(codes.InvalidArgument, "ttl_seconds can't be more than %d", int64(maxTokenTTL/time.Second))
+	}
+	token, apiToken, createErr := s.tokens.Create(id, req.Description, req.Scopes, time.Duration(req.TtlSeconds)*time.Second)
+	if createErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create api token: %v", createErr)
+	}
+	return &authv1.CreateTokenResponse{Token: token, ApiToken: apiToken}, nil
+}
+
+func (s *AuthServer) ListTokens(ctx context.Context, _ *emptypb.Empty) (*authv1.ListTokensResponse, error) {
+	id, err := s.tokenOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tokens, listErr := s.tokens.List(id.Subject)
+	if listErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list api tokens: %v", listErr)
+	}
+	return &authv1.ListTokensResponse{Tokens: tokens}, nil
+}
+
+func (s *AuthServer) RevokeToken(ctx context.Context, req *authv1.RevokeTokenRequest) (*emptypb.Empty, error) {
+	id, err := s.tokenOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if revokeErr := s.tokens.Revoke(id.Subject, req.Id); revokeErr != nil {
+		if errors.Is(revokeErr, errTokenNotFound) {
+			return nil, status.Errorf(codes.NotFound, "api token %q not found", req.Id)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to revoke api token: %v", revokeErr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
 // incomingCookies returns the raw Cookie header values from an inbound
 // grpc-gateway request. The gateway forwards the HTTP Cookie header as
 // metadata under the "grpcgateway-cookie" key.
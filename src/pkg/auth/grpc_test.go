@@ -26,6 +26,7 @@ func TestUnaryClientInterceptor_AddsMetadataWhenIdentityPresent(t *testing.T) {
 		Subject:  "alice-uuid",
 		Email:    "alice@example.com",
 		Name:     "Alice",
+		Groups:   []string{"dev", "ops"},
 		IssuedBy: "oidc:keycloak",
 	})
 
@@ -38,6 +39,7 @@ func TestUnaryClientInterceptor_AddsMetadataWhenIdentityPresent(t *testing.T) {
 	assert.Equal(t, []string{"oidc:keycloak"}, md.Get(mdIssuedBy))
 	assert.Equal(t, []string{"alice@example.com"}, md.Get(mdEmail))
 	assert.Equal(t, []string{"Alice"}, md.Get(mdName))
+	assert.Equal(t, []string{"dev", "ops"}, md.Get(mdGroups))
 }
 
 func TestUnaryClientInterceptor_OmitsEmptyOptionalFields(t *testing.T) {
@@ -100,6 +102,7 @@ func TestUnaryServerInterceptor_ExtractsIdentity(t *testing.T) {
 	md.Set(mdIssuedBy, "oidc:keycloak")
 	md.Set(mdEmail, "bob@example.com")
 	md.Set(mdName, "Bob")
+	md.Append(mdGroups, "dev", "ops")
 	ctx := metadata.NewIncomingContext(context.Background(), md)
 
 	handler := func(ctx context.Context, _ any) (any, error) {
@@ -114,6 +117,7 @@ func TestUnaryServerInterceptor_ExtractsIdentity(t *testing.T) {
 	assert.Equal(t, "oidc:keycloak", id.IssuedBy)
 	assert.Equal(t, "bob@example.com", id.Email)
 	assert.Equal(t, "Bob", id.Name)
+	assert.Equal(t, []string{"dev", "ops"}, id.Groups)
 }
 
 func TestUnaryServerInterceptor_NoMetadataLeavesIdentityNil(t *testing.T) {
@@ -175,3 +179,35 @@ func TestClientServerInterceptors_RoundTrip(t *testing.T) {
 	assert.Equal(t, "alice@example.com", id.Email)
 	assert.Equal(t, "Alice", id.Name)
 }
+
+func TestStreamInterceptors_RoundTrip(t *testing.T) {
+	clientCtx := WithIdentity(context.Background(), &Identity{Subject: "alice-uuid", IssuedBy: "oidc:keycloak"})
+	var fwd context.Context
+	streamer := func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, _ string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+		fwd = ctx
+		return nil, nil
+	}
+	_, err := StreamClientInterceptor()(clientCtx, &grpc.StreamDesc{}, nil, "/x", streamer)
+	require.NoError(t, err)
+
+	md, ok := metadata.FromOutgoingContext(fwd)
+	require.True(t, ok)
+	ss := &fakeServerStream{ctx: metadata.NewIncomingContext(context.Background(), md)}
+
+	var got *Identity
+	handler := func(_ any, stream grpc.ServerStream) error {
+		got = FromContext(stream.Context())
+		return nil
+	}
+	require.NoError(t, StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{}, handler))
+	require.NotNil(t, got)
+	assert.Equal(t, "alice-uuid", got.Subject)
+	assert.Equal(t, "oidc:keycloak", got.IssuedBy)
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
@@ -10,7 +10,8 @@ import (
 // Middleware tries each verifier in order and attaches the first identity
 // that matches to the request context. If no verifier has an opinion and
 // auth is disabled (no verifiers configured), requests pass as anonymous.
-// Otherwise unauthenticated requests get 401.
+// Otherwise unauthenticated requests get 401, and those a verifier refuses
+// with ErrForbidden 403.
 //
 // Paths in exemptPrefixes skip verification entirely — used for /auth/*
 // (login flow can't require auth) and /ui/* (static assets, the SPA itself
@@ -36,6 +37,10 @@ func Middleware(verifiers []Verifier, exemptPrefixes ...string) func(http.Handle
 					next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
 					return
 				}
+				if errors.Is(err, ErrForbidden) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 				if !errors.Is(err, ErrUnauthenticated) {
 					slog.WarnContext(r.Context(), "auth verifier error", "error", err)
 					http.Error(w, "unauthenticated", http.StatusUnauthorized)
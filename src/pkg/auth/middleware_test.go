@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -86,6 +87,20 @@ func TestMiddleware_HardErrorReturns401AndStopsChain(t *testing.T) {
 	assert.Nil(t, got, "second verifier must not run after a hard error")
 }
 
+func TestMiddleware_ForbiddenReturns403(t *testing.T) {
+	first := stubVerifier{err: fmt.Errorf("%w: read-only token", ErrForbidden)}
+
+	var got *Identity
+	handler := Middleware([]Verifier{first})(capture(&got))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/x", nil))
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "read-only token")
+	assert.Nil(t, got)
+}
+
 func TestMiddleware_ExemptPathPassesThroughWithoutIdentity(t *testing.T) {
 	v := stubVerifier{err: ErrUnauthenticated}
 
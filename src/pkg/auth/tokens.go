@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// TokenPrefix starts every personal API token, so TokenVerifier can tell
+// them from the bearer JWTs of the OIDC issuers.
+const TokenPrefix = "qct_"
+
+const (
+	defaultTokenTTL = 30 * 24 * time.Hour
+	// maxTokenTTL bounds how long a token keeps the groups its owner had
+	// when creating it, as they aren't looked up again.
+	maxTokenTTL = 90 * 24 * time.Hour
+	// lastUsedInterval is how stale a token's last use may get before it's
+	// stored again, so that not every request writes to the store.
+	lastUsedInterval  = time.Minute
+	tokenIssuerPrefix = "token:"
+)
+
+var (
+	errInvalidToken  = errors.New("invalid api token")
+	errTokenNotFound = errors.New("api token not found")
+)
+
+// TokenStore keeps personal API tokens by their ID.
+type TokenStore interface {
+	PutAPIToken(token *settingsv1.ApiToken) error
+	GetAPIToken(id string) (*settingsv1.ApiToken, error)
+	ListAPITokens() ([]*settingsv1.ApiToken, error)
+	RemoveAPIToken(id string) error
+}
+
+// TokenVerifier authenticates requests bearing a personal API token as the
+// user who created it. Bearer tokens without TokenPrefix are left to the
+// other verifiers, so it goes before OIDCVerifier in the chain.
+type TokenVerifier struct {
+	store TokenStore
+	now   func() time.Time
+	// mu serializes the writes to the store, so that recording a token's
+	// use can't bring back a revoked one.
+	mu sync.Mutex
+}
+
+func NewTokenVerifier(store TokenStore) *TokenVerifier {
+	return &TokenVerifier{store: store, now: time.Now}
+}
+
+func hashToken(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isTokenIdentity reports whether id was authenticated with an API token.
+func isTokenIdentity(id *Identity) bool {
+	return strings.HasPrefix(id.IssuedBy, tokenIssuerPrefix)
+}
+
+// allows reports whether scopes permit a request with method.
+func allows(scopes []settingsv1.ApiToken_Scope, method string) bool {
+	if slices.Contains(scopes, settingsv1.ApiToken_SCOPE_WRITE) {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return slices.Contains(scopes, settingsv1.ApiToken_SCOPE_READ)
+	default:
+		return false
+	}
+}
+
+func (v *TokenVerifier) Verify(r *http.Request) (*Identity, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "+TokenPrefix)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	id, secret, ok := strings.Cut(raw, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, errInvalidToken
+	}
+	token, err := v.store.GetAPIToken(id)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Failed to look up api token", "id", id, "error", err)
+		return nil, errInvalidToken
+	}
+	if subtle.ConstantTimeCompare(hashToken(secret), token.SecretHash) != 1 {
+		return nil, errInvalidToken
+	}
+	now := v.now()
+	if now.Unix() >= token.ExpiresAt {
+		return nil, fmt.Errorf("api token %s expired", id)
+	}
+	if !allows(token.Scopes, r.Method) {
+		return nil, fmt.Errorf("%w: api token %s may not make %s requests", ErrForbidden, id, r.Method)
+	}
+	if now.Sub(time.Unix(token.LastUsedAt, 0)) >= lastUsedInterval {
+		v.touch(r.Context(), id, now)
+	}
+	return &Identity{
+		Subject:  token.Subject,
+		Email:    token.Email,
+		Name:     token.Name,
+		Groups:   token.Groups,
+		IssuedBy: tokenIssuerPrefix + id,
+	}, nil
+}
+
+// touch records the token was used at now. Failing to doesn't fail the
+// request.
+func (v *TokenVerifier) touch(ctx context.Context, id string, now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	token, err := v.store.GetAPIToken(id)
+	if err != nil {
+		return // revoked meanwhile
+	}
+	token.LastUsedAt = now.Unix()
+	if err := v.store.PutAPIToken(token); err != nil {
+		slog.WarnContext(ctx, "Failed to record api token use", "id", id, "error", err)
+	}
+}
+
+// Create issues a token authenticating as owner, with owner's current
+// groups. It returns the token to present, which isn't stored, and the
+// stored token without its hash.
+func (v *TokenVerifier) Create(owner *Identity, description string, scopes []settingsv1.ApiToken_Scope, ttl time.Duration) (string, *settingsv1.ApiToken, error) {
+	if ttl == 0 {
+		ttl = defaultTokenTTL
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	now := v.now()
+	token := &settingsv1.ApiToken{
+		Id:          id,
+		SecretHash:  hashToken(secret),
+		Description: description,
+		Subject:     owner.Subject,
+		Email:       owner.Email,
+		Name:        owner.Name,
+		Groups:      owner.Groups,
+		Scopes:      scopes,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(ttl).Unix(),
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.store.PutAPIToken(token); err != nil {
+		return "", nil, err
+	}
+	listed := proto.CloneOf(token)
+	listed.SecretHash = nil
+	return TokenPrefix + id + "." + secret, listed, nil
+}
+
+// List returns subject's tokens, oldest first and without their hashes,
+// dropping expired ones.
+func (v *TokenVerifier) List(subject string) ([]*settingsv1.ApiToken, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	tokens, err := v.store.ListAPITokens()
+	if err != nil {
+		return nil, err
+	}
+	now := v.now().Unix()
+	var out []*settingsv1.ApiToken
+	for _, token := range tokens {
+		if token.ExpiresAt <= now {
+			if rmErr := v.store.RemoveAPIToken(token.Id); rmErr != nil {
+				slog.Warn("Failed to remove expired api token", "id", token.Id, "error", rmErr)
+			}
+			continue
+		}
+		if token.Subject != subject {
+			continue
+		}
+		token.SecretHash = nil
+		out = append(out, token)
+	}
+	slices.SortFunc(out, func(a, b *settingsv1.ApiToken) int {
+		return cmp.Compare(a.CreatedAt, b.CreatedAt)
+	})
+	return out, nil
+}
+
+// Revoke removes subject's token with the ID.
+func (v *TokenVerifier) Revoke(subject, id string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	token, err := v.store.GetAPIToken(id)
+	if err != nil || token.Subject != subject {
+		return errTokenNotFound
+	}
+	return v.store.RemoveAPIToken(id)
+}
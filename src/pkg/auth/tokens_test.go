@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authv1 "github.com/q-controller/qcontroller/src/generated/services/auth/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// memTokenStore is an in-memory TokenStore.
+type memTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*settingsv1.ApiToken
+	puts   int
+}
+
+func newMemTokenStore() *memTokenStore {
+	return &memTokenStore{tokens: map[string]*settingsv1.ApiToken{}}
+}
+
+func (s *memTokenStore) PutAPIToken(token *settingsv1.ApiToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.Id] = proto.CloneOf(token)
+	s.puts++
+	return nil
+}
+
+func (s *memTokenStore) GetAPIToken(id string) (*settingsv1.ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return proto.CloneOf(token), nil
+}
+
+func (s *memTokenStore) ListAPITokens() ([]*settingsv1.ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*settingsv1.ApiToken
+	for _, token := range s.tokens {
+		out = append(out, proto.CloneOf(token))
+	}
+	return out, nil
+}
+
+func (s *memTokenStore) RemoveAPIToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, id)
+	return nil
+}
+
+func bearer(method, token string) *http.Request {
+	r := httptest.NewRequest(method, "/v1/instances", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+var alice = &Identity{Subject: "alice", Email: "alice@example.com", Groups: []string{"ops"}, IssuedBy: "oidc:test"}
+
+func TestTokenVerifier_Verify(t *testing.T) {
+	store := newMemTokenStore()
+	v := NewTokenVerifier(store)
+	now := time.Unix(1_700_000_000, 0)
+	v.now = func() time.Time { return now }
+
+	readOnly, created, err := v.Create(alice, "ci", []settingsv1.ApiToken_Scope{settingsv1.ApiToken_SCOPE_READ}, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, created.SecretHash)
+	_, secret, _ := strings.Cut(readOnly, ".")
+	assert.Equal(t, hashToken(secret), store.tokens[created.Id].SecretHash, "only the hash is stored")
+
+	id, err := v.Verify(bearer(http.MethodGet, readOnly))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", id.Subject)
+	assert.Equal(t, []string{"ops"}, id.Groups)
+	assert.Equal(t, "token:"+created.Id, id.IssuedBy)
+	assert.Equal(t, now.Unix(), store.tokens[created.Id].LastUsedAt)
+
+	_, err = v.Verify(bearer(http.MethodPost, readOnly))
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	// Uses within lastUsedInterval aren't stored again.
+	puts := store.puts
+	now = now.Add(30 * time.Second)
+	_, err = v.Verify(bearer(http.MethodGet, readOnly))
+	require.NoError(t, err)
+	assert.Equal(t, puts, store.puts)
+
+	_, err = v.Verify(bearer(http.MethodGet, readOnly+"x"))
+	assert.ErrorIs(t, err, errInvalidToken)
+	_, err = v.Verify(bearer(http.MethodGet, TokenPrefix+"nope.secret"))
+	assert.ErrorIs(t, err, errInvalidToken)
+	_, err = v.Verify(bearer(http.MethodGet, "eyJhbGciOi.jwt.sig"))
+	assert.ErrorIs(t, err, ErrUnauthenticated, "other bearer tokens are left to the next verifier")
+
+	now = now.Add(time.Hour)
+	_, err = v.Verify(bearer(http.MethodGet, readOnly))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnauthenticated)
+
+	writable, _, err := v.Create(alice, "deploy", []settingsv1.ApiToken_Scope{settingsv1.ApiToken_SCOPE_WRITE}, 0)
+	require.NoError(t, err)
+	_, err = v.Verify(bearer(http.MethodDelete, writable))
+	assert.NoError(t, err)
+}
+
+func TestTokenVerifier_ListAndRevoke(t *testing.T) {
+	store := newMemTokenStore()
+	v := NewTokenVerifier(store)
+	now := time.Unix(1_700_000_000, 0)
+	v.now = func() time.Time { return now }
+
+	read := []settingsv1.ApiToken_Scope{settingsv1.ApiToken_SCOPE_READ}
+	_, short, err := v.Create(alice, "short", read, time.Minute)
+	require.NoError(t, err)
+	now = now.Add(time.Second)
+	token, long, err := v.Create(alice, "long", read, 0)
+	require.NoError(t, err)
+	_, _, err = v.Create(&Identity{Subject: "bob"}, "bob's", read, 0)
+	require.NoError(t, err)
+
+	tokens, err := v.List("alice")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, short.Id, tokens[0].Id)
+	assert.Nil(t, tokens[1].SecretHash)
+
+	now = now.Add(time.Minute)
+	tokens, err = v.List("alice")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, long.Id, tokens[0].Id)
+	assert.NotContains(t, store.tokens, short.Id, "expired tokens are removed")
+
+	assert.ErrorIs(t, v.Revoke("bob", long.Id), errTokenNotFound)
+	require.NoError(t, v.Revoke("alice", long.Id))
+	_, err = v.Verify(bearer(http.MethodGet, token))
+	assert.ErrorIs(t, err, errInvalidToken)
+}
+
+func TestAuthServer_Tokens(t *testing.T) {
+	s := NewAuthServer(nil, NewTokenVerifier(newMemTokenStore()))
+	ctx := WithIdentity(context.Background(), alice)
+	read := []settingsv1.ApiToken_Scope{settingsv1.ApiToken_SCOPE_READ}
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		req  *authv1.CreateTokenRequest
+		code codes.Code
+	}{
+		{context.Background(), &authv1.CreateTokenRequest{Scopes: read}, codes.Unauthenticated},
+		{WithIdentity(context.Background(), Anonymous()), &authv1.CreateTokenRequest{Scopes: read}, codes.Unauthenticated},
+		{ctx, &authv1.CreateTokenRequest{}, codes.InvalidArgument},
+		{ctx, &authv1.CreateTokenRequest{Scopes: []settingsv1.ApiToken_Scope{settingsv1.ApiToken_SCOPE_UNSPECIFIED}}, codes.InvalidArgument},
+		{ctx, &authv1.CreateTokenRequest{Scopes: read, TtlSeconds: -1}, codes.InvalidArgument},
+		{ctx, &authv1.CreateTokenRequest{Scopes: read, TtlSeconds: int64(maxTokenTTL/time.Second) + 1}, codes.InvalidArgument},
+		{WithIdentity(context.Background(), &Identity{Subject: "alice", IssuedBy: "token:abc"}), &authv1.CreateTokenRequest{Scopes: read}, codes.PermissionDenied},
+	} {
+		_, err := s.CreateToken(tc.ctx, tc.req)
+		assert.Equal(t, tc.code, status.Code(err), "%v", tc.req)
+	}
+
+	resp, err := s.CreateToken(ctx, &authv1.CreateTokenRequest{Description: "ci", Scopes: read})
+	require.NoError(t, err)
+	assert.Contains(t, resp.Token, TokenPrefix+resp.ApiToken.Id+".")
+	assert.Equal(t, int64(defaultTokenTTL/time.Second), resp.ApiToken.ExpiresAt-resp.ApiToken.CreatedAt)
+
+	list, err := s.ListTokens(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, list.Tokens, 1)
+	assert.Equal(t, "ci", list.Tokens[0].Description)
+
+	_, err = s.RevokeToken(ctx, &authv1.RevokeTokenRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.RevokeToken(ctx, &authv1.RevokeTokenRequest{Id: resp.ApiToken.Id})
+	require.NoError(t, err)
+
+	_, err = NewAuthServer(nil, nil).ListTokens(ctx, &emptypb.Empty{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
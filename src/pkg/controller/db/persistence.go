@@ -13,6 +13,7 @@ import (
 
 var ErrNoInstanceRemoved = errors.New("no instance was removed")
 var ErrConstraint = errors.New("constraint violation")
+var ErrHwaddrNotUnique = fmt.Errorf("%w: hwaddr not unique", ErrConstraint)
 
 const (
 	instancePrefix = "instance:"
@@ -93,7 +94,7 @@ func (d *databaseImpl) Update(instance *vmv1.Instance) (*vmv1.Instance, error) {
 				return err
 			}
 			if existing != instance.Id {
-				return fmt.Errorf("%w: hwaddr not unique", ErrConstraint)
+				return ErrHwaddrNotUnique
 			}
 		} else if !errors.Is(err, badger.ErrKeyNotFound) {
 			return err
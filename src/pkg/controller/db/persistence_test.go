@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -127,8 +128,8 @@ func TestUpdate_HwaddrUniqueness(t *testing.T) {
 		t.Fatalf("Update failed: %v", err)
 	}
 	_, err := db.Update(inst2)
-	if err == nil {
-		t.Error("expected error for duplicate hwaddr")
+	if !errors.Is(err, ErrHwaddrNotUnique) {
+		t.Errorf("expected ErrHwaddrNotUnique for duplicate hwaddr, got %v", err)
 	}
 }
 
@@ -2,23 +2,35 @@ package vm
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"slices"
 
 	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
 	processv1 "github.com/q-controller/qcontroller/src/generated/services/process/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
-	runtimev1 "github.com/q-controller/qcontroller/src/generated/vm/runtime/v1"
 	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
 	"github.com/q-controller/qcontroller/src/pkg/controller"
 	"github.com/q-controller/qcontroller/src/pkg/controller/db"
 	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
 	"github.com/q-controller/qcontroller/src/pkg/node"
 	"github.com/q-controller/qemu-client/pkg/utils"
 	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
+// ErrNotStopped is returned by operations that need a stopped instance.
+var ErrNotStopped = errors.New("not stopped")
+
+// ErrNotFound is returned for instances the node doesn't have.
+var ErrNotFound = errors.New("not found")
+
 // localNodeManager implements NodeManager for the local node.
 // It wraps a QemuService gRPC client for VM operations and a local DB for state persistence.
 type localNodeManager struct {
@@ -56,35 +68,113 @@ func (n *localNodeManager) qemuList(ctx context.Context) ([]string, error) {
 	return resp.Ids, nil
 }
 
-func (n *localNodeManager) Create(ctx context.Context, id, imageID string,
-	cpus, memory, disk uint32, cloudInit *vmv1.CloudInit) error {
+// instanceKey returns the key the node stores the instance called name in
+// project under. Names in the default project are their own key; names
+// can't contain '/', so other projects' keys can't collide with them or
+// each other.
+func instanceKey(project, name string) string {
+	if project == "" {
+		return name
+	}
+	return project + "/" + name
+}
+
+// newProcessID returns the id the QEMU service knows the instance stored
+// under key by. It names the instance's network interface, which Linux
+// limits to 15 bytes, so it's a prefix of a hash of the key rather than the
+// key itself.
+func newProcessID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "qc" + hex.EncodeToString(sum[:])[:13]
+}
+
+// processID returns the id the QEMU service knows inst by.
+func processID(inst *vmv1.Instance) string {
+	if inst.ProcessId != "" {
+		return inst.ProcessId
+	}
+	return inst.Id
+}
+
+// instanceName returns the name of inst within its project.
+func instanceName(inst *vmv1.Instance) string {
+	if inst.Name != "" {
+		return inst.Name
+	}
+	return inst.Id
+}
+
+// get returns the instance called name in project.
+func (n *localNodeManager) get(project, name string) (*vmv1.Instance, error) {
+	inst, err := n.state.Get(instanceKey(project, name))
+	if err != nil {
+		return nil, err
+	}
+	// Another project's instance, or a default one, may have the key.
+	if inst.Project != project || instanceName(inst) != name {
+		return nil, ErrNotFound
+	}
+	return inst, nil
+}
+
+func (n *localNodeManager) Create(ctx context.Context, name string, spec *controllerv1.VMSpec) error {
+	if err := node.ValidateName(name); err != nil {
+		return err
+	}
+	if spec.GetProject() != "" {
+		if err := node.ValidateName(spec.GetProject()); err != nil {
+			return err
+		}
+	}
+	id := instanceKey(spec.GetProject(), name)
 	if _, err := n.state.Get(id); err == nil {
-		return fmt.Errorf("instance %s already exists", id)
+		return fmt.Errorf("instance %s already exists", name)
 	}
 
-	hwaddr, hwaddrErr := utils.GenerateRandomMAC()
-	if hwaddrErr != nil {
-		return hwaddrErr
+	hwaddr := spec.GetMacAddress()
+	if hwaddr == "" {
+		generated, hwaddrErr := utils.GenerateRandomMAC()
+		if hwaddrErr != nil {
+			return hwaddrErr
+		}
+		hwaddr = generated
+	}
+	// Instances are owned by the caller, whatever the spec claims; the
+	// orchestrator forwards the identity of whoever it creates them for.
+	owner := auth.FromContext(ctx)
+	if owner == nil {
+		owner = auth.Anonymous()
 	}
 
 	_, err := n.state.Update(&vmv1.Instance{
 		Hardware: &settingsv1.VM{
-			Cpus:   cpus,
-			Memory: memory,
-			Disk:   disk,
+			Cpus:   spec.GetVm().GetCpus(),
+			Memory: spec.GetVm().GetMemory(),
+			Disk:   spec.GetVm().GetDisk(),
 		},
-		ImageId:   imageID,
-		Id:        id,
-		Hwaddr:    &hwaddr,
-		State:     vmv1.State_STATE_STOPPED,
-		Cloudinit: cloudInit,
-		Node:      n.name,
+		ImageId:        spec.GetImage(),
+		Id:             id,
+		ProcessId:      newProcessID(id),
+		Name:           name,
+		Hwaddr:         &hwaddr,
+		State:          vmv1.State_STATE_STOPPED,
+		Cloudinit:      spec.GetCloudInit(),
+		Node:           n.name,
+		NetworkLimits:  spec.GetNetworkLimits(),
+		VlanId:         spec.GetVlanId(),
+		OverlayNetwork: spec.GetOverlayNetwork(),
+		Labels:         spec.GetLabels(),
+		Annotations:    spec.GetAnnotations(),
+		Placement:      spec.GetPlacement(),
+		Owner:          owner.Subject,
+		OwnerGroups:    owner.Groups,
+		Project:        spec.GetProject(),
 	})
 	return err
 }
 
-func (n *localNodeManager) Start(ctx context.Context, name string) error {
-	inst, err := n.state.Get(name)
+func (n *localNodeManager) Start(ctx context.Context, project, name string) error {
+	inst, err := n.get(project, name)
 	if err != nil {
 		return fmt.Errorf("instance %s not found: %w", name, err)
 	}
@@ -101,7 +191,7 @@ func (n *localNodeManager) Start(ctx context.Context, name string) error {
 			cloudInit = &vmv1.CloudInit{}
 		}
 		cloudInit.NetworkConfig = fmt.Sprintf(
-			"version: 2\nethernets:\n  id0:\n    match:\n      macaddress: %s\n    dhcp4: true\n    dhcp-identifier: mac\n",
+			"version: 2\nethernets:\n  id0:\n    match:\n      macaddress: %s\n    dhcp4: true\n    dhcp-identifier: mac\n    accept-ra: true\n    ipv6-address-generation: eui64\n",
 			*inst.Hwaddr,
 		)
 	}
@@ -117,7 +207,7 @@ func (n *localNodeManager) Start(ctx context.Context, name string) error {
 
 	if _, startErr := processv1.NewQemuServiceClient(conn).Start(ctx, &processv1.StartRequest{
 		Config: &processv1.QemuConfig{
-			Id:      inst.Id,
+			Id:      processID(inst),
 			ImageId: inst.ImageId,
 			Hardware: &settingsv1.VM{
 				Cpus:   inst.Hardware.Cpus,
@@ -125,9 +215,12 @@ func (n *localNodeManager) Start(ctx context.Context, name string) error {
 				Disk:   inst.Hardware.Disk,
 			},
 			Network: &processv1.NetworkConfig{
-				Mac: *inst.Hwaddr,
+				Mac:            *inst.Hwaddr,
+				VlanId:         inst.VlanId,
+				OverlayNetwork: inst.OverlayNetwork,
 			},
-			CloudInit: cloudInit,
+			CloudInit:     cloudInit,
+			NetworkLimits: inst.NetworkLimits,
 		},
 	}); startErr != nil {
 		n.setInstanceState(inst.Id, vmv1.State_STATE_STOPPED)
@@ -138,18 +231,22 @@ func (n *localNodeManager) Start(ctx context.Context, name string) error {
 	return nil
 }
 
-func (n *localNodeManager) Stop(ctx context.Context, name string, force bool) error {
+func (n *localNodeManager) Stop(ctx context.Context, project, name string, force bool) error {
+	inst, err := n.get(project, name)
+	if err != nil {
+		return fmt.Errorf("instance %s not found: %w", name, err)
+	}
 	conn, err := n.dial()
 	if err != nil {
 		return err
 	}
 	defer func() { _ = conn.Close() }()
-	_, err = processv1.NewQemuServiceClient(conn).Stop(ctx, &processv1.StopRequest{Id: name, Force: force})
+	_, err = processv1.NewQemuServiceClient(conn).Stop(ctx, &processv1.StopRequest{Id: processID(inst), Force: force})
 	return err
 }
 
-func (n *localNodeManager) Remove(ctx context.Context, name string) error {
-	inst, getErr := n.state.Get(name)
+func (n *localNodeManager) Remove(ctx context.Context, project, name string) error {
+	inst, getErr := n.get(project, name)
 	if getErr != nil {
 		return nil // already gone
 	}
@@ -158,7 +255,7 @@ func (n *localNodeManager) Remove(ctx context.Context, name string) error {
 		return fmt.Errorf("instance %s is not stopped", name)
 	}
 
-	if err := n.state.Remove(name); err != nil {
+	if err := n.state.Remove(inst.Id); err != nil {
 		if errors.Is(err, db.ErrNoInstanceRemoved) {
 			return nil
 		}
@@ -168,57 +265,264 @@ func (n *localNodeManager) Remove(ctx context.Context, name string) error {
 	// Clean up instance directory on QEMU side
 	conn, dialErr := n.dial()
 	if dialErr != nil {
-		slog.Warn("Failed to connect to QEMU for cleanup", "id", name, "error", dialErr)
+		slog.Warn("Failed to connect to QEMU for cleanup", "id", inst.Id, "error", dialErr)
 		return nil
 	}
 	defer func() { _ = conn.Close() }()
-	if _, removeErr := processv1.NewQemuServiceClient(conn).Remove(ctx, &processv1.RemoveRequest{Id: name}); removeErr != nil {
-		slog.Warn("Failed to remove instance from QEMU", "id", name, "error", removeErr)
+	if _, removeErr := processv1.NewQemuServiceClient(conn).Remove(ctx, &processv1.RemoveRequest{Id: processID(inst)}); removeErr != nil {
+		slog.Warn("Failed to remove instance from QEMU", "id", inst.Id, "error", removeErr)
 	}
 
 	return nil
 }
 
-func (n *localNodeManager) Info(ctx context.Context, name string) ([]*controllerv1.Info, error) {
+func (n *localNodeManager) Info(ctx context.Context, project, name string) ([]*controllerv1.Info, error) {
 	var instances []*vmv1.Instance
 	if name == "" {
 		listed, err := n.state.List()
 		if err != nil {
 			return nil, err
 		}
-		instances = listed
 
 		// Reconcile state with QEMU
-		n.reconcileInstances(ctx, instances)
+		n.reconcileInstances(ctx, listed)
+
+		for _, inst := range listed {
+			if project == node.AllProjects || inst.Project == project {
+				instances = append(instances, inst)
+			}
+		}
 	} else {
-		inst, err := n.state.Get(name)
+		inst, err := n.get(project, name)
 		if err != nil {
 			return nil, err
 		}
 		instances = append(instances, inst)
 	}
 
-	// Collect running instance IDs for a single batched runtime query.
-	var runningIDs []string
+	// Collect running instances for a single batched runtime query.
+	running := make(map[string]*controllerv1.Info)
 	res := make([]*controllerv1.Info, 0, len(instances))
 	for _, inst := range instances {
 		info := n.instanceToInfo(inst)
 		if inst.State == vmv1.State_STATE_RUNNING {
-			runningIDs = append(runningIDs, inst.Id)
+			running[processID(inst)] = info
 		}
 		res = append(res, info)
 	}
 
-	if len(runningIDs) > 0 {
-		n.batchEnrichWithRuntime(ctx, res, runningIDs)
+	if len(running) > 0 {
+		n.batchEnrichWithRuntime(ctx, running)
 	}
 	return res, nil
 }
 
+func (n *localNodeManager) SetDNSRecords(ctx context.Context, records []*settingsv1.DnsRecord) error {
+	conn, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = processv1.NewQemuServiceClient(conn).SetDnsRecords(ctx, &processv1.SetDnsRecordsRequest{Records: records})
+	return err
+}
+
+func (n *localNodeManager) ListLeases(ctx context.Context) (*controllerv1.ListLeasesResponse, error) {
+	conn, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	resp, err := processv1.NewQemuServiceClient(conn).ListLeases(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return &controllerv1.ListLeasesResponse{Network: resp.Network, Leases: resp.Leases}, nil
+}
+
+// ListAuditRecords returns nothing: the controller's gRPC server keeps the
+// audit log, not the node manager behind it.
+func (n *localNodeManager) ListAuditRecords(context.Context, *settingsv1.AuditQuery) ([]*settingsv1.AuditRecord, error) {
+	return nil, nil
+}
+
+func (n *localNodeManager) NodeInfo(ctx context.Context) (*settingsv1.NodeInfo, error) {
+	instances, listErr := n.state.List()
+	if listErr != nil {
+		return nil, listErr
+	}
+	allocated := &settingsv1.VM{}
+	for _, inst := range instances {
+		allocated.Cpus += inst.GetHardware().GetCpus()
+		allocated.Memory += inst.GetHardware().GetMemory()
+		allocated.Disk += inst.GetHardware().GetDisk()
+	}
+
+	conn, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	host, err := processv1.NewQemuServiceClient(conn).HostResources(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return &settingsv1.NodeInfo{
+		Host:      host,
+		Allocated: allocated,
+		Instances: uint32(len(instances)), //nolint:gosec // G115: instance counts fit
+	}, nil
+}
+
+func (n *localNodeManager) SetOverlayNetworks(ctx context.Context, networks []*settingsv1.OverlayNetwork, peers []string) error {
+	conn, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = processv1.NewQemuServiceClient(conn).SetOverlayNetworks(ctx, &processv1.SetOverlayNetworksRequest{
+		Networks: networks,
+		Peers:    peers,
+	})
+	return err
+}
+
+// stoppedInstance returns the instance called name in project if it's
+// stopped.
+func (n *localNodeManager) stoppedInstance(project, name string) (*vmv1.Instance, error) {
+	inst, err := n.get(project, name)
+	if err != nil {
+		return nil, fmt.Errorf("instance %s not found: %w", name, err)
+	}
+	if inst.State != vmv1.State_STATE_STOPPED {
+		return nil, fmt.Errorf("instance %s is %w", name, ErrNotStopped)
+	}
+	return inst, nil
+}
+
+func (n *localNodeManager) ExportDisk(ctx context.Context, project, name string) (io.ReadCloser, int64, error) {
+	inst, err := n.stoppedInstance(project, name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, dialErr := n.dial()
+	if dialErr != nil {
+		return nil, 0, dialErr
+	}
+	streamCtx, cancel := context.WithCancel(ctx)
+	release := func() {
+		cancel()
+		_ = conn.Close()
+	}
+
+	stream, streamErr := processv1.NewQemuServiceClient(conn).ExportDisk(streamCtx, &processv1.ExportDiskRequest{Id: processID(inst)})
+	if streamErr != nil {
+		release()
+		return nil, 0, streamErr
+	}
+	header, headerErr := stream.Recv()
+	if headerErr != nil {
+		release()
+		return nil, 0, headerErr
+	}
+
+	return grpcutil.NewChunkReadCloser(func() ([]byte, error) {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return chunk.Chunk, nil
+	}, release), header.Size, nil
+}
+
+func (n *localNodeManager) ImportDisk(ctx context.Context, project, name string, r io.Reader) error {
+	inst, err := n.stoppedInstance(project, name)
+	if err != nil {
+		return err
+	}
+
+	conn, dialErr := n.dial()
+	if dialErr != nil {
+		return dialErr
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Cancelling the stream on a read error makes the QEMU service discard
+	// the partial disk; only a clean close commits it.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, streamErr := processv1.NewQemuServiceClient(conn).ImportDisk(streamCtx)
+	if streamErr != nil {
+		return streamErr
+	}
+	sendErr := grpcutil.SendStream(r, func(chunk []byte, first bool) error {
+		req := &processv1.ImportDiskRequest{Chunk: chunk}
+		if first {
+			req.Id = processID(inst)
+		}
+		return stream.Send(req)
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func (n *localNodeManager) SetNetworkLimits(ctx context.Context, project, name string, limits *settingsv1.NetworkLimits) error {
+	inst, err := n.get(project, name)
+	if err != nil {
+		return fmt.Errorf("instance %s not found: %w", name, err)
+	}
+
+	// Stopped instances pick the limits up on their next start.
+	if inst.State == vmv1.State_STATE_RUNNING {
+		conn, dialErr := n.dial()
+		if dialErr != nil {
+			return dialErr
+		}
+		defer func() { _ = conn.Close() }()
+		if _, setErr := processv1.NewQemuServiceClient(conn).SetNetworkLimits(ctx, &processv1.SetNetworkLimitsRequest{
+			Id:     processID(inst),
+			Limits: limits,
+		}); setErr != nil {
+			return setErr
+		}
+	}
+
+	inst.NetworkLimits = limits
+	_, err = n.state.Update(inst)
+	return err
+}
+
+func (n *localNodeManager) SetMetadata(_ context.Context, project, name string, labels, annotations map[string]string) error {
+	inst, err := n.get(project, name)
+	if err != nil {
+		return fmt.Errorf("instance %s not found: %w", name, err)
+	}
+	inst.Labels = labels
+	inst.Annotations = annotations
+	_, err = n.state.Update(inst)
+	return err
+}
+
 func (n *localNodeManager) instanceToInfo(inst *vmv1.Instance) *controllerv1.Info {
 	spec := &controllerv1.VMSpec{
-		Vm:    inst.Hardware,
-		Image: inst.ImageId,
+		Vm:             inst.Hardware,
+		Image:          inst.ImageId,
+		NetworkLimits:  inst.NetworkLimits,
+		VlanId:         inst.VlanId,
+		OverlayNetwork: inst.OverlayNetwork,
+		Labels:         inst.Labels,
+		Annotations:    inst.Annotations,
+		Placement:      inst.Placement,
+		Owner:          inst.Owner,
+		OwnerGroups:    inst.OwnerGroups,
+		Project:        inst.Project,
+	}
+	if inst.Hwaddr != nil {
+		spec.MacAddress = *inst.Hwaddr
 	}
 	if inst.Cloudinit != nil {
 		spec.CloudInit = inst.Cloudinit
@@ -230,30 +534,28 @@ func (n *localNodeManager) instanceToInfo(inst *vmv1.Instance) *controllerv1.Inf
 		status.Hwaddr = *inst.Hwaddr
 	}
 	return &controllerv1.Info{
-		Name:   inst.Id,
+		Name:   instanceName(inst),
 		Spec:   spec,
 		Status: status,
 	}
 }
 
-func (n *localNodeManager) batchEnrichWithRuntime(ctx context.Context, infos []*controllerv1.Info, runningIDs []string) {
+// batchEnrichWithRuntime adds the runtime info of the running instances,
+// keyed by their process id.
+func (n *localNodeManager) batchEnrichWithRuntime(ctx context.Context, running map[string]*controllerv1.Info) {
 	conn, err := n.dial()
 	if err != nil {
 		return
 	}
 	defer func() { _ = conn.Close() }()
-	resp, err := processv1.NewQemuServiceClient(conn).Info(ctx, &processv1.InfoRequest{Ids: runningIDs})
+	resp, err := processv1.NewQemuServiceClient(conn).Info(ctx, &processv1.InfoRequest{Ids: slices.Collect(maps.Keys(running))})
 	if err != nil {
 		return
 	}
 
-	// Index runtime info by name for fast lookup.
-	runtimeByName := make(map[string]*runtimev1.RuntimeInfo, len(resp.Info))
+	// Runtime info is named after the instance's process id.
 	for _, ri := range resp.Info {
-		runtimeByName[ri.Name] = ri
-	}
-	for _, info := range infos {
-		if ri, ok := runtimeByName[info.Name]; ok {
+		if info, ok := running[ri.Name]; ok {
 			if info.Status == nil {
 				info.Status = &controllerv1.VMStatus{}
 			}
@@ -287,10 +589,11 @@ func (n *localNodeManager) reconcileInstances(ctx context.Context, instances []*
 		running[id] = true
 	}
 	for _, inst := range instances {
-		if running[inst.Id] && inst.State != vmv1.State_STATE_RUNNING {
+		pid := processID(inst)
+		if running[pid] && inst.State != vmv1.State_STATE_RUNNING {
 			inst.State = vmv1.State_STATE_RUNNING
 			n.setInstanceState(inst.Id, vmv1.State_STATE_RUNNING)
-		} else if !running[inst.Id] && inst.State == vmv1.State_STATE_RUNNING {
+		} else if !running[pid] && inst.State == vmv1.State_STATE_RUNNING {
 			inst.State = vmv1.State_STATE_STOPPED
 			n.setInstanceState(inst.Id, vmv1.State_STATE_STOPPED)
 		}
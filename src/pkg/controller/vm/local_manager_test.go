@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/controller/db"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpec(project string, i int) *controllerv1.VMSpec {
+	return &controllerv1.VMSpec{
+		Image:      "img",
+		Vm:         &settingsv1.VM{Cpus: 1, Memory: 512, Disk: 5},
+		Project:    project,
+		MacAddress: fmt.Sprintf("52:54:00:00:00:%02x", i),
+	}
+}
+
+func newTestLocalManager(t *testing.T) *localNodeManager {
+	state, err := db.NewDatabase(filepath.Join(t.TempDir(), "badger"))
+	require.NoError(t, err)
+	return &localNodeManager{name: "local", endpoint: "127.0.0.1:1", state: state}
+}
+
+// These used to share the key "a.b.c" when names and projects were joined
+// with '.'.
+var collidingInstances = []struct{ project, name string }{
+	{"c", "a.b"},
+	{"b.c", "a"},
+	{"", "a.b.c"},
+}
+
+func TestLocalManager_CollidingNamesStayDistinct(t *testing.T) {
+	n := newTestLocalManager(t)
+	ctx := context.Background()
+
+	processIDs := make(map[string]bool)
+	for i, c := range collidingInstances {
+		require.NoError(t, n.Create(ctx, c.name, testSpec(c.project, i)), "%s/%s", c.project, c.name)
+
+		inst, err := n.get(c.project, c.name)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(processID(inst)), 15, "process ids name interfaces")
+		processIDs[processID(inst)] = true
+	}
+	assert.Len(t, processIDs, len(collidingInstances))
+
+	for _, c := range collidingInstances {
+		infos, err := n.Info(ctx, c.project, c.name)
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.Equal(t, c.name, infos[0].Name)
+		assert.Equal(t, c.project, infos[0].GetSpec().GetProject())
+	}
+}
+
+func TestLocalManager_InfoFiltersByProject(t *testing.T) {
+	n := newTestLocalManager(t)
+	ctx := context.Background()
+	for i, c := range collidingInstances {
+		require.NoError(t, n.Create(ctx, c.name, testSpec(c.project, i)))
+	}
+
+	infos, err := n.Info(ctx, "c", "")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "a.b", infos[0].Name)
+
+	infos, err = n.Info(ctx, "", "")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "a.b.c", infos[0].Name)
+
+	infos, err = n.Info(ctx, node.AllProjects, "")
+	require.NoError(t, err)
+	assert.Len(t, infos, len(collidingInstances))
+}
+
+func TestLocalManager_CreateRejectsInvalidNames(t *testing.T) {
+	n := newTestLocalManager(t)
+	spec := testSpec("", 0)
+	for _, name := range []string{"", "a/b", "-a", "a b"} {
+		assert.Error(t, n.Create(context.Background(), name, spec), name)
+	}
+	spec.Project = "team/a"
+	assert.Error(t, n.Create(context.Background(), "vm1", spec))
+}
@@ -4,13 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"sync"
 	"time"
 
 	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
-	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
 	"github.com/q-controller/qcontroller/src/pkg/controller"
 	"github.com/q-controller/qcontroller/src/pkg/events"
 	"github.com/q-controller/qcontroller/src/pkg/node"
@@ -54,56 +54,94 @@ func newManager(local *settingsv1.Node, state controller.State, eventPublisher *
 	return manager, nil
 }
 
-func (m *Manager) Create(ctx context.Context, id, imageID string,
-	cpus uint32, memory, disk uint32, cloudInit *vmv1.CloudInit) (string, error) {
-	if err := m.nm.Create(ctx, id, imageID, cpus, memory, disk, cloudInit); err != nil {
-		return "", err
+func (m *Manager) Create(ctx context.Context, name string, spec *controllerv1.VMSpec) error {
+	if err := m.nm.Create(ctx, name, spec); err != nil {
+		return err
 	}
-
-	_ = m.eventsPublisher.VMUpdated(&controllerv1.Info{
-		Name: id,
-		Spec: &controllerv1.VMSpec{
-			Image: imageID,
-			Vm:    &settingsv1.VM{Cpus: cpus, Memory: memory, Disk: disk},
-		},
-		Status: &controllerv1.VMStatus{
-			State: vmv1.State_STATE_STOPPED.String(),
-		},
-	})
-
-	return id, nil
+	m.publish(ctx, spec.GetProject(), name)
+	return nil
 }
 
-func (m *Manager) Start(ctx context.Context, id string) error {
+func (m *Manager) Start(ctx context.Context, project, name string) error {
 	go func() {
 		asyncCtx, cancel := utils.AsyncCtx(ctx, m.ctx.Done())
 		defer cancel()
-		if err := m.nm.Start(asyncCtx, id); err != nil {
-			slog.ErrorContext(asyncCtx, "Start failed", "id", id, "error", err)
-			_ = m.eventsPublisher.PublishError(fmt.Sprintf("failed to start: %v", err), id)
+		if err := m.nm.Start(asyncCtx, project, name); err != nil {
+			slog.ErrorContext(asyncCtx, "Start failed", "project", project, "name", name, "error", err)
+			_ = m.eventsPublisher.PublishError(fmt.Sprintf("failed to start: %v", err), name)
 		}
 	}()
 	return nil
 }
 
-func (m *Manager) Stop(ctx context.Context, id string, force bool) error {
-	return m.nm.Stop(ctx, id, force)
+func (m *Manager) Stop(ctx context.Context, project, name string, force bool) error {
+	return m.nm.Stop(ctx, project, name, force)
 }
 
-func (m *Manager) Remove(ctx context.Context, id string) error {
-	if removeErr := m.nm.Remove(ctx, id); removeErr != nil {
+func (m *Manager) Remove(ctx context.Context, project, name string) error {
+	if removeErr := m.nm.Remove(ctx, project, name); removeErr != nil {
 		return removeErr
 	}
 
-	if eventErr := m.eventsPublisher.VMRemoved(id); eventErr != nil {
-		slog.Warn("Failed to publish VM removal event", "id", id, "error", eventErr)
+	if eventErr := m.eventsPublisher.VMRemoved(project, name); eventErr != nil {
+		slog.Warn("Failed to publish VM removal event", "project", project, "name", name, "error", eventErr)
 	}
 
 	return nil
 }
 
-func (m *Manager) Info(ctx context.Context, id string) ([]*controllerv1.Info, error) {
-	return m.nm.Info(ctx, id)
+func (m *Manager) Info(ctx context.Context, project, name string) ([]*controllerv1.Info, error) {
+	return m.nm.Info(ctx, project, name)
+}
+
+func (m *Manager) SetDNSRecords(ctx context.Context, records []*settingsv1.DnsRecord) error {
+	return m.nm.SetDNSRecords(ctx, records)
+}
+
+func (m *Manager) ListLeases(ctx context.Context) (*controllerv1.ListLeasesResponse, error) {
+	return m.nm.ListLeases(ctx)
+}
+
+func (m *Manager) SetNetworkLimits(ctx context.Context, project, name string, limits *settingsv1.NetworkLimits) error {
+	return m.nm.SetNetworkLimits(ctx, project, name, limits)
+}
+
+// SetMetadata replaces an instance's labels and annotations and publishes
+// the change right away rather than on the next poll.
+func (m *Manager) SetMetadata(ctx context.Context, project, name string, labels, annotations map[string]string) error {
+	if err := m.nm.SetMetadata(ctx, project, name, labels, annotations); err != nil {
+		return err
+	}
+	m.publish(ctx, project, name)
+	return nil
+}
+
+// publish sends the instance's current info, leaving it to the polling
+// loop if it can't be read.
+func (m *Manager) publish(ctx context.Context, project, name string) {
+	infos, infoErr := m.nm.Info(ctx, project, name)
+	if infoErr != nil || len(infos) == 0 {
+		return
+	}
+	if eventErr := m.eventsPublisher.VMUpdated(infos[0]); eventErr != nil {
+		slog.Warn("Failed to publish VM update event", "project", project, "name", name, "error", eventErr)
+	}
+}
+
+func (m *Manager) NodeInfo(ctx context.Context) (*settingsv1.NodeInfo, error) {
+	return m.nm.NodeInfo(ctx)
+}
+
+func (m *Manager) SetOverlayNetworks(ctx context.Context, networks []*settingsv1.OverlayNetwork, peers []string) error {
+	return m.nm.SetOverlayNetworks(ctx, networks, peers)
+}
+
+func (m *Manager) ExportDisk(ctx context.Context, project, name string) (io.ReadCloser, int64, error) {
+	return m.nm.ExportDisk(ctx, project, name)
+}
+
+func (m *Manager) ImportDisk(ctx context.Context, project, name string, r io.Reader) error {
+	return m.nm.ImportDisk(ctx, project, name, r)
 }
 
 func (m *Manager) Close() {
@@ -144,29 +182,31 @@ func (m *Manager) startPollingLoop() {
 }
 
 func (m *Manager) poll() {
-	infos, err := m.nm.Info(m.ctx, "")
+	infos, err := m.nm.Info(m.ctx, node.AllProjects, "")
 	if err != nil {
 		slog.Debug("Failed to poll local node", "error", err)
 		return
 	}
 
-	current := make(map[string]bool, len(infos))
+	type key struct{ project, name string }
+	current := make(map[key]bool, len(infos))
 	for _, info := range infos {
-		current[info.Name] = true
+		current[key{info.GetSpec().GetProject(), info.Name}] = true
 	}
 
 	// Detect VMs that disappeared.
 	for _, cached := range m.eventsPublisher.GetAll() {
-		if !current[cached.Name] {
-			if eventErr := m.eventsPublisher.VMRemoved(cached.Name); eventErr != nil {
-				slog.Warn("Failed to publish VM removal event", "id", cached.Name, "error", eventErr)
+		project := cached.GetSpec().GetProject()
+		if !current[key{project, cached.Name}] {
+			if eventErr := m.eventsPublisher.VMRemoved(project, cached.Name); eventErr != nil {
+				slog.Warn("Failed to publish VM removal event", "project", project, "name", cached.Name, "error", eventErr)
 			}
 		}
 	}
 
 	for _, info := range infos {
 		if eventErr := m.eventsPublisher.VMUpdated(info); eventErr != nil {
-			slog.Warn("Failed to publish VM info event", "id", info.Name, "error", eventErr)
+			slog.Warn("Failed to publish VM info event", "project", info.GetSpec().GetProject(), "name", info.Name, "error", eventErr)
 		}
 	}
 }
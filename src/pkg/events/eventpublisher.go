@@ -19,13 +19,22 @@ import (
 type Publisher struct {
 	ch    chan<- *eventv1.PublishRequest
 	mu    sync.Mutex
-	cache map[string]*controllerv1.Info
+	cache map[vmKey]*controllerv1.Info
+}
+
+// vmKey identifies a VM, whose name is scoped to its project.
+type vmKey struct {
+	project, name string
+}
+
+func keyOf(info *controllerv1.Info) vmKey {
+	return vmKey{project: info.GetSpec().GetProject(), name: info.Name}
 }
 
 func (p *Publisher) VMUpdated(info *controllerv1.Info) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	prev, exists := p.cache[info.Name]
+	prev, exists := p.cache[keyOf(info)]
 	if exists && proto.Equal(prev, info) {
 		slog.Debug("Skipping duplicate VM event", "vm", info.Name, "state", info.GetStatus().GetState(), "ips", info.GetStatus().GetRuntimeInfo().GetIpaddresses())
 		return nil // No change, skip send
@@ -35,7 +44,7 @@ func (p *Publisher) VMUpdated(info *controllerv1.Info) error {
 	} else {
 		slog.Info("First VM event", "vm", info.Name, "state", info.GetStatus().GetState(), "ips", info.GetStatus().GetRuntimeInfo().GetIpaddresses())
 	}
-	p.cache[info.Name] = proto.Clone(info).(*controllerv1.Info)
+	p.cache[keyOf(info)] = proto.Clone(info).(*controllerv1.Info)
 	update := &eventv1.Update{
 		Timestamp: time.Now().Unix(),
 		Payload: &eventv1.Update_VmEvent{
@@ -48,17 +57,22 @@ func (p *Publisher) VMUpdated(info *controllerv1.Info) error {
 	return p.publish(&eventv1.PublishRequest{Update: update})
 }
 
-func (p *Publisher) VMRemoved(id string) error {
+// VMRemoved publishes the removal of a VM along with its last known spec,
+// which subscribers need to tell whether they may see the event.
+func (p *Publisher) VMRemoved(project, name string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	delete(p.cache, id)
+	key := vmKey{project: project, name: name}
+	info := &controllerv1.Info{Name: name, Spec: &controllerv1.VMSpec{Project: project}}
+	if cached, ok := p.cache[key]; ok {
+		info.Spec = cached.Spec
+	}
+	delete(p.cache, key)
 	update := &eventv1.Update{
 		Timestamp: time.Now().Unix(),
 		Payload: &eventv1.Update_VmEvent{
 			VmEvent: &eventv1.VMEvent{
-				Info: &controllerv1.Info{
-					Name: id,
-				},
+				Info: info,
 				Type: eventv1.VMEvent_EVENT_TYPE_REMOVED,
 			},
 		},
@@ -78,10 +92,10 @@ func (p *Publisher) GetAll() []*controllerv1.Info {
 }
 
 // Get returns the cached info for a specific VM, or nil if not found.
-func (p *Publisher) Get(name string) *controllerv1.Info {
+func (p *Publisher) Get(project, name string) *controllerv1.Info {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.cache[name]
+	return p.cache[vmKey{project: project, name: name}]
 }
 
 func (p *Publisher) PublishImageUpdate(image *fileregistryv1.VMImage, eventType eventv1.ImageEvent_EventType) error {
@@ -124,6 +138,17 @@ func (p *Publisher) PublishError(message, resource string) error {
 	return p.publish(&eventv1.PublishRequest{Update: update})
 }
 
+// PublishAudit sends an audit record as an event.
+func (p *Publisher) PublishAudit(record *settingsv1.AuditRecord) error {
+	update := &eventv1.Update{
+		Timestamp: record.GetTime(),
+		Payload: &eventv1.Update_AuditEvent{
+			AuditEvent: &eventv1.AuditEvent{Record: record},
+		},
+	}
+	return p.publish(&eventv1.PublishRequest{Update: update})
+}
+
 func (p *Publisher) publish(req *eventv1.PublishRequest) error {
 	select {
 	case p.ch <- req:
@@ -140,7 +165,7 @@ func NewEventPublisher(ctx context.Context, endpoint string, tlsCfg *settingsv1.
 	}
 
 	events := make(chan *eventv1.PublishRequest, 100)
-	publisher := &Publisher{ch: events, cache: make(map[string]*controllerv1.Info)}
+	publisher := &Publisher{ch: events, cache: make(map[vmKey]*controllerv1.Info)}
 
 	go func() {
 		defer func() { _ = conn.Close() }()
@@ -0,0 +1,90 @@
+package grpcutil
+
+import (
+	"errors"
+	"io"
+)
+
+// ChunkSize is the payload size of streamed file chunks.
+const ChunkSize = 1024 * 1024 // 1 MB
+
+// SendChunks reads r to EOF and passes its contents to send in chunks of
+// at most ChunkSize. The buffer is reused, so send must not retain it.
+func SendChunks(r io.Reader, send func([]byte) error) error {
+	buffer := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(r, buffer)
+		if n > 0 {
+			if sendErr := send(buffer[:n]); sendErr != nil {
+				return sendErr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// SendStream sends r's contents over a client stream via send, like
+// SendChunks, except that the first message is always sent, even for empty
+// input, so it can carry metadata. io.EOF from send means the server ended
+// the stream; it's swallowed so the caller's CloseAndRecv reports the real
+// status.
+func SendStream(r io.Reader, send func(chunk []byte, first bool) error) error {
+	first := true
+	err := SendChunks(r, func(chunk []byte) error {
+		sendErr := send(chunk, first)
+		first = false
+		return sendErr
+	})
+	if err == nil && first {
+		err = send(nil, true)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+type chunkReader struct {
+	recv func() ([]byte, error)
+	buf  []byte
+}
+
+// NewChunkReader turns a stream of chunks into an io.Reader. recv returns
+// the next chunk, or io.EOF once the stream is done.
+func NewChunkReader(recv func() ([]byte, error)) io.Reader {
+	return &chunkReader{recv: recv}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		chunk, err := c.recv()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = chunk
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+type chunkReadCloser struct {
+	io.Reader
+	closeFn func()
+}
+
+func (c *chunkReadCloser) Close() error {
+	c.closeFn()
+	return nil
+}
+
+// NewChunkReadCloser is NewChunkReader whose Close calls closeFn, e.g. to
+// cancel the stream and release its connection.
+func NewChunkReadCloser(recv func() ([]byte, error), closeFn func()) io.ReadCloser {
+	return &chunkReadCloser{Reader: NewChunkReader(recv), closeFn: closeFn}
+}
@@ -0,0 +1,82 @@
+package grpcutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunks_RoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), ChunkSize/4)
+
+	var chunks [][]byte
+	require.NoError(t, SendChunks(bytes.NewReader(data), func(b []byte) error {
+		assert.LessOrEqual(t, len(b), ChunkSize)
+		chunks = append(chunks, append([]byte(nil), b...))
+		return nil
+	}))
+	assert.Len(t, chunks, 3)
+
+	r := NewChunkReader(func() ([]byte, error) {
+		if len(chunks) == 0 {
+			return nil, io.EOF
+		}
+		c := chunks[0]
+		chunks = chunks[1:]
+		return c, nil
+	})
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestSendChunks_Empty(t *testing.T) {
+	calls := 0
+	require.NoError(t, SendChunks(bytes.NewReader(nil), func([]byte) error {
+		calls++
+		return nil
+	}))
+	assert.Zero(t, calls)
+}
+
+func TestChunkReader_PropagatesErrors(t *testing.T) {
+	streamErr := errors.New("stream broken")
+	r := NewChunkReader(func() ([]byte, error) { return nil, streamErr })
+	_, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, streamErr)
+}
+
+func TestSendStream_FirstMessage(t *testing.T) {
+	var firsts []bool
+	require.NoError(t, SendStream(bytes.NewReader(nil), func(chunk []byte, first bool) error {
+		firsts = append(firsts, first)
+		assert.Empty(t, chunk)
+		return nil
+	}))
+	assert.Equal(t, []bool{true}, firsts)
+
+	firsts = nil
+	data := make([]byte, ChunkSize+1)
+	require.NoError(t, SendStream(bytes.NewReader(data), func(_ []byte, first bool) error {
+		firsts = append(firsts, first)
+		return nil
+	}))
+	assert.Equal(t, []bool{true, false}, firsts)
+}
+
+func TestSendStream_ServerClosed(t *testing.T) {
+	assert.NoError(t, SendStream(bytes.NewReader([]byte("data")), func([]byte, bool) error {
+		return io.EOF
+	}))
+
+	readErr := errors.New("source broken")
+	err := SendStream(io.MultiReader(bytes.NewReader([]byte("data")), iotest.ErrReader(readErr)), func([]byte, bool) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, readErr)
+}
@@ -3,10 +3,13 @@ package grpcutil
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
 	"github.com/q-controller/qcontroller/src/pkg/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -14,7 +17,8 @@ import (
 )
 
 type options struct {
-	tls *settingsv1.TLSConfig
+	tls   *settingsv1.TLSConfig
+	audit *audit.Log
 }
 
 type Option func(*options)
@@ -26,8 +30,16 @@ func WithTLS(cfg *settingsv1.TLSConfig) Option {
 	}
 }
 
+// WithAudit records the server's mutating calls to l. Only applies to
+// servers.
+func WithAudit(l *audit.Log) Option {
+	return func(o *options) {
+		o.audit = l
+	}
+}
+
 // NewServer creates a gRPC server, optionally with mTLS. The auth identity
-// server interceptor is always installed so downstream handlers see the
+// server interceptors are always installed so downstream handlers see the
 // originating user via auth.FromContext.
 func NewServer(opts ...Option) (*grpc.Server, error) {
 	o := &options{}
@@ -36,7 +48,8 @@ func NewServer(opts ...Option) (*grpc.Server, error) {
 	}
 
 	serverOpts := []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor()),
+		grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(), audit.UnaryServerInterceptor(o.audit)),
+		grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(), audit.StreamServerInterceptor(o.audit)),
 	}
 	if o.tls != nil {
 		creds, err := serverCredentials(o.tls)
@@ -49,7 +62,7 @@ func NewServer(opts ...Option) (*grpc.Server, error) {
 }
 
 // Dial creates a gRPC client connection, optionally with mTLS. The auth
-// identity client interceptor is always installed so the caller's Identity
+// identity client interceptors are always installed so the caller's Identity
 // (when present in context) rides along as gRPC metadata.
 func Dial(endpoint string, opts ...Option) (*grpc.ClientConn, error) {
 	o := &options{}
@@ -59,6 +72,7 @@ func Dial(endpoint string, opts ...Option) (*grpc.ClientConn, error) {
 
 	dialOpts := []grpc.DialOption{
 		grpc.WithChainUnaryInterceptor(auth.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(auth.StreamClientInterceptor()),
 	}
 	if o.tls == nil {
 		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -81,12 +95,32 @@ func serverCredentials(cfg *settingsv1.TLSConfig) (credentials.TransportCredenti
 	if err != nil {
 		return nil, err
 	}
-	return credentials.NewTLS(&tls.Config{
+	tlsCfg := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		ClientCAs:    caPool,
 		ClientAuth:   tls.RequireAndVerifyClientCert,
 		MinVersion:   tls.VersionTLS13,
-	}), nil
+	}
+	if len(cfg.AllowedClients) > 0 {
+		tlsCfg.VerifyConnection = verifyClient(cfg.AllowedClients)
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// verifyClient accepts the verified client certificates whose common name
+// is one of allowed. Any certificate from the CA would pass the handshake,
+// and every client is trusted with the identity it passes along.
+func verifyClient(allowed []string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no client certificate")
+		}
+		name := cs.PeerCertificates[0].Subject.CommonName
+		if !slices.Contains(allowed, name) {
+			return fmt.Errorf("client %q is not allowed", name)
+		}
+		return nil
+	}
 }
 
 func clientCredentials(cfg *settingsv1.TLSConfig) (credentials.TransportCredentials, error) {
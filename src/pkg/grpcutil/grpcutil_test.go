@@ -0,0 +1,23 @@
+package grpcutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyClient(t *testing.T) {
+	verify := verifyClient([]string{"orchestrator", "node1-client"})
+	peer := func(name string) tls.ConnectionState {
+		return tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: name}}}}
+	}
+
+	assert.NoError(t, verify(peer("orchestrator")))
+	assert.NoError(t, verify(peer("node1-client")))
+	assert.Error(t, verify(peer("node2-client")))
+	assert.Error(t, verify(peer("")))
+	assert.Error(t, verify(tls.ConnectionState{}))
+}
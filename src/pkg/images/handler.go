@@ -86,8 +86,13 @@ func (h *Handler) GetV1Images(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func CreateHandler(cli ImageClient, mux *http.ServeMux) http.Handler {
-	return imageservice.HandlerFromMux(&Handler{
+// CreateHandler registers the image handlers on mux, wrapped in
+// middlewares.
+func CreateHandler(cli ImageClient, mux *http.ServeMux, middlewares ...imageservice.MiddlewareFunc) http.Handler {
+	return imageservice.HandlerWithOptions(&Handler{
 		imageCli: cli,
-	}, mux)
+	}, imageservice.StdHTTPServerOptions{
+		BaseRouter:  mux,
+		Middlewares: middlewares,
+	})
 }
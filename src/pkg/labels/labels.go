@@ -0,0 +1,290 @@
+// Package labels validates instance labels and annotations and matches
+// labels against selectors such as "team=web,env in (dev,staging),!legacy".
+package labels
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	maxNameLength   = 63
+	maxPrefixLength = 253
+	// MaxAnnotationsSize caps the total size of an instance's annotation
+	// keys and values in bytes.
+	MaxAnnotationsSize = 256 * 1024
+)
+
+var (
+	nameRe   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	prefixRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// ValidateKey checks a label or annotation key: a name of up to 63
+// alphanumerics, '-', '_' and '.', starting and ending with an
+// alphanumeric, optionally prefixed with a DNS subdomain and '/'.
+func ValidateKey(key string) error {
+	name := key
+	if prefix, rest, ok := strings.Cut(key, "/"); ok {
+		if prefix == "" || len(prefix) > maxPrefixLength || !prefixRe.MatchString(prefix) {
+			return fmt.Errorf("invalid key %q: prefix must be a DNS subdomain", key)
+		}
+		name = rest
+	}
+	if len(name) > maxNameLength || !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid key %q: name must be up to %d alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric", key, maxNameLength)
+	}
+	return nil
+}
+
+// ValidateValue checks a label value, which is empty or follows the rules
+// for a key's name.
+func ValidateValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > maxNameLength || !nameRe.MatchString(value) {
+		return fmt.Errorf("invalid value %q: must be up to %d alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric", value, maxNameLength)
+	}
+	return nil
+}
+
+// Validate checks a set of labels.
+func Validate(labels map[string]string) error {
+	for key, value := range labels {
+		if err := ValidateKey(key); err != nil {
+			return err
+		}
+		if err := ValidateValue(value); err != nil {
+			return fmt.Errorf("label %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ValidateAnnotations checks a set of annotations. Their values are free
+// form, but their total size is limited.
+func ValidateAnnotations(annotations map[string]string) error {
+	size := 0
+	for key, value := range annotations {
+		if err := ValidateKey(key); err != nil {
+			return err
+		}
+		size += len(key) + len(value)
+	}
+	if size > MaxAnnotationsSize {
+		return fmt.Errorf("annotations are %d bytes, more than the %d allowed", size, MaxAnnotationsSize)
+	}
+	return nil
+}
+
+// Operator is how a Requirement compares a label.
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single condition on a label.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Matches reports whether labels satisfy the requirement. Like in
+// Kubernetes, != and notin match when the label is missing.
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	case Equals, In:
+		return ok && slices.Contains(r.Values, value)
+	case NotEquals, NotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case Exists:
+		return r.Key
+	case DoesNotExist:
+		return "!" + r.Key
+	case In, NotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	}
+	return r.Key + string(r.Operator) + r.Values[0]
+}
+
+// Selector matches labels that satisfy all of its requirements. The empty
+// selector matches everything.
+type Selector []Requirement
+
+// Matches reports whether labels satisfy every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the selector matches everything.
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse parses a comma-separated list of requirements, each one of:
+//
+//	key, !key
+//	key=value, key==value, key!=value
+//	key in (v1,v2), key notin (v1,v2)
+func Parse(selector string) (Selector, error) {
+	parts, splitErr := splitRequirements(selector)
+	if splitErr != nil {
+		return nil, splitErr
+	}
+	var out Selector
+	for _, part := range parts {
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// splitRequirements splits on the commas outside parentheses.
+func splitRequirements(selector string) ([]string, error) {
+	if strings.TrimSpace(selector) == "" {
+		return nil, nil
+	}
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("invalid selector %q: nested parentheses", selector)
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("invalid selector %q: unbalanced parentheses", selector)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid selector %q: unbalanced parentheses", selector)
+	}
+	return append(parts, selector[start:]), nil
+}
+
+func parseRequirement(s string) (Requirement, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Requirement{}, errors.New("invalid selector: empty requirement")
+	}
+
+	if key, rest, ok := cutSetOperator(s); ok {
+		return parseSetRequirement(s, key, rest)
+	}
+
+	var r Requirement
+	switch {
+	case strings.HasPrefix(s, "!") && !strings.ContainsAny(s, "="):
+		r = Requirement{Key: strings.TrimSpace(s[1:]), Operator: DoesNotExist}
+	case strings.Contains(s, "!="):
+		key, value, _ := strings.Cut(s, "!=")
+		r = Requirement{Key: strings.TrimSpace(key), Operator: NotEquals, Values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(s, "=="):
+		key, value, _ := strings.Cut(s, "==")
+		r = Requirement{Key: strings.TrimSpace(key), Operator: Equals, Values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(s, "="):
+		key, value, _ := strings.Cut(s, "=")
+		r = Requirement{Key: strings.TrimSpace(key), Operator: Equals, Values: []string{strings.TrimSpace(value)}}
+	default:
+		r = Requirement{Key: s, Operator: Exists}
+	}
+	if err := ValidateKey(r.Key); err != nil {
+		return Requirement{}, fmt.Errorf("invalid selector %q: %w", s, err)
+	}
+	for _, value := range r.Values {
+		if err := ValidateValue(value); err != nil {
+			return Requirement{}, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+	}
+	return r, nil
+}
+
+// cutSetOperator splits "key in (...)" and "key notin (...)" into the key
+// and the rest starting at the operator.
+func cutSetOperator(s string) (string, string, bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return "", "", false
+	}
+	op := fields[1]
+	if i := strings.Index(op, "("); i >= 0 {
+		op = op[:i]
+	}
+	if op != string(In) && op != string(NotIn) {
+		return "", "", false
+	}
+	key := fields[0]
+	return key, strings.TrimSpace(strings.TrimPrefix(s, key)), true
+}
+
+func parseSetRequirement(s, key, rest string) (Requirement, error) {
+	r := Requirement{Key: key, Operator: In}
+	if strings.HasPrefix(rest, string(NotIn)) {
+		r.Operator = NotIn
+	}
+	list := strings.TrimSpace(strings.TrimPrefix(rest, string(r.Operator)))
+	if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+		return Requirement{}, fmt.Errorf("invalid selector %q: values must be in parentheses", s)
+	}
+	if err := ValidateKey(key); err != nil {
+		return Requirement{}, fmt.Errorf("invalid selector %q: %w", s, err)
+	}
+	for value := range strings.SplitSeq(list[1:len(list)-1], ",") {
+		value = strings.TrimSpace(value)
+		if err := ValidateValue(value); err != nil {
+			return Requirement{}, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		r.Values = append(r.Values, value)
+	}
+	return r, nil
+}
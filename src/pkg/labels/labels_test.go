@@ -0,0 +1,98 @@
+package labels
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	for _, good := range []map[string]string{
+		nil,
+		{"team": "web"},
+		{"example.com/team": "web", "env": "", "a.b_c-d": "X.y_z-1"},
+	} {
+		assert.NoError(t, Validate(good), "%v", good)
+	}
+	for _, bad := range []map[string]string{
+		{"": "x"},
+		{"-team": "web"},
+		{"team": "web-"},
+		{"team": "has space"},
+		{"Example.com/team": "web"},
+		{"/team": "web"},
+		{"example.com/": "web"},
+		{strings.Repeat("a", 64): "x"},
+		{"team": strings.Repeat("a", 64)},
+	} {
+		assert.Error(t, Validate(bad), "%v", bad)
+	}
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	assert.NoError(t, ValidateAnnotations(map[string]string{"example.com/notes": "free form, with spaces!"}))
+	assert.Error(t, ValidateAnnotations(map[string]string{"bad key": "x"}))
+	assert.Error(t, ValidateAnnotations(map[string]string{"big": strings.Repeat("x", MaxAnnotationsSize)}))
+}
+
+func TestParse(t *testing.T) {
+	sel, err := Parse("team=web, env in (dev, staging),tier!=db,owner,!legacy, zone notin (a)")
+	require.NoError(t, err)
+	assert.Equal(t, Selector{
+		{Key: "team", Operator: Equals, Values: []string{"web"}},
+		{Key: "env", Operator: In, Values: []string{"dev", "staging"}},
+		{Key: "tier", Operator: NotEquals, Values: []string{"db"}},
+		{Key: "owner", Operator: Exists},
+		{Key: "legacy", Operator: DoesNotExist},
+		{Key: "zone", Operator: NotIn, Values: []string{"a"}},
+	}, sel)
+	assert.Equal(t, "team=web,env in (dev,staging),tier!=db,owner,!legacy,zone notin (a)", sel.String())
+
+	sel, err = Parse("team==web")
+	require.NoError(t, err)
+	assert.Equal(t, Selector{{Key: "team", Operator: Equals, Values: []string{"web"}}}, sel)
+
+	sel, err = Parse("  ")
+	require.NoError(t, err)
+	assert.True(t, sel.Empty())
+
+	for _, bad := range []string{
+		"team=web,",
+		"team=web bad",
+		"env in dev",
+		"env in (dev",
+		"env in ((dev))",
+		"env)",
+		"-team",
+		"!",
+	} {
+		_, err := Parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "web", "env": "dev"}
+	for selector, want := range map[string]bool{
+		"":                          true,
+		"team=web":                  true,
+		"team=db":                   false,
+		"team!=db":                  true,
+		"tier!=db":                  true,
+		"env in (dev,staging)":      true,
+		"env notin (dev)":           false,
+		"tier notin (db)":           true,
+		"tier in (db)":              false,
+		"team":                      true,
+		"tier":                      false,
+		"!tier":                     true,
+		"!team":                     false,
+		"team=web,env in (staging)": false,
+	} {
+		sel, err := Parse(selector)
+		require.NoError(t, err, selector)
+		assert.Equal(t, want, sel.Matches(labels), selector)
+	}
+}
@@ -2,18 +2,64 @@ package node
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"regexp"
 
 	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
-	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
 )
 
-// Manager handles VM operations on a single node.
+// AllProjects, passed to Info as the project along with an empty name,
+// lists the instances in every project.
+const AllProjects = "*"
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+// ValidateName checks name can name an instance or a project: up to 63
+// letters, digits, '-', '_' and '.', starting and ending with a letter or
+// digit.
+func ValidateName(name string) error {
+	if len(name) > 63 || !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid name %q: must be up to 63 letters, digits, '-', '_' or '.', starting and ending with a letter or digit", name)
+	}
+	return nil
+}
+
+// Manager handles VM operations on a single node. Instance names are scoped
+// to their project, which is empty for the default one.
 type Manager interface {
 	Endpoint() string
-	Create(ctx context.Context, id, imageID string, cpus, memory, disk uint32, cloudInit *vmv1.CloudInit) error
-	Start(ctx context.Context, name string) error
-	Stop(ctx context.Context, name string, force bool) error
-	Remove(ctx context.Context, name string) error
-	Info(ctx context.Context, name string) ([]*controllerv1.Info, error)
+	// Create creates the instance called name in spec's project.
+	Create(ctx context.Context, name string, spec *controllerv1.VMSpec) error
+	Start(ctx context.Context, project, name string) error
+	Stop(ctx context.Context, project, name string, force bool) error
+	Remove(ctx context.Context, project, name string) error
+	// Info describes the instance, or every instance in project if name is
+	// empty. Project AllProjects with an empty name lists every project's
+	// instances.
+	Info(ctx context.Context, project, name string) ([]*controllerv1.Info, error)
+	// SetDNSRecords replaces the user-defined DNS records served on the node.
+	SetDNSRecords(ctx context.Context, records []*settingsv1.DnsRecord) error
+	// ListLeases returns the DHCP leases of the node's VM network.
+	ListLeases(ctx context.Context) (*controllerv1.ListLeasesResponse, error)
+	// SetNetworkLimits replaces an instance's network limits, applying them
+	// right away if it's running.
+	SetNetworkLimits(ctx context.Context, project, name string, limits *settingsv1.NetworkLimits) error
+	// SetMetadata replaces an instance's labels and annotations.
+	SetMetadata(ctx context.Context, project, name string, labels, annotations map[string]string) error
+	// SetOverlayNetworks replaces the overlay networks on the node; peers
+	// are the underlay addresses of the other nodes.
+	SetOverlayNetworks(ctx context.Context, networks []*settingsv1.OverlayNetwork, peers []string) error
+	// ExportDisk opens a stream of a stopped instance's disk along with its
+	// size. Fails with a NotFound status if the instance has no disk yet.
+	ExportDisk(ctx context.Context, project, name string) (io.ReadCloser, int64, error)
+	// ImportDisk replaces a stopped instance's disk with r's contents.
+	ImportDisk(ctx context.Context, project, name string, r io.Reader) error
+	// NodeInfo reports the host's resources and the instances' allocation.
+	NodeInfo(ctx context.Context) (*settingsv1.NodeInfo, error)
+	// ListAuditRecords returns the mutating calls the node's controller
+	// served, newest first.
+	ListAuditRecords(ctx context.Context, q *settingsv1.AuditQuery) ([]*settingsv1.AuditRecord, error)
 	Close()
 }
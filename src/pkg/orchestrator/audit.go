@@ -0,0 +1,82 @@
+package orchestrator
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListAuditRecords merges the orchestrator's audit records with those of
+// the nodes, queried concurrently, newest first. Nodes that can't be
+// reached are reported under errors. The log is for cluster admins only.
+func (s *Server) ListAuditRecords(ctx context.Context, req *orchestratorv1.ListAuditRecordsRequest) (*orchestratorv1.ListAuditRecordsResponse, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	q := &settingsv1.AuditQuery{
+		Subject: req.Subject,
+		Since:   req.Since,
+		Until:   req.Until,
+		Method:  req.Method,
+		Limit:   req.Limit,
+	}
+
+	var records []*settingsv1.AuditRecord
+	nodes := s.nodeManagers()
+	if req.Node == "" {
+		own, err := s.audit.List(q)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list audit records: %v", err)
+		}
+		records = own
+	} else {
+		_, nm, err := s.getNode(req.Node)
+		if err != nil {
+			return nil, err
+		}
+		nodes = map[string]node.Manager{req.Node: nm}
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		nodeErrs = map[string]string{}
+	)
+	for name, nm := range nodes {
+		wg.Go(func() {
+			nodeCtx, cancel := context.WithTimeout(ctx, listTimeout)
+			defer cancel()
+			nodeRecords, listErr := nm.ListAuditRecords(nodeCtx, q)
+			mu.Lock()
+			defer mu.Unlock()
+			if listErr != nil {
+				slog.WarnContext(ctx, "Failed to list audit records", "node", name, "error", listErr)
+				nodeErrs[name] = listErr.Error()
+				return
+			}
+			for _, record := range nodeRecords {
+				record.Node = name
+			}
+			records = append(records, nodeRecords...)
+		})
+	}
+	wg.Wait()
+
+	slices.SortStableFunc(records, func(a, b *settingsv1.AuditRecord) int {
+		return cmp.Or(cmp.Compare(b.Time, a.Time), cmp.Compare(b.Id, a.Id))
+	})
+	if limit := audit.Limit(q); len(records) > limit {
+		records = records[:limit]
+	}
+	return &orchestratorv1.ListAuditRecordsResponse{Records: records, Errors: nodeErrs}, nil
+}
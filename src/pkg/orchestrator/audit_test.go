@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"path/filepath"
+	"testing"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListAuditRecords(t *testing.T) {
+	a, b := newFakeNode(), newFakeNode()
+	a.audit = []*settingsv1.AuditRecord{
+		{Id: "2", Time: 300, Subject: "alice", Method: "/services.controller.v1.ControllerService/Remove"},
+		{Id: "1", Time: 100, Subject: "bob", Method: "/services.controller.v1.ControllerService/Create"},
+	}
+	b.down = true
+	s := newTestServer(map[string]node.Manager{"a": a, "b": b})
+	l, err := audit.Open(filepath.Join(t.TempDir(), "audit"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	s.audit = l
+	l.Record(as("alice"), "DELETE /v1/nodes/a/instances/vm1", "", "200 OK", nil)
+
+	resp, err := s.ListAuditRecords(as("alice"), &orchestratorv1.ListAuditRecordsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 3)
+	assert.Equal(t, "DELETE /v1/nodes/a/instances/vm1", resp.Records[0].Method)
+	assert.Empty(t, resp.Records[0].Node)
+	assert.Equal(t, "a", resp.Records[1].Node)
+	assert.Equal(t, "bob", resp.Records[2].Subject)
+	assert.Contains(t, resp.Errors, "b")
+
+	resp, err = s.ListAuditRecords(as("alice"), &orchestratorv1.ListAuditRecordsRequest{Subject: "alice", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Empty(t, resp.Records[0].Node)
+
+	resp, err = s.ListAuditRecords(as("alice"), &orchestratorv1.ListAuditRecordsRequest{Node: "a", Until: 200})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, "bob", resp.Records[0].Subject)
+	assert.Empty(t, resp.Errors)
+
+	_, err = s.ListAuditRecords(as("alice"), &orchestratorv1.ListAuditRecordsRequest{Node: "c"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Only cluster admins may read the log.
+	policy, err := rbac.NewPolicy([]*settingsv1.RoleBinding{
+		{Subject: "alice", Role: settingsv1.Role_ROLE_ADMIN},
+		{Subject: "bob", Role: settingsv1.Role_ROLE_ADMIN, Nodes: []string{"a"}},
+	}, nil)
+	require.NoError(t, err)
+	s.policy = policy
+	_, err = s.ListAuditRecords(as("alice"), &orchestratorv1.ListAuditRecordsRequest{})
+	require.NoError(t, err)
+	_, err = s.ListAuditRecords(as("bob"), &orchestratorv1.ListAuditRecordsRequest{Node: "a"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
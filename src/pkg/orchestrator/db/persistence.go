@@ -0,0 +1,266 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v4"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/orchestrator"
+)
+
+const (
+	dnsRecordPrefix      = "dnsrecord:"
+	overlayNetworkPrefix = "overlay:"
+	nodePrefix           = "node:"
+	joinTokenPrefix      = "jointoken:"
+	cordonPrefix         = "cordon:"
+	apiTokenPrefix       = "apitoken:"
+)
+
+type databaseImpl struct {
+	db *badger.DB
+}
+
+// put stores v as JSON under prefix+key.
+func (d *databaseImpl) put(prefix, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(prefix+key), data)
+	})
+}
+
+// get decodes the value under prefix+key into v. Missing keys yield an
+// error wrapping orchestrator.ErrNotFound, described by kind.
+func (d *databaseImpl) get(prefix, key, kind string, v any) error {
+	err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(prefix + key))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, v)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return fmt.Errorf("%s %s: %w", kind, key, orchestrator.ErrNotFound)
+	}
+	return err
+}
+
+// list decodes every value under prefix.
+func list[T any](d *databaseImpl, prefix string) ([]*T, error) {
+	var result []*T
+	err := d.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		p := []byte(prefix)
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var v T
+				if err := json.Unmarshal(val, &v); err != nil {
+					return err
+				}
+				result = append(result, &v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// remove deletes prefix+key. Missing keys yield an error wrapping
+// orchestrator.ErrNotFound, described by kind.
+func (d *databaseImpl) remove(prefix, key, kind string) error {
+	k := []byte(prefix + key)
+	err := d.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(k); err != nil {
+			return err
+		}
+		return txn.Delete(k)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return fmt.Errorf("%s %s: %w", kind, key, orchestrator.ErrNotFound)
+	}
+	return err
+}
+
+// dnsRecordKey keys a record by name, type and value, so that a name can
+// hold several A/AAAA records. Names and values never contain a slash.
+func dnsRecordKey(record *settingsv1.DnsRecord) string {
+	return record.GetName() + "/" + record.GetType().String() + "/" + record.GetValue()
+}
+
+func (d *databaseImpl) PutDNSRecord(record *settingsv1.DnsRecord) error {
+	if record.GetName() == "" {
+		return errors.New("record name is required")
+	}
+	return d.put(dnsRecordPrefix, dnsRecordKey(record), record)
+}
+
+func (d *databaseImpl) ReplaceDNSRecord(old, record *settingsv1.DnsRecord) error {
+	if record.GetName() == "" {
+		return errors.New("record name is required")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(txn *badger.Txn) error {
+		if oldKey := dnsRecordKey(old); oldKey != dnsRecordKey(record) {
+			if err := txn.Delete([]byte(dnsRecordPrefix + oldKey)); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte(dnsRecordPrefix+dnsRecordKey(record)), data)
+	})
+}
+
+func (d *databaseImpl) GetDNSRecords(name string) ([]*settingsv1.DnsRecord, error) {
+	return list[settingsv1.DnsRecord](d, dnsRecordPrefix+name+"/")
+}
+
+func (d *databaseImpl) ListDNSRecords() ([]*settingsv1.DnsRecord, error) {
+	return list[settingsv1.DnsRecord](d, dnsRecordPrefix)
+}
+
+func (d *databaseImpl) RemoveDNSRecord(record *settingsv1.DnsRecord) error {
+	return d.remove(dnsRecordPrefix, dnsRecordKey(record), "dns record")
+}
+
+func (d *databaseImpl) PutOverlayNetwork(network *settingsv1.OverlayNetwork) error {
+	if network.GetName() == "" {
+		return errors.New("overlay network name is required")
+	}
+	return d.put(overlayNetworkPrefix, network.Name, network)
+}
+
+func (d *databaseImpl) GetOverlayNetwork(name string) (*settingsv1.OverlayNetwork, error) {
+	var network settingsv1.OverlayNetwork
+	if err := d.get(overlayNetworkPrefix, name, "overlay network", &network); err != nil {
+		return nil, err
+	}
+	return &network, nil
+}
+
+func (d *databaseImpl) ListOverlayNetworks() ([]*settingsv1.OverlayNetwork, error) {
+	return list[settingsv1.OverlayNetwork](d, overlayNetworkPrefix)
+}
+
+func (d *databaseImpl) RemoveOverlayNetwork(name string) error {
+	return d.remove(overlayNetworkPrefix, name, "overlay network")
+}
+
+func (d *databaseImpl) PutNode(node *settingsv1.Node) error {
+	if node.GetName() == "" {
+		return errors.New("node name is required")
+	}
+	return d.put(nodePrefix, node.Name, node)
+}
+
+func (d *databaseImpl) GetNode(name string) (*settingsv1.Node, error) {
+	var node settingsv1.Node
+	if err := d.get(nodePrefix, name, "node", &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (d *databaseImpl) ListNodes() ([]*settingsv1.Node, error) {
+	return list[settingsv1.Node](d, nodePrefix)
+}
+
+func (d *databaseImpl) RemoveNode(name string) error {
+	return d.remove(nodePrefix, name, "node")
+}
+
+func (d *databaseImpl) PutCordon(cordon *settingsv1.Cordon) error {
+	if cordon.GetNode() == "" {
+		return errors.New("cordon node is required")
+	}
+	return d.put(cordonPrefix, cordon.Node, cordon)
+}
+
+func (d *databaseImpl) GetCordon(node string) (*settingsv1.Cordon, error) {
+	var cordon settingsv1.Cordon
+	if err := d.get(cordonPrefix, node, "cordon", &cordon); err != nil {
+		return nil, err
+	}
+	return &cordon, nil
+}
+
+func (d *databaseImpl) ListCordons() ([]*settingsv1.Cordon, error) {
+	return list[settingsv1.Cordon](d, cordonPrefix)
+}
+
+func (d *databaseImpl) RemoveCordon(node string) error {
+	return d.remove(cordonPrefix, node, "cordon")
+}
+
+func (d *databaseImpl) PutJoinToken(token *settingsv1.JoinToken) error {
+	if token.GetId() == "" {
+		return errors.New("join token id is required")
+	}
+	return d.put(joinTokenPrefix, token.Id, token)
+}
+
+func (d *databaseImpl) GetJoinToken(id string) (*settingsv1.JoinToken, error) {
+	var token settingsv1.JoinToken
+	if err := d.get(joinTokenPrefix, id, "join token", &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (d *databaseImpl) ListJoinTokens() ([]*settingsv1.JoinToken, error) {
+	return list[settingsv1.JoinToken](d, joinTokenPrefix)
+}
+
+func (d *databaseImpl) RemoveJoinToken(id string) error {
+	return d.remove(joinTokenPrefix, id, "join token")
+}
+
+func (d *databaseImpl) PutAPIToken(token *settingsv1.ApiToken) error {
+	if token.GetId() == "" {
+		return errors.New("api token id is required")
+	}
+	return d.put(apiTokenPrefix, token.Id, token)
+}
+
+func (d *databaseImpl) GetAPIToken(id string) (*settingsv1.ApiToken, error) {
+	var token settingsv1.ApiToken
+	if err := d.get(apiTokenPrefix, id, "api token", &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (d *databaseImpl) ListAPITokens() ([]*settingsv1.ApiToken, error) {
+	return list[settingsv1.ApiToken](d, apiTokenPrefix)
+}
+
+func (d *databaseImpl) RemoveAPIToken(id string) error {
+	return d.remove(apiTokenPrefix, id, "api token")
+}
+
+func NewDatabase(path string) (orchestrator.State, error) {
+	opts := badger.DefaultOptions(filepath.Clean(path))
+	opts.Logger = nil // Disable badger logging
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &databaseImpl{db: db}, nil
+}
@@ -0,0 +1,329 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/orchestrator"
+)
+
+func tempDB(t *testing.T) (*databaseImpl, func()) {
+	dir := t.TempDir()
+	opts := badger.DefaultOptions(filepath.Join(dir, "badger"))
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatalf("failed to open badger: %v", err)
+	}
+	return &databaseImpl{db: db}, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestPutAndGetDNSRecords(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	rec := &settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.5", Ttl: 60}
+	if err := d.PutDNSRecord(rec); err != nil {
+		t.Fatalf("PutDNSRecord failed: %v", err)
+	}
+	got, err := d.GetDNSRecords("db.test.zone.")
+	if err != nil {
+		t.Fatalf("GetDNSRecords failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Value != "10.0.0.5" || got[0].Type != settingsv1.DnsRecord_TYPE_A {
+		t.Errorf("unexpected records: %v", got)
+	}
+	if got, _ := d.GetDNSRecords("test.zone."); len(got) != 0 {
+		t.Errorf("expected no records for the parent name, got %v", got)
+	}
+}
+
+func TestPutDNSRecordKeepsOtherValues(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	_ = d.PutDNSRecord(&settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.5"})
+	_ = d.PutDNSRecord(&settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.6"})
+	_ = d.PutDNSRecord(&settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.6", Ttl: 30})
+
+	list, err := d.GetDNSRecords("db.test.zone.")
+	if err != nil {
+		t.Fatalf("GetDNSRecords failed: %v", err)
+	}
+	if len(list) != 2 || list[1].Ttl != 30 {
+		t.Errorf("expected two A records, the second updated, got %v", list)
+	}
+}
+
+func TestListDNSRecords(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	for _, name := range []string{"a.test.zone.", "b.test.zone.", "c.test.zone."} {
+		if err := d.PutDNSRecord(&settingsv1.DnsRecord{Name: name, Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.1"}); err != nil {
+			t.Fatalf("PutDNSRecord failed: %v", err)
+		}
+	}
+	list, err := d.ListDNSRecords()
+	if err != nil {
+		t.Fatalf("ListDNSRecords failed: %v", err)
+	}
+	if len(list) != 3 {
+		t.Errorf("expected 3 records, got %d", len(list))
+	}
+}
+
+func TestRemoveDNSRecord(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	first := &settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.5"}
+	_ = d.PutDNSRecord(first)
+	_ = d.PutDNSRecord(&settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.6"})
+	if err := d.RemoveDNSRecord(first); err != nil {
+		t.Fatalf("RemoveDNSRecord failed: %v", err)
+	}
+	if list, _ := d.GetDNSRecords("db.test.zone."); len(list) != 1 || list[0].Value != "10.0.0.6" {
+		t.Errorf("expected only the other record to be left, got %v", list)
+	}
+	if err := d.RemoveDNSRecord(first); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound on second remove, got %v", err)
+	}
+}
+
+func TestReplaceDNSRecord(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	old := &settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.5"}
+	_ = d.PutDNSRecord(old)
+	if err := d.ReplaceDNSRecord(old, &settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.7"}); err != nil {
+		t.Fatalf("ReplaceDNSRecord failed: %v", err)
+	}
+	if list, _ := d.GetDNSRecords("db.test.zone."); len(list) != 1 || list[0].Value != "10.0.0.7" {
+		t.Errorf("expected only the new record, got %v", list)
+	}
+
+	// Replacing a record with itself only updates it.
+	same := &settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.7", Ttl: 30}
+	if err := d.ReplaceDNSRecord(same, same); err != nil {
+		t.Fatalf("ReplaceDNSRecord failed: %v", err)
+	}
+	if list, _ := d.GetDNSRecords("db.test.zone."); len(list) != 1 || list[0].Ttl != 30 {
+		t.Errorf("expected the updated record, got %v", list)
+	}
+}
+
+func TestOverlayNetworks(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	for i, name := range []string{"blue", "green"} {
+		if err := d.PutOverlayNetwork(&settingsv1.OverlayNetwork{Name: name, Vni: uint32(1000 + i), Subnet: "10.10.0.0/24"}); err != nil {
+			t.Fatalf("PutOverlayNetwork failed: %v", err)
+		}
+	}
+
+	got, err := d.GetOverlayNetwork("green")
+	if err != nil {
+		t.Fatalf("GetOverlayNetwork failed: %v", err)
+	}
+	if got.Vni != 1001 || got.Subnet != "10.10.0.0/24" {
+		t.Errorf("unexpected overlay network: %v", got)
+	}
+
+	list, err := d.ListOverlayNetworks()
+	if err != nil {
+		t.Fatalf("ListOverlayNetworks failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("expected 2 overlay networks, got %d", len(list))
+	}
+
+	if err := d.RemoveOverlayNetwork("blue"); err != nil {
+		t.Fatalf("RemoveOverlayNetwork failed: %v", err)
+	}
+	if _, err := d.GetOverlayNetwork("blue"); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := d.RemoveOverlayNetwork("blue"); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound on second remove, got %v", err)
+	}
+}
+
+func TestOverlayNetworksDontMixWithDNSRecords(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	_ = d.PutDNSRecord(&settingsv1.DnsRecord{Name: "db.test.zone.", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.5"})
+	_ = d.PutOverlayNetwork(&settingsv1.OverlayNetwork{Name: "blue", Vni: 1000})
+
+	records, _ := d.ListDNSRecords()
+	networks, _ := d.ListOverlayNetworks()
+	if len(records) != 1 || len(networks) != 1 {
+		t.Errorf("expected 1 record and 1 network, got %d and %d", len(records), len(networks))
+	}
+}
+
+func TestNodes(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	node := &settingsv1.Node{
+		Name:                 "node2",
+		Endpoint:             "10.0.0.2:8008",
+		FileRegistryEndpoint: "10.0.0.2:8009",
+		EventsEndpoint:       "10.0.0.2:8010",
+		ControllerTls:        &settingsv1.TLSConfig{Ca: "/etc/qcontroller/ca.pem"},
+		Capacity:             &settingsv1.VM{Cpus: 8},
+	}
+	if err := d.PutNode(node); err != nil {
+		t.Fatalf("PutNode failed: %v", err)
+	}
+	if err := d.PutNode(&settingsv1.Node{}); err == nil {
+		t.Error("expected error for node without name")
+	}
+
+	got, err := d.GetNode("node2")
+	if err != nil {
+		t.Fatalf("GetNode failed: %v", err)
+	}
+	if got.Endpoint != node.Endpoint || got.ControllerTls.GetCa() != node.ControllerTls.Ca || got.Capacity.GetCpus() != 8 {
+		t.Errorf("unexpected node: %v", got)
+	}
+
+	list, err := d.ListNodes()
+	if err != nil {
+		t.Fatalf("ListNodes failed: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("expected 1 node, got %d", len(list))
+	}
+
+	if err := d.RemoveNode("node2"); err != nil {
+		t.Fatalf("RemoveNode failed: %v", err)
+	}
+	if _, err := d.GetNode("node2"); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestJoinTokens(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	token := &settingsv1.JoinToken{Id: "abc123", SecretHash: []byte{1, 2, 3}, ExpiresAt: 1700000000}
+	if err := d.PutJoinToken(token); err != nil {
+		t.Fatalf("PutJoinToken failed: %v", err)
+	}
+	if err := d.PutJoinToken(&settingsv1.JoinToken{}); err == nil {
+		t.Error("expected error for token without id")
+	}
+
+	got, err := d.GetJoinToken("abc123")
+	if err != nil {
+		t.Fatalf("GetJoinToken failed: %v", err)
+	}
+	if string(got.SecretHash) != string(token.SecretHash) || got.ExpiresAt != token.ExpiresAt {
+		t.Errorf("unexpected token: %v", got)
+	}
+
+	list, err := d.ListJoinTokens()
+	if err != nil {
+		t.Fatalf("ListJoinTokens failed: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("expected 1 token, got %d", len(list))
+	}
+
+	if err := d.RemoveJoinToken("abc123"); err != nil {
+		t.Fatalf("RemoveJoinToken failed: %v", err)
+	}
+	if err := d.RemoveJoinToken("abc123"); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCordons(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	if err := d.PutCordon(&settingsv1.Cordon{Node: "node1", Reason: "kernel update", Since: 1700000000}); err != nil {
+		t.Fatalf("PutCordon failed: %v", err)
+	}
+	if err := d.PutCordon(&settingsv1.Cordon{}); err == nil {
+		t.Error("expected error for cordon without node")
+	}
+
+	got, err := d.GetCordon("node1")
+	if err != nil {
+		t.Fatalf("GetCordon failed: %v", err)
+	}
+	if got.Reason != "kernel update" || got.Since != 1700000000 {
+		t.Errorf("unexpected cordon: %v", got)
+	}
+
+	list, err := d.ListCordons()
+	if err != nil {
+		t.Fatalf("ListCordons failed: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("expected 1 cordon, got %d", len(list))
+	}
+
+	if err := d.RemoveCordon("node1"); err != nil {
+		t.Fatalf("RemoveCordon failed: %v", err)
+	}
+	if _, err := d.GetCordon("node1"); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	d, cleanup := tempDB(t)
+	defer cleanup()
+
+	token := &settingsv1.ApiToken{
+		Id:         "abc123",
+		SecretHash: []byte{1, 2, 3},
+		Subject:    "alice",
+		Scopes:     []settingsv1.ApiToken_Scope{settingsv1.ApiToken_SCOPE_READ},
+		ExpiresAt:  1700000000,
+	}
+	if err := d.PutAPIToken(token); err != nil {
+		t.Fatalf("PutAPIToken failed: %v", err)
+	}
+	if err := d.PutAPIToken(&settingsv1.ApiToken{}); err == nil {
+		t.Error("expected error for token without id")
+	}
+
+	got, err := d.GetAPIToken("abc123")
+	if err != nil {
+		t.Fatalf("GetAPIToken failed: %v", err)
+	}
+	if string(got.SecretHash) != string(token.SecretHash) || got.Subject != "alice" || len(got.Scopes) != 1 {
+		t.Errorf("unexpected token: %v", got)
+	}
+
+	list, err := d.ListAPITokens()
+	if err != nil {
+		t.Fatalf("ListAPITokens failed: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("expected 1 token, got %d", len(list))
+	}
+
+	if err := d.RemoveAPIToken("abc123"); err != nil {
+		t.Fatalf("RemoveAPIToken failed: %v", err)
+	}
+	if _, err := d.GetAPIToken("abc123"); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
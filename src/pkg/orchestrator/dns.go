@@ -0,0 +1,230 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// normalizeDNSRecord converts, normalizes and validates an API record
+// against the configured zone. The zone is required: nodes reject the whole
+// record set if a single record falls outside theirs, so accepting any name
+// here would let one record block every node's DNS sync.
+func (s *Server) normalizeDNSRecord(record *settingsv1.DnsRecord) (*settingsv1.DnsRecord, error) {
+	if dns.Fqdn(s.dnsZone) == "." {
+		return nil, status.Errorf(codes.FailedPrecondition, "dns_zone must be set to the nodes' zone to manage dns records")
+	}
+	if record == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "record is required")
+	}
+	rec := dns.Normalize(dns.FromProto(record))
+	if err := dns.Validate(rec, s.dnsZone); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record: %v", err)
+	}
+	return dns.ToProto(rec), nil
+}
+
+// sameDNSValue reports whether two record values are the same address or
+// name, however they're written.
+func sameDNSValue(a, b string) bool {
+	if ipA, ipB := net.ParseIP(strings.TrimSpace(a)), net.ParseIP(strings.TrimSpace(b)); ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return dns.Fqdn(a) == dns.Fqdn(b)
+}
+
+// selectDNSRecords returns the records of name matching typ and value. An
+// unspecified typ or empty value matches any.
+func (s *Server) selectDNSRecords(name string, typ settingsv1.DnsRecord_Type, value string) ([]*settingsv1.DnsRecord, error) {
+	records, err := s.state.GetDNSRecords(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get dns records: %v", err)
+	}
+	return slices.DeleteFunc(records, func(r *settingsv1.DnsRecord) bool {
+		return typ != settingsv1.DnsRecord_TYPE_UNSPECIFIED && r.Type != typ ||
+			value != "" && !sameDNSValue(r.Value, value)
+	}), nil
+}
+
+// selectDNSRecord is selectDNSRecords for exactly one record.
+func (s *Server) selectDNSRecord(name string, typ settingsv1.DnsRecord_Type, value string) (*settingsv1.DnsRecord, error) {
+	records, err := s.selectDNSRecords(name, typ, value)
+	if err != nil {
+		return nil, err
+	}
+	switch len(records) {
+	case 0:
+		return nil, status.Errorf(codes.NotFound, "dns record %s not found", name)
+	case 1:
+		return records[0], nil
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "%s has %d dns records, pick one with type and value", name, len(records))
+	}
+}
+
+// checkDNSConflicts checks record against the other records of its name: a
+// name holds either a single CNAME or any number of distinct A/AAAA
+// records.
+func checkDNSConflicts(record *settingsv1.DnsRecord, others []*settingsv1.DnsRecord) error {
+	for _, other := range others {
+		switch {
+		case other.Type == record.Type && sameDNSValue(other.Value, record.Value):
+			return status.Errorf(codes.AlreadyExists, "dns record %s %s %s already exists", record.Name, record.Type, record.Value)
+		case other.Type == settingsv1.DnsRecord_TYPE_CNAME:
+			return status.Errorf(codes.AlreadyExists, "%s is already a CNAME", record.Name)
+		case record.Type == settingsv1.DnsRecord_TYPE_CNAME:
+			return status.Errorf(codes.AlreadyExists, "%s already has records, a CNAME can't share its name", record.Name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) CreateDnsRecord(ctx context.Context, req *orchestratorv1.CreateDnsRecordRequest) (*settingsv1.DnsRecord, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	record, err := s.normalizeDNSRecord(req.Record)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dnsMu.Lock()
+	defer s.dnsMu.Unlock()
+	others, selectErr := s.selectDNSRecords(record.Name, settingsv1.DnsRecord_TYPE_UNSPECIFIED, "")
+	if selectErr != nil {
+		return nil, selectErr
+	}
+	if conflictErr := checkDNSConflicts(record, others); conflictErr != nil {
+		return nil, conflictErr
+	}
+
+	if putErr := s.state.PutDNSRecord(record); putErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store dns record: %v", putErr)
+	}
+	s.pushDNSRecords(ctx)
+
+	return record, nil
+}
+
+func (s *Server) GetDnsRecord(ctx context.Context, req *orchestratorv1.GetDnsRecordRequest) (*settingsv1.DnsRecord, error) {
+	if authErr := s.authorizeAnywhere(ctx, viewer); authErr != nil {
+		return nil, authErr
+	}
+	return s.selectDNSRecord(dns.Fqdn(req.Name), req.Type, req.Value)
+}
+
+func (s *Server) UpdateDnsRecord(ctx context.Context, req *orchestratorv1.UpdateDnsRecordRequest) (*settingsv1.DnsRecord, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	name := dns.Fqdn(req.Name)
+	if req.Record != nil && req.Record.Name == "" {
+		req.Record.Name = name
+	}
+	record, err := s.normalizeDNSRecord(req.Record)
+	if err != nil {
+		return nil, err
+	}
+	if record.Name != name {
+		return nil, status.Errorf(codes.InvalidArgument, "record name %s does not match %s", record.Name, name)
+	}
+
+	s.dnsMu.Lock()
+	defer s.dnsMu.Unlock()
+	old, selectErr := s.selectDNSRecord(name, req.Type, req.Value)
+	if selectErr != nil {
+		return nil, selectErr
+	}
+	others, selectErr := s.selectDNSRecords(name, settingsv1.DnsRecord_TYPE_UNSPECIFIED, "")
+	if selectErr != nil {
+		return nil, selectErr
+	}
+	others = slices.DeleteFunc(others, func(r *settingsv1.DnsRecord) bool {
+		return r.Type == old.Type && r.Value == old.Value
+	})
+	if conflictErr := checkDNSConflicts(record, others); conflictErr != nil {
+		return nil, conflictErr
+	}
+
+	if putErr := s.state.ReplaceDNSRecord(old, record); putErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store dns record: %v", putErr)
+	}
+	s.pushDNSRecords(ctx)
+
+	return record, nil
+}
+
+func (s *Server) DeleteDnsRecord(ctx context.Context, req *orchestratorv1.DeleteDnsRecordRequest) (*emptypb.Empty, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	s.dnsMu.Lock()
+	defer s.dnsMu.Unlock()
+	records, err := s.selectDNSRecords(dns.Fqdn(req.Name), req.Type, req.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, status.Errorf(codes.NotFound, "dns record %s not found", req.Name)
+	}
+	for _, record := range records {
+		if rmErr := s.state.RemoveDNSRecord(record); rmErr != nil && !errors.Is(rmErr, ErrNotFound) {
+			return nil, status.Errorf(codes.Internal, "failed to remove dns record: %v", rmErr)
+		}
+	}
+	s.pushDNSRecords(ctx)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ListDnsRecords(ctx context.Context, _ *emptypb.Empty) (*orchestratorv1.ListDnsRecordsResponse, error) {
+	if authErr := s.authorizeAnywhere(ctx, viewer); authErr != nil {
+		return nil, authErr
+	}
+	records, err := s.state.ListDNSRecords()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list dns records: %v", err)
+	}
+	return &orchestratorv1.ListDnsRecordsResponse{Records: records}, nil
+}
+
+// pushDNSRecords syncs the records to every node in the background so the
+// API call doesn't wait on slow or unreachable nodes.
+func (s *Server) pushDNSRecords(ctx context.Context) {
+	go func() {
+		asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
+		defer cancel()
+		s.syncDNSRecords(asyncCtx)
+	}()
+}
+
+// syncDNSRecords sends the full record set to every node. The list is read
+// under dnsSyncMu so concurrent syncs can't deliver an older snapshot after
+// a newer one.
+func (s *Server) syncDNSRecords(ctx context.Context) {
+	s.dnsSyncMu.Lock()
+	defer s.dnsSyncMu.Unlock()
+
+	records, err := s.state.ListDNSRecords()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list dns records for sync", "error", err)
+		return
+	}
+	for name, nm := range s.nodeManagers() {
+		if setErr := nm.SetDNSRecords(ctx, records); setErr != nil {
+			slog.WarnContext(ctx, "Failed to push dns records", "node", name, "error", setErr)
+		}
+	}
+}
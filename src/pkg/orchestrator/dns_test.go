@@ -0,0 +1,78 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDNSRecordsNeedZone(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode()})
+	ctx := context.Background()
+	record := &settingsv1.DnsRecord{Name: "db.example.com", Type: settingsv1.DnsRecord_TYPE_A, Value: "10.0.0.5"}
+
+	_, err := s.CreateDnsRecord(ctx, &orchestratorv1.CreateDnsRecordRequest{Record: record})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	s.dnsZone = "test.zone"
+	_, err = s.CreateDnsRecord(ctx, &orchestratorv1.CreateDnsRecordRequest{Record: record})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestDNSRecordsPerName(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode()})
+	s.dnsZone = "test.zone"
+	ctx := context.Background()
+	create := func(typ settingsv1.DnsRecord_Type, value string) error {
+		_, err := s.CreateDnsRecord(ctx, &orchestratorv1.CreateDnsRecordRequest{Record: &settingsv1.DnsRecord{
+			Name: "db.test.zone", Type: typ, Value: value,
+		}})
+		return err
+	}
+	a, aaaa, cname := settingsv1.DnsRecord_TYPE_A, settingsv1.DnsRecord_TYPE_AAAA, settingsv1.DnsRecord_TYPE_CNAME
+
+	require.NoError(t, create(a, "10.0.0.5"))
+	require.NoError(t, create(a, "10.0.0.6"))
+	require.NoError(t, create(aaaa, "fd00::5"))
+	assert.Equal(t, codes.AlreadyExists, status.Code(create(a, "10.0.0.6")))
+	assert.Equal(t, codes.AlreadyExists, status.Code(create(aaaa, "fd00:0::5")))
+	assert.Equal(t, codes.AlreadyExists, status.Code(create(cname, "vm1.test.zone")))
+
+	_, err := s.GetDnsRecord(ctx, &orchestratorv1.GetDnsRecordRequest{Name: "db.test.zone"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	got, err := s.GetDnsRecord(ctx, &orchestratorv1.GetDnsRecordRequest{Name: "db.test.zone", Type: aaaa})
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::5", got.Value)
+
+	_, err = s.UpdateDnsRecord(ctx, &orchestratorv1.UpdateDnsRecordRequest{
+		Name: "db.test.zone", Type: a, Value: "10.0.0.6",
+		Record: &settingsv1.DnsRecord{Type: a, Value: "10.0.0.5"},
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = s.UpdateDnsRecord(ctx, &orchestratorv1.UpdateDnsRecordRequest{
+		Name: "db.test.zone", Type: a, Value: "10.0.0.6",
+		Record: &settingsv1.DnsRecord{Type: a, Value: "10.0.0.7"},
+	})
+	require.NoError(t, err)
+	_, err = s.GetDnsRecord(ctx, &orchestratorv1.GetDnsRecordRequest{Name: "db.test.zone", Type: a, Value: "10.0.0.6"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.DeleteDnsRecord(ctx, &orchestratorv1.DeleteDnsRecordRequest{Name: "db.test.zone", Type: a})
+	require.NoError(t, err)
+	list, err := s.ListDnsRecords(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, list.Records, 1)
+	assert.Equal(t, aaaa, list.Records[0].Type)
+
+	_, err = s.DeleteDnsRecord(ctx, &orchestratorv1.DeleteDnsRecordRequest{Name: "db.test.zone"})
+	require.NoError(t, err)
+	_, err = s.DeleteDnsRecord(ctx, &orchestratorv1.DeleteDnsRecordRequest{Name: "db.test.zone"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
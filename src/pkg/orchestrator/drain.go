@@ -0,0 +1,441 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Variables so tests can shorten them.
+var (
+	// drainPollInterval is how often a stopping instance's state is checked.
+	drainPollInterval = time.Second
+	// drainStopTimeout is how long an instance gets to shut down before it's
+	// stopped forcibly, and then again to go down after that.
+	drainStopTimeout = 2 * time.Minute
+)
+
+// cordons tracks the cordoned nodes, mirroring State.
+type cordons struct {
+	mu     sync.RWMutex
+	byNode map[string]*settingsv1.Cordon
+}
+
+func (c *cordons) get(node string) *settingsv1.Cordon {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byNode[node]
+}
+
+func (c *cordons) set(cordon *settingsv1.Cordon) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byNode == nil {
+		c.byNode = make(map[string]*settingsv1.Cordon)
+	}
+	c.byNode[cordon.Node] = cordon
+}
+
+func (c *cordons) remove(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byNode, node)
+}
+
+func (c *cordons) snapshot() map[string]*settingsv1.Cordon {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]*settingsv1.Cordon, len(c.byNode))
+	for name, cordon := range c.byNode {
+		out[name] = proto.CloneOf(cordon)
+	}
+	return out
+}
+
+// loadCordons restores the cordons kept in State.
+func (s *Server) loadCordons() error {
+	stored, err := s.state.ListCordons()
+	if err != nil {
+		return fmt.Errorf("failed to list cordons: %w", err)
+	}
+	for _, cordon := range stored {
+		s.cordons.set(cordon)
+	}
+	return nil
+}
+
+// checkSchedulable fails with FailedPrecondition if the node is cordoned.
+func (s *Server) checkSchedulable(nodeName string) error {
+	if cordon := s.cordons.get(nodeName); cordon != nil {
+		if cordon.Reason != "" {
+			return status.Errorf(codes.FailedPrecondition, "node %s is cordoned: %s", nodeName, cordon.Reason)
+		}
+		return status.Errorf(codes.FailedPrecondition, "node %s is cordoned", nodeName)
+	}
+	return nil
+}
+
+func (s *Server) cordon(ctx context.Context, nodeName, reason string) (*settingsv1.Cordon, error) {
+	if existing := s.cordons.get(nodeName); existing != nil && reason == "" {
+		return proto.CloneOf(existing), nil
+	}
+	cordon := &settingsv1.Cordon{Node: nodeName, Reason: reason, Since: time.Now().Unix()}
+	if existing := s.cordons.get(nodeName); existing != nil {
+		cordon.Since = existing.Since
+	}
+	if err := s.state.PutCordon(cordon); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store cordon: %v", err)
+	}
+	s.cordons.set(cordon)
+	slog.InfoContext(ctx, "Cordoned node", "node", nodeName, "reason", reason)
+	return proto.CloneOf(cordon), nil
+}
+
+// uncordon drops the node's cordon, if any.
+func (s *Server) uncordon(nodeName string) error {
+	if err := s.state.RemoveCordon(nodeName); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	s.cordons.remove(nodeName)
+	return nil
+}
+
+func (s *Server) CordonNode(ctx context.Context, req *orchestratorv1.CordonNodeRequest) (*settingsv1.Cordon, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{Node: req.Name}); authErr != nil {
+		return nil, authErr
+	}
+	if _, _, err := s.getNode(req.Name); err != nil {
+		return nil, err
+	}
+	return s.cordon(ctx, req.Name, req.Reason)
+}
+
+func (s *Server) UncordonNode(ctx context.Context, req *orchestratorv1.UncordonNodeRequest) (*emptypb.Empty, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{Node: req.Name}); authErr != nil {
+		return nil, authErr
+	}
+	if _, _, err := s.getNode(req.Name); err != nil {
+		return nil, err
+	}
+	if s.drains.running(req.Name) {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is being drained", req.Name)
+	}
+	if err := s.uncordon(req.Name); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove cordon: %v", err)
+	}
+	slog.InfoContext(ctx, "Uncordoned node", "node", req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+// drains keeps each node's current or last drain report.
+type drains struct {
+	mu      sync.Mutex
+	reports map[string]*settingsv1.DrainReport
+}
+
+// start begins a drain of node, unless one is already running.
+func (d *drains) start(node string, total int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r, ok := d.reports[node]; ok && r.FinishedAt == 0 {
+		return false
+	}
+	if d.reports == nil {
+		d.reports = make(map[string]*settingsv1.DrainReport)
+	}
+	d.reports[node] = &settingsv1.DrainReport{Node: node, StartedAt: time.Now().Unix(), Total: uint32(total)}
+	return true
+}
+
+func (d *drains) running(node string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.reports[node]
+	return ok && r.FinishedAt == 0
+}
+
+// record adds result to node's report and returns how many instances have
+// been handled.
+func (d *drains) record(node string, result *settingsv1.DrainResult) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.reports[node]
+	r.Results = append(r.Results, result)
+	return len(r.Results)
+}
+
+// finish completes node's report and returns it.
+func (d *drains) finish(node string) *settingsv1.DrainReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.reports[node]
+	r.FinishedAt = time.Now().Unix()
+	return proto.CloneOf(r)
+}
+
+func (d *drains) get(node string) *settingsv1.DrainReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r, ok := d.reports[node]; ok {
+		return proto.CloneOf(r)
+	}
+	return nil
+}
+
+func (d *drains) remove(node string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.reports, node)
+}
+
+// DrainNode cordons the node and, in the background, handles each of its
+// instances according to the policy. Progress is reported as progress
+// events on the node and the outcome as a DrainEvent.
+func (s *Server) DrainNode(ctx context.Context, req *orchestratorv1.DrainNodeRequest) (*emptypb.Empty, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{Node: req.Name}); authErr != nil {
+		return nil, authErr
+	}
+	_, nm, err := s.getNode(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if req.TargetNode != "" && req.Policy != orchestratorv1.DrainNodeRequest_POLICY_STOP {
+		if req.TargetNode == req.Name {
+			return nil, status.Errorf(codes.InvalidArgument, "can't drain node %s onto itself", req.Name)
+		}
+		if _, _, targetErr := s.getNode(req.TargetNode); targetErr != nil {
+			return nil, targetErr
+		}
+		if authErr := s.authorize(ctx, admin, rbac.Resource{Node: req.TargetNode}); authErr != nil {
+			return nil, authErr
+		}
+		if cordonErr := s.checkSchedulable(req.TargetNode); cordonErr != nil {
+			return nil, cordonErr
+		}
+	}
+
+	infos, infoErr := nm.Info(ctx, node.AllProjects, "")
+	if infoErr != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to list instances on %s: %v", req.Name, infoErr)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.GetSpec().GetProject() != b.GetSpec().GetProject() {
+			return a.GetSpec().GetProject() < b.GetSpec().GetProject()
+		}
+		return a.Name < b.Name
+	})
+
+	if !s.drains.start(req.Name, len(infos)) {
+		return nil, status.Errorf(codes.Aborted, "node %s is already being drained", req.Name)
+	}
+	if _, cordonErr := s.cordon(ctx, req.Name, ""); cordonErr != nil {
+		s.drains.finish(req.Name)
+		return nil, cordonErr
+	}
+	slog.InfoContext(ctx, "Draining node", "node", req.Name, "instances", len(infos), "policy", req.Policy)
+
+	go func() {
+		asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
+		defer cancel()
+		s.drain(asyncCtx, req, nm, infos)
+	}()
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) drain(ctx context.Context, req *orchestratorv1.DrainNodeRequest, nm node.Manager, infos []*controllerv1.Info) {
+	progress := func(done int) {
+		percent := int32(100)
+		if len(infos) > 0 {
+			percent = int32(done * 100 / len(infos))
+		}
+		s.broadcaster.Send(&orchestratorv1.Event{
+			Node: req.Name,
+			Update: &eventv1.Update{
+				Payload: &eventv1.Update_ProgressEvent{
+					ProgressEvent: &eventv1.ProgressEvent{
+						Resource: "node:" + req.Name,
+						Message:  fmt.Sprintf("Draining node (%d/%d)", done, len(infos)),
+						Percent:  percent,
+					},
+				},
+			},
+		})
+	}
+	progress(0)
+
+	for _, info := range infos {
+		result := s.drainInstance(ctx, req, nm, info)
+		if result.Action == settingsv1.DrainResult_ACTION_FAILED {
+			slog.ErrorContext(ctx, "Failed to drain instance", "node", req.Name, "project", info.GetSpec().GetProject(), "name", info.Name, "error", result.Error)
+		}
+		progress(s.drains.record(req.Name, result))
+	}
+
+	report := s.drains.finish(req.Name)
+	slog.InfoContext(ctx, "Drained node", "node", req.Name, "instances", report.Total)
+	s.broadcaster.Send(&orchestratorv1.Event{
+		Node: req.Name,
+		Update: &eventv1.Update{
+			Payload: &eventv1.Update_DrainEvent{
+				DrainEvent: &eventv1.DrainEvent{Report: report},
+			},
+		},
+	})
+}
+
+func (s *Server) drainInstance(ctx context.Context, req *orchestratorv1.DrainNodeRequest, nm node.Manager, info *controllerv1.Info) *settingsv1.DrainResult {
+	project := info.GetSpec().GetProject()
+	result := &settingsv1.DrainResult{Instance: info.Name, Project: project}
+	fail := func(err error) *settingsv1.DrainResult {
+		result.Action = settingsv1.DrainResult_ACTION_FAILED
+		result.Error = err.Error()
+		return result
+	}
+	running := info.GetStatus().GetState() != vmv1.State_STATE_STOPPED.String()
+
+	if req.Policy == orchestratorv1.DrainNodeRequest_POLICY_STOP {
+		if !running {
+			result.Action = settingsv1.DrainResult_ACTION_LEFT
+			return result
+		}
+		if err := s.stopInstance(ctx, nm, req.Name, project, info.Name); err != nil {
+			return fail(err)
+		}
+		result.Action = settingsv1.DrainResult_ACTION_STOPPED
+		return result
+	}
+
+	target, release, targetErr := s.drainTarget(ctx, req.TargetNode, info.Spec)
+	if targetErr != nil {
+		return fail(targetErr)
+	}
+	defer release()
+	result.TargetNode = target
+
+	_, dst, err := s.getNode(target)
+	if err != nil {
+		return fail(err)
+	}
+	if running {
+		if stopErr := s.stopInstance(ctx, nm, req.Name, project, info.Name); stopErr != nil {
+			return fail(stopErr)
+		}
+	}
+	ref := instanceRef(project, info.Name)
+	keys := []string{req.Name + "/" + ref, target + "/" + ref}
+	if !s.moves.start(keys...) {
+		return fail(fmt.Errorf("instance %s is already being moved", ref))
+	}
+	moveErr := s.move(ctx, &orchestratorv1.MoveRequest{Node: req.Name, Project: project, Name: info.Name, TargetNode: target}, info.Spec, nm, dst)
+	s.moves.finish(keys...)
+	if moveErr != nil {
+		if running {
+			// Better running on the drained node than not at all.
+			if startErr := nm.Start(ctx, project, info.Name); startErr != nil {
+				slog.WarnContext(ctx, "Failed to restart instance after failed move", "node", req.Name, "project", project, "name", info.Name, "error", startErr)
+			}
+		}
+		return fail(moveErr)
+	}
+	if running {
+		if startErr := dst.Start(ctx, project, info.Name); startErr != nil {
+			return fail(fmt.Errorf("moved to %s but failed to start: %w", target, startErr))
+		}
+	}
+	result.Action = settingsv1.DrainResult_ACTION_MOVED
+	return result
+}
+
+// drainTarget returns target if set and it meets spec's placement rules,
+// or the scheduler's pick for spec among the nodes the caller administers.
+// The drained node is cordoned, so the scheduler won't pick it. The
+// instance counts as placed on the returned node until release is called,
+// which should be once it's moved there.
+func (s *Server) drainTarget(ctx context.Context, target string, spec *controllerv1.VMSpec) (string, func(), error) {
+	if target != "" {
+		release, err := s.placeOn(ctx, target, spec)
+		if err != nil {
+			return "", nil, err
+		}
+		return target, release, nil
+	}
+	picked, release, err := s.schedule(ctx, spec, func(c *Candidate) bool {
+		return s.allowed(ctx, admin, rbac.Resource{Node: c.Name})
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to pick a node: %w", err)
+	}
+	return picked, release, nil
+}
+
+// stopInstance shuts the instance down and waits until it's stopped,
+// forcing it off if it doesn't go down within drainStopTimeout.
+func (s *Server) stopInstance(ctx context.Context, nm node.Manager, nodeName, project, name string) error {
+	if err := nm.Stop(ctx, project, name, false); err != nil {
+		return fmt.Errorf("failed to stop: %w", err)
+	}
+	if waitErr := waitStopped(ctx, nm, nodeName, project, name); waitErr == nil || ctx.Err() != nil {
+		return waitErr
+	}
+	slog.WarnContext(ctx, "Instance didn't shut down in time, forcing it off", "node", nodeName, "project", project, "name", name)
+	if err := nm.Stop(ctx, project, name, true); err != nil {
+		return fmt.Errorf("failed to force stop: %w", err)
+	}
+	return waitStopped(ctx, nm, nodeName, project, name)
+}
+
+func waitStopped(ctx context.Context, nm node.Manager, nodeName, project, name string) error {
+	timeout := time.NewTimer(drainStopTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		info, err := findInstance(ctx, nodeName, nm, project, name)
+		if err != nil {
+			return err
+		}
+		if info.GetStatus().GetState() == vmv1.State_STATE_STOPPED.String() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("instance %s didn't stop within %s", instanceRef(project, name), drainStopTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) GetDrain(ctx context.Context, req *orchestratorv1.GetDrainRequest) (*settingsv1.DrainReport, error) {
+	if authErr := s.authorize(ctx, viewer, rbac.Resource{Node: req.Name}); authErr != nil {
+		return nil, authErr
+	}
+	if _, _, err := s.getNode(req.Name); err != nil {
+		return nil, err
+	}
+	report := s.drains.get(req.Name)
+	if report == nil {
+		return nil, status.Errorf(codes.NotFound, "node %s hasn't been drained", req.Name)
+	}
+	return report, nil
+}
@@ -0,0 +1,253 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func shortDrainTimeouts(t *testing.T) {
+	interval, timeout := drainPollInterval, drainStopTimeout
+	drainPollInterval, drainStopTimeout = time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { drainPollInterval, drainStopTimeout = interval, timeout })
+}
+
+func runningInstance(name, mac string) *controllerv1.Info {
+	info := instanceWithMAC(name, mac)
+	info.Status.State = "STATE_RUNNING"
+	return info
+}
+
+// waitForDrain waits for the node's drain to finish and returns its report.
+func waitForDrain(t *testing.T, s *Server, nodeName string) *settingsv1.DrainReport {
+	t.Helper()
+	var report *settingsv1.DrainReport
+	require.Eventually(t, func() bool {
+		report = s.drains.get(nodeName)
+		return report != nil && report.FinishedAt != 0
+	}, 5*time.Second, 5*time.Millisecond)
+	return report
+}
+
+func drainReports(b *Broadcaster) []*settingsv1.DrainReport {
+	var out []*settingsv1.DrainReport
+	for _, ev := range drainEvents(b) {
+		if de := ev.GetUpdate().GetDrainEvent(); de != nil {
+			out = append(out, de.Report)
+		}
+	}
+	return out
+}
+
+func TestCordon(t *testing.T) {
+	a, b := newFakeNode(), newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"))
+	s := newTestServer(map[string]node.Manager{"a": a, "b": b})
+	ctx := context.Background()
+
+	_, err := s.CordonNode(ctx, &orchestratorv1.CordonNodeRequest{Name: "x"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	cordon, err := s.CordonNode(ctx, &orchestratorv1.CordonNodeRequest{Name: "b", Reason: "kernel update"})
+	require.NoError(t, err)
+	assert.Equal(t, "kernel update", cordon.Reason)
+	assert.NotZero(t, cordon.Since)
+
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: "b", Name: "vm2", Spec: specWith(1, 512, 5)})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "kernel update")
+	_, err = s.Start(ctx, &orchestratorv1.StartRequest{Node: "b", Name: "vm1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	resp, err := s.Create(ctx, &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm2", Spec: specWith(1, 512, 5)})
+	require.NoError(t, err)
+	assert.Equal(t, "a", resp.Node)
+	_, err = s.Move(ctx, &orchestratorv1.MoveRequest{Node: "a", Name: "vm2", TargetNode: "b"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	list, err := s.ListNodes(ctx, nil)
+	require.NoError(t, err)
+	require.Contains(t, list.Cordons, "b")
+	assert.NotContains(t, list.Cordons, "a")
+	stored, err := s.state.ListCordons()
+	require.NoError(t, err)
+	assert.Len(t, stored, 1)
+
+	// Cordoning again updates the reason but keeps the time.
+	again, err := s.CordonNode(ctx, &orchestratorv1.CordonNodeRequest{Name: "b", Reason: "disk swap"})
+	require.NoError(t, err)
+	assert.Equal(t, "disk swap", again.Reason)
+	assert.Equal(t, cordon.Since, again.Since)
+
+	_, err = s.UncordonNode(ctx, &orchestratorv1.UncordonNodeRequest{Name: "b"})
+	require.NoError(t, err)
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: "b", Name: "vm3", Spec: specWith(1, 512, 5)})
+	assert.NoError(t, err)
+	stored, err = s.state.ListCordons()
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestDrainNode_Validation(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": newFakeNode(), "c": newFakeNode()})
+	ctx := context.Background()
+	_, err := s.CordonNode(ctx, &orchestratorv1.CordonNodeRequest{Name: "c"})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		req  *orchestratorv1.DrainNodeRequest
+		code codes.Code
+	}{
+		{&orchestratorv1.DrainNodeRequest{Name: "x"}, codes.NotFound},
+		{&orchestratorv1.DrainNodeRequest{Name: "a", TargetNode: "a"}, codes.InvalidArgument},
+		{&orchestratorv1.DrainNodeRequest{Name: "a", TargetNode: "x"}, codes.NotFound},
+		{&orchestratorv1.DrainNodeRequest{Name: "a", TargetNode: "c"}, codes.FailedPrecondition},
+	} {
+		_, err := s.DrainNode(ctx, tc.req)
+		assert.Equal(t, tc.code, status.Code(err), "%v", tc.req)
+	}
+
+	_, err = s.GetDrain(ctx, &orchestratorv1.GetDrainRequest{Name: "a"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestDrainNode_MovesInstances(t *testing.T) {
+	shortDrainTimeouts(t)
+	src := newFakeNode(runningInstance("vm1", "52:54:00:00:00:01"), instanceWithMAC("vm2", "52:54:00:00:00:02"))
+	src.disks["vm1"] = []byte("disk")
+	dst := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": src, "b": dst})
+	ctx := context.Background()
+
+	_, err := s.DrainNode(ctx, &orchestratorv1.DrainNodeRequest{Name: "a"})
+	require.NoError(t, err)
+	report := waitForDrain(t, s, "a")
+
+	assert.EqualValues(t, 2, report.Total)
+	require.Len(t, report.Results, 2)
+	for _, r := range report.Results {
+		assert.Equal(t, settingsv1.DrainResult_ACTION_MOVED, r.Action, r.Instance)
+		assert.Equal(t, "b", r.TargetNode)
+	}
+	assert.False(t, src.has("vm1"))
+	assert.False(t, src.has("vm2"))
+	assert.Equal(t, "STATE_RUNNING", dst.state("vm1"))
+	assert.Equal(t, "STATE_STOPPED", dst.state("vm2"))
+	assert.Equal(t, []byte("disk"), dst.disks["vm1"])
+
+	assert.NotNil(t, s.cordons.get("a"), "drained node stays cordoned")
+	_, err = s.UncordonNode(ctx, &orchestratorv1.UncordonNodeRequest{Name: "a"})
+	assert.NoError(t, err)
+
+	reports := drainReports(s.broadcaster)
+	require.Len(t, reports, 1)
+	assert.Len(t, reports[0].Results, 2)
+
+	got, err := s.GetDrain(ctx, &orchestratorv1.GetDrainRequest{Name: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, report.FinishedAt, got.FinishedAt)
+}
+
+func TestDrainNode_SchedulesOnAdministeredNodes(t *testing.T) {
+	shortDrainTimeouts(t)
+	src := newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"), instanceWithMAC("vm2", "52:54:00:00:00:02"),
+		instanceWithMAC("vm3", "52:54:00:00:00:03"))
+	b, c := newFakeNode(), newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": src, "b": b, "c": c})
+	policy, err := rbac.NewPolicy([]*settingsv1.RoleBinding{
+		{Subject: "bob", Role: settingsv1.Role_ROLE_ADMIN, Nodes: []string{"a", "b"}},
+	}, nil)
+	require.NoError(t, err)
+	s.policy = policy
+
+	_, err = s.DrainNode(as("bob"), &orchestratorv1.DrainNodeRequest{Name: "a"})
+	require.NoError(t, err)
+	report := waitForDrain(t, s, "a")
+
+	require.Len(t, report.Results, 3)
+	for _, r := range report.Results {
+		assert.Equal(t, "b", r.TargetNode, r.Instance)
+	}
+	assert.Empty(t, c.instances)
+}
+
+func TestDrainTarget_RecordsPlacement(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"b": newFakeNode()})
+	s.nodeConfigs["b"].Capacity = &settingsv1.VM{Cpus: 2}
+	ctx := context.Background()
+
+	target, release, err := s.drainTarget(ctx, "", specWith(2, 512, 5))
+	require.NoError(t, err)
+	assert.Equal(t, "b", target)
+	// Until the first instance is moved, creates count it on the target.
+	_, _, _, err = s.resolveNode(ctx, AutoNode, "vm2", specWith(1, 512, 5))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	release()
+	_, _, releaseCreate, err := s.resolveNode(ctx, AutoNode, "vm2", specWith(1, 512, 5))
+	require.NoError(t, err)
+	releaseCreate()
+}
+
+func TestDrainNode_Stop(t *testing.T) {
+	shortDrainTimeouts(t)
+	src := newFakeNode(runningInstance("vm1", "52:54:00:00:00:01"), runningInstance("vm2", "52:54:00:00:00:02"), instanceWithMAC("vm3", "52:54:00:00:00:03"))
+	src.stubborn = map[string]bool{"vm2": true}
+	s := newTestServer(map[string]node.Manager{"a": src})
+
+	_, err := s.DrainNode(context.Background(), &orchestratorv1.DrainNodeRequest{Name: "a", Policy: orchestratorv1.DrainNodeRequest_POLICY_STOP})
+	require.NoError(t, err)
+	report := waitForDrain(t, s, "a")
+
+	actions := map[string]settingsv1.DrainResult_Action{}
+	for _, r := range report.Results {
+		actions[r.Instance] = r.Action
+	}
+	assert.Equal(t, map[string]settingsv1.DrainResult_Action{
+		"vm1": settingsv1.DrainResult_ACTION_STOPPED,
+		"vm2": settingsv1.DrainResult_ACTION_STOPPED, // forced off
+		"vm3": settingsv1.DrainResult_ACTION_LEFT,
+	}, actions)
+	for _, name := range []string{"vm1", "vm2", "vm3"} {
+		assert.Equal(t, "STATE_STOPPED", src.state(name), name)
+	}
+}
+
+func TestDrainNode_Failures(t *testing.T) {
+	shortDrainTimeouts(t)
+	src := newFakeNode(runningInstance("vm1", "52:54:00:00:00:01"))
+	dst := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": src, "b": dst})
+	ctx := context.Background()
+
+	// A failed move restarts the instance where it was.
+	dst.importErr = errors.New("disk full")
+	src.disks["vm1"] = []byte("disk")
+	_, err := s.DrainNode(ctx, &orchestratorv1.DrainNodeRequest{Name: "a", Policy: orchestratorv1.DrainNodeRequest_POLICY_MOVE})
+	require.NoError(t, err)
+	report := waitForDrain(t, s, "a")
+	require.Len(t, report.Results, 1)
+	assert.Equal(t, settingsv1.DrainResult_ACTION_FAILED, report.Results[0].Action)
+	assert.Contains(t, report.Results[0].Error, "disk full")
+	assert.Equal(t, "STATE_RUNNING", src.state("vm1"))
+	assert.False(t, dst.has("vm1"))
+
+	// No node to go to.
+	_, err = s.CordonNode(ctx, &orchestratorv1.CordonNodeRequest{Name: "b"})
+	require.NoError(t, err)
+	_, err = s.DrainNode(ctx, &orchestratorv1.DrainNodeRequest{Name: "a"})
+	require.NoError(t, err)
+	report = waitForDrain(t, s, "a")
+	require.Len(t, report.Results, 1)
+	assert.Equal(t, settingsv1.DrainResult_ACTION_FAILED, report.Results[0].Action)
+	assert.True(t, src.has("vm1"))
+}
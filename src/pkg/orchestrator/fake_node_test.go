@@ -0,0 +1,428 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeNode is an in-memory node.Manager. Instances are keyed by their
+// instanceRef. Methods the tests don't need panic via the embedded nil
+// interface.
+type fakeNode struct {
+	node.Manager
+	mu        sync.Mutex
+	instances map[string]*controllerv1.Info
+	disks     map[string][]byte
+	importErr error
+	host      *settingsv1.HostResources
+	down      bool
+	closed    bool
+	// stubborn instances ignore graceful stops.
+	stubborn map[string]bool
+	startErr error
+	audit    []*settingsv1.AuditRecord
+}
+
+func newFakeNode(instances ...*controllerv1.Info) *fakeNode {
+	n := &fakeNode{
+		instances: make(map[string]*controllerv1.Info),
+		disks:     make(map[string][]byte),
+	}
+	for _, info := range instances {
+		n.instances[instanceRef(info.GetSpec().GetProject(), info.Name)] = info
+	}
+	return n
+}
+
+var errNodeDown = errors.New("node is down")
+
+func (n *fakeNode) Endpoint() string { return "fake:0" }
+
+func (n *fakeNode) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+}
+
+func (n *fakeNode) isClosed() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closed
+}
+
+func (n *fakeNode) SetDNSRecords(context.Context, []*settingsv1.DnsRecord) error { return nil }
+
+func (n *fakeNode) SetOverlayNetworks(context.Context, []*settingsv1.OverlayNetwork, []string) error {
+	return nil
+}
+
+func (n *fakeNode) Create(_ context.Context, name string, spec *controllerv1.VMSpec) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return errNodeDown
+	}
+	n.instances[instanceRef(spec.GetProject(), name)] = &controllerv1.Info{
+		Name:   name,
+		Spec:   spec,
+		Status: &controllerv1.VMStatus{State: "STATE_STOPPED", Hwaddr: spec.GetMacAddress()},
+	}
+	return nil
+}
+
+func (n *fakeNode) Start(_ context.Context, project, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.startErr != nil {
+		return n.startErr
+	}
+	info, ok := n.instances[instanceRef(project, name)]
+	if !ok {
+		return status.Errorf(codes.NotFound, "instance %s not found", name)
+	}
+	info.Status = &controllerv1.VMStatus{State: "STATE_RUNNING", Hwaddr: info.GetStatus().GetHwaddr()}
+	return nil
+}
+
+func (n *fakeNode) Stop(_ context.Context, project, name string, force bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	info, ok := n.instances[instanceRef(project, name)]
+	if !ok {
+		return status.Errorf(codes.NotFound, "instance %s not found", name)
+	}
+	if !force && n.stubborn[instanceRef(project, name)] {
+		return nil
+	}
+	info.Status = &controllerv1.VMStatus{State: "STATE_STOPPED", Hwaddr: info.GetStatus().GetHwaddr()}
+	return nil
+}
+
+func (n *fakeNode) SetMetadata(_ context.Context, project, name string, labels, annotations map[string]string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return errNodeDown
+	}
+	info, ok := n.instances[instanceRef(project, name)]
+	if !ok {
+		return status.Errorf(codes.NotFound, "instance %s not found", name)
+	}
+	spec := proto.CloneOf(info.GetSpec())
+	if spec == nil {
+		spec = &controllerv1.VMSpec{}
+	}
+	spec.Labels, spec.Annotations = labels, annotations
+	info.Spec = spec
+	return nil
+}
+
+func (n *fakeNode) state(name string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.instances[name].GetStatus().GetState()
+}
+
+func (n *fakeNode) Info(_ context.Context, project, name string) ([]*controllerv1.Info, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return nil, errNodeDown
+	}
+	var out []*controllerv1.Info
+	for key, info := range n.instances {
+		switch {
+		case name != "":
+			if key != instanceRef(project, name) {
+				continue
+			}
+		case project != node.AllProjects && info.GetSpec().GetProject() != project:
+			continue
+		}
+		out = append(out, proto.CloneOf(info))
+	}
+	return out, nil
+}
+
+func (n *fakeNode) NodeInfo(_ context.Context) (*settingsv1.NodeInfo, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return nil, errNodeDown
+	}
+	info := &settingsv1.NodeInfo{
+		Host:      n.host,
+		Allocated: &settingsv1.VM{},
+		Instances: uint32(len(n.instances)),
+	}
+	for _, inst := range n.instances {
+		info.Allocated.Cpus += inst.GetSpec().GetVm().GetCpus()
+		info.Allocated.Memory += inst.GetSpec().GetVm().GetMemory()
+		info.Allocated.Disk += inst.GetSpec().GetVm().GetDisk()
+	}
+	return info, nil
+}
+
+func (n *fakeNode) ListAuditRecords(_ context.Context, q *settingsv1.AuditQuery) ([]*settingsv1.AuditRecord, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return nil, errNodeDown
+	}
+	var out []*settingsv1.AuditRecord
+	for _, record := range n.audit {
+		if audit.Matches(q, record) {
+			out = append(out, proto.CloneOf(record))
+		}
+	}
+	return out, nil
+}
+
+func (n *fakeNode) Remove(_ context.Context, project, name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down {
+		return errNodeDown
+	}
+	delete(n.instances, instanceRef(project, name))
+	delete(n.disks, instanceRef(project, name))
+	return nil
+}
+
+func (n *fakeNode) ExportDisk(_ context.Context, project, name string) (io.ReadCloser, int64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	disk, ok := n.disks[instanceRef(project, name)]
+	if !ok {
+		return nil, 0, status.Errorf(codes.NotFound, "instance %s has no disk", name)
+	}
+	return io.NopCloser(bytes.NewReader(disk)), int64(len(disk)), nil
+}
+
+func (n *fakeNode) ImportDisk(_ context.Context, project, name string, r io.Reader) error {
+	if n.importErr != nil {
+		return n.importErr
+	}
+	disk, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disks[instanceRef(project, name)] = disk
+	return nil
+}
+
+func (n *fakeNode) has(name string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.instances[name]
+	return ok
+}
+
+func instanceWithMAC(name, mac string) *controllerv1.Info {
+	return &controllerv1.Info{
+		Name:   name,
+		Spec:   &controllerv1.VMSpec{MacAddress: mac},
+		Status: &controllerv1.VMStatus{State: "STATE_STOPPED", Hwaddr: mac},
+	}
+}
+
+// newTestServer returns a Server over the given nodes, which count as
+// defined in the config, without starting its background loops or event
+// subscriptions.
+func newTestServer(nodes map[string]node.Manager) *Server {
+	bc := NewBroadcaster(100)
+	s := &Server{
+		stop:               make(chan struct{}),
+		broadcaster:        bc,
+		state:              newMemState(),
+		nodes:              nodes,
+		nodeConfigs:        make(map[string]*settingsv1.Node),
+		staticNodes:        make(map[string]bool),
+		nodeStops:          make(map[string]chan struct{}),
+		macPrefix:          []byte{0x52, 0x54, 0x00},
+		pendingMACs:        make(map[string]bool),
+		reportedCollisions: make(map[string]bool),
+		scheduler:          DefaultScheduler(),
+		health:             newHealthTracker(bc),
+	}
+	for name := range nodes {
+		s.nodeConfigs[name] = &settingsv1.Node{Name: name}
+		s.staticNodes[name] = true
+		s.health.add(name)
+	}
+	return s
+}
+
+// memState is an in-memory State. Only DNS records, nodes, cordons and join
+// tokens are implemented; the other methods panic via the embedded nil
+// interface.
+type memState struct {
+	State
+	mu      sync.Mutex
+	dns     map[string]*settingsv1.DnsRecord
+	nodes   map[string]*settingsv1.Node
+	cordons map[string]*settingsv1.Cordon
+	tokens  map[string]*settingsv1.JoinToken
+}
+
+func newMemState() *memState {
+	return &memState{
+		dns:     make(map[string]*settingsv1.DnsRecord),
+		nodes:   make(map[string]*settingsv1.Node),
+		cordons: make(map[string]*settingsv1.Cordon),
+		tokens:  make(map[string]*settingsv1.JoinToken),
+	}
+}
+
+func (m *memState) PutNode(n *settingsv1.Node) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[n.Name] = n
+	return nil
+}
+
+func dnsKey(record *settingsv1.DnsRecord) string {
+	return record.Name + "/" + record.Type.String() + "/" + record.Value
+}
+
+func (m *memState) PutDNSRecord(record *settingsv1.DnsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dns[dnsKey(record)] = proto.CloneOf(record)
+	return nil
+}
+
+func (m *memState) ReplaceDNSRecord(old, record *settingsv1.DnsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.dns, dnsKey(old))
+	m.dns[dnsKey(record)] = proto.CloneOf(record)
+	return nil
+}
+
+func (m *memState) GetDNSRecords(name string) ([]*settingsv1.DnsRecord, error) {
+	records, _ := m.ListDNSRecords()
+	return slices.DeleteFunc(records, func(r *settingsv1.DnsRecord) bool { return r.Name != name }), nil
+}
+
+func (m *memState) ListDNSRecords() ([]*settingsv1.DnsRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*settingsv1.DnsRecord, 0, len(m.dns))
+	for _, record := range m.dns {
+		out = append(out, proto.CloneOf(record))
+	}
+	slices.SortFunc(out, func(a, b *settingsv1.DnsRecord) int { return strings.Compare(dnsKey(a), dnsKey(b)) })
+	return out, nil
+}
+
+func (m *memState) RemoveDNSRecord(record *settingsv1.DnsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.dns[dnsKey(record)]; !ok {
+		return ErrNotFound
+	}
+	delete(m.dns, dnsKey(record))
+	return nil
+}
+
+func (m *memState) ListOverlayNetworks() ([]*settingsv1.OverlayNetwork, error) { return nil, nil }
+
+func (m *memState) ListNodes() ([]*settingsv1.Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*settingsv1.Node, 0, len(m.nodes))
+	for _, n := range m.nodes {
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func (m *memState) RemoveNode(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+func (m *memState) PutCordon(c *settingsv1.Cordon) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cordons[c.Node] = proto.CloneOf(c)
+	return nil
+}
+
+func (m *memState) ListCordons() ([]*settingsv1.Cordon, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*settingsv1.Cordon, 0, len(m.cordons))
+	for _, c := range m.cordons {
+		out = append(out, proto.CloneOf(c))
+	}
+	return out, nil
+}
+
+func (m *memState) RemoveCordon(node string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cordons[node]; !ok {
+		return ErrNotFound
+	}
+	delete(m.cordons, node)
+	return nil
+}
+
+func (m *memState) PutJoinToken(t *settingsv1.JoinToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.Id] = proto.CloneOf(t)
+	return nil
+}
+
+func (m *memState) GetJoinToken(id string) (*settingsv1.JoinToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.CloneOf(t), nil
+}
+
+func (m *memState) ListJoinTokens() ([]*settingsv1.JoinToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*settingsv1.JoinToken, 0, len(m.tokens))
+	for _, t := range m.tokens {
+		out = append(out, proto.CloneOf(t))
+	}
+	return out, nil
+}
+
+func (m *memState) RemoveJoinToken(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tokens[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.tokens, id)
+	return nil
+}
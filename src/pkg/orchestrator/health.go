@@ -0,0 +1,199 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// healthInterval is how often every node's services are probed.
+	healthInterval = 10 * time.Second
+	// probeTimeout bounds a single probe of a node's service.
+	probeTimeout = 5 * time.Second
+)
+
+// healthTracker holds each node's health and broadcasts a NodeEvent when a
+// node goes up or down.
+type healthTracker struct {
+	mu    sync.Mutex
+	nodes map[string]*nodeHealth
+	bc    *Broadcaster
+	now   func() time.Time
+}
+
+type nodeHealth struct {
+	health            *settingsv1.NodeHealth
+	controllerChecked bool
+	eventsChecked     bool
+	// reported is set once the node's up state has been broadcast.
+	reported bool
+}
+
+func newHealthTracker(bc *Broadcaster) *healthTracker {
+	return &healthTracker{
+		nodes: make(map[string]*nodeHealth),
+		bc:    bc,
+		now:   time.Now,
+	}
+}
+
+// add starts tracking the node. Updates for untracked nodes are ignored.
+func (h *healthTracker) add(node string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodes[node] = &nodeHealth{health: &settingsv1.NodeHealth{}}
+}
+
+// update applies fn to the node's health. The first time both the
+// controller and the event stream have been checked, and on every change
+// after that, the node's up state is broadcast.
+func (h *healthTracker) update(node string, fn func(*nodeHealth)) {
+	h.mu.Lock()
+	state, ok := h.nodes[node]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	health := state.health
+	wasUp := health.Up
+	fn(state)
+	health.Up = health.Controller && health.Events
+
+	var event *eventv1.NodeEvent
+	if state.controllerChecked && state.eventsChecked && (!state.reported || wasUp != health.Up) {
+		state.reported = true
+		eventType := eventv1.NodeEvent_EVENT_TYPE_DOWN
+		if health.Up {
+			eventType = eventv1.NodeEvent_EVENT_TYPE_UP
+		}
+		event = &eventv1.NodeEvent{Type: eventType, Health: proto.CloneOf(health)}
+	}
+	h.mu.Unlock()
+
+	if event == nil {
+		return
+	}
+	if event.Health.Up {
+		slog.Info("Node is up", "node", node)
+	} else {
+		slog.Warn("Node is down", "node", node, "error", event.Health.LastError)
+	}
+	h.bc.Send(&orchestratorv1.Event{
+		Node: node,
+		Update: &eventv1.Update{
+			Timestamp: h.now().Unix(),
+			Payload:   &eventv1.Update_NodeEvent{NodeEvent: event},
+		},
+	})
+}
+
+// controllerProbed records the outcome of a controller probe.
+func (h *healthTracker) controllerProbed(node string, err error) {
+	h.update(node, func(state *nodeHealth) {
+		health := state.health
+		state.controllerChecked = true
+		health.Controller = err == nil
+		if err != nil {
+			health.ConsecutiveFailures++
+			health.LastError = err.Error()
+			return
+		}
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+		health.LastSeen = h.now().Unix()
+	})
+}
+
+// fileRegistryProbed records the outcome of a file registry probe.
+func (h *healthTracker) fileRegistryProbed(node string, err error) {
+	h.update(node, func(state *nodeHealth) {
+		state.health.FileRegistry = err == nil
+	})
+}
+
+// eventsConnected records whether the node's event stream is connected.
+func (h *healthTracker) eventsConnected(node string, connected bool) {
+	h.update(node, func(state *nodeHealth) {
+		state.eventsChecked = true
+		state.health.Events = connected
+		if connected {
+			state.health.LastSeen = h.now().Unix()
+		}
+	})
+}
+
+// seen records contact with the node, e.g. an event received from it.
+func (h *healthTracker) seen(node string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if state, ok := h.nodes[node]; ok {
+		state.health.LastSeen = h.now().Unix()
+	}
+}
+
+// remove forgets the node.
+func (h *healthTracker) remove(node string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.nodes, node)
+}
+
+// snapshot returns a copy of every node's health.
+func (h *healthTracker) snapshot() map[string]*settingsv1.NodeHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]*settingsv1.NodeHealth, len(h.nodes))
+	for name, state := range h.nodes {
+		out[name] = proto.CloneOf(state.health)
+	}
+	return out
+}
+
+// fileRegistryProber is implemented by node managers that can check the
+// node's file registry.
+type fileRegistryProber interface {
+	probeFileRegistry(ctx context.Context) error
+}
+
+// probeNodes checks every node's controller and file registry in parallel.
+func (s *Server) probeNodes(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, nm := range s.nodeManagers() {
+		wg.Go(func() {
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			defer cancel()
+			_, infoErr := nm.Info(probeCtx, node.AllProjects, "")
+			s.health.controllerProbed(name, infoErr)
+			if fr, ok := nm.(fileRegistryProber); ok {
+				s.health.fileRegistryProbed(name, fr.probeFileRegistry(probeCtx))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func (s *Server) healthLoop() {
+	ctx, cancel := utils.AsyncCtx(context.Background(), s.stop)
+	defer cancel()
+
+	s.probeNodes(ctx)
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.probeNodes(ctx)
+		}
+	}
+}
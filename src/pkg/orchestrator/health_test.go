@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nodeEvents(b *Broadcaster) []*eventv1.NodeEvent {
+	var out []*eventv1.NodeEvent
+	for _, ev := range drainEvents(b) {
+		if e := ev.GetUpdate().GetNodeEvent(); e != nil {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestHealthTracker_ReportsTransitions(t *testing.T) {
+	bc := NewBroadcaster(100)
+	h := newHealthTracker(bc)
+	h.now = func() time.Time { return time.Unix(1000, 0) }
+	h.add("a")
+
+	// Untracked nodes are ignored.
+	h.controllerProbed("b", nil)
+	h.eventsConnected("b", true)
+	assert.NotContains(t, h.snapshot(), "b")
+
+	// Nothing is reported until both the controller and the event stream
+	// have been checked.
+	h.controllerProbed("a", nil)
+	assert.Empty(t, nodeEvents(bc))
+
+	h.eventsConnected("a", true)
+	events := nodeEvents(bc)
+	require.Len(t, events, 1)
+	assert.Equal(t, eventv1.NodeEvent_EVENT_TYPE_UP, events[0].Type)
+	assert.Equal(t, int64(1000), events[0].Health.LastSeen)
+
+	// Repeated successes don't repeat the event.
+	h.controllerProbed("a", nil)
+	assert.Empty(t, nodeEvents(bc))
+
+	h.controllerProbed("a", errors.New("connection refused"))
+	h.controllerProbed("a", errors.New("connection refused"))
+	events = nodeEvents(bc)
+	require.Len(t, events, 1)
+	assert.Equal(t, eventv1.NodeEvent_EVENT_TYPE_DOWN, events[0].Type)
+
+	health := h.snapshot()["a"]
+	assert.False(t, health.Up)
+	assert.False(t, health.Controller)
+	assert.True(t, health.Events)
+	assert.Equal(t, uint32(2), health.ConsecutiveFailures)
+	assert.Equal(t, "connection refused", health.LastError)
+
+	h.controllerProbed("a", nil)
+	events = nodeEvents(bc)
+	require.Len(t, events, 1)
+	assert.Equal(t, eventv1.NodeEvent_EVENT_TYPE_UP, events[0].Type)
+	assert.Zero(t, h.snapshot()["a"].ConsecutiveFailures)
+
+	h.eventsConnected("a", false)
+	events = nodeEvents(bc)
+	require.Len(t, events, 1)
+	assert.Equal(t, eventv1.NodeEvent_EVENT_TYPE_DOWN, events[0].Type)
+}
+
+func TestProbeNodes(t *testing.T) {
+	down := newFakeNode()
+	down.down = true
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": down})
+	s.health.eventsConnected("a", true)
+	s.health.eventsConnected("b", true)
+
+	s.probeNodes(context.Background())
+
+	resp, err := s.ListNodes(context.Background(), nil)
+	require.NoError(t, err)
+	require.Contains(t, resp.Health, "a")
+	require.Contains(t, resp.Health, "b")
+	assert.True(t, resp.Health["a"].Up)
+	assert.False(t, resp.Health["b"].Up)
+	assert.Equal(t, uint32(1), resp.Health["b"].ConsecutiveFailures)
+}
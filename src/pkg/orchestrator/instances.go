@@ -0,0 +1,163 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
+	"github.com/q-controller/qcontroller/src/pkg/labels"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// listTimeout bounds how long listing instances waits for each node, so a
+// hanging node doesn't hold up the others' results.
+const listTimeout = 10 * time.Second
+
+// instanceFilter is a parsed ListInstancesRequest.
+type instanceFilter struct {
+	state      string
+	image      string
+	addr       netip.Addr
+	prefix     netip.Prefix
+	namePrefix string
+	selector   labels.Selector
+	project    string
+}
+
+func parseInstanceFilter(req *orchestratorv1.ListInstancesRequest) (*instanceFilter, error) {
+	selector, selectorErr := parseSelector(req.LabelSelector)
+	if selectorErr != nil {
+		return nil, selectorErr
+	}
+	f := &instanceFilter{image: req.Image, namePrefix: req.NamePrefix, selector: selector, project: req.Project}
+	if req.State != "" {
+		state := strings.ToUpper(req.State)
+		if !strings.HasPrefix(state, "STATE_") {
+			state = "STATE_" + state
+		}
+		if _, ok := vmv1.State_value[state]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown state %q", req.State)
+		}
+		f.state = state
+	}
+	if req.IpAddress != "" {
+		if strings.Contains(req.IpAddress, "/") {
+			prefix, err := netip.ParsePrefix(req.IpAddress)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid ipAddress %q: %v", req.IpAddress, err)
+			}
+			f.prefix = prefix.Masked()
+		} else {
+			addr, err := netip.ParseAddr(req.IpAddress)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid ipAddress %q: %v", req.IpAddress, err)
+			}
+			f.addr = addr
+		}
+	}
+	return f, nil
+}
+
+func (f *instanceFilter) match(info *controllerv1.Info) bool {
+	switch {
+	case f.state != "" && info.GetStatus().GetState() != f.state:
+		return false
+	case f.image != "" && info.GetSpec().GetImage() != f.image:
+		return false
+	case !strings.HasPrefix(info.GetName(), f.namePrefix):
+		return false
+	case f.project != "" && info.GetSpec().GetProject() != f.project:
+		return false
+	case !f.selector.Matches(info.GetSpec().GetLabels()):
+		return false
+	case f.addr.IsValid() || f.prefix.IsValid():
+		return f.matchAddress(info.GetStatus().GetRuntimeInfo().GetIpaddresses())
+	}
+	return true
+}
+
+// matchAddress reports whether any of addrs, which may carry a prefix
+// length, is the filter's address or inside its prefix.
+func (f *instanceFilter) matchAddress(addrs []string) bool {
+	for _, a := range addrs {
+		host, _, _ := strings.Cut(a, "/")
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		if f.addr.IsValid() && addr == f.addr.Unmap() {
+			return true
+		}
+		if f.prefix.IsValid() && f.prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListInstances queries every node concurrently and merges their matching
+// instances the caller may view. Nodes that fail are reported in errors
+// rather than failing the call.
+func (s *Server) ListInstances(ctx context.Context, req *orchestratorv1.ListInstancesRequest) (*orchestratorv1.ListInstancesResponse, error) {
+	filter, err := parseInstanceFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, nodeErrs := listInstances(ctx, s.nodeManagers())
+	resp := &orchestratorv1.ListInstancesResponse{Errors: nodeErrs}
+	for _, info := range instances {
+		if filter.match(info.Info) && s.allowed(ctx, viewer, instanceResource(info.Node, info.Info)) {
+			resp.Instances = append(resp.Instances, info)
+		}
+	}
+	return resp, nil
+}
+
+// listInstances lists the instances of nodes concurrently, ordered by node
+// and name, along with the errors of the nodes that couldn't be listed.
+func listInstances(ctx context.Context, nodes map[string]node.Manager) ([]*orchestratorv1.Info, map[string]string) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		instances []*orchestratorv1.Info
+		nodeErrs  = map[string]string{}
+	)
+	for name, nm := range nodes {
+		wg.Go(func() {
+			nodeCtx, cancel := context.WithTimeout(ctx, listTimeout)
+			defer cancel()
+			infos, infoErr := nm.Info(nodeCtx, node.AllProjects, "")
+			mu.Lock()
+			defer mu.Unlock()
+			if infoErr != nil {
+				slog.WarnContext(ctx, "Failed to list instances", "node", name, "error", infoErr)
+				nodeErrs[name] = infoErr.Error()
+				return
+			}
+			for _, info := range infos {
+				instances = append(instances, &orchestratorv1.Info{Node: name, Info: info})
+			}
+		})
+	}
+	wg.Wait()
+
+	sort.Slice(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.Info.GetName() < b.Info.GetName()
+	})
+	return instances, nodeErrs
+}
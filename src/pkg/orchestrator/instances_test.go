@@ -0,0 +1,82 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	runtimev1 "github.com/q-controller/qcontroller/src/generated/vm/runtime/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func listedInstance(name, state, image string, ips ...string) *controllerv1.Info {
+	return &controllerv1.Info{
+		Name: name,
+		Spec: &controllerv1.VMSpec{Image: image},
+		Status: &controllerv1.VMStatus{
+			State:       state,
+			RuntimeInfo: &runtimev1.RuntimeInfo{Name: name, Ipaddresses: ips},
+		},
+	}
+}
+
+func listedNames(resp *orchestratorv1.ListInstancesResponse) []string {
+	var out []string
+	for _, i := range resp.Instances {
+		out = append(out, i.Node+"/"+instanceRef(i.Info.GetSpec().GetProject(), i.Info.Name))
+	}
+	return out
+}
+
+func TestListInstances(t *testing.T) {
+	down := newFakeNode(listedInstance("db-2", "STATE_RUNNING", "ubuntu"))
+	down.down = true
+	s := newTestServer(map[string]node.Manager{
+		"a": newFakeNode(
+			listedInstance("web-1", "STATE_RUNNING", "ubuntu", "10.0.0.5/24", "fd00::5"),
+			listedInstance("db-1", "STATE_STOPPED", "debian"),
+		),
+		"b": newFakeNode(listedInstance("web-2", "STATE_RUNNING", "debian", "10.0.1.7")),
+		"c": down,
+	})
+	ctx := context.Background()
+
+	resp, err := s.ListInstances(ctx, &orchestratorv1.ListInstancesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/db-1", "a/web-1", "b/web-2"}, listedNames(resp))
+	require.Contains(t, resp.Errors, "c")
+	assert.Len(t, resp.Errors, 1)
+
+	for _, tc := range []struct {
+		req  *orchestratorv1.ListInstancesRequest
+		want []string
+	}{
+		{&orchestratorv1.ListInstancesRequest{State: "running"}, []string{"a/web-1", "b/web-2"}},
+		{&orchestratorv1.ListInstancesRequest{State: "STATE_STOPPED"}, []string{"a/db-1"}},
+		{&orchestratorv1.ListInstancesRequest{Image: "debian"}, []string{"a/db-1", "b/web-2"}},
+		{&orchestratorv1.ListInstancesRequest{NamePrefix: "web-"}, []string{"a/web-1", "b/web-2"}},
+		{&orchestratorv1.ListInstancesRequest{IpAddress: "10.0.0.5"}, []string{"a/web-1"}},
+		{&orchestratorv1.ListInstancesRequest{IpAddress: "fd00::5"}, []string{"a/web-1"}},
+		{&orchestratorv1.ListInstancesRequest{IpAddress: "10.0.0.0/16"}, []string{"a/web-1", "b/web-2"}},
+		{&orchestratorv1.ListInstancesRequest{IpAddress: "10.0.2.1"}, nil},
+		{&orchestratorv1.ListInstancesRequest{State: "running", Image: "debian", NamePrefix: "web"}, []string{"b/web-2"}},
+	} {
+		resp, err := s.ListInstances(ctx, tc.req)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, listedNames(resp), "%v", tc.req)
+	}
+
+	for _, req := range []*orchestratorv1.ListInstancesRequest{
+		{State: "bogus"},
+		{IpAddress: "not-an-ip"},
+		{IpAddress: "10.0.0.0/99"},
+	} {
+		_, err := s.ListInstances(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", req)
+	}
+}
@@ -0,0 +1,290 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// defaultJoinTokenTTL is how long join tokens are valid unless asked
+// otherwise.
+const defaultJoinTokenTTL = 24 * time.Hour
+
+var errInvalidJoinToken = status.Error(codes.Unauthenticated, "invalid join token")
+
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) CreateJoinToken(ctx context.Context, req *orchestratorv1.CreateJoinTokenRequest) (*orchestratorv1.CreateJoinTokenResponse, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	if s.ca == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "joining is disabled")
+	}
+	if req.TtlSeconds < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ttlSeconds must not be negative")
+	}
+	ttl := defaultJoinTokenTTL
+	if req.TtlSeconds > 0 {
+		ttl = time.Duration(req.TtlSeconds) * time.Second
+	}
+
+	id, idErr := randomHex(6)
+	if idErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate token: %v", idErr)
+	}
+	secret, secretErr := randomHex(16)
+	if secretErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate token: %v", secretErr)
+	}
+	token := &settingsv1.JoinToken{
+		Id:          id,
+		SecretHash:  hashSecret(secret),
+		Description: req.Description,
+		ExpiresAt:   time.Now().Add(ttl).Unix(),
+	}
+	if putErr := s.state.PutJoinToken(token); putErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store token: %v", putErr)
+	}
+	slog.InfoContext(ctx, "Created join token", "id", id, "expiresAt", time.Unix(token.ExpiresAt, 0))
+
+	listed := proto.CloneOf(token)
+	listed.SecretHash = nil
+	return &orchestratorv1.CreateJoinTokenResponse{
+		Token:      id + "." + secret,
+		JoinToken:  listed,
+		CaCertHash: s.ca.Hash(),
+	}, nil
+}
+
+// ListJoinTokens returns the pending tokens, dropping expired ones.
+func (s *Server) ListJoinTokens(ctx context.Context, _ *emptypb.Empty) (*orchestratorv1.ListJoinTokensResponse, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	tokens, err := s.state.ListJoinTokens()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list tokens: %v", err)
+	}
+	now := time.Now().Unix()
+	resp := &orchestratorv1.ListJoinTokensResponse{}
+	for _, token := range tokens {
+		if token.ExpiresAt <= now {
+			if rmErr := s.state.RemoveJoinToken(token.Id); rmErr != nil && !errors.Is(rmErr, ErrNotFound) {
+				slog.WarnContext(ctx, "Failed to remove expired join token", "id", token.Id, "error", rmErr)
+			}
+			continue
+		}
+		token.SecretHash = nil
+		resp.Tokens = append(resp.Tokens, token)
+	}
+	sort.Slice(resp.Tokens, func(i, j int) bool { return resp.Tokens[i].ExpiresAt < resp.Tokens[j].ExpiresAt })
+	return resp, nil
+}
+
+func (s *Server) DeleteJoinToken(ctx context.Context, req *orchestratorv1.DeleteJoinTokenRequest) (*emptypb.Empty, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	if err := s.state.RemoveJoinToken(req.Id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "join token %s not found", req.Id)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to remove token: %v", err)
+	}
+	slog.InfoContext(ctx, "Deleted join token", "id", req.Id)
+	return &emptypb.Empty{}, nil
+}
+
+// checkJoinToken returns the stored token matching the presented one.
+func (s *Server) checkJoinToken(presented string) (*settingsv1.JoinToken, error) {
+	id, secret, ok := strings.Cut(presented, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, errInvalidJoinToken
+	}
+	token, err := s.state.GetJoinToken(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errInvalidJoinToken
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read token: %v", err)
+	}
+	if subtle.ConstantTimeCompare(hashSecret(secret), token.SecretHash) != 1 {
+		return nil, errInvalidJoinToken
+	}
+	if time.Now().Unix() >= token.ExpiresAt {
+		return nil, status.Error(codes.Unauthenticated, "join token expired")
+	}
+	return token, nil
+}
+
+// endpointHost returns the host of a host:port endpoint.
+func endpointHost(endpoint string) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return host
+}
+
+// canonicalHost lowercases names and formats addresses uniformly, so that
+// spellings of the same host compare equal.
+func canonicalHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// isLoopbackHost reports whether host only reaches the local machine, which
+// every node's certificates may name.
+func isLoopbackHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return canonicalHost(host) == "localhost"
+}
+
+// certHosts are the names a node's service at endpoint is reached by: the
+// endpoint's host by the orchestrator, localhost by the node's other
+// services.
+func certHosts(endpoint string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host := endpointHost(endpoint); host != "" && !isLoopbackHost(host) {
+		hosts = append([]string{canonicalHost(host)}, hosts...)
+	}
+	return hosts
+}
+
+func nodeEndpoints(cfg *settingsv1.Node) []string {
+	return []string{cfg.Endpoint, cfg.FileRegistryEndpoint, cfg.EventsEndpoint}
+}
+
+// checkJoinHosts makes sure a joining node only gets certificates for
+// names of its own: plain hosts that neither the orchestrator nor another
+// node goes by.
+func (s *Server) checkJoinHosts(cfg *settingsv1.Node) error {
+	taken := make(map[string]string)
+	for _, host := range s.ownHosts {
+		taken[canonicalHost(host)] = "the orchestrator"
+	}
+	for _, other := range s.nodeList() {
+		for _, endpoint := range nodeEndpoints(other) {
+			taken[canonicalHost(endpointHost(endpoint))] = "node " + other.Name
+		}
+	}
+	for _, endpoint := range nodeEndpoints(cfg) {
+		host := endpointHost(endpoint)
+		if isLoopbackHost(host) {
+			continue
+		}
+		if net.ParseIP(host) == nil {
+			if err := dns.ValidateHostname(canonicalHost(host)); err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid host in endpoint %s: %v", endpoint, err)
+			}
+		}
+		if owner, ok := taken[canonicalHost(host)]; ok {
+			return status.Errorf(codes.AlreadyExists, "host %s is already used by %s", host, owner)
+		}
+	}
+	return nil
+}
+
+// Join enrolls a node: it consumes the token, issues server certificates
+// for the node's services and a client certificate they call each other
+// with, and registers the node, reached with the orchestrator's client
+// certificate.
+func (s *Server) Join(ctx context.Context, req *orchestratorv1.JoinRequest) (*orchestratorv1.JoinResponse, error) {
+	if s.ca == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "joining is disabled")
+	}
+	token, tokenErr := s.checkJoinToken(req.Token)
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+	if err := validateNode(req.Node); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid node: %v", err)
+	}
+	cfg := proto.CloneOf(req.Node)
+	cfg.ControllerTls = proto.CloneOf(s.clientTLS)
+	cfg.FileRegistryTls = proto.CloneOf(s.clientTLS)
+	cfg.EventsTls = proto.CloneOf(s.clientTLS)
+
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+	if _, _, err := s.getNode(cfg.Name); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "node %s already exists", cfg.Name)
+	}
+	if hostErr := s.checkJoinHosts(cfg); hostErr != nil {
+		return nil, hostErr
+	}
+
+	resp := &orchestratorv1.JoinResponse{CaCert: string(s.ca.CertPEM())}
+	for _, svc := range []struct {
+		name     string
+		endpoint string
+		csr      string
+		out      *string
+	}{
+		{"controller", cfg.Endpoint, req.ControllerCsr, &resp.ControllerCert},
+		{"fileregistry", cfg.FileRegistryEndpoint, req.FileRegistryCsr, &resp.FileRegistryCert},
+		{"eventservice", cfg.EventsEndpoint, req.EventsCsr, &resp.EventsCert},
+	} {
+		cert, signErr := s.ca.SignCSR([]byte(svc.csr), fmt.Sprintf("%s-%s", cfg.Name, svc.name), certHosts(svc.endpoint))
+		if signErr != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s certificate request: %v", svc.name, signErr)
+		}
+		*svc.out = string(cert)
+	}
+	clientCert, signErr := s.ca.SignClientCSR([]byte(req.ClientCsr), cfg.Name+"-client")
+	if signErr != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid client certificate request: %v", signErr)
+	}
+	resp.ClientCert = string(clientCert)
+
+	// Consuming the token first keeps two nodes from joining with it; it's
+	// put back if the node can't be added.
+	if rmErr := s.state.RemoveJoinToken(token.Id); rmErr != nil {
+		if errors.Is(rmErr, ErrNotFound) {
+			return nil, errInvalidJoinToken
+		}
+		return nil, status.Errorf(codes.Internal, "failed to consume token: %v", rmErr)
+	}
+	if addErr := s.addNode(ctx, cfg); addErr != nil {
+		if putErr := s.state.PutJoinToken(token); putErr != nil {
+			slog.WarnContext(ctx, "Failed to restore join token", "id", token.Id, "error", putErr)
+		}
+		return nil, addErr
+	}
+	slog.InfoContext(ctx, "Node joined", "node", cfg.Name, "token", token.Id)
+	return resp, nil
+}
@@ -0,0 +1,207 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/pki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newJoinTestServer(t *testing.T) (*Server, map[string]*fakeNode) {
+	s, connected := newNodesTestServer(t, map[string]node.Manager{"a": newFakeNode()})
+	ca, err := pki.LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+	clientTLS, err := ca.ClientTLS("orchestrator")
+	require.NoError(t, err)
+	s.ca, s.clientTLS = ca, clientTLS
+	return s, connected
+}
+
+func joinRequest(t *testing.T, token, name string) *orchestratorv1.JoinRequest {
+	csr := func() string {
+		key, _, err := pki.GenerateKey()
+		require.NoError(t, err)
+		req, err := pki.CreateCSR(key, name)
+		require.NoError(t, err)
+		return string(req)
+	}
+	return &orchestratorv1.JoinRequest{
+		Token: token,
+		Node: &settingsv1.Node{
+			Name:                 name,
+			Endpoint:             name + ".example:8008",
+			FileRegistryEndpoint: name + ".example:8009",
+			EventsEndpoint:       "10.0.0.9:8010",
+			ControllerTls:        &settingsv1.TLSConfig{Ca: "/ignored"},
+		},
+		ControllerCsr:   csr(),
+		FileRegistryCsr: csr(),
+		EventsCsr:       csr(),
+		ClientCsr:       csr(),
+	}
+}
+
+func TestJoin(t *testing.T) {
+	s, connected := newJoinTestServer(t)
+	ctx := context.Background()
+
+	created, err := s.CreateJoinToken(ctx, &orchestratorv1.CreateJoinTokenRequest{Description: "rack 2"})
+	require.NoError(t, err)
+	assert.Equal(t, s.ca.Hash(), created.CaCertHash)
+	assert.Empty(t, created.JoinToken.SecretHash)
+
+	resp, err := s.Join(ctx, joinRequest(t, created.Token, "b"))
+	require.NoError(t, err)
+	assert.Equal(t, string(s.ca.CertPEM()), resp.CaCert)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(s.ca.CertPEM())
+	for certPEM, host := range map[string]string{
+		resp.ControllerCert:   "b.example",
+		resp.FileRegistryCert: "b.example",
+		resp.EventsCert:       "10.0.0.9",
+	} {
+		cert, parseErr := pki.ParseCertificate([]byte(certPEM))
+		require.NoError(t, parseErr)
+		for _, name := range []string{host, "localhost"} {
+			_, verifyErr := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: name})
+			assert.NoError(t, verifyErr, "%s for %s", cert.Subject.CommonName, name)
+		}
+		// Server certificates can't call other services.
+		_, verifyErr := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		assert.Error(t, verifyErr, cert.Subject.CommonName)
+	}
+	client, err := pki.ParseCertificate([]byte(resp.ClientCert))
+	require.NoError(t, err)
+	assert.Equal(t, "b-client", client.Subject.CommonName)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, client.ExtKeyUsage)
+	assert.Empty(t, client.DNSNames)
+
+	cfg := s.nodeConfig("b")
+	require.NotNil(t, cfg)
+	assert.Equal(t, s.clientTLS.Cert, cfg.ControllerTls.GetCert())
+	assert.Equal(t, s.clientTLS.Ca, cfg.EventsTls.GetCa())
+	assert.Contains(t, connected, "b")
+
+	// The token is spent.
+	_, err = s.Join(ctx, joinRequest(t, created.Token, "c"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestJoinRejected(t *testing.T) {
+	s, _ := newJoinTestServer(t)
+	ctx := context.Background()
+
+	created, err := s.CreateJoinToken(ctx, &orchestratorv1.CreateJoinTokenRequest{})
+	require.NoError(t, err)
+	id := created.JoinToken.Id
+
+	for _, token := range []string{"", "nodot", id + ".wrong", "unknown.secret"} {
+		_, err = s.Join(ctx, joinRequest(t, token, "b"))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), token)
+	}
+
+	// Rejected joins don't spend the token.
+	_, err = s.Join(ctx, joinRequest(t, created.Token, "a"))
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	bad := joinRequest(t, created.Token, "b")
+	bad.EventsCsr = "garbage"
+	_, err = s.Join(ctx, bad)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.Join(ctx, joinRequest(t, created.Token, "b"))
+	assert.NoError(t, err)
+
+	require.NoError(t, s.state.PutJoinToken(&settingsv1.JoinToken{
+		Id:         "expired",
+		SecretHash: hashSecret("secret"),
+		ExpiresAt:  time.Now().Add(-time.Minute).Unix(),
+	}))
+	_, err = s.Join(ctx, joinRequest(t, "expired.secret", "c"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestJoinHosts(t *testing.T) {
+	s, _ := newJoinTestServer(t)
+	s.ownHosts = []string{"Orchestrator.example", "localhost", "10.0.0.1"}
+	ctx := context.Background()
+	token := func() string {
+		created, err := s.CreateJoinToken(ctx, &orchestratorv1.CreateJoinTokenRequest{})
+		require.NoError(t, err)
+		return created.Token
+	}
+
+	_, err := s.Join(ctx, joinRequest(t, token(), "b"))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		endpoint string
+		code     codes.Code
+	}{
+		{"B.example.:8008", codes.AlreadyExists},
+		{"10.0.0.9:8008", codes.AlreadyExists},
+		{"orchestrator.example:8008", codes.AlreadyExists},
+		{"[::ffff:10.0.0.1]:8008", codes.AlreadyExists},
+		{"*.example:8008", codes.InvalidArgument},
+		{":8008", codes.InvalidArgument},
+	} {
+		req := joinRequest(t, token(), "c")
+		req.Node.EventsEndpoint = "10.0.0.10:8010"
+		req.Node.FileRegistryEndpoint = tc.endpoint
+		_, err = s.Join(ctx, req)
+		assert.Equal(t, tc.code, status.Code(err), tc.endpoint)
+	}
+
+	// Every node's services are reached on localhost too.
+	req := joinRequest(t, token(), "c")
+	req.Node.FileRegistryEndpoint = "localhost:8009"
+	req.Node.EventsEndpoint = "[::1]:8010"
+	_, err = s.Join(ctx, req)
+	assert.NoError(t, err)
+}
+
+func TestJoinDisabled(t *testing.T) {
+	s, _ := newNodesTestServer(t, nil)
+	ctx := context.Background()
+
+	_, err := s.CreateJoinToken(ctx, &orchestratorv1.CreateJoinTokenRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = s.Join(ctx, joinRequest(t, "a.b", "b"))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestJoinTokens(t *testing.T) {
+	s, _ := newJoinTestServer(t)
+	ctx := context.Background()
+
+	_, err := s.CreateJoinToken(ctx, &orchestratorv1.CreateJoinTokenRequest{TtlSeconds: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := s.CreateJoinToken(ctx, &orchestratorv1.CreateJoinTokenRequest{TtlSeconds: 60})
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), created.JoinToken.ExpiresAt, 5)
+	require.NoError(t, s.state.PutJoinToken(&settingsv1.JoinToken{Id: "expired", ExpiresAt: time.Now().Unix() - 1}))
+
+	list, err := s.ListJoinTokens(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, list.Tokens, 1)
+	assert.Equal(t, created.JoinToken.Id, list.Tokens[0].Id)
+	assert.Empty(t, list.Tokens[0].SecretHash)
+	_, err = s.state.GetJoinToken("expired")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = s.DeleteJoinToken(ctx, &orchestratorv1.DeleteJoinTokenRequest{Id: created.JoinToken.Id})
+	require.NoError(t, err)
+	_, err = s.DeleteJoinToken(ctx, &orchestratorv1.DeleteJoinTokenRequest{Id: created.JoinToken.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.Join(ctx, joinRequest(t, created.Token, "b"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,52 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListLeases returns the DHCP leases of one node's network, or of every
+// node's the caller may view when req.Node is empty. Nodes that fail to
+// answer are skipped when listing all of them so one unreachable node
+// doesn't hide the rest.
+func (s *Server) ListLeases(ctx context.Context, req *orchestratorv1.ListLeasesRequest) (*orchestratorv1.ListLeasesResponse, error) {
+	if req.Node != "" {
+		nodeName, nm, err := s.getNode(req.Node)
+		if err != nil {
+			return nil, err
+		}
+		if authErr := s.authorize(ctx, viewer, rbac.Resource{Node: nodeName}); authErr != nil {
+			return nil, authErr
+		}
+		resp, listErr := nm.ListLeases(ctx)
+		if listErr != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list leases: %v", listErr)
+		}
+		return &orchestratorv1.ListLeasesResponse{
+			Networks: []*orchestratorv1.NetworkLeases{{Node: nodeName, Network: resp.Network, Leases: resp.Leases}},
+		}, nil
+	}
+
+	nodes := s.nodeManagers()
+	networks := make([]*orchestratorv1.NetworkLeases, 0, len(nodes))
+	for name, nm := range nodes {
+		if !s.allowed(ctx, viewer, rbac.Resource{Node: name}) {
+			continue
+		}
+		resp, listErr := nm.ListLeases(ctx)
+		if listErr != nil {
+			slog.WarnContext(ctx, "Failed to list leases", "node", name, "error", listErr)
+			continue
+		}
+		networks = append(networks, &orchestratorv1.NetworkLeases{Node: name, Network: resp.Network, Leases: resp.Leases})
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Node < networks[j].Node })
+
+	return &orchestratorv1.ListLeasesResponse{Networks: networks}, nil
+}
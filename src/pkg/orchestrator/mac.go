@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// macAllocationAttempts bounds how many random MACs are tried before giving
+// up, which only happens when the prefix's space is nearly exhausted.
+const macAllocationAttempts = 64
+
+// reserveMAC assigns spec a MAC that no instance on any node uses: the
+// requested one, or one allocated from the configured prefix. The MAC stays
+// reserved against concurrent creates until release is called. A requested
+// MAC is refused while nodes can't be queried; allocation skips them, and
+// collisions that slip through are reported by detectMACCollisions.
+func (s *Server) reserveMAC(ctx context.Context, spec *controllerv1.VMSpec) (func(), error) {
+	requested := spec.GetMacAddress()
+	if requested != "" {
+		mac, err := network.NormalizeMAC(requested)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid mac_address: %v", err)
+		}
+		requested = mac
+	}
+
+	used, nodeErrs := s.usedMACs(ctx)
+	if requested != "" && len(nodeErrs) > 0 {
+		return nil, status.Errorf(codes.Unavailable, "can't check mac address %s is unused while nodes are unreachable: %s",
+			requested, strings.Join(slices.Sorted(maps.Keys(nodeErrs)), ", "))
+	}
+
+	s.macMu.Lock()
+	defer s.macMu.Unlock()
+
+	mac := requested
+	if mac != "" {
+		if used[mac] != "" || s.pendingMACs[mac] {
+			return nil, status.Errorf(codes.AlreadyExists, "mac address %s is already in use", mac)
+		}
+	} else {
+		for range macAllocationAttempts {
+			candidate, err := network.RandomMAC(s.macPrefix)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to allocate mac address: %v", err)
+			}
+			if used[candidate] == "" && !s.pendingMACs[candidate] {
+				mac = candidate
+				break
+			}
+		}
+		if mac == "" {
+			return nil, status.Errorf(codes.ResourceExhausted, "no free mac address left")
+		}
+	}
+
+	s.pendingMACs[mac] = true
+	spec.MacAddress = mac
+	return func() {
+		s.macMu.Lock()
+		defer s.macMu.Unlock()
+		delete(s.pendingMACs, mac)
+	}, nil
+}
+
+// usedMACs maps the MAC of every instance on the reachable nodes to
+// "node/instance", along with the errors of the nodes that couldn't be
+// listed.
+func (s *Server) usedMACs(ctx context.Context) (map[string]string, map[string]string) {
+	instances, nodeErrs := listInstances(ctx, s.nodeManagers())
+	used := make(map[string]string)
+	for _, info := range instances {
+		if mac := info.Info.GetStatus().GetHwaddr(); mac != "" {
+			used[strings.ToLower(mac)] = info.Node + "/" + info.Info.GetName()
+		}
+	}
+	return used, nodeErrs
+}
+
+// detectMACCollisions reports instances on different nodes sharing a MAC,
+// e.g. created while a node was unreachable. Each collision is reported
+// once, as an error event for every instance involved.
+func (s *Server) detectMACCollisions(ctx context.Context) {
+	owners := make(map[string][]*orchestratorv1.Info)
+	instances, _ := listInstances(ctx, s.nodeManagers())
+	for _, info := range instances {
+		// An instance being moved briefly exists on both nodes.
+		if s.moves.contains(info.Node + "/" + instanceRef(info.Info.GetSpec().GetProject(), info.Info.GetName())) {
+			continue
+		}
+		if mac := info.Info.GetStatus().GetHwaddr(); mac != "" {
+			mac = strings.ToLower(mac)
+			owners[mac] = append(owners[mac], info)
+		}
+	}
+
+	s.macMu.Lock()
+	defer s.macMu.Unlock()
+
+	for mac := range s.reportedCollisions {
+		if len(owners[mac]) < 2 {
+			delete(s.reportedCollisions, mac)
+		}
+	}
+	for mac, infos := range owners {
+		if len(infos) < 2 || s.reportedCollisions[mac] {
+			continue
+		}
+		s.reportedCollisions[mac] = true
+
+		names := make([]string, 0, len(infos))
+		for _, info := range infos {
+			names = append(names, info.Node+"/"+instanceRef(info.Info.GetSpec().GetProject(), info.Info.Name))
+		}
+		sort.Strings(names)
+		slog.WarnContext(ctx, "MAC address collision", "mac", mac, "instances", names)
+		for _, info := range infos {
+			s.broadcaster.Send(&orchestratorv1.Event{
+				Node: info.Node,
+				Update: &eventv1.Update{
+					Payload: &eventv1.Update_ErrorEvent{
+						ErrorEvent: &eventv1.ErrorEvent{
+							Message:  "mac address " + mac + " is also used by " + strings.Join(names, ", "),
+							Resource: instanceRef(info.Info.GetSpec().GetProject(), info.Info.Name),
+						},
+					},
+				},
+			})
+		}
+	}
+}
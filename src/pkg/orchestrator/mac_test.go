@@ -0,0 +1,95 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReserveMAC_AllocatesFromPrefix(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode()})
+
+	spec := &controllerv1.VMSpec{}
+	release, err := s.reserveMAC(context.Background(), spec)
+	require.NoError(t, err)
+	defer release()
+
+	assert.True(t, strings.HasPrefix(spec.MacAddress, "52:54:00:"), spec.MacAddress)
+	assert.True(t, s.pendingMACs[spec.MacAddress])
+}
+
+func TestReserveMAC_RequestedMAC(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{
+		"a": newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01")),
+		"b": newFakeNode(),
+	})
+
+	spec := &controllerv1.VMSpec{MacAddress: "52-54-00-00-00-02"}
+	release, err := s.reserveMAC(context.Background(), spec)
+	require.NoError(t, err)
+	assert.Equal(t, "52:54:00:00:00:02", spec.MacAddress)
+
+	// Taken on another node.
+	_, err = s.reserveMAC(context.Background(), &controllerv1.VMSpec{MacAddress: "52:54:00:00:00:01"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// Reserved by an in-flight create until released.
+	_, err = s.reserveMAC(context.Background(), &controllerv1.VMSpec{MacAddress: "52:54:00:00:00:02"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	release()
+	_, err = s.reserveMAC(context.Background(), &controllerv1.VMSpec{MacAddress: "52:54:00:00:00:02"})
+	assert.NoError(t, err)
+
+	_, err = s.reserveMAC(context.Background(), &controllerv1.VMSpec{MacAddress: "01:00:5e:00:00:01"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestReserveMAC_UnreachableNode(t *testing.T) {
+	down := newFakeNode()
+	down.down = true
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": down})
+
+	_, err := s.reserveMAC(context.Background(), &controllerv1.VMSpec{MacAddress: "52:54:00:00:00:02"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// Allocated MACs are unlikely to collide, so allocation goes ahead.
+	release, err := s.reserveMAC(context.Background(), &controllerv1.VMSpec{})
+	require.NoError(t, err)
+	release()
+}
+
+func TestDetectMACCollisions_ReportsOnce(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{
+		"a": newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01")),
+		"b": newFakeNode(instanceWithMAC("vm2", "52:54:00:00:00:01"), instanceWithMAC("vm3", "52:54:00:00:00:03")),
+	})
+
+	s.detectMACCollisions(context.Background())
+	events := drainEvents(s.broadcaster)
+	require.Len(t, events, 2)
+	resources := []string{events[0].Update.GetErrorEvent().Resource, events[1].Update.GetErrorEvent().Resource}
+	assert.ElementsMatch(t, []string{"vm1", "vm2"}, resources)
+
+	s.detectMACCollisions(context.Background())
+	assert.Empty(t, drainEvents(s.broadcaster))
+}
+
+func drainEvents(b *Broadcaster) []*orchestratorv1.Event {
+	var out []*orchestratorv1.Event
+	for {
+		select {
+		case e := <-b.eventCh:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package orchestrator
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
+	"github.com/q-controller/qcontroller/src/pkg/labels"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func parseSelector(selector string) (labels.Selector, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid labelSelector: %v", err)
+	}
+	return parsed, nil
+}
+
+func validateMetadata(instanceLabels, annotations map[string]string) error {
+	if err := labels.Validate(instanceLabels); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid labels: %v", err)
+	}
+	if err := labels.ValidateAnnotations(annotations); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid annotations: %v", err)
+	}
+	return nil
+}
+
+// UpdateMetadata merges the requested changes into an instance's labels
+// and annotations. Instances of owners over their quota can't be updated.
+func (s *Server) UpdateMetadata(ctx context.Context, req *orchestratorv1.UpdateMetadataRequest) (*emptypb.Empty, error) {
+	nodeName, nm, err := s.getNode(req.Node)
+	if err != nil {
+		return nil, err
+	}
+	info, findErr := findInstance(ctx, nodeName, nm, req.Project, req.Name)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if authErr := s.authorize(ctx, operator, instanceResource(nodeName, info)); authErr != nil {
+		return nil, authErr
+	}
+
+	instanceLabels := editMap(info.GetSpec().GetLabels(), req.Labels, req.RemoveLabels)
+	annotations := editMap(info.GetSpec().GetAnnotations(), req.Annotations, req.RemoveAnnotations)
+	if metadataErr := validateMetadata(instanceLabels, annotations); metadataErr != nil {
+		return nil, metadataErr
+	}
+	// Relabeling mustn't move the instance out of the caller's reach.
+	relabeled := proto.CloneOf(info.GetSpec())
+	relabeled.Labels = instanceLabels
+	if authErr := s.authorize(ctx, operator, specResource(nodeName, req.Name, relabeled)); authErr != nil {
+		return nil, authErr
+	}
+	if quotaErr := s.checkOwnerQuota(ctx, info.GetSpec()); quotaErr != nil {
+		return nil, quotaErr
+	}
+	if setErr := nm.SetMetadata(ctx, req.Project, req.Name, instanceLabels, annotations); setErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set metadata: %v", setErr)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// editMap returns a copy of m with set applied and then the keys in remove
+// deleted.
+func editMap(m, set map[string]string, remove []string) map[string]string {
+	out := make(map[string]string, len(m)+len(set))
+	maps.Copy(out, m)
+	maps.Copy(out, set)
+	for _, key := range remove {
+		delete(out, key)
+	}
+	return out
+}
+
+// Bulk applies an action to every instance matching the request's label
+// selector. Nodes are handled concurrently and their instances one at a
+// time; instances in the wrong state for the action are skipped. Instances
+// the caller can't view are left out, and those it can't operate fail.
+func (s *Server) Bulk(ctx context.Context, req *orchestratorv1.BulkRequest) (*orchestratorv1.BulkResponse, error) {
+	if req.LabelSelector == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "labelSelector is required")
+	}
+	selector, err := parseSelector(req.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	switch req.Action {
+	case orchestratorv1.BulkRequest_ACTION_START, orchestratorv1.BulkRequest_ACTION_STOP, orchestratorv1.BulkRequest_ACTION_REMOVE:
+	case orchestratorv1.BulkRequest_ACTION_LABEL:
+		if len(req.Labels) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "labels are required to label instances")
+		}
+		if metadataErr := validateMetadata(req.Labels, nil); metadataErr != nil {
+			return nil, metadataErr
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "action is required")
+	}
+
+	nodes := s.nodeManagers()
+	if req.Node != "" {
+		nodeName, nm, nodeErr := s.getNode(req.Node)
+		if nodeErr != nil {
+			return nil, nodeErr
+		}
+		nodes = map[string]node.Manager{nodeName: nm}
+	}
+
+	instances, nodeErrs := listInstances(ctx, nodes)
+	byNode := make(map[string][]*orchestratorv1.Info)
+	for _, inst := range instances {
+		if selector.Matches(inst.Info.GetSpec().GetLabels()) && s.allowed(ctx, viewer, instanceResource(inst.Node, inst.Info)) {
+			byNode[inst.Node] = append(byNode[inst.Node], inst)
+		}
+	}
+
+	resp := &orchestratorv1.BulkResponse{Errors: nodeErrs}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for nodeName, matched := range byNode {
+		wg.Go(func() {
+			for _, inst := range matched {
+				result := s.bulkApply(ctx, req, nodes[nodeName], inst)
+				mu.Lock()
+				resp.Results = append(resp.Results, result)
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	sort.Slice(resp.Results, func(i, j int) bool {
+		a, b := resp.Results[i], resp.Results[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		return a.Name < b.Name
+	})
+	return resp, nil
+}
+
+func (s *Server) bulkApply(ctx context.Context, req *orchestratorv1.BulkRequest, nm node.Manager, inst *orchestratorv1.Info) *orchestratorv1.BulkResult {
+	project, name := inst.Info.GetSpec().GetProject(), inst.Info.GetName()
+	result := &orchestratorv1.BulkResult{Node: inst.Node, Project: project, Name: name}
+	if authErr := s.authorize(ctx, operator, instanceResource(inst.Node, inst.Info)); authErr != nil {
+		result.Error = status.Convert(authErr).Message()
+		return result
+	}
+	stopped := inst.Info.GetStatus().GetState() == vmv1.State_STATE_STOPPED.String()
+
+	var err error
+	switch req.Action {
+	case orchestratorv1.BulkRequest_ACTION_START:
+		if !stopped {
+			result.Skipped = true
+			return result
+		}
+		if err = s.checkSchedulable(inst.Node); err == nil {
+			s.startAsync(ctx, inst.Node, nm, project, name)
+		}
+	case orchestratorv1.BulkRequest_ACTION_STOP:
+		if stopped {
+			result.Skipped = true
+			return result
+		}
+		err = nm.Stop(ctx, project, name, req.Force)
+	case orchestratorv1.BulkRequest_ACTION_REMOVE:
+		if !stopped {
+			result.Skipped = true
+			return result
+		}
+		err = nm.Remove(ctx, project, name)
+	case orchestratorv1.BulkRequest_ACTION_LABEL:
+		relabeled := proto.CloneOf(inst.Info.GetSpec())
+		relabeled.Labels = editMap(relabeled.GetLabels(), req.Labels, nil)
+		if err = s.authorize(ctx, operator, specResource(inst.Node, name, relabeled)); err == nil {
+			err = s.checkOwnerQuota(ctx, relabeled)
+		}
+		if err == nil {
+			err = nm.SetMetadata(ctx, project, name, relabeled.Labels, relabeled.GetAnnotations())
+		}
+	}
+	if err != nil {
+		result.Error = status.Convert(err).Message()
+	}
+	return result
+}
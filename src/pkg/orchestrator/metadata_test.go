@@ -0,0 +1,171 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func labeled(info *controllerv1.Info, labels map[string]string) *controllerv1.Info {
+	info.Spec.Labels = labels
+	return info
+}
+
+func bulkOutcomes(resp *orchestratorv1.BulkResponse) map[string]string {
+	out := make(map[string]string, len(resp.Results))
+	for _, r := range resp.Results {
+		switch {
+		case r.Skipped:
+			out[r.Node+"/"+r.Name] = "skipped"
+		case r.Error != "":
+			out[r.Node+"/"+r.Name] = "error"
+		default:
+			out[r.Node+"/"+r.Name] = "ok"
+		}
+	}
+	return out
+}
+
+func TestCreate_ValidatesMetadata(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode()})
+	ctx := context.Background()
+
+	spec := specWith(1, 512, 5)
+	spec.Labels = map[string]string{"team": "not valid"}
+	_, err := s.Create(ctx, &orchestratorv1.CreateRequest{Node: "a", Name: "vm1", Spec: spec})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	spec.Labels = map[string]string{"team": "web"}
+	spec.Annotations = map[string]string{"example.com/notes": "anything goes here"}
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: "a", Name: "vm1", Spec: spec})
+	require.NoError(t, err)
+
+	resp, err := s.Info(ctx, &orchestratorv1.InfoRequest{Node: "a", Name: "vm1"})
+	require.NoError(t, err)
+	require.Len(t, resp.Info, 1)
+	assert.Equal(t, map[string]string{"team": "web"}, resp.Info[0].Info.Spec.Labels)
+}
+
+func TestLabelSelectors(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{
+		"a": newFakeNode(
+			labeled(instanceWithMAC("vm1", "52:54:00:00:00:01"), map[string]string{"team": "web", "env": "prod"}),
+			labeled(instanceWithMAC("vm2", "52:54:00:00:00:02"), map[string]string{"team": "db"}),
+		),
+		"b": newFakeNode(labeled(instanceWithMAC("vm3", "52:54:00:00:00:03"), map[string]string{"team": "web", "env": "dev"})),
+	})
+	ctx := context.Background()
+
+	info, err := s.Info(ctx, &orchestratorv1.InfoRequest{Node: "a", LabelSelector: "team=web"})
+	require.NoError(t, err)
+	require.Len(t, info.Info, 1)
+	assert.Equal(t, "vm1", info.Info[0].Info.Name)
+
+	list, err := s.ListInstances(ctx, &orchestratorv1.ListInstancesRequest{LabelSelector: "team=web,env!=prod"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/vm3"}, listedNames(list))
+
+	_, err = s.Info(ctx, &orchestratorv1.InfoRequest{Node: "a", LabelSelector: "env in (dev"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.ListInstances(ctx, &orchestratorv1.ListInstancesRequest{LabelSelector: "=x"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	inst := labeled(instanceWithMAC("vm1", "52:54:00:00:00:01"), map[string]string{"team": "web", "env": "dev"})
+	inst.Spec.Annotations = map[string]string{"owner": "alice"}
+	n := newFakeNode(inst)
+	s := newTestServer(map[string]node.Manager{"a": n})
+	ctx := context.Background()
+
+	_, err := s.UpdateMetadata(ctx, &orchestratorv1.UpdateMetadataRequest{
+		Node:              "a",
+		Name:              "vm1",
+		Labels:            map[string]string{"env": "prod", "tier": "frontend"},
+		RemoveLabels:      []string{"team"},
+		Annotations:       map[string]string{"ticket": "OPS-1"},
+		RemoveAnnotations: []string{"owner"},
+	})
+	require.NoError(t, err)
+	infos, err := n.Info(ctx, "", "vm1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "tier": "frontend"}, infos[0].Spec.Labels)
+	assert.Equal(t, map[string]string{"ticket": "OPS-1"}, infos[0].Spec.Annotations)
+
+	for _, tc := range []struct {
+		req  *orchestratorv1.UpdateMetadataRequest
+		code codes.Code
+	}{
+		{&orchestratorv1.UpdateMetadataRequest{Node: "x", Name: "vm1"}, codes.NotFound},
+		{&orchestratorv1.UpdateMetadataRequest{Node: "a", Name: "vm9"}, codes.NotFound},
+		{&orchestratorv1.UpdateMetadataRequest{Node: "a", Name: "vm1", Labels: map[string]string{"bad key": "x"}}, codes.InvalidArgument},
+		{&orchestratorv1.UpdateMetadataRequest{Node: "a", Name: "vm1", Annotations: map[string]string{"-": "x"}}, codes.InvalidArgument},
+	} {
+		_, err := s.UpdateMetadata(ctx, tc.req)
+		assert.Equal(t, tc.code, status.Code(err), "%v", tc.req)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	web := map[string]string{"team": "web"}
+	a := newFakeNode(
+		labeled(instanceWithMAC("vm1", "52:54:00:00:00:01"), web),
+		labeled(runningInstance("vm2", "52:54:00:00:00:02"), web),
+		labeled(instanceWithMAC("vm3", "52:54:00:00:00:03"), map[string]string{"team": "db"}),
+	)
+	b := newFakeNode(labeled(instanceWithMAC("vm4", "52:54:00:00:00:04"), web))
+	down := newFakeNode()
+	down.down = true
+	s := newTestServer(map[string]node.Manager{"a": a, "b": b, "c": down})
+	ctx := context.Background()
+
+	resp, err := s.Bulk(ctx, &orchestratorv1.BulkRequest{LabelSelector: "team=web", Action: orchestratorv1.BulkRequest_ACTION_START})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a/vm1": "ok", "a/vm2": "skipped", "b/vm4": "ok"}, bulkOutcomes(resp))
+	assert.Equal(t, []string{"a", "a", "b"}, []string{resp.Results[0].Node, resp.Results[1].Node, resp.Results[2].Node})
+	assert.Contains(t, resp.Errors, "c")
+	require.Eventually(t, func() bool {
+		return a.state("vm1") == "STATE_RUNNING" && b.state("vm4") == "STATE_RUNNING"
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "STATE_STOPPED", a.state("vm3"))
+
+	resp, err = s.Bulk(ctx, &orchestratorv1.BulkRequest{LabelSelector: "team=web", Action: orchestratorv1.BulkRequest_ACTION_STOP, Node: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a/vm1": "ok", "a/vm2": "ok"}, bulkOutcomes(resp))
+	assert.Equal(t, "STATE_RUNNING", b.state("vm4"))
+
+	resp, err = s.Bulk(ctx, &orchestratorv1.BulkRequest{LabelSelector: "team=web", Action: orchestratorv1.BulkRequest_ACTION_LABEL, Labels: map[string]string{"env": "dev"}})
+	require.NoError(t, err)
+	assert.Len(t, resp.Results, 3)
+	list, err := s.ListInstances(ctx, &orchestratorv1.ListInstancesRequest{LabelSelector: "team=web,env=dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/vm1", "a/vm2", "b/vm4"}, listedNames(list))
+
+	resp, err = s.Bulk(ctx, &orchestratorv1.BulkRequest{LabelSelector: "env=dev", Action: orchestratorv1.BulkRequest_ACTION_REMOVE})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a/vm1": "ok", "a/vm2": "ok", "b/vm4": "skipped"}, bulkOutcomes(resp))
+	assert.False(t, a.has("vm1"))
+	assert.True(t, a.has("vm3"))
+	assert.True(t, b.has("vm4"))
+
+	for _, req := range []*orchestratorv1.BulkRequest{
+		{Action: orchestratorv1.BulkRequest_ACTION_STOP},
+		{LabelSelector: "team=web"},
+		{LabelSelector: "team=", Action: orchestratorv1.BulkRequest_ACTION_LABEL},
+		{LabelSelector: "team=web", Action: orchestratorv1.BulkRequest_ACTION_LABEL, Labels: map[string]string{"env": "not valid"}},
+		{LabelSelector: "team in web", Action: orchestratorv1.BulkRequest_ACTION_STOP},
+	} {
+		_, err := s.Bulk(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", req)
+	}
+	_, err = s.Bulk(ctx, &orchestratorv1.BulkRequest{LabelSelector: "team=web", Action: orchestratorv1.BulkRequest_ACTION_STOP, Node: "x"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
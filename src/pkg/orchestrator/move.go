@@ -0,0 +1,221 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// moves tracks the instances being moved, keyed by "node/name" on both the
+// source and the target node.
+type moves struct {
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+func (m *moves) start(keys ...string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		if m.active[k] {
+			return false
+		}
+	}
+	if m.active == nil {
+		m.active = make(map[string]bool)
+	}
+	for _, k := range keys {
+		m.active[k] = true
+	}
+	return true
+}
+
+func (m *moves) finish(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.active, k)
+	}
+}
+
+func (m *moves) contains(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active[key]
+}
+
+// findInstance returns the instance called name in project on nm, or a
+// NotFound status.
+func findInstance(ctx context.Context, nodeName string, nm node.Manager, project, name string) (*controllerv1.Info, error) {
+	infos, err := nm.Info(ctx, node.AllProjects, "")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list instances on %s: %v", nodeName, err)
+	}
+	for _, info := range infos {
+		if info.Name == name && info.GetSpec().GetProject() == project {
+			return info, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "instance %s not found on %s", instanceRef(project, name), nodeName)
+}
+
+// Move moves a stopped instance to another node: it's recreated there with
+// the same spec and MAC, its disk is copied over and the original is
+// removed. The request is validated up front; the copy runs in the
+// background and reports progress and failures as events.
+func (s *Server) Move(ctx context.Context, req *orchestratorv1.MoveRequest) (*emptypb.Empty, error) {
+	_, src, err := s.getNode(req.Node)
+	if err != nil {
+		return nil, err
+	}
+	_, dst, err := s.getNode(req.TargetNode)
+	if err != nil {
+		return nil, err
+	}
+	if req.TargetNode == req.Node {
+		return nil, status.Errorf(codes.InvalidArgument, "instance %s is already on %s", req.Name, req.Node)
+	}
+	if cordonErr := s.checkSchedulable(req.TargetNode); cordonErr != nil {
+		return nil, cordonErr
+	}
+
+	info, findErr := findInstance(ctx, req.Node, src, req.Project, req.Name)
+	if findErr != nil {
+		return nil, findErr
+	}
+	if authErr := s.authorizeMove(ctx, info, req.Node, req.TargetNode); authErr != nil {
+		return nil, authErr
+	}
+	if info.GetStatus().GetState() != vmv1.State_STATE_STOPPED.String() {
+		return nil, status.Errorf(codes.FailedPrecondition, "instance %s must be stopped to be moved", req.Name)
+	}
+	if _, targetErr := findInstance(ctx, req.TargetNode, dst, req.Project, req.Name); targetErr == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "instance %s already exists on %s", instanceRef(req.Project, req.Name), req.TargetNode)
+	} else if status.Code(targetErr) != codes.NotFound {
+		return nil, targetErr
+	}
+	// The instance counts as placed on the target until the move is over.
+	release, placementErr := s.placeOn(ctx, req.TargetNode, info.Spec)
+	if placementErr != nil {
+		return nil, placementErr
+	}
+
+	ref := instanceRef(req.Project, req.Name)
+	keys := []string{req.Node + "/" + ref, req.TargetNode + "/" + ref}
+	if !s.moves.start(keys...) {
+		release()
+		return nil, status.Errorf(codes.Aborted, "instance %s is already being moved", req.Name)
+	}
+
+	go func() {
+		defer release()
+		defer s.moves.finish(keys...)
+		asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
+		defer cancel()
+		if moveErr := s.move(asyncCtx, req, info.Spec, src, dst); moveErr != nil {
+			slog.ErrorContext(asyncCtx, "Move failed", "node", req.Node, "project", req.Project, "name", req.Name, "target", req.TargetNode, "error", moveErr)
+			s.broadcaster.Send(&orchestratorv1.Event{
+				Node: req.Node,
+				Update: &eventv1.Update{
+					Payload: &eventv1.Update_ErrorEvent{
+						ErrorEvent: &eventv1.ErrorEvent{
+							Message:  moveErr.Error(),
+							Resource: ref,
+						},
+					},
+				},
+			})
+		}
+	}()
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) move(ctx context.Context, req *orchestratorv1.MoveRequest, spec *controllerv1.VMSpec, src, dst node.Manager) error {
+	report := func(percent int32) {
+		s.broadcaster.Send(&orchestratorv1.Event{
+			Node: req.Node,
+			Update: &eventv1.Update{
+				Payload: &eventv1.Update_ProgressEvent{
+					ProgressEvent: &eventv1.ProgressEvent{
+						Resource: "instance:" + instanceRef(req.Project, req.Name),
+						Message:  "Moving to " + req.TargetNode,
+						Percent:  percent,
+					},
+				},
+			},
+		})
+	}
+	report(0)
+
+	// Nodes make whoever creates an instance its owner, so it's recreated
+	// on the owner's behalf.
+	owner := &auth.Identity{Subject: spec.GetOwner(), Groups: spec.GetOwnerGroups(), IssuedBy: identity(ctx).IssuedBy}
+	if err := dst.Create(auth.WithIdentity(ctx, owner), req.Name, spec); err != nil {
+		return fmt.Errorf("failed to create on %s: %w", req.TargetNode, err)
+	}
+	if err := copyDisk(ctx, req.Project, req.Name, src, dst, report); err != nil {
+		if rmErr := dst.Remove(ctx, req.Project, req.Name); rmErr != nil {
+			slog.WarnContext(ctx, "Failed to roll back moved instance", "node", req.TargetNode, "name", req.Name, "error", rmErr)
+		}
+		return fmt.Errorf("failed to copy disk: %w", err)
+	}
+	if err := src.Remove(ctx, req.Project, req.Name); err != nil {
+		return fmt.Errorf("instance was copied to %s but not removed from %s: %w", req.TargetNode, req.Node, err)
+	}
+
+	report(100)
+	slog.InfoContext(ctx, "Moved instance", "project", req.Project, "name", req.Name, "from", req.Node, "to", req.TargetNode)
+	return nil
+}
+
+// copyDisk streams the instance's disk from src to dst. Instances that were
+// never started have no disk, so there's nothing to copy.
+func copyDisk(ctx context.Context, project, name string, src, dst node.Manager, report func(int32)) error {
+	disk, size, err := src.ExportDisk(ctx, project, name)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = disk.Close() }()
+
+	return dst.ImportDisk(ctx, project, name, &progressReader{r: disk, total: size, report: report})
+}
+
+// progressReader reports how much of total has been read, in whole
+// percents, each time the percentage changes.
+type progressReader struct {
+	r       io.Reader
+	total   int64
+	read    int64
+	lastPct int32
+	report  func(int32)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.total > 0 {
+		pct := int32(min(p.read*100/p.total, 100))
+		if pct != p.lastPct {
+			p.lastPct = pct
+			p.report(pct)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,100 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMove_Validation(t *testing.T) {
+	running := instanceWithMAC("vm2", "52:54:00:00:00:02")
+	running.Status.State = "STATE_RUNNING"
+	s := newTestServer(map[string]node.Manager{
+		"a": newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"), running),
+		"b": newFakeNode(instanceWithMAC("vm3", "52:54:00:00:00:03")),
+	})
+	s.nodes["a"].(*fakeNode).instances["vm3"] = instanceWithMAC("vm3", "52:54:00:00:00:04")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		req  *orchestratorv1.MoveRequest
+		code codes.Code
+	}{
+		{&orchestratorv1.MoveRequest{Node: "x", Name: "vm1", TargetNode: "b"}, codes.NotFound},
+		{&orchestratorv1.MoveRequest{Node: "a", Name: "vm1", TargetNode: "x"}, codes.NotFound},
+		{&orchestratorv1.MoveRequest{Node: "a", Name: "vm1", TargetNode: "a"}, codes.InvalidArgument},
+		{&orchestratorv1.MoveRequest{Node: "a", Name: "missing", TargetNode: "b"}, codes.NotFound},
+		{&orchestratorv1.MoveRequest{Node: "a", Name: "vm2", TargetNode: "b"}, codes.FailedPrecondition},
+		{&orchestratorv1.MoveRequest{Node: "a", Name: "vm3", TargetNode: "b"}, codes.AlreadyExists},
+	} {
+		_, err := s.Move(ctx, tc.req)
+		assert.Equal(t, tc.code, status.Code(err), "%v", tc.req)
+	}
+}
+
+func waitForMove(t *testing.T, s *Server, key string) {
+	t.Helper()
+	require.Eventually(t, func() bool { return !s.moves.contains(key) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMove_CopiesDiskAndRemovesSource(t *testing.T) {
+	src := newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"))
+	src.disks["vm1"] = []byte("disk contents")
+	dst := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": src, "b": dst})
+
+	_, err := s.Move(context.Background(), &orchestratorv1.MoveRequest{Node: "a", Name: "vm1", TargetNode: "b"})
+	require.NoError(t, err)
+	waitForMove(t, s, "a/vm1")
+
+	assert.False(t, src.has("vm1"))
+	require.True(t, dst.has("vm1"))
+	assert.Equal(t, "52:54:00:00:00:01", dst.instances["vm1"].Status.Hwaddr)
+	assert.Equal(t, []byte("disk contents"), dst.disks["vm1"])
+}
+
+func TestMove_WithoutDisk(t *testing.T) {
+	src := newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"))
+	dst := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": src, "b": dst})
+
+	_, err := s.Move(context.Background(), &orchestratorv1.MoveRequest{Node: "a", Name: "vm1", TargetNode: "b"})
+	require.NoError(t, err)
+	waitForMove(t, s, "a/vm1")
+
+	assert.False(t, src.has("vm1"))
+	assert.True(t, dst.has("vm1"))
+	assert.Empty(t, dst.disks)
+}
+
+func TestMove_RollsBackOnImportFailure(t *testing.T) {
+	src := newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"))
+	src.disks["vm1"] = []byte("disk contents")
+	dst := newFakeNode()
+	dst.importErr = errors.New("disk full")
+	s := newTestServer(map[string]node.Manager{"a": src, "b": dst})
+
+	_, err := s.Move(context.Background(), &orchestratorv1.MoveRequest{Node: "a", Name: "vm1", TargetNode: "b"})
+	require.NoError(t, err)
+	waitForMove(t, s, "a/vm1")
+
+	assert.True(t, src.has("vm1"))
+	assert.False(t, dst.has("vm1"))
+
+	var errorEvent bool
+	for _, ev := range drainEvents(s.broadcaster) {
+		if e := ev.GetUpdate().GetErrorEvent(); e != nil {
+			errorEvent = true
+			assert.Contains(t, e.Message, "disk full")
+		}
+	}
+	assert.True(t, errorEvent)
+}
@@ -0,0 +1,427 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/labels"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// nodeManagers returns a snapshot of the current nodes.
+func (s *Server) nodeManagers() map[string]node.Manager {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	out := make(map[string]node.Manager, len(s.nodes))
+	for name, nm := range s.nodes {
+		out[name] = nm
+	}
+	return out
+}
+
+// nodeConfig returns the node's configuration, or nil if it's unknown.
+func (s *Server) nodeConfig(name string) *settingsv1.Node {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	return s.nodeConfigs[name]
+}
+
+// nodeList returns the configuration of every node, ordered by name.
+func (s *Server) nodeList() []*settingsv1.Node {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	out := make([]*settingsv1.Node, 0, len(s.nodeConfigs))
+	for _, cfg := range s.nodeConfigs {
+		out = append(out, cfg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// attachNode makes nm the manager of the node described by cfg and starts
+// relaying its events. The caller must hold nodesMu.
+func (s *Server) attachNode(cfg *settingsv1.Node, nm node.Manager) {
+	stop := make(chan struct{})
+	s.nodes[cfg.Name] = &sharedNode{Manager: nm}
+	s.nodeConfigs[cfg.Name] = cfg
+	s.nodeStops[cfg.Name] = stop
+	s.health.add(cfg.Name)
+	go s.subscribeToNodeEvents(cfg, stop)
+}
+
+// detachNode stops managing the node and returns its old manager, which
+// the caller must close. Calls already using it can finish. The caller
+// must hold nodesMu.
+func (s *Server) detachNode(name string) node.Manager {
+	nm := s.nodes[name]
+	if stop, ok := s.nodeStops[name]; ok {
+		close(stop)
+	}
+	delete(s.nodes, name)
+	delete(s.nodeConfigs, name)
+	delete(s.nodeStops, name)
+	s.health.remove(name)
+	return nm
+}
+
+// loadNodes attaches the nodes registered at runtime. Nodes that clash with
+// the config are skipped.
+func (s *Server) loadNodes() error {
+	stored, err := s.state.ListNodes()
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, cfg := range stored {
+		if s.isStaticNode(cfg.Name) {
+			slog.Warn("Ignoring registered node shadowed by the config", "node", cfg.Name)
+		}
+	}
+	s.connectRegisteredNodes()
+	return nil
+}
+
+// connectRegisteredNodes attaches the registered nodes that aren't, such as
+// those that couldn't be connected before; they're retried on the next call.
+func (s *Server) connectRegisteredNodes() {
+	stored, err := s.state.ListNodes()
+	if err != nil {
+		slog.Error("Failed to list nodes", "error", err)
+		return
+	}
+	for _, cfg := range stored {
+		if _, _, getErr := s.getNode(cfg.Name); getErr == nil {
+			continue
+		}
+		nm, connectErr := s.connectNode(cfg)
+		if connectErr != nil {
+			slog.Error("Failed to connect to registered node", "node", cfg.Name, "error", connectErr)
+			continue
+		}
+		if !s.attachRegistered(cfg, nm) {
+			nm.Close()
+		}
+	}
+}
+
+// attachRegistered attaches nm as the node described by cfg, unless the
+// node was attached, changed or removed while nm was being connected.
+func (s *Server) attachRegistered(cfg *settingsv1.Node, nm node.Manager) bool {
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	if _, exists := s.nodes[cfg.Name]; exists {
+		return false
+	}
+	stored, err := s.state.ListNodes()
+	if err != nil {
+		slog.Error("Failed to list nodes", "error", err)
+		return false
+	}
+	if !slices.ContainsFunc(stored, func(n *settingsv1.Node) bool { return proto.Equal(n, cfg) }) {
+		return false
+	}
+	s.attachNode(cfg, nm)
+	return true
+}
+
+func validateNode(cfg *settingsv1.Node) error {
+	switch {
+	case cfg == nil:
+		return errors.New("node is required")
+	case cfg.Name == "":
+		return errors.New("node name is required")
+	case cfg.Name == AutoNode:
+		return fmt.Errorf("node name %q is reserved for scheduling", AutoNode)
+	case cfg.Endpoint == "":
+		return errors.New("endpoint is required")
+	case cfg.FileRegistryEndpoint == "":
+		return errors.New("fileRegistryEndpoint is required")
+	case cfg.EventsEndpoint == "":
+		return errors.New("eventsEndpoint is required")
+	}
+	if err := labels.Validate(cfg.Labels); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+	return nil
+}
+
+// nodesChanged pushes the cluster-wide configuration after the node set
+// changed: new nodes need it, and overlay peers have changed for everyone.
+func (s *Server) nodesChanged(ctx context.Context) {
+	go func() {
+		asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
+		defer cancel()
+		s.syncDNSRecords(asyncCtx)
+		s.syncOverlayNetworks(asyncCtx)
+	}()
+}
+
+func (s *Server) AddNode(ctx context.Context, req *orchestratorv1.AddNodeRequest) (*settingsv1.Node, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	if err := validateNode(req.Node); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid node: %v", err)
+	}
+	cfg := proto.CloneOf(req.Node)
+	if err := s.addNode(ctx, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// addNode registers and attaches a validated node.
+func (s *Server) addNode(ctx context.Context, cfg *settingsv1.Node) error {
+	if _, _, err := s.getNode(cfg.Name); err == nil {
+		return status.Errorf(codes.AlreadyExists, "node %s already exists", cfg.Name)
+	}
+	nm, connectErr := s.connectNode(cfg)
+	if connectErr != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to connect to node: %v", connectErr)
+	}
+
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	if _, exists := s.nodes[cfg.Name]; exists {
+		nm.Close()
+		return status.Errorf(codes.AlreadyExists, "node %s already exists", cfg.Name)
+	}
+	if putErr := s.state.PutNode(cfg); putErr != nil {
+		nm.Close()
+		return status.Errorf(codes.Internal, "failed to store node: %v", putErr)
+	}
+	s.attachNode(cfg, nm)
+	slog.InfoContext(ctx, "Added node", "node", cfg.Name, "endpoint", cfg.Endpoint)
+
+	s.nodesChanged(ctx)
+	return nil
+}
+
+func (s *Server) UpdateNode(ctx context.Context, req *orchestratorv1.UpdateNodeRequest) (*settingsv1.Node, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{Node: req.Name}); authErr != nil {
+		return nil, authErr
+	}
+	if req.Node != nil && req.Node.Name == "" {
+		req.Node.Name = req.Name
+	}
+	if err := validateNode(req.Node); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid node: %v", err)
+	}
+	if req.Node.Name != req.Name {
+		return nil, status.Errorf(codes.InvalidArgument, "node name %s doesn't match %s", req.Node.Name, req.Name)
+	}
+	cfg := proto.CloneOf(req.Node)
+	_, old, err := s.getNode(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if s.isStaticNode(cfg.Name) {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is defined in the config", cfg.Name)
+	}
+	nm, connectErr := s.connectNode(cfg)
+	if connectErr != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to connect to node: %v", connectErr)
+	}
+
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	if s.nodes[cfg.Name] != old {
+		nm.Close()
+		return nil, status.Errorf(codes.Aborted, "node %s changed while being updated", cfg.Name)
+	}
+	if putErr := s.state.PutNode(cfg); putErr != nil {
+		nm.Close()
+		return nil, status.Errorf(codes.Internal, "failed to store node: %v", putErr)
+	}
+	s.detachNode(cfg.Name).Close()
+	s.attachNode(cfg, nm)
+	slog.InfoContext(ctx, "Updated node", "node", cfg.Name, "endpoint", cfg.Endpoint)
+
+	s.nodesChanged(ctx)
+	return cfg, nil
+}
+
+func (s *Server) RemoveNode(ctx context.Context, req *orchestratorv1.RemoveNodeRequest) (*emptypb.Empty, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{Node: req.Name}); authErr != nil {
+		return nil, authErr
+	}
+	_, nm, err := s.getNode(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if s.isStaticNode(req.Name) {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is defined in the config", req.Name)
+	}
+	if s.drains.running(req.Name) {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is being drained", req.Name)
+	}
+	if !req.Force {
+		infos, infoErr := nm.Info(ctx, node.AllProjects, "")
+		if infoErr != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to list instances on %s, use force to remove it anyway: %v", req.Name, infoErr)
+		}
+		if len(infos) > 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "node %s still has %d instances", req.Name, len(infos))
+		}
+	}
+
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	if s.nodes[req.Name] != nm {
+		return nil, status.Errorf(codes.Aborted, "node %s changed while being removed", req.Name)
+	}
+	if rmErr := s.state.RemoveNode(req.Name); rmErr != nil && !errors.Is(rmErr, ErrNotFound) {
+		return nil, status.Errorf(codes.Internal, "failed to remove node: %v", rmErr)
+	}
+	if cordonErr := s.uncordon(req.Name); cordonErr != nil {
+		slog.WarnContext(ctx, "Failed to remove cordon of removed node", "node", req.Name, "error", cordonErr)
+	}
+	s.drains.remove(req.Name)
+	s.detachNode(req.Name).Close()
+	slog.InfoContext(ctx, "Removed node", "node", req.Name, "force", req.Force)
+
+	s.nodesChanged(ctx)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) isStaticNode(name string) bool {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	return s.staticNodes[name]
+}
+
+// sharedNode is a node manager that may be detached while calls are using
+// it. Closing it only closes the manager once the last of them returns.
+type sharedNode struct {
+	node.Manager
+	mu     sync.Mutex
+	calls  int
+	closed bool
+}
+
+// borrow counts a call using the manager until the returned func is called.
+func (n *sharedNode) borrow() func() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls++
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.calls--
+		if n.calls == 0 && n.closed {
+			n.Manager.Close()
+		}
+	}
+}
+
+func (n *sharedNode) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	n.closed = true
+	if n.calls == 0 {
+		n.Manager.Close()
+	}
+}
+
+func (n *sharedNode) Create(ctx context.Context, id string, spec *controllerv1.VMSpec) error {
+	defer n.borrow()()
+	return n.Manager.Create(ctx, id, spec)
+}
+
+func (n *sharedNode) Start(ctx context.Context, project, name string) error {
+	defer n.borrow()()
+	return n.Manager.Start(ctx, project, name)
+}
+
+func (n *sharedNode) Stop(ctx context.Context, project, name string, force bool) error {
+	defer n.borrow()()
+	return n.Manager.Stop(ctx, project, name, force)
+}
+
+func (n *sharedNode) Remove(ctx context.Context, project, name string) error {
+	defer n.borrow()()
+	return n.Manager.Remove(ctx, project, name)
+}
+
+func (n *sharedNode) Info(ctx context.Context, project, name string) ([]*controllerv1.Info, error) {
+	defer n.borrow()()
+	return n.Manager.Info(ctx, project, name)
+}
+
+func (n *sharedNode) SetDNSRecords(ctx context.Context, records []*settingsv1.DnsRecord) error {
+	defer n.borrow()()
+	return n.Manager.SetDNSRecords(ctx, records)
+}
+
+func (n *sharedNode) ListLeases(ctx context.Context) (*controllerv1.ListLeasesResponse, error) {
+	defer n.borrow()()
+	return n.Manager.ListLeases(ctx)
+}
+
+func (n *sharedNode) SetNetworkLimits(ctx context.Context, project, name string, limits *settingsv1.NetworkLimits) error {
+	defer n.borrow()()
+	return n.Manager.SetNetworkLimits(ctx, project, name, limits)
+}
+
+func (n *sharedNode) SetMetadata(ctx context.Context, project, name string, labels, annotations map[string]string) error {
+	defer n.borrow()()
+	return n.Manager.SetMetadata(ctx, project, name, labels, annotations)
+}
+
+func (n *sharedNode) SetOverlayNetworks(ctx context.Context, networks []*settingsv1.OverlayNetwork, peers []string) error {
+	defer n.borrow()()
+	return n.Manager.SetOverlayNetworks(ctx, networks, peers)
+}
+
+// ExportDisk keeps the manager borrowed until the stream is closed.
+func (n *sharedNode) ExportDisk(ctx context.Context, project, name string) (io.ReadCloser, int64, error) {
+	done := n.borrow()
+	r, size, err := n.Manager.ExportDisk(ctx, project, name)
+	if err != nil {
+		done()
+		return nil, 0, err
+	}
+	return &borrowedReader{ReadCloser: r, done: sync.OnceFunc(done)}, size, nil
+}
+
+func (n *sharedNode) ImportDisk(ctx context.Context, project, name string, r io.Reader) error {
+	defer n.borrow()()
+	return n.Manager.ImportDisk(ctx, project, name, r)
+}
+
+func (n *sharedNode) NodeInfo(ctx context.Context) (*settingsv1.NodeInfo, error) {
+	defer n.borrow()()
+	return n.Manager.NodeInfo(ctx)
+}
+
+func (n *sharedNode) ListAuditRecords(ctx context.Context, q *settingsv1.AuditQuery) ([]*settingsv1.AuditRecord, error) {
+	defer n.borrow()()
+	return n.Manager.ListAuditRecords(ctx, q)
+}
+
+type borrowedReader struct {
+	io.ReadCloser
+	done func()
+}
+
+func (r *borrowedReader) Close() error {
+	defer r.done()
+	return r.ReadCloser.Close()
+}
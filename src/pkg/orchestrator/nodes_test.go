@@ -0,0 +1,182 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testNodeConfig(name string) *settingsv1.Node {
+	return &settingsv1.Node{
+		Name:                 name,
+		Endpoint:             "127.0.0.1:1",
+		FileRegistryEndpoint: "127.0.0.1:2",
+		EventsEndpoint:       "127.0.0.1:3",
+	}
+}
+
+func labeledNodeConfig(name string, labels map[string]string) *settingsv1.Node {
+	cfg := testNodeConfig(name)
+	cfg.Labels = labels
+	return cfg
+}
+
+// newNodesTestServer returns a test server whose runtime nodes are
+// fakeNodes, recorded in connected by name.
+func newNodesTestServer(t *testing.T, nodes map[string]node.Manager) (*Server, map[string]*fakeNode) {
+	s := newTestServer(nodes)
+	t.Cleanup(s.Close)
+	connected := make(map[string]*fakeNode)
+	s.connectNode = func(cfg *settingsv1.Node) (node.Manager, error) {
+		n := newFakeNode()
+		connected[cfg.Name] = n
+		return n, nil
+	}
+	return s, connected
+}
+
+func TestAddNode(t *testing.T) {
+	s, connected := newNodesTestServer(t, map[string]node.Manager{"a": newFakeNode()})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		node *settingsv1.Node
+		code codes.Code
+	}{
+		{nil, codes.InvalidArgument},
+		{&settingsv1.Node{Name: "b"}, codes.InvalidArgument},
+		{testNodeConfig(AutoNode), codes.InvalidArgument},
+		{labeledNodeConfig("b", map[string]string{"disk": "not valid"}), codes.InvalidArgument},
+		{testNodeConfig("a"), codes.AlreadyExists},
+	} {
+		_, err := s.AddNode(ctx, &orchestratorv1.AddNodeRequest{Node: tc.node})
+		assert.Equal(t, tc.code, status.Code(err), "%v", tc.node)
+	}
+
+	added, err := s.AddNode(ctx, &orchestratorv1.AddNodeRequest{Node: labeledNodeConfig("b", map[string]string{"disk": "ssd"})})
+	require.NoError(t, err)
+	assert.Equal(t, "b", added.Name)
+	assert.Equal(t, map[string]string{"disk": "ssd"}, s.nodeConfig("b").GetLabels())
+
+	_, nm, err := s.getNode("b")
+	require.NoError(t, err)
+	assert.Same(t, connected["b"], nm.(*sharedNode).Manager)
+
+	stored, err := s.state.ListNodes()
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "b", stored[0].Name)
+
+	resp, err := s.ListNodes(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, resp.Nodes, 2)
+}
+
+func TestUpdateNode(t *testing.T) {
+	s, connected := newNodesTestServer(t, map[string]node.Manager{"a": newFakeNode()})
+	ctx := context.Background()
+
+	_, err := s.UpdateNode(ctx, &orchestratorv1.UpdateNodeRequest{Name: "a", Node: testNodeConfig("a")})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = s.UpdateNode(ctx, &orchestratorv1.UpdateNodeRequest{Name: "x", Node: testNodeConfig("x")})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.AddNode(ctx, &orchestratorv1.AddNodeRequest{Node: testNodeConfig("b")})
+	require.NoError(t, err)
+	old := connected["b"]
+
+	_, err = s.UpdateNode(ctx, &orchestratorv1.UpdateNodeRequest{Name: "b", Node: testNodeConfig("c")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	cfg := testNodeConfig("")
+	cfg.Endpoint = "127.0.0.1:4"
+	updated, err := s.UpdateNode(ctx, &orchestratorv1.UpdateNodeRequest{Name: "b", Node: cfg})
+	require.NoError(t, err)
+	assert.Equal(t, "b", updated.Name)
+	assert.NotSame(t, old, connected["b"])
+	assert.Equal(t, "127.0.0.1:4", s.nodeConfig("b").Endpoint)
+	assert.True(t, old.isClosed())
+
+	// Calls in flight keep using the old manager, which is closed once the
+	// last of them returns.
+	old = connected["b"]
+	old.disks["vm1"] = []byte("disk")
+	_, nm, err := s.getNode("b")
+	require.NoError(t, err)
+	disk, _, err := nm.ExportDisk(ctx, "", "vm1")
+	require.NoError(t, err)
+	_, err = s.UpdateNode(ctx, &orchestratorv1.UpdateNodeRequest{Name: "b", Node: testNodeConfig("b")})
+	require.NoError(t, err)
+	assert.False(t, old.isClosed())
+	require.NoError(t, disk.Close())
+	assert.True(t, old.isClosed())
+}
+
+func TestRemoveNode(t *testing.T) {
+	s, connected := newNodesTestServer(t, map[string]node.Manager{"a": newFakeNode()})
+	ctx := context.Background()
+
+	_, err := s.RemoveNode(ctx, &orchestratorv1.RemoveNodeRequest{Name: "a", Force: true})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = s.RemoveNode(ctx, &orchestratorv1.RemoveNodeRequest{Name: "x"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.AddNode(ctx, &orchestratorv1.AddNodeRequest{Node: testNodeConfig("b")})
+	require.NoError(t, err)
+	b := connected["b"]
+	b.instances["vm1"] = instanceWithMAC("vm1", "52:54:00:00:00:01")
+
+	_, err = s.RemoveNode(ctx, &orchestratorv1.RemoveNodeRequest{Name: "b"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	b.down = true
+	_, err = s.RemoveNode(ctx, &orchestratorv1.RemoveNodeRequest{Name: "b"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = s.RemoveNode(ctx, &orchestratorv1.RemoveNodeRequest{Name: "b", Force: true})
+	require.NoError(t, err)
+	assert.True(t, b.isClosed())
+	_, _, err = s.getNode("b")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	stored, _ := s.state.ListNodes()
+	assert.Empty(t, stored)
+	assert.NotContains(t, s.health.snapshot(), "b")
+}
+
+func TestLoadNodes(t *testing.T) {
+	s, connected := newNodesTestServer(t, map[string]node.Manager{"a": newFakeNode()})
+	require.NoError(t, s.state.PutNode(testNodeConfig("a")))
+	require.NoError(t, s.state.PutNode(testNodeConfig("b")))
+
+	require.NoError(t, s.state.PutNode(testNodeConfig("c")))
+	connect := s.connectNode
+	s.connectNode = func(cfg *settingsv1.Node) (node.Manager, error) {
+		if cfg.Name == "c" {
+			return nil, errors.New("unreachable")
+		}
+		return connect(cfg)
+	}
+
+	require.NoError(t, s.loadNodes())
+	assert.NotContains(t, connected, "a")
+	assert.Contains(t, connected, "b")
+	_, _, err := s.getNode("b")
+	assert.NoError(t, err)
+	_, _, err = s.getNode("c")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Nodes that couldn't be connected are retried.
+	s.connectNode = connect
+	s.connectRegisteredNodes()
+	_, _, err = s.getNode("c")
+	assert.NoError(t, err)
+	assert.Same(t, connected["b"], s.nodes["b"].(*sharedNode).Manager, "attached nodes are left alone")
+}
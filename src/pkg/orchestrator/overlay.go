@@ -0,0 +1,238 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/overlay"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// firstOverlayVNI is where VNI allocation starts when none is requested,
+// leaving the low range for manually assigned identifiers.
+const firstOverlayVNI = 1000
+
+func (s *Server) CreateOverlayNetwork(ctx context.Context, req *orchestratorv1.CreateOverlayNetworkRequest) (*settingsv1.OverlayNetwork, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	network := req.GetNetwork()
+	if network == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "network is required")
+	}
+	if err := overlay.ValidateName(network.Name); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid overlay network: %v", err)
+	}
+	if network.Subnet != "" {
+		if _, _, err := net.ParseCIDR(network.Subnet); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid subnet %q: %v", network.Subnet, err)
+		}
+	}
+	if network.Vni != 0 {
+		if err := overlay.ValidateVNI(network.Vni); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid overlay network: %v", err)
+		}
+	}
+
+	s.overlayMu.Lock()
+	defer s.overlayMu.Unlock()
+
+	existing, listErr := s.state.ListOverlayNetworks()
+	if listErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list overlay networks: %v", listErr)
+	}
+	used := make(map[uint32]bool, len(existing))
+	for _, n := range existing {
+		if n.Name == network.Name {
+			return nil, status.Errorf(codes.AlreadyExists, "overlay network %s already exists", network.Name)
+		}
+		used[n.Vni] = true
+	}
+
+	result := &settingsv1.OverlayNetwork{Name: network.Name, Vni: network.Vni, Subnet: network.Subnet}
+	if result.Vni == 0 {
+		vni, err := allocateVNI(used)
+		if err != nil {
+			return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+		}
+		result.Vni = vni
+	} else if used[result.Vni] {
+		return nil, status.Errorf(codes.AlreadyExists, "vni %d is already in use", result.Vni)
+	}
+
+	if putErr := s.state.PutOverlayNetwork(result); putErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store overlay network: %v", putErr)
+	}
+	s.pushOverlayNetworks(ctx)
+
+	return result, nil
+}
+
+func (s *Server) GetOverlayNetwork(ctx context.Context, req *orchestratorv1.GetOverlayNetworkRequest) (*settingsv1.OverlayNetwork, error) {
+	if authErr := s.authorizeAnywhere(ctx, viewer); authErr != nil {
+		return nil, authErr
+	}
+	network, err := s.state.GetOverlayNetwork(req.Name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "overlay network %s not found", req.Name)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get overlay network: %v", err)
+	}
+	return network, nil
+}
+
+// DeleteOverlayNetwork removes an overlay network unless an instance on any
+// node is still attached to it. Every node has to answer so an unreachable
+// one can't hide an attached instance.
+func (s *Server) DeleteOverlayNetwork(ctx context.Context, req *orchestratorv1.DeleteOverlayNetworkRequest) (*emptypb.Empty, error) {
+	if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+		return nil, authErr
+	}
+	s.overlayMu.Lock()
+	defer s.overlayMu.Unlock()
+
+	if _, err := s.state.GetOverlayNetwork(req.Name); errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "overlay network %s not found", req.Name)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get overlay network: %v", err)
+	}
+
+	for nodeName, nm := range s.nodeManagers() {
+		infos, infoErr := nm.Info(ctx, node.AllProjects, "")
+		if infoErr != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to check instances on %s: %v", nodeName, infoErr)
+		}
+		for _, info := range infos {
+			if info.GetSpec().GetOverlayNetwork() == req.Name {
+				return nil, status.Errorf(codes.FailedPrecondition, "overlay network %s is used by instance %s on %s", req.Name, info.Name, nodeName)
+			}
+		}
+	}
+
+	if err := s.state.RemoveOverlayNetwork(req.Name); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove overlay network: %v", err)
+	}
+	s.pushOverlayNetworks(ctx)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ListOverlayNetworks(ctx context.Context, _ *emptypb.Empty) (*orchestratorv1.ListOverlayNetworksResponse, error) {
+	if authErr := s.authorizeAnywhere(ctx, viewer); authErr != nil {
+		return nil, authErr
+	}
+	networks, err := s.state.ListOverlayNetworks()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list overlay networks: %v", err)
+	}
+	return &orchestratorv1.ListOverlayNetworksResponse{Networks: networks}, nil
+}
+
+// checkOverlayNetwork verifies an instance's overlay network exists.
+func (s *Server) checkOverlayNetwork(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, err := s.state.GetOverlayNetwork(name); errors.Is(err, ErrNotFound) {
+		return status.Errorf(codes.InvalidArgument, "overlay network %s not found", name)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "failed to get overlay network: %v", err)
+	}
+	return nil
+}
+
+func allocateVNI(used map[uint32]bool) (uint32, error) {
+	for vni := uint32(firstOverlayVNI); vni <= overlay.MaxVNI; vni++ {
+		if !used[vni] {
+			return vni, nil
+		}
+	}
+	return 0, errors.New("no free vni left")
+}
+
+// pushOverlayNetworks syncs the overlay networks to every node in the
+// background, like pushDNSRecords.
+func (s *Server) pushOverlayNetworks(ctx context.Context) {
+	go func() {
+		asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
+		defer cancel()
+		s.syncOverlayNetworks(asyncCtx)
+	}()
+}
+
+// syncOverlayNetworks sends every node the full list of overlay networks
+// together with the underlay addresses of all the other nodes.
+func (s *Server) syncOverlayNetworks(ctx context.Context) {
+	s.overlaySyncMu.Lock()
+	defer s.overlaySyncMu.Unlock()
+
+	networks, err := s.state.ListOverlayNetworks()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list overlay networks for sync", "error", err)
+		return
+	}
+
+	nodes := s.nodeList()
+	addrs := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		name, addr := n.Name, overlayAddress(n)
+		ip, resolveErr := resolveUnderlay(ctx, addr)
+		if resolveErr != nil {
+			slog.WarnContext(ctx, "Failed to resolve overlay address", "node", name, "address", addr, "error", resolveErr)
+			continue
+		}
+		addrs[name] = ip
+	}
+
+	for name, nm := range s.nodeManagers() {
+		peers := make([]string, 0, len(addrs))
+		for peer, ip := range addrs {
+			if peer != name {
+				peers = append(peers, ip)
+			}
+		}
+		if setErr := nm.SetOverlayNetworks(ctx, networks, peers); setErr != nil {
+			slog.WarnContext(ctx, "Failed to push overlay networks", "node", name, "error", setErr)
+		}
+	}
+}
+
+// overlayAddress returns the underlay address of a node: the configured
+// overlay address, or else the host of its controller endpoint.
+func overlayAddress(n *settingsv1.Node) string {
+	if n.OverlayAddress != "" {
+		return n.OverlayAddress
+	}
+	host, _, err := net.SplitHostPort(n.Endpoint)
+	if err != nil {
+		return n.Endpoint
+	}
+	return host
+}
+
+// resolveUnderlay turns an address into an IPv4 address, resolving host
+// names; VXLAN peers have to be given as IPs.
+func resolveUnderlay(ctx context.Context, addr string) (string, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String(), nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", addr)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no IPv4 address for %s", addr)
+	}
+	return ips[0].String(), nil
+}
@@ -0,0 +1,153 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validatePlacement checks that the placement's selectors parse.
+func validatePlacement(p *settingsv1.Placement) error {
+	if p == nil {
+		return nil
+	}
+	if err := labels.Validate(p.NodeSelector); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid nodeSelector: %v", err)
+	}
+	for _, rule := range []struct {
+		name  string
+		terms []*settingsv1.AffinityTerm
+	}{
+		{"nodeAffinity", p.NodeAffinity},
+		{"instanceAffinity", p.InstanceAffinity},
+		{"instanceAntiAffinity", p.InstanceAntiAffinity},
+	} {
+		for _, term := range rule.terms {
+			if term.GetLabelSelector() == "" {
+				return status.Errorf(codes.InvalidArgument, "invalid %s: labelSelector is required", rule.name)
+			}
+			if _, err := labels.Parse(term.GetLabelSelector()); err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid %s: %v", rule.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func hasInstanceAffinity(p *settingsv1.Placement) bool {
+	return len(p.GetInstanceAffinity()) > 0 || len(p.GetInstanceAntiAffinity()) > 0
+}
+
+// termMatches reports whether the term's selector matches the node's labels
+// or, for instance terms, any of its instances' labels.
+func termMatches(term *settingsv1.AffinityTerm, nodeLabels map[string]string, instanceLabels []map[string]string, instances bool) (bool, error) {
+	selector, err := labels.Parse(term.GetLabelSelector())
+	if err != nil {
+		return false, err
+	}
+	if !instances {
+		return selector.Matches(nodeLabels), nil
+	}
+	for _, l := range instanceLabels {
+		if selector.Matches(l) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MatchesPlacement rejects nodes that break the instance's node selector
+// or required affinity and anti-affinity rules.
+func MatchesPlacement(spec *controllerv1.VMSpec, c *Candidate) error {
+	p := spec.GetPlacement()
+	for key, value := range p.GetNodeSelector() {
+		if got, ok := c.Labels[key]; !ok || got != value {
+			return fmt.Errorf("node label %s=%s is required", key, value)
+		}
+	}
+	for _, rule := range []struct {
+		terms     []*settingsv1.AffinityTerm
+		instances bool
+		want      bool
+		reason    string
+	}{
+		{p.GetNodeAffinity(), false, true, "node labels don't match %q"},
+		{p.GetInstanceAffinity(), true, true, "no instance matching %q"},
+		{p.GetInstanceAntiAffinity(), true, false, "runs an instance matching %q"},
+	} {
+		for _, term := range rule.terms {
+			if term.GetPreferred() {
+				continue
+			}
+			matched, err := termMatches(term, c.Labels, c.InstanceLabels, rule.instances)
+			if err != nil {
+				return err
+			}
+			if matched != rule.want {
+				return fmt.Errorf(rule.reason, term.GetLabelSelector())
+			}
+		}
+	}
+	return nil
+}
+
+// PreferredPlacement prefers nodes meeting more of the instance's preferred
+// affinity and anti-affinity rules. Instances without any score 1.
+func PreferredPlacement(spec *controllerv1.VMSpec, c *Candidate) float64 {
+	p := spec.GetPlacement()
+	total, met := 0, 0
+	for _, rule := range []struct {
+		terms     []*settingsv1.AffinityTerm
+		instances bool
+		want      bool
+	}{
+		{p.GetNodeAffinity(), false, true},
+		{p.GetInstanceAffinity(), true, true},
+		{p.GetInstanceAntiAffinity(), true, false},
+	} {
+		for _, term := range rule.terms {
+			if !term.GetPreferred() {
+				continue
+			}
+			total++
+			if matched, err := termMatches(term, c.Labels, c.InstanceLabels, rule.instances); err == nil && matched == rule.want {
+				met++
+			}
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(met) / float64(total)
+}
+
+// placeOn fails with FailedPrecondition if the node breaks spec's required
+// placement rules, counting the pending placements, and otherwise records
+// spec as placed there until release is called. Describing the node, the
+// check and the record happen under one hold of placements.mu, so
+// concurrent placements can't all pass against the same state. It's for
+// nodes picked by the user rather than the scheduler.
+func (s *Server) placeOn(ctx context.Context, nodeName string, spec *controllerv1.VMSpec) (func(), error) {
+	s.placements.mu.Lock()
+	defer s.placements.mu.Unlock()
+	if spec.GetPlacement() != nil {
+		_, nm, err := s.getNode(nodeName)
+		if err != nil {
+			return nil, err
+		}
+		c, candidateErr := s.candidate(ctx, nodeName, nm, spec)
+		if candidateErr != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to check placement on %s: %v", nodeName, candidateErr)
+		}
+		s.placements.count(c, spec)
+		if placementErr := MatchesPlacement(spec, c); placementErr != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "node %s doesn't meet the placement rules: %v", nodeName, placementErr)
+		}
+	}
+	return s.placements.add(nodeName, spec), nil
+}
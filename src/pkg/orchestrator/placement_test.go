@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func placedSpec(p *settingsv1.Placement, instanceLabels map[string]string) *controllerv1.VMSpec {
+	spec := specWith(1, 512, 5)
+	spec.Placement = p
+	spec.Labels = instanceLabels
+	return spec
+}
+
+func TestSchedule_Placement(t *testing.T) {
+	candidates := []*Candidate{
+		{Name: "a", Labels: map[string]string{"disk": "ssd", "zone": "1"}, InstanceLabels: []map[string]string{{"app": "db"}}},
+		{Name: "b", Labels: map[string]string{"disk": "hdd", "zone": "1"}},
+		{Name: "c", Labels: map[string]string{"disk": "ssd", "zone": "2"}, InstanceLabels: []map[string]string{{"app": "web"}}},
+	}
+	for _, tc := range []struct {
+		name string
+		p    *settingsv1.Placement
+		want string
+	}{
+		{"node selector", &settingsv1.Placement{NodeSelector: map[string]string{"disk": "hdd"}}, "b"},
+		{"node affinity", &settingsv1.Placement{NodeAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "zone=2"}}}, "c"},
+		{"instance affinity", &settingsv1.Placement{InstanceAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "app=db"}}}, "a"},
+		{"anti-affinity", &settingsv1.Placement{
+			NodeSelector:         map[string]string{"disk": "ssd"},
+			InstanceAntiAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "app=db"}},
+		}, "c"},
+		{"preferred", &settingsv1.Placement{NodeAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "disk=hdd", Preferred: true}}}, "b"},
+		{"preferred anti-affinity", &settingsv1.Placement{
+			NodeSelector:         map[string]string{"disk": "ssd"},
+			InstanceAntiAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "app=web", Preferred: true}},
+		}, "a"},
+	} {
+		picked, err := DefaultScheduler().Schedule(placedSpec(tc.p, nil), candidates)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want, picked, tc.name)
+	}
+
+	_, err := DefaultScheduler().Schedule(placedSpec(&settingsv1.Placement{NodeSelector: map[string]string{"gpu": "yes"}}, nil), candidates)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node label gpu=yes is required")
+}
+
+func TestCreate_Placement(t *testing.T) {
+	a, b := newFakeNode(), newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": a, "b": b})
+	s.nodeConfigs["a"].Labels = map[string]string{"disk": "ssd"}
+	s.nodeConfigs["b"].Labels = map[string]string{"disk": "ssd"}
+	ctx := context.Background()
+
+	// Replicas of a group spread over the hosts, then run out of them.
+	spread := &settingsv1.Placement{InstanceAntiAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "group=ha"}}}
+	group := map[string]string{"group": "ha"}
+	first, err := s.Create(ctx, &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm1", Spec: placedSpec(spread, group)})
+	require.NoError(t, err)
+	second, err := s.Create(ctx, &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm2", Spec: placedSpec(spread, group)})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Node, second.Node)
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm3", Spec: placedSpec(spread, group)})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Picking a node by hand still has to meet the required rules.
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: "a", Name: "vm3", Spec: placedSpec(spread, group)})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	hdd := &settingsv1.Placement{NodeSelector: map[string]string{"disk": "hdd"}}
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: "a", Name: "vm3", Spec: placedSpec(hdd, nil)})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = s.Create(ctx, &orchestratorv1.CreateRequest{Node: "a", Name: "vm3", Spec: placedSpec(nil, group)})
+	assert.NoError(t, err)
+
+	// A stopped replica can't be moved next to another one.
+	_, err = s.Move(ctx, &orchestratorv1.MoveRequest{Node: first.Node, Name: "vm1", TargetNode: second.Node})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	for _, p := range []*settingsv1.Placement{
+		{NodeSelector: map[string]string{"bad key": "x"}},
+		{NodeAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "zone in (1"}}},
+		{InstanceAntiAffinity: []*settingsv1.AffinityTerm{{}}},
+	} {
+		_, err := s.Create(ctx, &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm9", Spec: placedSpec(p, nil)})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", p)
+	}
+}
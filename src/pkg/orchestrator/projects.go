@@ -0,0 +1,34 @@
+package orchestrator
+
+import (
+	"context"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// checkProject fails unless project is defined or empty, for the shared
+// default project. Instance names are scoped to their project, so every
+// project has its own namespace.
+func (s *Server) checkProject(project string) error {
+	if project != "" && !s.policy.HasProject(project) {
+		return status.Errorf(codes.InvalidArgument, "unknown project %s", project)
+	}
+	return nil
+}
+
+// instanceRef names the instance called name in project in messages and
+// events.
+func instanceRef(project, name string) string {
+	if project == "" {
+		return name
+	}
+	return project + "/" + name
+}
+
+func (s *Server) ListProjects(ctx context.Context, _ *emptypb.Empty) (*orchestratorv1.ListProjectsResponse, error) {
+	return &orchestratorv1.ListProjectsResponse{Projects: s.policy.Projects(auth.FromContext(ctx))}, nil
+}
@@ -0,0 +1,99 @@
+package orchestrator
+
+import (
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestProjects(t *testing.T) {
+	n := newFakeNode(instanceWithMAC("web", "52:54:00:00:00:01"))
+	s := newTestServer(map[string]node.Manager{"a": n})
+	policy, err := rbac.NewPolicy([]*settingsv1.RoleBinding{
+		{Group: "ops", Role: settingsv1.Role_ROLE_ADMIN},
+		{Subject: rbac.AnySubject, Role: settingsv1.Role_ROLE_OPERATOR},
+	}, []*settingsv1.Project{
+		{Name: "team-a", Subjects: []string{"alice"}},
+		{Name: "team-b", Groups: []string{"b"}},
+	})
+	require.NoError(t, err)
+	s.policy = policy
+	alice, bob, carol, root := as("alice"), as("bob", "b"), as("carol"), as("root", "ops")
+	inProject := func(project string) *controllerv1.VMSpec {
+		spec := specWith(1, 512, 5)
+		spec.Project = project
+		return spec
+	}
+
+	// Both projects get their own "db".
+	resp, err := s.Create(alice, &orchestratorv1.CreateRequest{Node: "a", Name: "db", Spec: inProject("team-a")})
+	require.NoError(t, err)
+	assert.Equal(t, "db", resp.Name)
+	resp, err = s.Create(bob, &orchestratorv1.CreateRequest{Node: "a", Name: "db", Spec: inProject("team-b")})
+	require.NoError(t, err)
+	assert.Equal(t, "db", resp.Name)
+	infos, err := n.Info(alice, "team-a", "db")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", infos[0].Spec.Owner)
+
+	_, err = s.Create(alice, &orchestratorv1.CreateRequest{Node: "a", Name: "db", Spec: inProject("team-b")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.Create(alice, &orchestratorv1.CreateRequest{Node: "a", Name: "db", Spec: inProject("team-c")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Requests name the project alongside the instance.
+	_, err = s.Stop(alice, &orchestratorv1.StopRequest{Node: "a", Name: "db"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.Stop(alice, &orchestratorv1.StopRequest{Node: "a", Project: "team-a", Name: "db"})
+	require.NoError(t, err)
+
+	// Members only see and touch their own project's instances.
+	list, err := s.ListInstances(alice, &orchestratorv1.ListInstancesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/team-a/db", "a/web"}, listedNames(list))
+	list, err = s.ListInstances(carol, &orchestratorv1.ListInstancesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/web"}, listedNames(list))
+	_, err = s.Stop(alice, &orchestratorv1.StopRequest{Node: "a", Project: "team-b", Name: "db"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.Remove(carol, &orchestratorv1.RemoveRequest{Node: "a", Project: "team-a", Name: "db"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.True(t, n.has("team-a/db"))
+
+	// Listing a node covers one project unless every project is asked for.
+	info, err := s.Info(root, &orchestratorv1.InfoRequest{Node: "a", Project: "team-a"})
+	require.NoError(t, err)
+	require.Len(t, info.Info, 1)
+	assert.Equal(t, "team-a", info.Info[0].Info.Spec.Project)
+	info, err = s.Info(root, &orchestratorv1.InfoRequest{Node: "a"})
+	require.NoError(t, err)
+	require.Len(t, info.Info, 1)
+	assert.Equal(t, "web", info.Info[0].Info.Name)
+	info, err = s.Info(root, &orchestratorv1.InfoRequest{Node: "a", AllProjects: true})
+	require.NoError(t, err)
+	assert.Len(t, info.Info, 3)
+	_, err = s.Info(root, &orchestratorv1.InfoRequest{Node: "a", Name: "db", AllProjects: true})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Cluster admins see every project.
+	list, err = s.ListInstances(root, &orchestratorv1.ListInstancesRequest{Project: "team-b"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/team-b/db"}, listedNames(list))
+
+	projects, err := s.ListProjects(bob, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, projects.Projects, 1)
+	assert.Equal(t, "team-b", projects.Projects[0].Name)
+	projects, err = s.ListProjects(root, &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Len(t, projects.Projects, 2)
+}
@@ -0,0 +1,288 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AnySubject is the Quota subject that applies to every subject without a
+// quota of their own.
+const AnySubject = "*"
+
+// quotas enforces the configured quotas on creates, and refuses updates
+// to the instances of owners who are over theirs.
+type quotas struct {
+	list []*settingsv1.Quota
+	// mu guards the creates that listings may miss. Checks hold it from
+	// adding them up to admitting the create, so concurrent creates can't
+	// both slip under a limit, but not while they list the instances.
+	mu sync.Mutex
+	// inflight are the specs of admitted creates that haven't finished.
+	inflight map[*controllerv1.VMSpec]bool
+	// finished are the specs of creates that finished while checks were
+	// listing instances, which the listings may have missed.
+	finished []*controllerv1.VMSpec
+	// listing is the number of checks listing instances.
+	listing int
+}
+
+// listed returns the specs of the instances on every node. mu isn't held
+// while the nodes are called, so the caller must add pending to them
+// afterwards, even if listing fails.
+func (q *quotas) listed(ctx context.Context, nodes map[string]node.Manager) ([]*controllerv1.VMSpec, error) {
+	q.mu.Lock()
+	q.listing++
+	q.mu.Unlock()
+
+	instances, nodeErrs := listInstances(ctx, nodes)
+	if len(nodeErrs) > 0 {
+		return nil, status.Errorf(codes.Unavailable, "can't check quotas while nodes are unreachable: %s",
+			strings.Join(slices.Sorted(maps.Keys(nodeErrs)), ", "))
+	}
+	specs := make([]*controllerv1.VMSpec, 0, len(instances))
+	for _, inst := range instances {
+		specs = append(specs, inst.Info.GetSpec())
+	}
+	return specs, nil
+}
+
+// pending ends a listing started by listed and returns the creates it may
+// have missed: those in flight and those that finished while it ran. The
+// listing may have seen the latter, so they can count twice. The caller
+// holds mu.
+func (q *quotas) pending() []*controllerv1.VMSpec {
+	q.listing--
+	specs := append(slices.Collect(maps.Keys(q.inflight)), q.finished...)
+	if q.listing == 0 {
+		q.finished = nil
+	}
+	return specs
+}
+
+// admit records spec as in flight until release is called. The caller
+// holds mu.
+func (q *quotas) admit(spec *controllerv1.VMSpec) func() {
+	if q.inflight == nil {
+		q.inflight = make(map[*controllerv1.VMSpec]bool)
+	}
+	q.inflight[spec] = true
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		delete(q.inflight, spec)
+		if q.listing > 0 {
+			q.finished = append(q.finished, spec)
+		}
+	}
+}
+
+// checkQuotas returns an error naming the first of applicable that the specs
+// owned by subject go over.
+func checkQuotas(applicable []*settingsv1.Quota, subject string, specs []*controllerv1.VMSpec) error {
+	for _, quota := range applicable {
+		used := &settingsv1.Usage{}
+		for _, spec := range specs {
+			if counts(quota, subject, spec) {
+				addUsage(used, spec)
+			}
+		}
+		if err := exceeded(quota, used); err != nil {
+			return status.Errorf(codes.ResourceExhausted, "quota of %s exceeded: %v", quotaName(quota, subject), err)
+		}
+	}
+	return nil
+}
+
+func validateQuotas(list []*settingsv1.Quota) error {
+	for _, q := range list {
+		if (q.Subject == "") == (q.Group == "") {
+			return errors.New("quota needs exactly one of subject and group")
+		}
+	}
+	return nil
+}
+
+// applicable returns the quotas limiting an identity: the subject's own,
+// or the AnySubject one, and those of its groups.
+func (q *quotas) applicable(subject string, groups []string) []*settingsv1.Quota {
+	var own, fallback, out []*settingsv1.Quota
+	for _, quota := range q.list {
+		switch {
+		case quota.Subject == subject:
+			own = append(own, quota)
+		case quota.Subject == AnySubject:
+			fallback = append(fallback, quota)
+		case quota.Group != "" && slices.Contains(groups, quota.Group):
+			out = append(out, quota)
+		}
+	}
+	if len(own) == 0 {
+		own = fallback
+	}
+	return append(own, out...)
+}
+
+// counts reports whether instances with spec count against quota for
+// subject.
+func counts(quota *settingsv1.Quota, subject string, spec *controllerv1.VMSpec) bool {
+	if quota.Group != "" {
+		return slices.Contains(spec.GetOwnerGroups(), quota.Group)
+	}
+	return spec.GetOwner() == subject
+}
+
+func addUsage(u *settingsv1.Usage, spec *controllerv1.VMSpec) {
+	u.Instances++
+	u.Cpus += uint64(spec.GetVm().GetCpus())
+	u.Memory += uint64(spec.GetVm().GetMemory())
+	u.Disk += uint64(spec.GetVm().GetDisk())
+}
+
+// exceeded names the first limit of quota that u is over.
+func exceeded(quota *settingsv1.Quota, u *settingsv1.Usage) error {
+	for _, l := range []struct {
+		name      string
+		used, max uint64
+	}{
+		{"instances", uint64(u.Instances), uint64(quota.MaxInstances)},
+		{"cpus", u.Cpus, quota.MaxCpus},
+		{"memory", u.Memory, quota.MaxMemory},
+		{"disk", u.Disk, quota.MaxDisk},
+	} {
+		if l.max != 0 && l.used > l.max {
+			return fmt.Errorf("%s would be %d of %d", l.name, l.used, l.max)
+		}
+	}
+	return nil
+}
+
+func quotaName(quota *settingsv1.Quota, subject string) string {
+	if quota.Group != "" {
+		return "group " + quota.Group
+	}
+	return "subject " + subject
+}
+
+// identity returns the caller's identity, treating callers without one as
+// anonymous.
+func identity(ctx context.Context) *auth.Identity {
+	if id := auth.FromContext(ctx); id != nil {
+		return id
+	}
+	return auth.Anonymous()
+}
+
+// reserveQuota records the caller as spec's owner and checks the instance
+// fits the quotas that apply to them. The instance counts as in flight
+// against them until release is called, which should be once it's created.
+// Usage can't be checked while nodes are unreachable, so quota-bound
+// creates are refused then.
+func (s *Server) reserveQuota(ctx context.Context, spec *controllerv1.VMSpec) (func(), error) {
+	id := identity(ctx)
+	spec.Owner = id.Subject
+	spec.OwnerGroups = id.Groups
+
+	applicable := s.quotas.applicable(id.Subject, id.Groups)
+	if len(applicable) == 0 {
+		return func() {}, nil
+	}
+
+	listed, listErr := s.quotas.listed(ctx, s.nodeManagers())
+
+	s.quotas.mu.Lock()
+	defer s.quotas.mu.Unlock()
+	specs := append(s.quotas.pending(), listed...)
+	if listErr != nil {
+		return nil, listErr
+	}
+	if err := checkQuotas(applicable, id.Subject, append(specs, spec)); err != nil {
+		return nil, err
+	}
+	return s.quotas.admit(spec), nil
+}
+
+// checkOwnerQuota refuses updates to an instance whose owner is over one of
+// their quotas, such as after it was lowered, until they're back under it.
+func (s *Server) checkOwnerQuota(ctx context.Context, spec *controllerv1.VMSpec) error {
+	applicable := s.quotas.applicable(spec.GetOwner(), spec.GetOwnerGroups())
+	if len(applicable) == 0 {
+		return nil
+	}
+	listed, listErr := s.quotas.listed(ctx, s.nodeManagers())
+	s.quotas.mu.Lock()
+	specs := append(s.quotas.pending(), listed...)
+	s.quotas.mu.Unlock()
+	if listErr != nil {
+		return listErr
+	}
+	return checkQuotas(applicable, spec.GetOwner(), specs)
+}
+
+// GetUsage adds up the instances owned by the requested subject or group,
+// or by the caller, and reports the quotas that apply. Only admins may ask
+// about subjects other than themselves and groups they aren't in.
+func (s *Server) GetUsage(ctx context.Context, req *orchestratorv1.GetUsageRequest) (*orchestratorv1.GetUsageResponse, error) {
+	if req.Subject != "" && req.Group != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "only one of subject and group can be set")
+	}
+	if id := identity(ctx); (req.Subject != "" && req.Subject != id.Subject) || (req.Group != "" && !slices.Contains(id.Groups, req.Group)) {
+		if authErr := s.authorize(ctx, admin, rbac.Resource{}); authErr != nil {
+			return nil, authErr
+		}
+	}
+	resp := &orchestratorv1.GetUsageResponse{Subject: req.Subject, Group: req.Group, Usage: &settingsv1.Usage{}}
+	var (
+		applicable []*settingsv1.Quota
+		owned      func(spec *controllerv1.VMSpec) bool
+	)
+	switch {
+	case req.Group != "":
+		for _, quota := range s.quotas.list {
+			if quota.Group == req.Group {
+				applicable = append(applicable, quota)
+			}
+		}
+		owned = func(spec *controllerv1.VMSpec) bool { return slices.Contains(spec.GetOwnerGroups(), req.Group) }
+	case req.Subject != "":
+		// The subject's groups aren't known, so only their own quota applies.
+		applicable = s.quotas.applicable(req.Subject, nil)
+		owned = func(spec *controllerv1.VMSpec) bool { return spec.GetOwner() == req.Subject }
+	default:
+		id := identity(ctx)
+		resp.Subject = id.Subject
+		applicable = s.quotas.applicable(id.Subject, id.Groups)
+		owned = func(spec *controllerv1.VMSpec) bool { return spec.GetOwner() == id.Subject }
+	}
+
+	instances, nodeErrs := listInstances(ctx, s.nodeManagers())
+	resp.Errors = nodeErrs
+	for _, quota := range applicable {
+		resp.Quotas = append(resp.Quotas, &orchestratorv1.QuotaUsage{Quota: quota, Used: &settingsv1.Usage{}})
+	}
+	for _, inst := range instances {
+		spec := inst.Info.GetSpec()
+		if owned(spec) {
+			addUsage(resp.Usage, spec)
+		}
+		for _, qu := range resp.Quotas {
+			if counts(qu.Quota, resp.Subject, spec) {
+				addUsage(qu.Used, spec)
+			}
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,210 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func as(subject string, groups ...string) context.Context {
+	return auth.WithIdentity(context.Background(), &auth.Identity{Subject: subject, Groups: groups})
+}
+
+func TestCreate_Quotas(t *testing.T) {
+	n := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": n})
+	s.quotas.list = []*settingsv1.Quota{
+		{Subject: AnySubject, MaxInstances: 1},
+		{Subject: "bob", MaxCpus: 4},
+		{Group: "dev", MaxMemory: 1024},
+	}
+	create := func(ctx context.Context, name string, cpus, mem uint32) error {
+		_, err := s.Create(ctx, &orchestratorv1.CreateRequest{Node: "a", Name: name, Spec: specWith(cpus, mem, 5)})
+		return err
+	}
+
+	// Subjects without a quota of their own get the AnySubject one.
+	require.NoError(t, create(as("alice"), "vm1", 1, 256))
+	err := create(as("alice"), "vm2", 1, 256)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "quota of subject alice exceeded: instances would be 2 of 1")
+
+	infos, err := n.Info(context.Background(), "", "vm1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", infos[0].Spec.Owner)
+
+	// Bob's own quota replaces it.
+	require.NoError(t, create(as("bob"), "vm2", 2, 256))
+	require.NoError(t, create(as("bob"), "vm3", 2, 256))
+	err = create(as("bob"), "vm4", 1, 256)
+	assert.Contains(t, err.Error(), "cpus would be 5 of 4")
+
+	// Group quotas add up everyone in the group, on top of their own.
+	require.NoError(t, create(as("carol", "dev"), "vm4", 1, 768))
+	err = create(as("dave", "dev"), "vm5", 1, 512)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "quota of group dev exceeded: memory would be 1280 of 1024")
+	require.NoError(t, create(as("dave", "ops"), "vm5", 1, 512))
+
+	// Rejected creates don't count.
+	assert.False(t, n.has("vm6"))
+	assert.Empty(t, s.quotas.inflight)
+}
+
+func TestGetUsage(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": newFakeNode()})
+	s.quotas.list = []*settingsv1.Quota{
+		{Subject: AnySubject, MaxInstances: 5},
+		{Group: "dev", MaxCpus: 8},
+	}
+	for _, c := range []struct {
+		ctx        context.Context
+		node, name string
+	}{
+		{as("alice", "dev"), "a", "vm1"},
+		{as("alice", "dev"), "b", "vm2"},
+		{as("bob", "dev"), "a", "vm3"},
+	} {
+		_, err := s.Create(c.ctx, &orchestratorv1.CreateRequest{Node: c.node, Name: c.name, Spec: specWith(2, 512, 5)})
+		require.NoError(t, err)
+	}
+
+	resp, err := s.GetUsage(as("alice", "dev"), &orchestratorv1.GetUsageRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", resp.Subject)
+	assert.Equal(t, &settingsv1.Usage{Instances: 2, Cpus: 4, Memory: 1024, Disk: 10}, resp.Usage)
+	require.Len(t, resp.Quotas, 2)
+	assert.Equal(t, uint32(2), resp.Quotas[0].Used.Instances)
+	assert.Equal(t, uint64(6), resp.Quotas[1].Used.Cpus)
+
+	resp, err = s.GetUsage(context.Background(), &orchestratorv1.GetUsageRequest{Subject: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), resp.Usage.Instances)
+	require.Len(t, resp.Quotas, 1)
+	assert.Equal(t, AnySubject, resp.Quotas[0].Quota.Subject)
+
+	resp, err = s.GetUsage(context.Background(), &orchestratorv1.GetUsageRequest{Group: "dev"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), resp.Usage.Instances)
+	require.Len(t, resp.Quotas, 1)
+	assert.Equal(t, uint64(6), resp.Quotas[0].Used.Cpus)
+
+	_, err = s.GetUsage(context.Background(), &orchestratorv1.GetUsageRequest{Subject: "bob", Group: "dev"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestValidateQuotas(t *testing.T) {
+	assert.NoError(t, validateQuotas([]*settingsv1.Quota{{Subject: AnySubject}, {Group: "dev"}}))
+	assert.Error(t, validateQuotas([]*settingsv1.Quota{{MaxCpus: 1}}))
+	assert.Error(t, validateQuotas([]*settingsv1.Quota{{Subject: "bob", Group: "dev"}}))
+}
+
+func TestCreate_QuotaUnreachableNode(t *testing.T) {
+	down := newFakeNode()
+	down.down = true
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": down})
+	s.quotas.list = []*settingsv1.Quota{{Group: "dev", MaxInstances: 5}}
+
+	// Instances on b can't be counted, so the quota can't be checked.
+	_, err := s.Create(as("alice", "dev"), &orchestratorv1.CreateRequest{Node: "a", Name: "vm1", Spec: specWith(1, 256, 5)})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "nodes are unreachable: b")
+
+	// Callers without a quota aren't held up.
+	_, err = s.Create(as("bob"), &orchestratorv1.CreateRequest{Node: "a", Name: "vm1", Spec: specWith(1, 256, 5)})
+	require.NoError(t, err)
+}
+
+func TestCreate_QuotaAfterAuthorization(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": newFakeNode()})
+	policy, err := rbac.NewPolicy([]*settingsv1.RoleBinding{
+		{Subject: "alice", Role: settingsv1.Role_ROLE_OPERATOR, Nodes: []string{"a"}},
+	}, nil)
+	require.NoError(t, err)
+	s.policy = policy
+	s.quotas.list = []*settingsv1.Quota{{Subject: AnySubject, MaxInstances: 1}}
+
+	_, err = s.Create(as("alice"), &orchestratorv1.CreateRequest{Node: "a", Name: "vm1", Spec: specWith(1, 256, 5)})
+	require.NoError(t, err)
+	// A node the caller can't create on is refused as such, whatever
+	// their quota.
+	_, err = s.Create(as("alice"), &orchestratorv1.CreateRequest{Node: "b", Name: "vm2", Spec: specWith(1, 256, 5)})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.Create(as("alice"), &orchestratorv1.CreateRequest{Node: "a", Name: "vm2", Spec: specWith(1, 256, 5)})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestReserveQuota_InFlight(t *testing.T) {
+	n := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": n})
+	s.quotas.list = []*settingsv1.Quota{{Subject: AnySubject, MaxInstances: 1}}
+
+	// Admitted creates count until they're released, listed or not.
+	spec := specWith(1, 256, 5)
+	release, err := s.reserveQuota(as("alice"), spec)
+	require.NoError(t, err)
+	_, err = s.reserveQuota(as("alice"), specWith(1, 256, 5))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	require.NoError(t, n.Create(context.Background(), "vm1", spec))
+	release()
+	assert.Empty(t, s.quotas.inflight)
+	_, err = s.reserveQuota(as("alice"), specWith(1, 256, 5))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "vm1 is listed once released")
+}
+
+func TestReserveQuota_FinishedWhileListing(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode()})
+	s.quotas.list = []*settingsv1.Quota{{Subject: AnySubject, MaxInstances: 1}}
+	spec := specWith(1, 256, 5)
+	release, err := s.reserveQuota(as("alice"), spec)
+	require.NoError(t, err)
+
+	// The listing misses the create, which finishes before the check adds
+	// up the pending ones.
+	listed, err := s.quotas.listed(context.Background(), s.nodeManagers())
+	require.NoError(t, err)
+	release()
+	s.quotas.mu.Lock()
+	specs := append(s.quotas.pending(), listed...)
+	s.quotas.mu.Unlock()
+	assert.Contains(t, specs, spec)
+	assert.Empty(t, s.quotas.finished, "kept only while listing")
+}
+
+func TestUpdateMetadata_OverQuota(t *testing.T) {
+	n := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": n})
+	s.quotas.list = []*settingsv1.Quota{{Subject: AnySubject, MaxInstances: 2}}
+	for _, name := range []string{"vm1", "vm2"} {
+		_, err := s.Create(as("alice"), &orchestratorv1.CreateRequest{Node: "a", Name: name, Spec: specWith(1, 256, 5)})
+		require.NoError(t, err)
+	}
+	update := func() error {
+		_, err := s.UpdateMetadata(as("alice"), &orchestratorv1.UpdateMetadataRequest{Node: "a", Name: "vm1", Labels: map[string]string{"tier": "web"}})
+		return err
+	}
+	require.NoError(t, update())
+
+	// Lowering the quota leaves alice over it, so her instances can't be
+	// updated until she's back under.
+	s.quotas.list[0].MaxInstances = 1
+	err := update()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	resp, err := s.Bulk(as("alice"), &orchestratorv1.BulkRequest{LabelSelector: "tier=web", Action: orchestratorv1.BulkRequest_ACTION_LABEL, Labels: map[string]string{"tier": "db"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Results, 1)
+	assert.Contains(t, resp.Results[0].Error, "quota of subject alice exceeded")
+
+	require.NoError(t, n.Remove(context.Background(), "", "vm2"))
+	require.NoError(t, update())
+}
@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"context"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	viewer   = settingsv1.Role_ROLE_VIEWER
+	operator = settingsv1.Role_ROLE_OPERATOR
+	admin    = settingsv1.Role_ROLE_ADMIN
+)
+
+func instanceResource(nodeName string, info *controllerv1.Info) rbac.Resource {
+	return specResource(nodeName, info.GetName(), info.GetSpec())
+}
+
+// specResource is the instance called name with spec on the node.
+func specResource(nodeName, name string, spec *controllerv1.VMSpec) rbac.Resource {
+	return rbac.Resource{Node: nodeName, Instance: name, Labels: spec.GetLabels(), Project: spec.GetProject()}
+}
+
+// allowed reports whether the caller has role on res.
+func (s *Server) allowed(ctx context.Context, role settingsv1.Role, res rbac.Resource) bool {
+	return s.policy.Allowed(auth.FromContext(ctx), role, res)
+}
+
+// authorize fails with PermissionDenied unless the caller has role on res.
+func (s *Server) authorize(ctx context.Context, role settingsv1.Role, res rbac.Resource) error {
+	if err := s.policy.Check(auth.FromContext(ctx), role, res); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// authorizeAnywhere fails with PermissionDenied unless the caller has role
+// on anything.
+func (s *Server) authorizeAnywhere(ctx context.Context, role settingsv1.Role) error {
+	if err := s.policy.CheckAnywhere(auth.FromContext(ctx), role); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// authorizeInstance checks the caller has role on the named instance,
+// looking it up for its labels only when a policy is set.
+func (s *Server) authorizeInstance(ctx context.Context, role settingsv1.Role, nodeName string, nm node.Manager, project, name string) error {
+	if !s.policy.Enabled() {
+		return nil
+	}
+	info, err := findInstance(ctx, nodeName, nm, project, name)
+	if err != nil {
+		return err
+	}
+	return s.authorize(ctx, role, instanceResource(nodeName, info))
+}
+
+// authorizeMove checks the caller may operate the instance both on the node
+// it's on and on the one it's going to.
+func (s *Server) authorizeMove(ctx context.Context, info *controllerv1.Info, from, to string) error {
+	if err := s.authorize(ctx, operator, instanceResource(from, info)); err != nil {
+		return err
+	}
+	return s.authorize(ctx, operator, instanceResource(to, info))
+}
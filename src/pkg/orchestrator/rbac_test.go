@@ -0,0 +1,91 @@
+package orchestrator
+
+import (
+	"testing"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestRBAC(t *testing.T) {
+	web := map[string]string{"team": "web"}
+	a := newFakeNode(
+		labeled(instanceWithMAC("vm1", "52:54:00:00:00:01"), web),
+		labeled(instanceWithMAC("vm2", "52:54:00:00:00:02"), map[string]string{"team": "db"}),
+	)
+	b := newFakeNode(labeled(instanceWithMAC("vm3", "52:54:00:00:00:03"), web))
+	s := newTestServer(map[string]node.Manager{"a": a, "b": b})
+	policy, err := rbac.NewPolicy([]*settingsv1.RoleBinding{
+		{Group: "ops", Role: settingsv1.Role_ROLE_ADMIN},
+		{Group: "web", Role: settingsv1.Role_ROLE_OPERATOR, LabelSelector: "team=web"},
+		{Subject: "bob", Role: settingsv1.Role_ROLE_VIEWER, Nodes: []string{"a"}},
+	}, nil)
+	require.NoError(t, err)
+	s.policy = policy
+	bob, alice, carol := as("bob", "web"), as("alice", "ops"), as("carol")
+
+	// Viewers only see what's in their scope.
+	list, err := s.ListInstances(bob, &orchestratorv1.ListInstancesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/vm1", "a/vm2", "b/vm3"}, listedNames(list))
+	list, err = s.ListInstances(carol, &orchestratorv1.ListInstancesRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.Instances)
+	nodes, err := s.ListNodes(bob, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, nodes.Nodes, 1)
+	assert.Equal(t, "a", nodes.Nodes[0].Name)
+	assert.NotContains(t, nodes.Health, "b")
+	_, err = s.Info(carol, &orchestratorv1.InfoRequest{Node: "a", Name: "vm1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Operators act on the instances their labels allow.
+	_, err = s.Start(bob, &orchestratorv1.StartRequest{Node: "b", Name: "vm3"})
+	require.NoError(t, err)
+	_, err = s.Remove(bob, &orchestratorv1.RemoveRequest{Node: "a", Name: "vm2"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.True(t, a.has("vm2"))
+	_, err = s.UpdateMetadata(bob, &orchestratorv1.UpdateMetadataRequest{Node: "a", Name: "vm1", Labels: map[string]string{"team": "db"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.Create(bob, &orchestratorv1.CreateRequest{Node: "a", Name: "vm4", Spec: specWith(1, 512, 5)})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	spec := specWith(1, 512, 5)
+	spec.Labels = web
+	_, err = s.Create(bob, &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm4", Spec: spec})
+	require.NoError(t, err)
+
+	resp, err := s.Bulk(bob, &orchestratorv1.BulkRequest{LabelSelector: "team", Action: orchestratorv1.BulkRequest_ACTION_LABEL, Labels: map[string]string{"env": "dev"}})
+	require.NoError(t, err)
+	outcomes := bulkOutcomes(resp)
+	assert.Equal(t, "ok", outcomes["a/vm1"])
+	assert.Equal(t, "error", outcomes["a/vm2"])
+	assert.Equal(t, "ok", outcomes["b/vm3"])
+
+	// Node and cluster management is for admins.
+	_, err = s.CordonNode(bob, &orchestratorv1.CordonNodeRequest{Name: "a"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.CordonNode(alice, &orchestratorv1.CordonNodeRequest{Name: "a"})
+	require.NoError(t, err)
+	_, err = s.GetDrain(carol, &orchestratorv1.GetDrainRequest{Name: "a"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.CreateJoinToken(bob, &orchestratorv1.CreateJoinTokenRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.ListDnsRecords(bob, &emptypb.Empty{})
+	require.NoError(t, err)
+	_, err = s.ListDnsRecords(carol, &emptypb.Empty{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = s.GetUsage(bob, &orchestratorv1.GetUsageRequest{Group: "web"})
+	require.NoError(t, err)
+	_, err = s.GetUsage(bob, &orchestratorv1.GetUsageRequest{Subject: "alice"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.GetUsage(alice, &orchestratorv1.GetUsageRequest{Subject: "bob"})
+	require.NoError(t, err)
+}
@@ -3,6 +3,7 @@ package orchestrator
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 
@@ -11,11 +12,11 @@ import (
 	fileregistryv1 "github.com/q-controller/qcontroller/src/generated/services/fileregistry/v1"
 	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
-	vmv1 "github.com/q-controller/qcontroller/src/generated/vm/statemachine/v1"
 	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
 	"github.com/q-controller/qcontroller/src/pkg/images"
 	"github.com/q-controller/qcontroller/src/pkg/node"
 	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
 // remoteNodeManager implements node.Manager by calling a remote controller via gRPC.
@@ -68,43 +69,61 @@ func (n *remoteNodeManager) Endpoint() string {
 	return n.endpoint
 }
 
-func (n *remoteNodeManager) Create(ctx context.Context, id, imageID string,
-	cpus, memory, disk uint32, cloudInit *vmv1.CloudInit) error {
-	if err := n.ensureImage(ctx, imageID); err != nil {
+func (n *remoteNodeManager) Create(ctx context.Context, name string, spec *controllerv1.VMSpec) error {
+	if err := n.ensureImage(ctx, spec.GetImage()); err != nil {
 		return fmt.Errorf("ensure image on %s: %w", n.name, err)
 	}
 
-	req := &controllerv1.CreateRequest{
-		Name: id,
-		Spec: &controllerv1.VMSpec{
-			Image: imageID,
-			Vm:    &settingsv1.VM{Cpus: cpus, Memory: memory, Disk: disk},
-		},
-	}
-	if cloudInit != nil {
-		req.Spec.CloudInit = cloudInit
-	}
-	_, err := n.client.Create(ctx, req)
+	_, err := n.client.Create(ctx, &controllerv1.CreateRequest{Name: name, Spec: spec})
 	if err != nil {
 		return fmt.Errorf("create on %s: %w", n.name, err)
 	}
 	return nil
 }
 
-func (n *remoteNodeManager) ensureImage(ctx context.Context, imageID string) error {
+func (n *remoteNodeManager) probeFileRegistry(ctx context.Context) error {
+	if _, err := n.nodeImages.List(ctx); err != nil {
+		return fmt.Errorf("list images on %s: %w", n.name, err)
+	}
+	return nil
+}
+
+// HasImage reports whether the node already holds the current version of
+// the image, so creating an instance from it needs no push.
+func (n *remoteNodeManager) HasImage(ctx context.Context, imageID string) (bool, error) {
+	localHash, err := n.localImageHash(ctx, imageID)
+	if err != nil {
+		return false, err
+	}
+	remoteImgs, err := n.nodeImages.List(ctx)
+	if err != nil {
+		return false, fmt.Errorf("list remote images: %w", err)
+	}
+	for _, img := range remoteImgs {
+		if img.ImageId == imageID && img.Hash == localHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (n *remoteNodeManager) localImageHash(ctx context.Context, imageID string) (string, error) {
 	localImgs, err := n.localImages.List(ctx)
 	if err != nil {
-		return fmt.Errorf("list local images: %w", err)
+		return "", fmt.Errorf("list local images: %w", err)
 	}
-	var localHash string
 	for _, img := range localImgs {
 		if img.ImageId == imageID {
-			localHash = img.Hash
-			break
+			return img.Hash, nil
 		}
 	}
-	if localHash == "" {
-		return fmt.Errorf("image %s not found locally", imageID)
+	return "", fmt.Errorf("image %s not found locally", imageID)
+}
+
+func (n *remoteNodeManager) ensureImage(ctx context.Context, imageID string) error {
+	localHash, err := n.localImageHash(ctx, imageID)
+	if err != nil {
+		return err
 	}
 
 	remoteImgs, err := n.nodeImages.List(ctx)
@@ -174,38 +193,144 @@ func (n *remoteNodeManager) publishProgress(imageID string, percent int32) {
 	})
 }
 
-func (n *remoteNodeManager) Start(ctx context.Context, name string) error {
-	_, err := n.client.Start(ctx, &controllerv1.StartRequest{Name: name})
+func (n *remoteNodeManager) Start(ctx context.Context, project, name string) error {
+	_, err := n.client.Start(ctx, &controllerv1.StartRequest{Name: name, Project: project})
 	if err != nil {
 		return fmt.Errorf("start on %s: %w", n.name, err)
 	}
 	return nil
 }
 
-func (n *remoteNodeManager) Stop(ctx context.Context, name string, force bool) error {
-	_, err := n.client.Stop(ctx, &controllerv1.StopRequest{Name: name, Force: force})
+func (n *remoteNodeManager) Stop(ctx context.Context, project, name string, force bool) error {
+	_, err := n.client.Stop(ctx, &controllerv1.StopRequest{Name: name, Project: project, Force: force})
 	if err != nil {
 		return fmt.Errorf("stop on %s: %w", n.name, err)
 	}
 	return nil
 }
 
-func (n *remoteNodeManager) Remove(ctx context.Context, name string) error {
-	_, err := n.client.Remove(ctx, &controllerv1.RemoveRequest{Name: name})
+func (n *remoteNodeManager) Remove(ctx context.Context, project, name string) error {
+	_, err := n.client.Remove(ctx, &controllerv1.RemoveRequest{Name: name, Project: project})
 	if err != nil {
 		return fmt.Errorf("remove on %s: %w", n.name, err)
 	}
 	return nil
 }
 
-func (n *remoteNodeManager) Info(ctx context.Context, name string) ([]*controllerv1.Info, error) {
-	resp, err := n.client.Info(ctx, &controllerv1.InfoRequest{Name: name})
+func (n *remoteNodeManager) Info(ctx context.Context, project, name string) ([]*controllerv1.Info, error) {
+	req := &controllerv1.InfoRequest{Name: name, Project: project}
+	if project == node.AllProjects {
+		req = &controllerv1.InfoRequest{AllProjects: true}
+	}
+	resp, err := n.client.Info(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("info on %s: %w", n.name, err)
 	}
 	return resp.Info, nil
 }
 
+func (n *remoteNodeManager) SetDNSRecords(ctx context.Context, records []*settingsv1.DnsRecord) error {
+	_, err := n.client.SetDnsRecords(ctx, &controllerv1.SetDnsRecordsRequest{Records: records})
+	if err != nil {
+		return fmt.Errorf("set dns records on %s: %w", n.name, err)
+	}
+	return nil
+}
+
+func (n *remoteNodeManager) ListLeases(ctx context.Context) (*controllerv1.ListLeasesResponse, error) {
+	resp, err := n.client.ListLeases(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("list leases on %s: %w", n.name, err)
+	}
+	return resp, nil
+}
+
+func (n *remoteNodeManager) NodeInfo(ctx context.Context) (*settingsv1.NodeInfo, error) {
+	info, err := n.client.NodeInfo(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("node info on %s: %w", n.name, err)
+	}
+	return info, nil
+}
+
+func (n *remoteNodeManager) ListAuditRecords(ctx context.Context, q *settingsv1.AuditQuery) ([]*settingsv1.AuditRecord, error) {
+	resp, err := n.client.ListAuditRecords(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("list audit records on %s: %w", n.name, err)
+	}
+	return resp.Records, nil
+}
+
+func (n *remoteNodeManager) SetNetworkLimits(ctx context.Context, project, name string, limits *settingsv1.NetworkLimits) error {
+	_, err := n.client.SetNetworkLimits(ctx, &controllerv1.SetNetworkLimitsRequest{Name: name, Project: project, Limits: limits})
+	if err != nil {
+		return fmt.Errorf("set network limits on %s: %w", n.name, err)
+	}
+	return nil
+}
+
+func (n *remoteNodeManager) SetMetadata(ctx context.Context, project, name string, labels, annotations map[string]string) error {
+	_, err := n.client.SetMetadata(ctx, &controllerv1.SetMetadataRequest{Name: name, Project: project, Labels: labels, Annotations: annotations})
+	if err != nil {
+		return fmt.Errorf("set metadata on %s: %w", n.name, err)
+	}
+	return nil
+}
+
+func (n *remoteNodeManager) SetOverlayNetworks(ctx context.Context, networks []*settingsv1.OverlayNetwork, peers []string) error {
+	_, err := n.client.SetOverlayNetworks(ctx, &controllerv1.SetOverlayNetworksRequest{Networks: networks, Peers: peers})
+	if err != nil {
+		return fmt.Errorf("set overlay networks on %s: %w", n.name, err)
+	}
+	return nil
+}
+
+func (n *remoteNodeManager) ExportDisk(ctx context.Context, project, name string) (io.ReadCloser, int64, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := n.client.ExportDisk(streamCtx, &controllerv1.ExportDiskRequest{Name: name, Project: project})
+	if err != nil {
+		cancel()
+		return nil, 0, fmt.Errorf("export disk on %s: %w", n.name, err)
+	}
+	header, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, 0, fmt.Errorf("export disk on %s: %w", n.name, err)
+	}
+
+	return grpcutil.NewChunkReadCloser(func() ([]byte, error) {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return chunk.Chunk, nil
+	}, cancel), header.Size, nil
+}
+
+func (n *remoteNodeManager) ImportDisk(ctx context.Context, project, name string, r io.Reader) error {
+	// See localNodeManager.ImportDisk: only a clean close commits the disk.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := n.client.ImportDisk(streamCtx)
+	if err != nil {
+		return fmt.Errorf("import disk on %s: %w", n.name, err)
+	}
+	if err := grpcutil.SendStream(r, func(chunk []byte, first bool) error {
+		req := &controllerv1.ImportDiskRequest{Chunk: chunk}
+		if first {
+			req.Name = name
+			req.Project = project
+		}
+		return stream.Send(req)
+	}); err != nil {
+		return fmt.Errorf("import disk on %s: %w", n.name, err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return fmt.Errorf("import disk on %s: %w", n.name, err)
+	}
+	return nil
+}
+
 // progressFile embeds *os.File and overrides Read to track upload progress.
 type progressFile struct {
 	*os.File
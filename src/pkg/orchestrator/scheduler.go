@@ -0,0 +1,402 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AutoNode is the node name that asks the scheduler to place an instance.
+const AutoNode = "auto"
+
+// Resources is an amount of CPUs, memory and disk, in the units of
+// instance specs.
+type Resources struct {
+	CPUs   uint64
+	Memory uint64
+	Disk   uint64
+}
+
+func specResources(spec *controllerv1.VMSpec) Resources {
+	return vmResources(spec.GetVm())
+}
+
+// Candidate is a node the scheduler may place an instance on.
+type Candidate struct {
+	Name string
+	// Capacity is what may be allocated on the node; zero fields are
+	// unlimited.
+	Capacity Resources
+	// Allocated is the sum of the node's instance specs.
+	Allocated Resources
+	// Instances is the number of instances on the node.
+	Instances int
+	// ImageCached is set when the node already holds the instance's image.
+	ImageCached bool
+	// Host is what the node reported about its host, if anything.
+	Host *settingsv1.HostResources
+	// Labels are the node's labels.
+	Labels map[string]string
+	// InstanceLabels are the labels of the node's instances. Only filled
+	// in when the instance has instance affinity rules.
+	InstanceLabels []map[string]string
+}
+
+// free returns how much of the resource with capacity c and allocation a is
+// left, and false if it isn't limited.
+func free(c, a uint64) (uint64, bool) {
+	if c == 0 {
+		return 0, false
+	}
+	if a >= c {
+		return 0, true
+	}
+	return c - a, true
+}
+
+// Filter rejects candidates that can't take the instance, returning why.
+type Filter func(spec *controllerv1.VMSpec, c *Candidate) error
+
+// Scorer rates a candidate that passed the filters; higher is better.
+// Scores are expected in [0, 1].
+type Scorer func(spec *controllerv1.VMSpec, c *Candidate) float64
+
+// WeightedScorer is a Scorer with its weight in the total score.
+type WeightedScorer struct {
+	Name   string
+	Weight float64
+	Score  Scorer
+}
+
+// Scheduler picks the node for an instance: candidates failing any filter
+// are dropped and the one with the highest weighted score wins, ties going
+// to the first by name.
+type Scheduler struct {
+	Filters []Filter
+	Scorers []WeightedScorer
+}
+
+// DefaultScheduler places instances on nodes with room for them that meet
+// their placement rules, preferring nodes meeting their preferred rules,
+// the most free CPU, memory and disk and nodes that have the image cached,
+// then the node with the fewest instances.
+func DefaultScheduler() *Scheduler {
+	return &Scheduler{
+		Filters: []Filter{FitsResources, MatchesPlacement},
+		Scorers: []WeightedScorer{
+			{Name: "affinity", Weight: 2, Score: PreferredPlacement},
+			{Name: "cpu", Weight: 1, Score: FreeCPU},
+			{Name: "memory", Weight: 1, Score: FreeMemory},
+			{Name: "disk", Weight: 1, Score: FreeDisk},
+			{Name: "image", Weight: 1, Score: ImageCached},
+			{Name: "spread", Weight: 0.5, Score: FewestInstances},
+		},
+	}
+}
+
+// FitsResources rejects nodes without enough free CPU, memory or disk for
+// the instance.
+func FitsResources(spec *controllerv1.VMSpec, c *Candidate) error {
+	want := specResources(spec)
+	for _, r := range []struct {
+		name                   string
+		want, capacity, allocd uint64
+	}{
+		{"cpus", want.CPUs, c.Capacity.CPUs, c.Allocated.CPUs},
+		{"memory", want.Memory, c.Capacity.Memory, c.Allocated.Memory},
+		{"disk", want.Disk, c.Capacity.Disk, c.Allocated.Disk},
+	} {
+		if left, limited := free(r.capacity, r.allocd); limited && r.want > left {
+			return fmt.Errorf("insufficient %s: %d requested, %d free", r.name, r.want, left)
+		}
+	}
+	return nil
+}
+
+// freeFraction is the share of capacity left after placing want. Unlimited
+// resources score 1.
+func freeFraction(want, capacity, allocated uint64) float64 {
+	left, limited := free(capacity, allocated)
+	if !limited {
+		return 1
+	}
+	if want >= left {
+		return 0
+	}
+	return float64(left-want) / float64(capacity)
+}
+
+// FreeCPU prefers nodes with the most CPUs left after placement, counting
+// the host's CPUs when no capacity is configured.
+func FreeCPU(spec *controllerv1.VMSpec, c *Candidate) float64 {
+	capacity := c.Capacity.CPUs
+	if capacity == 0 {
+		capacity = uint64(c.Host.GetCpus())
+	}
+	return freeFraction(specResources(spec).CPUs, capacity, c.Allocated.CPUs)
+}
+
+// FreeMemory prefers nodes with the most memory left after placement, or
+// the largest share of the host's memory available when no capacity is
+// configured.
+func FreeMemory(spec *controllerv1.VMSpec, c *Candidate) float64 {
+	if c.Capacity.Memory == 0 {
+		return hostShare(c.Host.GetMemoryAvailable(), c.Host.GetMemoryTotal())
+	}
+	return freeFraction(specResources(spec).Memory, c.Capacity.Memory, c.Allocated.Memory)
+}
+
+// FreeDisk prefers nodes with the most disk left after placement, or the
+// largest share of the host's disk free when no capacity is configured.
+func FreeDisk(spec *controllerv1.VMSpec, c *Candidate) float64 {
+	if c.Capacity.Disk == 0 {
+		return hostShare(c.Host.GetDiskFree(), c.Host.GetDiskTotal())
+	}
+	return freeFraction(specResources(spec).Disk, c.Capacity.Disk, c.Allocated.Disk)
+}
+
+// hostShare is free's share of total, or 1 if either is unknown.
+func hostShare(free, total uint64) float64 {
+	if free == 0 || total == 0 {
+		return 1
+	}
+	return float64(free) / float64(total)
+}
+
+// ImageCached prefers nodes that needn't be sent the image.
+func ImageCached(_ *controllerv1.VMSpec, c *Candidate) float64 {
+	if c.ImageCached {
+		return 1
+	}
+	return 0
+}
+
+// FewestInstances spreads instances across nodes.
+func FewestInstances(_ *controllerv1.VMSpec, c *Candidate) float64 {
+	return 1 / float64(1+c.Instances)
+}
+
+// Schedule returns the name of the best candidate for spec.
+func (sch *Scheduler) Schedule(spec *controllerv1.VMSpec, candidates []*Candidate) (string, error) {
+	sorted := append([]*Candidate(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	best, bestScore := "", -1.0
+	var reasons []string
+	for _, c := range sorted {
+		if err := sch.filter(spec, c); err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: %v", c.Name, err))
+			continue
+		}
+		score := 0.0
+		for _, s := range sch.Scorers {
+			score += s.Weight * s.Score(spec, c)
+		}
+		if score > bestScore {
+			best, bestScore = c.Name, score
+		}
+	}
+	if best == "" {
+		if len(reasons) == 0 {
+			return "", errors.New("no nodes available")
+		}
+		return "", fmt.Errorf("no node fits the instance (%s)", strings.Join(reasons, "; "))
+	}
+	return best, nil
+}
+
+func (sch *Scheduler) filter(spec *controllerv1.VMSpec, c *Candidate) error {
+	for _, f := range sch.Filters {
+		if err := f(spec, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageCache is implemented by node managers that can tell whether an
+// image is already on the node.
+type imageCache interface {
+	HasImage(ctx context.Context, imageID string) (bool, error)
+}
+
+// candidates describes every reachable, uncordoned node for scheduling
+// spec.
+func (s *Server) candidates(ctx context.Context, spec *controllerv1.VMSpec) []*Candidate {
+	var out []*Candidate
+	for name, nm := range s.nodeManagers() {
+		if s.cordons.get(name) != nil {
+			continue
+		}
+		c, err := s.candidate(ctx, name, nm, spec)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping unreachable node when scheduling", "node", name, "error", err)
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// candidate describes the node for scheduling spec.
+func (s *Server) candidate(ctx context.Context, name string, nm node.Manager, spec *controllerv1.VMSpec) (*Candidate, error) {
+	info, err := nm.NodeInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg := s.nodeConfig(name)
+	c := &Candidate{
+		Name:      name,
+		Capacity:  vmResources(cfg.GetCapacity()),
+		Allocated: vmResources(info.GetAllocated()),
+		Instances: int(info.GetInstances()),
+		Host:      info.GetHost(),
+		Labels:    cfg.GetLabels(),
+	}
+	if cache, ok := nm.(imageCache); ok && spec.GetImage() != "" {
+		cached, cacheErr := cache.HasImage(ctx, spec.GetImage())
+		if cacheErr != nil {
+			slog.WarnContext(ctx, "Failed to check image cache", "node", name, "error", cacheErr)
+		}
+		c.ImageCached = cached
+	}
+	if hasInstanceAffinity(spec.GetPlacement()) {
+		instances, infoErr := nm.Info(ctx, node.AllProjects, "")
+		if infoErr != nil {
+			return nil, infoErr
+		}
+		for _, inst := range instances {
+			c.InstanceLabels = append(c.InstanceLabels, inst.GetSpec().GetLabels())
+		}
+	}
+	return c, nil
+}
+
+// placements are the creates placed on a node whose instances don't exist
+// there yet. Candidates count them, so concurrent creates can't overcommit
+// a node or break each other's instance affinity rules.
+type placements struct {
+	// mu serializes describing the nodes and scheduling with recording the
+	// pick.
+	mu      sync.Mutex
+	pending map[*controllerv1.VMSpec]string
+}
+
+// count adds the pending placements on c's node to it. The caller holds
+// mu.
+func (p *placements) count(c *Candidate, spec *controllerv1.VMSpec) {
+	for pending, nodeName := range p.pending {
+		if nodeName != c.Name {
+			continue
+		}
+		r := specResources(pending)
+		c.Allocated.CPUs += r.CPUs
+		c.Allocated.Memory += r.Memory
+		c.Allocated.Disk += r.Disk
+		c.Instances++
+		if hasInstanceAffinity(spec.GetPlacement()) {
+			c.InstanceLabels = append(c.InstanceLabels, pending.GetLabels())
+		}
+	}
+}
+
+// add records spec as placed on nodeName until release is called. The
+// caller holds mu.
+func (p *placements) add(nodeName string, spec *controllerv1.VMSpec) func() {
+	if p.pending == nil {
+		p.pending = make(map[*controllerv1.VMSpec]string)
+	}
+	p.pending[spec] = nodeName
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.pending, spec)
+	}
+}
+
+// errNoPermittedNode is returned by schedule when there are nodes, but the
+// caller may use none of them.
+var errNoPermittedNode = errors.New("no permitted node")
+
+// schedule picks the node for spec among the schedulable nodes permitted
+// accepts, counting the pending placements, and records spec as placed
+// there until release is called. The nodes are described under the same
+// hold of placements.mu, so concurrent calls can't both pick from a
+// snapshot that misses the other's pick.
+func (s *Server) schedule(ctx context.Context, spec *controllerv1.VMSpec, permitted func(c *Candidate) bool) (string, func(), error) {
+	s.placements.mu.Lock()
+	defer s.placements.mu.Unlock()
+	all := s.candidates(ctx, spec)
+	candidates := slices.DeleteFunc(slices.Clone(all), func(c *Candidate) bool { return !permitted(c) })
+	if len(candidates) == 0 && len(all) > 0 {
+		return "", nil, errNoPermittedNode
+	}
+	for _, c := range candidates {
+		s.placements.count(c, spec)
+	}
+	picked, err := s.scheduler.Schedule(spec, candidates)
+	if err != nil {
+		return "", nil, err
+	}
+	return picked, s.placements.add(picked, spec), nil
+}
+
+func vmResources(vm *settingsv1.VM) Resources {
+	return Resources{
+		CPUs:   uint64(vm.GetCpus()),
+		Memory: uint64(vm.GetMemory()),
+		Disk:   uint64(vm.GetDisk()),
+	}
+}
+
+// resolveNode returns the node called nodeName, or the scheduler's pick when
+// nodeName is AutoNode or empty, for creating the instance called name. The
+// scheduler only picks from the nodes the caller may create it on. The
+// instance counts as placed on the node until release is called, which
+// should be once it's created there.
+func (s *Server) resolveNode(ctx context.Context, nodeName, name string, spec *controllerv1.VMSpec) (string, node.Manager, func(), error) {
+	if nodeName != "" && nodeName != AutoNode {
+		_, nm, err := s.getNode(nodeName)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if err := s.authorize(ctx, operator, specResource(nodeName, name, spec)); err != nil {
+			return "", nil, nil, err
+		}
+		if err := s.checkSchedulable(nodeName); err != nil {
+			return "", nil, nil, err
+		}
+		release, err := s.placeOn(ctx, nodeName, spec)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		return nodeName, nm, release, nil
+	}
+	picked, release, err := s.schedule(ctx, spec, func(c *Candidate) bool {
+		return s.allowed(ctx, operator, specResource(c.Name, name, spec))
+	})
+	if errors.Is(err, errNoPermittedNode) {
+		return "", nil, nil, status.Errorf(codes.PermissionDenied, "permission denied: may not create %s on any node", name)
+	}
+	if err != nil {
+		return "", nil, nil, status.Errorf(codes.ResourceExhausted, "failed to schedule instance: %v", err)
+	}
+	_, nm, err := s.getNode(picked)
+	if err != nil {
+		release()
+		return "", nil, nil, err
+	}
+	slog.InfoContext(ctx, "Scheduled instance", "node", picked)
+	return picked, nm, release, nil
+}
@@ -0,0 +1,227 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func specWith(cpus, memory, disk uint32) *controllerv1.VMSpec {
+	return &controllerv1.VMSpec{Vm: &settingsv1.VM{Cpus: cpus, Memory: memory, Disk: disk}, Image: "img"}
+}
+
+func TestSchedule_FiltersNodesWithoutRoom(t *testing.T) {
+	candidates := []*Candidate{
+		{Name: "a", Capacity: Resources{CPUs: 4, Memory: 4096}, Allocated: Resources{CPUs: 3, Memory: 1024}},
+		{Name: "b", Capacity: Resources{CPUs: 8, Memory: 4096}, Allocated: Resources{CPUs: 2, Memory: 1024}},
+	}
+	picked, err := DefaultScheduler().Schedule(specWith(2, 1024, 10), candidates)
+	require.NoError(t, err)
+	assert.Equal(t, "b", picked)
+
+	_, err = DefaultScheduler().Schedule(specWith(16, 1024, 10), candidates)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "insufficient cpus")
+}
+
+func TestSchedule_PrefersFreeResources(t *testing.T) {
+	picked, err := DefaultScheduler().Schedule(specWith(1, 1024, 10), []*Candidate{
+		{Name: "a", Capacity: Resources{Memory: 8192}, Allocated: Resources{Memory: 6144}},
+		{Name: "b", Capacity: Resources{Memory: 8192}, Allocated: Resources{Memory: 1024}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "b", picked)
+}
+
+func TestSchedule_PrefersCachedImage(t *testing.T) {
+	picked, err := DefaultScheduler().Schedule(specWith(1, 1024, 10), []*Candidate{
+		{Name: "a"},
+		{Name: "b", ImageCached: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "b", picked)
+}
+
+func TestSchedule_TiesGoToFirstByName(t *testing.T) {
+	picked, err := DefaultScheduler().Schedule(specWith(1, 1024, 10), []*Candidate{{Name: "b"}, {Name: "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, "a", picked)
+
+	_, err = DefaultScheduler().Schedule(specWith(1, 1024, 10), nil)
+	assert.Error(t, err)
+}
+
+func TestSchedule_CustomPipeline(t *testing.T) {
+	sch := &Scheduler{
+		Scorers: []WeightedScorer{{Name: "most", Weight: 1, Score: func(_ *controllerv1.VMSpec, c *Candidate) float64 {
+			return float64(c.Instances) / 10
+		}}},
+	}
+	picked, err := sch.Schedule(specWith(1, 1024, 10), []*Candidate{{Name: "a", Instances: 1}, {Name: "b", Instances: 3}})
+	require.NoError(t, err)
+	assert.Equal(t, "b", picked)
+}
+
+func TestCreate_AutoNode(t *testing.T) {
+	busy := newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"))
+	busy.instances["vm1"].Spec.Vm = &settingsv1.VM{Cpus: 4, Memory: 4096, Disk: 10}
+	idle := newFakeNode()
+	down := newFakeNode()
+	down.down = true
+	s := newTestServer(map[string]node.Manager{"a": busy, "b": idle, "c": down})
+	s.nodeConfigs["a"].Capacity = &settingsv1.VM{Cpus: 4}
+
+	for i, nodeName := range []string{AutoNode, ""} {
+		name := fmt.Sprintf("vm-%d", i)
+		resp, err := s.Create(context.Background(), &orchestratorv1.CreateRequest{Node: nodeName, Name: name, Spec: specWith(1, 512, 5)})
+		require.NoError(t, err)
+		assert.Equal(t, "b", resp.Node)
+		assert.True(t, idle.has(name))
+	}
+
+	s.nodeConfigs["b"].Capacity = &settingsv1.VM{Cpus: 1}
+	_, err := s.Create(context.Background(), &orchestratorv1.CreateRequest{Node: AutoNode, Name: "vm2", Spec: specWith(2, 512, 5)})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestResolveNode_CountsPendingPlacements(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode(), "b": newFakeNode()})
+	s.nodeConfigs["a"].Capacity = &settingsv1.VM{Cpus: 2}
+	s.nodeConfigs["b"].Capacity = &settingsv1.VM{Cpus: 1}
+	ctx := context.Background()
+
+	// Neither create has reached its node yet.
+	picked, _, releaseFirst, err := s.resolveNode(ctx, AutoNode, "vm1", specWith(2, 512, 5))
+	require.NoError(t, err)
+	assert.Equal(t, "a", picked)
+	_, _, _, err = s.resolveNode(ctx, AutoNode, "vm2", specWith(2, 512, 5))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	releaseFirst()
+	_, _, release, err := s.resolveNode(ctx, AutoNode, "vm2", specWith(2, 512, 5))
+	require.NoError(t, err)
+	release()
+
+	spread := func() *controllerv1.VMSpec {
+		spec := specWith(1, 512, 5)
+		spec.Labels = map[string]string{"group": "ha"}
+		spec.Placement = &settingsv1.Placement{InstanceAntiAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "group=ha"}}}
+		return spec
+	}
+	first, _, releaseFirst, err := s.resolveNode(ctx, AutoNode, "ha1", spread())
+	require.NoError(t, err)
+	defer releaseFirst()
+	second, _, releaseSecond, err := s.resolveNode(ctx, AutoNode, "ha2", spread())
+	require.NoError(t, err)
+	defer releaseSecond()
+	assert.NotEqual(t, first, second)
+	_, _, _, err = s.resolveNode(ctx, AutoNode, "ha3", spread())
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestResolveNode_ExplicitNodeRecordsPlacement(t *testing.T) {
+	s := newTestServer(map[string]node.Manager{"a": newFakeNode()})
+	ctx := context.Background()
+	spread := func() *controllerv1.VMSpec {
+		spec := specWith(1, 512, 5)
+		spec.Labels = map[string]string{"group": "ha"}
+		spec.Placement = &settingsv1.Placement{InstanceAntiAffinity: []*settingsv1.AffinityTerm{{LabelSelector: "group=ha"}}}
+		return spec
+	}
+
+	// Of concurrent creates on the same node, only one passes the rule.
+	results := make(chan error, 8)
+	releases := make(chan func(), 8)
+	for i := range 8 {
+		go func() {
+			_, _, release, err := s.resolveNode(ctx, "a", fmt.Sprintf("ha%d", i), spread())
+			if release != nil {
+				releases <- release
+			}
+			results <- err
+		}()
+	}
+	passed := 0
+	for range 8 {
+		err := <-results
+		if err == nil {
+			passed++
+		} else {
+			assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		}
+	}
+	assert.Equal(t, 1, passed)
+
+	(<-releases)()
+	_, _, release, err := s.resolveNode(ctx, "a", "ha9", spread())
+	require.NoError(t, err)
+	release()
+}
+
+func TestCreate_AutoNodeConcurrentCreatesShareLastSlot(t *testing.T) {
+	n := newFakeNode()
+	s := newTestServer(map[string]node.Manager{"a": n})
+	s.nodeConfigs["a"].Capacity = &settingsv1.VM{Cpus: 1}
+
+	// Each create describes the node and reserves its pick in one step, so
+	// none of them schedules against a snapshot missing another's instance.
+	results := make(chan error, 8)
+	for i := range 8 {
+		go func() {
+			_, err := s.Create(context.Background(), &orchestratorv1.CreateRequest{Node: AutoNode, Name: fmt.Sprintf("vm%d", i), Spec: specWith(1, 512, 5)})
+			results <- err
+		}()
+	}
+	created := 0
+	for range 8 {
+		if err := <-results; err == nil {
+			created++
+		} else {
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		}
+	}
+	assert.Equal(t, 1, created)
+}
+
+func TestSchedule_UsesHostResources(t *testing.T) {
+	picked, err := DefaultScheduler().Schedule(specWith(1, 1024, 10), []*Candidate{
+		{Name: "a", Allocated: Resources{CPUs: 6}, Host: &settingsv1.HostResources{Cpus: 8}},
+		{Name: "b", Allocated: Resources{CPUs: 6}, Host: &settingsv1.HostResources{Cpus: 32}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "b", picked)
+
+	picked, err = DefaultScheduler().Schedule(specWith(1, 1024, 10), []*Candidate{
+		{Name: "a", Host: &settingsv1.HostResources{MemoryTotal: 100, MemoryAvailable: 10, DiskTotal: 100, DiskFree: 50}},
+		{Name: "b", Host: &settingsv1.HostResources{MemoryTotal: 100, MemoryAvailable: 90, DiskTotal: 100, DiskFree: 50}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "b", picked)
+}
+
+func TestListNodes_IncludesNodeInfo(t *testing.T) {
+	up := newFakeNode(instanceWithMAC("vm1", "52:54:00:00:00:01"))
+	up.instances["vm1"].Spec.Vm = &settingsv1.VM{Cpus: 2, Memory: 2048, Disk: 10}
+	up.host = &settingsv1.HostResources{Cpus: 8, Accelerators: []string{"kvm", "tcg"}}
+	down := newFakeNode()
+	down.down = true
+	s := newTestServer(map[string]node.Manager{"a": up, "b": down})
+
+	resp, err := s.ListNodes(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, resp.Nodes, 2)
+	require.Contains(t, resp.Info, "a")
+	assert.NotContains(t, resp.Info, "b")
+	assert.Equal(t, uint32(1), resp.Info["a"].Instances)
+	assert.Equal(t, uint32(2), resp.Info["a"].Allocated.Cpus)
+	assert.Equal(t, uint32(8), resp.Info["a"].Host.Cpus)
+}
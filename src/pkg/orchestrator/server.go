@@ -2,65 +2,282 @@ package orchestrator
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"maps"
 	"sync"
+	"time"
 
 	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
 	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
 	"github.com/q-controller/qcontroller/src/pkg/images"
 	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/pki"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
 	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
+// resyncInterval is how often the cluster-wide configuration is re-pushed to
+// every node.
+const resyncInterval = 30 * time.Second
+
 type Server struct {
 	orchestratorv1.UnimplementedOrchestratorServiceServer
 	stop        chan struct{}
 	stopOnce    sync.Once
-	nodes       map[string]node.Manager
 	localImages images.ImageClient
 	broadcaster *Broadcaster
+	state       State
+	dnsZone     string
+	// dnsMu serializes the DNS record changes, so their conflict checks
+	// hold.
+	dnsMu     sync.Mutex
+	dnsSyncMu sync.Mutex
+
+	// nodesMu guards nodes, nodeConfigs, staticNodes and nodeStops.
+	nodesMu     sync.RWMutex
+	nodes       map[string]node.Manager
+	nodeConfigs map[string]*settingsv1.Node
+	// staticNodes are the nodes from the config, which can't be changed at
+	// runtime.
+	staticNodes map[string]bool
+	// nodeStops end each node's event subscription.
+	nodeStops   map[string]chan struct{}
+	connectNode func(cfg *settingsv1.Node) (node.Manager, error)
+
+	overlayMu     sync.Mutex
+	overlaySyncMu sync.Mutex
+
+	macPrefix []byte
+	// macMu guards pendingMACs, the MACs of in-flight creates, and
+	// reportedCollisions.
+	macMu              sync.Mutex
+	pendingMACs        map[string]bool
+	reportedCollisions map[string]bool
+
+	moves   moves
+	cordons cordons
+	drains  drains
+
+	scheduler  *Scheduler
+	placements placements
+	quotas     quotas
+	// policy decides what callers may do; nil allows everything.
+	policy *rbac.Policy
+	// audit holds the records of the mutating calls the orchestrator
+	// served; nil if it keeps none.
+	audit *audit.Log
+
+	health *healthTracker
+
+	// ca issues certificates to joining nodes, which are then reached with
+	// clientTLS. Join is disabled without it.
+	ca        *pki.CA
+	clientTLS *settingsv1.TLSConfig
+	// ownHosts are the orchestrator's names, which joining nodes can't get
+	// certificates for.
+	ownHosts []string
+	// joinMu serializes joins, so their host checks hold.
+	joinMu sync.Mutex
 }
 
-func NewServer(nodes []*settingsv1.Node, localImages images.ImageClient, bc *Broadcaster) (*Server, error) {
-	nodeMap := make(map[string]node.Manager, len(nodes))
-	for _, n := range nodes {
-		nm, err := newRemoteNodeManager(n, localImages, bc)
-		if err != nil {
-			return nil, err
+type serverOptions struct {
+	macPrefix string
+	scheduler *Scheduler
+	quotas    []*settingsv1.Quota
+	policy    *rbac.Policy
+	audit     *audit.Log
+	ca        *pki.CA
+	clientTLS *settingsv1.TLSConfig
+	ownHosts  []string
+}
+
+type ServerOption func(*serverOptions)
+
+// WithMACPrefix sets the prefix instance MACs are allocated from. Empty
+// keeps network.DefaultMACPrefix.
+func WithMACPrefix(prefix string) ServerOption {
+	return func(o *serverOptions) {
+		if prefix != "" {
+			o.macPrefix = prefix
 		}
-		nodeMap[n.Name] = nm
+	}
+}
+
+// WithScheduler sets the scheduler that places instances created on
+// AutoNode. Nil keeps DefaultScheduler.
+func WithScheduler(scheduler *Scheduler) ServerOption {
+	return func(o *serverOptions) {
+		if scheduler != nil {
+			o.scheduler = scheduler
+		}
+	}
+}
+
+// WithQuotas limits what each subject and group may create.
+func WithQuotas(quotas []*settingsv1.Quota) ServerOption {
+	return func(o *serverOptions) {
+		o.quotas = quotas
+	}
+}
+
+// WithPolicy restricts callers to what their roles allow.
+func WithPolicy(policy *rbac.Policy) ServerOption {
+	return func(o *serverOptions) {
+		o.policy = policy
+	}
+}
+
+// WithAudit has ListAuditRecords include the records in l, along with the
+// nodes'.
+func WithAudit(l *audit.Log) ServerOption {
+	return func(o *serverOptions) {
+		o.audit = l
+	}
+}
+
+// WithCA enables Join: joining nodes get certificates issued by ca and are
+// reached with clientTLS, which must be trusted by ca. ownHosts are the
+// orchestrator's names, which nodes can't get certificates for.
+func WithCA(ca *pki.CA, clientTLS *settingsv1.TLSConfig, ownHosts []string) ServerOption {
+	return func(o *serverOptions) {
+		o.ca = ca
+		o.clientTLS = clientTLS
+		o.ownHosts = ownHosts
+	}
+}
+
+func NewServer(nodes []*settingsv1.Node, localImages images.ImageClient, bc *Broadcaster, state State, dnsZone string, opts ...ServerOption) (*Server, error) {
+	o := &serverOptions{macPrefix: network.DefaultMACPrefix, scheduler: DefaultScheduler()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	macPrefix, prefixErr := network.ParseMACPrefix(o.macPrefix)
+	if prefixErr != nil {
+		return nil, prefixErr
+	}
+	if quotaErr := validateQuotas(o.quotas); quotaErr != nil {
+		return nil, quotaErr
 	}
 
-	return &Server{
+	s := &Server{
 		stop:        make(chan struct{}),
-		nodes:       nodeMap,
 		localImages: localImages,
 		broadcaster: bc,
-	}, nil
+		state:       state,
+		dnsZone:     dnsZone,
+
+		nodes:       make(map[string]node.Manager, len(nodes)),
+		nodeConfigs: make(map[string]*settingsv1.Node, len(nodes)),
+		staticNodes: make(map[string]bool, len(nodes)),
+		nodeStops:   make(map[string]chan struct{}, len(nodes)),
+		connectNode: func(cfg *settingsv1.Node) (node.Manager, error) {
+			return newRemoteNodeManager(cfg, localImages, bc)
+		},
+
+		macPrefix:          macPrefix,
+		pendingMACs:        make(map[string]bool),
+		reportedCollisions: make(map[string]bool),
+
+		scheduler: o.scheduler,
+		quotas:    quotas{list: o.quotas},
+		policy:    o.policy,
+		audit:     o.audit,
+		health:    newHealthTracker(bc),
+
+		ca:        o.ca,
+		clientTLS: o.clientTLS,
+		ownHosts:  o.ownHosts,
+	}
+
+	s.nodesMu.Lock()
+	for _, n := range nodes {
+		if n.Name == AutoNode {
+			s.nodesMu.Unlock()
+			s.Close()
+			return nil, fmt.Errorf("node name %q is reserved for scheduling", AutoNode)
+		}
+		nm, err := s.connectNode(n)
+		if err != nil {
+			s.nodesMu.Unlock()
+			s.Close()
+			return nil, err
+		}
+		s.staticNodes[n.Name] = true
+		s.attachNode(n, nm)
+	}
+	s.nodesMu.Unlock()
+	if loadErr := s.loadNodes(); loadErr != nil {
+		s.Close()
+		return nil, loadErr
+	}
+	if loadErr := s.loadCordons(); loadErr != nil {
+		s.Close()
+		return nil, loadErr
+	}
+
+	go s.syncLoop()
+	go s.healthLoop()
+
+	return s, nil
 }
 
 func (s *Server) getNode(name string) (string, node.Manager, error) {
+	s.nodesMu.RLock()
 	nm, ok := s.nodes[name]
+	s.nodesMu.RUnlock()
 	if !ok {
 		return "", nil, status.Errorf(codes.NotFound, "node %s not found", name)
 	}
 	return name, nm, nil
 }
 
-func (s *Server) Create(ctx context.Context, req *orchestratorv1.CreateRequest) (*emptypb.Empty, error) {
-	nodeName, nm, err := s.getNode(req.Node)
+func (s *Server) Create(ctx context.Context, req *orchestratorv1.CreateRequest) (*orchestratorv1.CreateResponse, error) {
+	if req.Spec == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "spec is required")
+	}
+
+	if metadataErr := validateMetadata(req.Spec.Labels, req.Spec.Annotations); metadataErr != nil {
+		return nil, metadataErr
+	}
+	if placementErr := validatePlacement(req.Spec.Placement); placementErr != nil {
+		return nil, placementErr
+	}
+	if overlayErr := s.checkOverlayNetwork(req.GetSpec().GetOverlayNetwork()); overlayErr != nil {
+		return nil, overlayErr
+	}
+
+	if nameErr := node.ValidateName(req.Name); nameErr != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", nameErr)
+	}
+	if projectErr := s.checkProject(req.Spec.Project); projectErr != nil {
+		return nil, projectErr
+	}
+	name := req.Name
+
+	nodeName, nm, releasePlacement, err := s.resolveNode(ctx, req.Node, name, req.Spec)
 	if err != nil {
 		return nil, err
 	}
+	defer releasePlacement()
+	releaseQuota, quotaErr := s.reserveQuota(ctx, req.Spec)
+	if quotaErr != nil {
+		return nil, quotaErr
+	}
+	defer releaseQuota()
+	release, macErr := s.reserveMAC(ctx, req.Spec)
+	if macErr != nil {
+		return nil, macErr
+	}
+	defer release()
 
-	cloudInit := req.Spec.GetCloudInit()
-	if createErr := nm.Create(ctx, req.Name, req.Spec.GetImage(),
-		req.Spec.GetVm().GetCpus(), req.Spec.GetVm().GetMemory(),
-		req.Spec.GetVm().GetDisk(), cloudInit); createErr != nil {
+	if createErr := nm.Create(ctx, name, req.Spec); createErr != nil {
 		return nil, status.Errorf(codes.Internal, "failed to create: %v", createErr)
 	}
 
@@ -68,7 +285,7 @@ func (s *Server) Create(ctx context.Context, req *orchestratorv1.CreateRequest)
 		go func() {
 			asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
 			defer cancel()
-			if startErr := nm.Start(asyncCtx, req.Name); startErr != nil {
+			if startErr := nm.Start(asyncCtx, req.Spec.Project, name); startErr != nil {
 				slog.ErrorContext(asyncCtx, "failed to start after create", "error", startErr)
 				s.broadcaster.Send(&orchestratorv1.Event{
 					Node: nodeName,
@@ -76,7 +293,7 @@ func (s *Server) Create(ctx context.Context, req *orchestratorv1.CreateRequest)
 						Payload: &eventv1.Update_ErrorEvent{
 							ErrorEvent: &eventv1.ErrorEvent{
 								Message:  startErr.Error(),
-								Resource: req.Name,
+								Resource: instanceRef(req.Spec.Project, name),
 							},
 						},
 					},
@@ -85,7 +302,7 @@ func (s *Server) Create(ctx context.Context, req *orchestratorv1.CreateRequest)
 		}()
 	}
 
-	return &emptypb.Empty{}, nil
+	return &orchestratorv1.CreateResponse{Node: nodeName, Name: name}, nil
 }
 
 func (s *Server) Start(ctx context.Context, req *orchestratorv1.StartRequest) (*emptypb.Empty, error) {
@@ -93,27 +310,38 @@ func (s *Server) Start(ctx context.Context, req *orchestratorv1.StartRequest) (*
 	if err != nil {
 		return nil, err
 	}
+	if authErr := s.authorizeInstance(ctx, operator, req.Node, nm, req.Project, req.Name); authErr != nil {
+		return nil, authErr
+	}
+	if cordonErr := s.checkSchedulable(req.Node); cordonErr != nil {
+		return nil, cordonErr
+	}
 
+	s.startAsync(ctx, req.Node, nm, req.Project, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+// startAsync starts an instance in the background, reporting a failure as
+// an error event.
+func (s *Server) startAsync(ctx context.Context, nodeName string, nm node.Manager, project, name string) {
 	go func() {
 		asyncCtx, cancel := utils.AsyncCtx(ctx, s.stop)
 		defer cancel()
-		if startErr := nm.Start(asyncCtx, req.Name); startErr != nil {
-			slog.ErrorContext(asyncCtx, "Start failed", "node", req.Node, "name", req.Name, "error", startErr)
+		if startErr := nm.Start(asyncCtx, project, name); startErr != nil {
+			slog.ErrorContext(asyncCtx, "Start failed", "node", nodeName, "project", project, "name", name, "error", startErr)
 			s.broadcaster.Send(&orchestratorv1.Event{
-				Node: req.Node,
+				Node: nodeName,
 				Update: &eventv1.Update{
 					Payload: &eventv1.Update_ErrorEvent{
 						ErrorEvent: &eventv1.ErrorEvent{
 							Message:  startErr.Error(),
-							Resource: req.Name,
+							Resource: instanceRef(project, name),
 						},
 					},
 				},
 			})
 		}
 	}()
-
-	return &emptypb.Empty{}, nil
 }
 
 func (s *Server) Stop(ctx context.Context, req *orchestratorv1.StopRequest) (*emptypb.Empty, error) {
@@ -121,8 +349,11 @@ func (s *Server) Stop(ctx context.Context, req *orchestratorv1.StopRequest) (*em
 	if err != nil {
 		return nil, err
 	}
+	if authErr := s.authorizeInstance(ctx, operator, req.Node, nm, req.Project, req.Name); authErr != nil {
+		return nil, authErr
+	}
 
-	if stopErr := nm.Stop(ctx, req.Name, req.Force); stopErr != nil {
+	if stopErr := nm.Stop(ctx, req.Project, req.Name, req.Force); stopErr != nil {
 		return nil, status.Errorf(codes.Internal, "failed to stop: %v", stopErr)
 	}
 
@@ -134,27 +365,67 @@ func (s *Server) Remove(ctx context.Context, req *orchestratorv1.RemoveRequest)
 	if err != nil {
 		return nil, err
 	}
+	if authErr := s.authorizeInstance(ctx, operator, req.Node, nm, req.Project, req.Name); authErr != nil {
+		return nil, authErr
+	}
 
-	if removeErr := nm.Remove(ctx, req.Name); removeErr != nil {
+	if removeErr := nm.Remove(ctx, req.Project, req.Name); removeErr != nil {
 		return nil, status.Errorf(codes.Internal, "failed to remove: %v", removeErr)
 	}
 
 	return &emptypb.Empty{}, nil
 }
 
+func (s *Server) SetNetworkLimits(ctx context.Context, req *orchestratorv1.SetNetworkLimitsRequest) (*emptypb.Empty, error) {
+	_, nm, err := s.getNode(req.Node)
+	if err != nil {
+		return nil, err
+	}
+	if authErr := s.authorizeInstance(ctx, operator, req.Node, nm, req.Project, req.Name); authErr != nil {
+		return nil, authErr
+	}
+
+	if setErr := nm.SetNetworkLimits(ctx, req.Project, req.Name, req.Limits); setErr != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set network limits: %v", setErr)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
 func (s *Server) Info(ctx context.Context, req *orchestratorv1.InfoRequest) (*orchestratorv1.InfoResponse, error) {
 	nodeName, nm, err := s.getNode(req.Node)
 	if err != nil {
 		return nil, err
 	}
+	selector, selectorErr := parseSelector(req.LabelSelector)
+	if selectorErr != nil {
+		return nil, selectorErr
+	}
 
-	infos, infoErr := nm.Info(ctx, req.Name)
+	project := req.Project
+	if req.AllProjects {
+		if req.Name != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "all_projects can't be combined with a name")
+		}
+		project = node.AllProjects
+	}
+	infos, infoErr := nm.Info(ctx, project, req.Name)
 	if infoErr != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get info: %v", infoErr)
 	}
 
 	result := make([]*orchestratorv1.Info, 0, len(infos))
 	for _, info := range infos {
+		if !selector.Matches(info.GetSpec().GetLabels()) {
+			continue
+		}
+		if req.Name != "" {
+			if authErr := s.authorize(ctx, viewer, instanceResource(nodeName, info)); authErr != nil {
+				return nil, authErr
+			}
+		} else if !s.allowed(ctx, viewer, instanceResource(nodeName, info)) {
+			continue
+		}
 		result = append(result, &orchestratorv1.Info{
 			Node: nodeName,
 			Info: info,
@@ -164,17 +435,92 @@ func (s *Server) Info(ctx context.Context, req *orchestratorv1.InfoRequest) (*or
 	return &orchestratorv1.InfoResponse{Info: result}, nil
 }
 
+// syncLoop periodically re-pushes the DNS records and overlay networks to
+// every node, so nodes that were unreachable during a mutation converge.
+func (s *Server) syncLoop() {
+	ctx, cancel := utils.AsyncCtx(context.Background(), s.stop)
+	defer cancel()
+
+	s.syncDNSRecords(ctx)
+	s.syncOverlayNetworks(ctx)
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.syncDNSRecords(ctx)
+			s.syncOverlayNetworks(ctx)
+			s.detectMACCollisions(ctx)
+			s.connectRegisteredNodes()
+		}
+	}
+}
+
 func (s *Server) Close() {
 	s.stopOnce.Do(func() { close(s.stop) })
-	for _, nm := range s.nodes {
-		nm.Close()
+	s.nodesMu.Lock()
+	defer s.nodesMu.Unlock()
+	for name := range s.nodes {
+		s.detachNode(name).Close()
 	}
 }
 
-func (s *Server) ListNodes(_ context.Context, _ *emptypb.Empty) (*orchestratorv1.ListNodesResponse, error) {
-	out := make([]*settingsv1.Node, 0, len(s.nodes))
-	for name, nm := range s.nodes {
-		out = append(out, &settingsv1.Node{Name: name, Endpoint: nm.Endpoint()})
+// ListNodes describes the nodes the caller may view.
+func (s *Server) ListNodes(ctx context.Context, _ *emptypb.Empty) (*orchestratorv1.ListNodesResponse, error) {
+	hidden := func(name string) bool {
+		return !s.allowed(ctx, viewer, rbac.Resource{Node: name})
+	}
+	nodes := s.nodeList()
+	out := make([]*settingsv1.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if hidden(n.Name) {
+			continue
+		}
+		out = append(out, &settingsv1.Node{
+			Name:                 n.Name,
+			Endpoint:             n.Endpoint,
+			FileRegistryEndpoint: n.FileRegistryEndpoint,
+			EventsEndpoint:       n.EventsEndpoint,
+			OverlayAddress:       n.OverlayAddress,
+			Capacity:             n.Capacity,
+			Labels:               n.Labels,
+		})
+	}
+	resp := &orchestratorv1.ListNodesResponse{
+		Nodes:   out,
+		Info:    s.nodeInfo(ctx),
+		Health:  s.health.snapshot(),
+		Cordons: s.cordons.snapshot(),
+	}
+	maps.DeleteFunc(resp.Info, func(name string, _ *settingsv1.NodeInfo) bool { return hidden(name) })
+	maps.DeleteFunc(resp.Health, func(name string, _ *settingsv1.NodeHealth) bool { return hidden(name) })
+	maps.DeleteFunc(resp.Cordons, func(name string, _ *settingsv1.Cordon) bool { return hidden(name) })
+	return resp, nil
+}
+
+// nodeInfo queries every node's NodeInfo in parallel. Nodes that fail are
+// logged and left out.
+func (s *Server) nodeInfo(ctx context.Context) map[string]*settingsv1.NodeInfo {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		nodes = s.nodeManagers()
+		out   = make(map[string]*settingsv1.NodeInfo, len(nodes))
+	)
+	for name, nm := range nodes {
+		wg.Go(func() {
+			info, err := nm.NodeInfo(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to get node info", "node", name, "error", err)
+				return
+			}
+			mu.Lock()
+			out[name] = info
+			mu.Unlock()
+		})
 	}
-	return &orchestratorv1.ListNodesResponse{Nodes: out}, nil
+	wg.Wait()
+	return out
 }
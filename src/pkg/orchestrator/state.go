@@ -0,0 +1,52 @@
+package orchestrator
+
+import (
+	"errors"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// State persists orchestrator-level objects that don't belong to a single
+// node.
+type State interface {
+	// DNS records are keyed by their fully-qualified name, type and value,
+	// so a name can hold several A/AAAA records. GetDNSRecords returns a
+	// name's records, if any. ReplaceDNSRecord removes old and stores
+	// record in a single transaction.
+	PutDNSRecord(record *settingsv1.DnsRecord) error
+	ReplaceDNSRecord(old, record *settingsv1.DnsRecord) error
+	GetDNSRecords(name string) ([]*settingsv1.DnsRecord, error)
+	ListDNSRecords() ([]*settingsv1.DnsRecord, error)
+	RemoveDNSRecord(record *settingsv1.DnsRecord) error
+
+	PutOverlayNetwork(network *settingsv1.OverlayNetwork) error
+	GetOverlayNetwork(name string) (*settingsv1.OverlayNetwork, error)
+	ListOverlayNetworks() ([]*settingsv1.OverlayNetwork, error)
+	RemoveOverlayNetwork(name string) error
+
+	// Nodes registered at runtime, as opposed to those in the config.
+	PutNode(node *settingsv1.Node) error
+	GetNode(name string) (*settingsv1.Node, error)
+	ListNodes() ([]*settingsv1.Node, error)
+	RemoveNode(name string) error
+
+	// Cordons are keyed by node name.
+	PutCordon(cordon *settingsv1.Cordon) error
+	GetCordon(node string) (*settingsv1.Cordon, error)
+	ListCordons() ([]*settingsv1.Cordon, error)
+	RemoveCordon(node string) error
+
+	// Join tokens are keyed by their ID.
+	PutJoinToken(token *settingsv1.JoinToken) error
+	GetJoinToken(id string) (*settingsv1.JoinToken, error)
+	ListJoinTokens() ([]*settingsv1.JoinToken, error)
+	RemoveJoinToken(id string) error
+
+	// Personal API tokens are keyed by their ID.
+	PutAPIToken(token *settingsv1.ApiToken) error
+	GetAPIToken(id string) (*settingsv1.ApiToken, error)
+	ListAPITokens() ([]*settingsv1.ApiToken, error)
+	RemoveAPIToken(id string) error
+}
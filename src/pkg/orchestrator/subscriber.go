@@ -1,4 +1,4 @@
-package cmd
+package orchestrator
 
 import (
 	"context"
@@ -12,20 +12,38 @@ import (
 	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
 	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
-	"github.com/q-controller/qcontroller/src/pkg/orchestrator"
+	"github.com/q-controller/qcontroller/src/pkg/utils"
+	"google.golang.org/grpc"
 )
 
-func subscribeToNodeEvents(ctx context.Context, n *settingsv1.Node, bc *orchestrator.Broadcaster) {
-	controllerConn, controllerConnErr := grpcutil.Dial(n.Endpoint, grpcutil.WithTLS(n.ControllerTls))
-	if controllerConnErr != nil {
-		slog.Error("Failed to connect to controller", "node", n.Name, "error", controllerConnErr)
+// subscribeToNodeEvents relays the node's events to the broadcaster until
+// stop is closed or the server is, reconnecting whenever the stream is lost,
+// and tracks whether the stream is connected.
+func (s *Server) subscribeToNodeEvents(n *settingsv1.Node, stop <-chan struct{}) {
+	ctx, cancel := utils.AsyncCtx(context.Background(), s.stop)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// Once stopped, the node may already be removed or replaced.
+	setConnected := func(connected bool) {
+		if ctx.Err() == nil {
+			s.health.eventsConnected(n.Name, connected)
+		}
+	}
+
+	controllerConn := dialNode(ctx, n, "controller", n.Endpoint, n.ControllerTls, setConnected)
+	if controllerConn == nil {
 		return
 	}
 	defer func() { _ = controllerConn.Close() }()
 
-	eventsConn, eventsConnErr := grpcutil.Dial(n.EventsEndpoint, grpcutil.WithTLS(n.EventsTls))
-	if eventsConnErr != nil {
-		slog.Error("Failed to connect to event service", "node", n.Name, "error", eventsConnErr)
+	eventsConn := dialNode(ctx, n, "event service", n.EventsEndpoint, n.EventsTls, setConnected)
+	if eventsConn == nil {
 		return
 	}
 	defer func() { _ = eventsConn.Close() }()
@@ -41,11 +59,12 @@ func subscribeToNodeEvents(ctx context.Context, n *settingsv1.Node, bc *orchestr
 		}
 
 		// Seed initial state before subscribing to events.
-		seedNodeState(ctx, n.Name, controllerCli, bc)
+		seedNodeState(ctx, n.Name, controllerCli, s.broadcaster)
 
 		stream, streamErr := eventCli.Subscribe(ctx, &eventv1.SubscribeRequest{})
 		if streamErr != nil {
 			slog.Debug("Failed to subscribe to node events, retrying", "node", n.Name, "error", streamErr)
+			setConnected(false)
 			select {
 			case <-ctx.Done():
 				return
@@ -55,6 +74,7 @@ func subscribeToNodeEvents(ctx context.Context, n *settingsv1.Node, bc *orchestr
 		}
 
 		slog.Info("Subscribed to node events", "node", n.Name, "endpoint", n.EventsEndpoint)
+		setConnected(true)
 
 		for {
 			resp, recvErr := stream.Recv()
@@ -62,15 +82,21 @@ func subscribeToNodeEvents(ctx context.Context, n *settingsv1.Node, bc *orchestr
 				if !errors.Is(recvErr, io.EOF) {
 					slog.Warn("Node event stream lost, reconnecting", "node", n.Name, "error", recvErr)
 				}
+				setConnected(false)
 				break
 			}
+			s.health.seen(n.Name)
 
 			update := resp.GetUpdate()
 			if update == nil {
 				continue
 			}
+			// Controllers don't know the name they're registered under.
+			if record := update.GetAuditEvent().GetRecord(); record != nil {
+				record.Node = n.Name
+			}
 
-			bc.Send(&orchestratorv1.Event{
+			s.broadcaster.Send(&orchestratorv1.Event{
 				Node:   n.Name,
 				Update: update,
 			})
@@ -84,8 +110,26 @@ func subscribeToNodeEvents(ctx context.Context, n *settingsv1.Node, bc *orchestr
 	}
 }
 
-func seedNodeState(ctx context.Context, nodeName string, cli controllerv1.ControllerServiceClient, bc *orchestrator.Broadcaster) {
-	resp, err := cli.Info(ctx, &controllerv1.InfoRequest{})
+// dialNode connects to one of the node's services, retrying until it
+// succeeds or ctx is done, in which case it returns nil.
+func dialNode(ctx context.Context, n *settingsv1.Node, service, endpoint string, tls *settingsv1.TLSConfig, setConnected func(bool)) *grpc.ClientConn {
+	for {
+		conn, err := grpcutil.Dial(endpoint, grpcutil.WithTLS(tls))
+		if err == nil {
+			return conn
+		}
+		slog.Error("Failed to connect to "+service+", retrying", "node", n.Name, "error", err)
+		setConnected(false)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func seedNodeState(ctx context.Context, nodeName string, cli controllerv1.ControllerServiceClient, bc *Broadcaster) {
+	resp, err := cli.Info(ctx, &controllerv1.InfoRequest{AllProjects: true})
 	if err != nil {
 		slog.Debug("Failed to seed node state", "node", nodeName, "error", err)
 		return
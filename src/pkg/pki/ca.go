@@ -0,0 +1,329 @@
+// Package pki is the orchestrator's built-in certificate authority, which
+// issues the certificates nodes and the orchestrator use for mTLS.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+)
+
+// OrchestratorName is the common name of the orchestrator's client
+// certificate, the only client controllers using a built-in CA accept by
+// default.
+const OrchestratorName = "orchestrator"
+
+const (
+	caName = "qcontroller-ca"
+	// CAValidity is how long a new CA is valid.
+	CAValidity = 10 * 365 * 24 * time.Hour
+	// CertValidity is how long issued certificates are valid.
+	CertValidity = 365 * 24 * time.Hour
+	// renewBefore is how long before expiry ClientTLS reissues a certificate.
+	renewBefore = 30 * 24 * time.Hour
+	// clockSkew backdates certificates so hosts with slightly slow clocks
+	// accept them.
+	clockSkew = 5 * time.Minute
+)
+
+// CA signs certificates with a key kept in its directory.
+type CA struct {
+	dir     string
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// LoadOrCreateCA loads the CA from ca.pem and ca-key.pem in dir, creating
+// the directory and a new CA if there's none yet.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create CA dir %s: %w", dir, err)
+	}
+	ca := &CA{dir: dir}
+	certPEM, certErr := os.ReadFile(ca.CertPath())
+	if errors.Is(certErr, os.ErrNotExist) {
+		if err := ca.create(); err != nil {
+			return nil, err
+		}
+		return ca, nil
+	}
+	if certErr != nil {
+		return nil, fmt.Errorf("read CA cert: %w", certErr)
+	}
+	cert, parseErr := ParseCertificate(certPEM)
+	if parseErr != nil {
+		return nil, fmt.Errorf("CA cert %s: %w", ca.CertPath(), parseErr)
+	}
+	key, keyErr := readKey(ca.keyPath())
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	ca.cert, ca.certPEM, ca.key = cert, certPEM, key
+	return ca, nil
+}
+
+func (ca *CA) create() error {
+	key, keyPEM, keyErr := GenerateKey()
+	if keyErr != nil {
+		return keyErr
+	}
+	serial, serialErr := newSerial()
+	if serialErr != nil {
+		return serialErr
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: caName},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, signErr := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if signErr != nil {
+		return fmt.Errorf("create CA cert: %w", signErr)
+	}
+	cert, parseErr := x509.ParseCertificate(der)
+	if parseErr != nil {
+		return parseErr
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	// The key goes first: a cert without its key is unusable, while a key
+	// without a cert is simply replaced on the next start.
+	if err := os.WriteFile(ca.keyPath(), keyPEM, 0o600); err != nil {
+		return fmt.Errorf("write CA key: %w", err)
+	}
+	if err := os.WriteFile(ca.CertPath(), certPEM, 0o644); err != nil {
+		return fmt.Errorf("write CA cert: %w", err)
+	}
+	ca.cert, ca.certPEM, ca.key = cert, certPEM, key
+	return nil
+}
+
+// CertPath is the path of the CA's PEM certificate.
+func (ca *CA) CertPath() string {
+	return filepath.Join(ca.dir, "ca.pem")
+}
+
+func (ca *CA) keyPath() string {
+	return filepath.Join(ca.dir, "ca-key.pem")
+}
+
+// CertPEM returns the CA's PEM certificate.
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// Hash identifies the CA's public key, for nodes to pin.
+func (ca *CA) Hash() string {
+	return publicKeyHash(ca.cert)
+}
+
+// Hash returns the "sha256:<hex>" digest of the public key of the PEM
+// certificate certPEM.
+func Hash(certPEM []byte) (string, error) {
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return "", err
+	}
+	return publicKeyHash(cert), nil
+}
+
+// IsBuiltinCA reports whether the PEM CA certificate certPEM is a built-in
+// CA's, as used by nodes that joined with a token.
+func IsBuiltinCA(certPEM []byte) (bool, error) {
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return false, err
+	}
+	return cert.IsCA && cert.Subject.CommonName == caName, nil
+}
+
+func publicKeyHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// SignCSR issues a PEM server certificate for the key of the PEM
+// certificate request csrPEM, valid on hosts. The names in the request
+// itself are ignored.
+func (ca *CA) SignCSR(csrPEM []byte, commonName string, hosts []string) ([]byte, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	return ca.issue(csr.PublicKey, commonName, hosts, x509.ExtKeyUsageServerAuth, time.Now().Add(CertValidity))
+}
+
+// SignClientCSR issues a PEM client certificate for the key of the PEM
+// certificate request csrPEM. Servers tell clients apart by commonName.
+func (ca *CA) SignClientCSR(csrPEM []byte, commonName string) ([]byte, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	return ca.issue(csr.PublicKey, commonName, nil, x509.ExtKeyUsageClientAuth, time.Now().Add(CertValidity))
+}
+
+func parseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("no PEM certificate request found")
+	}
+	csr, parseErr := x509.ParseCertificateRequest(block.Bytes)
+	if parseErr != nil {
+		return nil, fmt.Errorf("parse certificate request: %w", parseErr)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+	return csr, nil
+}
+
+// issue signs a certificate for a single use, so that server certificates
+// can't be presented as clients and the other way round.
+func (ca *CA) issue(pub crypto.PublicKey, commonName string, hosts []string, usage x509.ExtKeyUsage, notAfter time.Time) ([]byte, error) {
+	serial, serialErr := newSerial()
+	if serialErr != nil {
+		return nil, serialErr
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("sign certificate for %s: %w", commonName, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// ClientTLS returns a TLS config presenting a client certificate for name,
+// issued into the CA's directory and reissued when it's about to expire.
+func (ca *CA) ClientTLS(name string) (*settingsv1.TLSConfig, error) {
+	cfg := &settingsv1.TLSConfig{
+		Ca:   ca.CertPath(),
+		Cert: filepath.Join(ca.dir, name+".pem"),
+		Key:  filepath.Join(ca.dir, name+"-key.pem"),
+	}
+	if certPEM, err := os.ReadFile(cfg.Cert); err == nil {
+		cert, parseErr := ParseCertificate(certPEM)
+		if parseErr == nil && time.Until(cert.NotAfter) > renewBefore && cert.CheckSignatureFrom(ca.cert) == nil &&
+			slices.Equal(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+			if _, keyErr := readKey(cfg.Key); keyErr == nil {
+				return cfg, nil
+			}
+		}
+	}
+
+	key, keyPEM, keyErr := GenerateKey()
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	certPEM, issueErr := ca.issue(key.Public(), name, nil, x509.ExtKeyUsageClientAuth, time.Now().Add(CertValidity))
+	if issueErr != nil {
+		return nil, issueErr
+	}
+	if err := os.WriteFile(cfg.Key, keyPEM, 0o600); err != nil {
+		return nil, fmt.Errorf("write key for %s: %w", name, err)
+	}
+	if err := os.WriteFile(cfg.Cert, certPEM, 0o644); err != nil {
+		return nil, fmt.Errorf("write cert for %s: %w", name, err)
+	}
+	return cfg, nil
+}
+
+// GenerateKey creates a P-256 key, returned along with its PEM encoding.
+func GenerateKey() (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal key: %w", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// CreateCSR returns a PEM certificate request for key.
+func CreateCSR(key crypto.Signer, commonName string) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate request: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCertificate parses the first certificate in certPEM.
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in %s", path)
+	}
+	key, parseErr := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if parseErr != nil {
+		return nil, fmt.Errorf("parse key %s: %w", path, parseErr)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key %s can't sign", path)
+	}
+	return signer, nil
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial: %w", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,133 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	require.NoError(t, err)
+	cert, err := ParseCertificate(ca.CertPEM())
+	require.NoError(t, err)
+	assert.True(t, cert.IsCA)
+
+	reloaded, err := LoadOrCreateCA(dir)
+	require.NoError(t, err)
+	assert.Equal(t, ca.CertPEM(), reloaded.CertPEM())
+	assert.Equal(t, ca.Hash(), reloaded.Hash())
+
+	hash, err := Hash(ca.CertPEM())
+	require.NoError(t, err)
+	assert.Equal(t, ca.Hash(), hash)
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, hash)
+
+	builtin, err := IsBuiltinCA(ca.CertPEM())
+	require.NoError(t, err)
+	assert.True(t, builtin)
+	// Issued certificates aren't CAs, whatever their name.
+	key, _, err := GenerateKey()
+	require.NoError(t, err)
+	csr, err := CreateCSR(key, caName)
+	require.NoError(t, err)
+	client, err := ca.SignClientCSR(csr, caName)
+	require.NoError(t, err)
+	builtin, err = IsBuiltinCA(client)
+	require.NoError(t, err)
+	assert.False(t, builtin)
+}
+
+func TestSignCSR(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+
+	key, _, err := GenerateKey()
+	require.NoError(t, err)
+	csr, err := CreateCSR(key, "ignored")
+	require.NoError(t, err)
+
+	certPEM, err := ca.SignCSR(csr, "node1-controller", []string{"node1.example", "10.0.0.1", "localhost"})
+	require.NoError(t, err)
+	cert, err := ParseCertificate(certPEM)
+	require.NoError(t, err)
+
+	assert.Equal(t, "node1-controller", cert.Subject.CommonName)
+	assert.Equal(t, []string{"node1.example", "localhost"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	assert.True(t, cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertPEM())
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "node1.example", KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	assert.NoError(t, err)
+	// Server certificates can't authenticate clients.
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.Error(t, err)
+
+	_, err = ca.SignCSR([]byte("garbage"), "x", nil)
+	assert.Error(t, err)
+}
+
+func TestSignClientCSR(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+
+	key, _, err := GenerateKey()
+	require.NoError(t, err)
+	csr, err := CreateCSR(key, "ignored")
+	require.NoError(t, err)
+
+	certPEM, err := ca.SignClientCSR(csr, "node1-client")
+	require.NoError(t, err)
+	cert, err := ParseCertificate(certPEM)
+	require.NoError(t, err)
+	assert.Equal(t, "node1-client", cert.Subject.CommonName)
+	assert.Empty(t, cert.DNSNames)
+	assert.Empty(t, cert.IPAddresses)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+
+	_, err = ca.SignClientCSR([]byte("garbage"), "x")
+	assert.Error(t, err)
+}
+
+func TestClientTLS(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+
+	cfg, err := ca.ClientTLS("orchestrator")
+	require.NoError(t, err)
+	assert.Equal(t, ca.CertPath(), cfg.Ca)
+	pair, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	require.NoError(t, err)
+	require.NotEmpty(t, pair.Certificate)
+
+	first, err := os.ReadFile(cfg.Cert)
+	require.NoError(t, err)
+	again, err := ca.ClientTLS("orchestrator")
+	require.NoError(t, err)
+	second, err := os.ReadFile(again.Cert)
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "a valid certificate is reused")
+
+	// A certificate from another CA is replaced.
+	other, err := LoadOrCreateCA(t.TempDir())
+	require.NoError(t, err)
+	otherCfg, err := other.ClientTLS("orchestrator")
+	require.NoError(t, err)
+	foreign, err := os.ReadFile(otherCfg.Cert)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cfg.Cert, foreign, 0o644))
+	_, err = ca.ClientTLS("orchestrator")
+	require.NoError(t, err)
+	replaced, err := os.ReadFile(cfg.Cert)
+	require.NoError(t, err)
+	assert.NotEqual(t, foreign, replaced)
+}
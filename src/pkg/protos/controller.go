@@ -1,17 +1,24 @@
 package protos
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 
 	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
 	"github.com/q-controller/qcontroller/src/pkg/controller/db"
 	"github.com/q-controller/qcontroller/src/pkg/controller/vm"
 	"github.com/q-controller/qcontroller/src/pkg/events"
+	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
+	"github.com/q-controller/qcontroller/src/pkg/node"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
@@ -21,10 +28,11 @@ type Server struct {
 	controllerv1.UnimplementedControllerServiceServer
 	Config  *settingsv1.ControllerConfig
 	manager *vm.Manager
+	audit   *audit.Log
 }
 
 func (s *Server) Start(ctx context.Context, request *controllerv1.StartRequest) (*emptypb.Empty, error) {
-	if startErr := s.manager.Start(ctx, request.Name); startErr != nil {
+	if startErr := s.manager.Start(ctx, request.Project, request.Name); startErr != nil {
 		slog.ErrorContext(ctx, "failed to start an instance", "error", startErr)
 		return nil, status.Errorf(codes.Unknown, "failed to start a VM instance")
 	}
@@ -33,15 +41,40 @@ func (s *Server) Start(ctx context.Context, request *controllerv1.StartRequest)
 }
 
 func (s *Server) Create(ctx context.Context, request *controllerv1.CreateRequest) (*emptypb.Empty, error) {
-	qualifiedName, createErr := s.manager.Create(ctx, request.Name, request.Spec.Image,
-		request.Spec.Vm.Cpus, request.Spec.Vm.Memory,
-		request.Spec.Vm.Disk, request.Spec.CloudInit)
+	if nameErr := node.ValidateName(request.Name); nameErr != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", nameErr)
+	}
+	if request.Spec.Project != "" {
+		if projectErr := node.ValidateName(request.Spec.Project); projectErr != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid project: %v", projectErr)
+		}
+	}
+	if request.Spec.VlanId != 0 {
+		if vlanErr := network.ValidateVlanID(request.Spec.VlanId); vlanErr != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", vlanErr)
+		}
+		if request.Spec.OverlayNetwork != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "vlan_id and overlay_network are mutually exclusive")
+		}
+	}
+	if request.Spec.MacAddress != "" {
+		mac, macErr := network.NormalizeMAC(request.Spec.MacAddress)
+		if macErr != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid mac_address: %v", macErr)
+		}
+		request.Spec.MacAddress = mac
+	}
+
+	createErr := s.manager.Create(ctx, request.Name, request.Spec)
+	if errors.Is(createErr, db.ErrHwaddrNotUnique) {
+		return nil, status.Errorf(codes.AlreadyExists, "mac address %s is already in use", request.Spec.MacAddress)
+	}
 	if createErr != nil {
 		return nil, status.Errorf(codes.Internal, "method Launch failed: %v", createErr)
 	}
 
 	if request.Start {
-		if startErr := s.manager.Start(ctx, qualifiedName); startErr != nil {
+		if startErr := s.manager.Start(ctx, request.Spec.Project, request.Name); startErr != nil {
 			return nil, status.Errorf(codes.Unknown, "failed to start a VM instance")
 		}
 	}
@@ -50,7 +83,7 @@ func (s *Server) Create(ctx context.Context, request *controllerv1.CreateRequest
 }
 
 func (s *Server) Stop(ctx context.Context, request *controllerv1.StopRequest) (*emptypb.Empty, error) {
-	if stopErr := s.manager.Stop(ctx, request.Name, request.Force); stopErr != nil {
+	if stopErr := s.manager.Stop(ctx, request.Project, request.Name, request.Force); stopErr != nil {
 		slog.ErrorContext(ctx, "failed to stop an instance", "error", stopErr)
 		return nil, status.Errorf(codes.Unknown, "failed to stop a VM instance")
 	}
@@ -59,7 +92,7 @@ func (s *Server) Stop(ctx context.Context, request *controllerv1.StopRequest) (*
 }
 
 func (s *Server) Remove(ctx context.Context, req *controllerv1.RemoveRequest) (*emptypb.Empty, error) {
-	if removeErr := s.manager.Remove(ctx, req.Name); removeErr != nil {
+	if removeErr := s.manager.Remove(ctx, req.Project, req.Name); removeErr != nil {
 		slog.ErrorContext(ctx, "failed to remove an instance", "error", removeErr)
 		return nil, status.Errorf(codes.Unknown, "failed to remove a VM instance")
 	}
@@ -68,7 +101,14 @@ func (s *Server) Remove(ctx context.Context, req *controllerv1.RemoveRequest) (*
 }
 
 func (s *Server) Info(ctx context.Context, request *controllerv1.InfoRequest) (*controllerv1.InfoResponse, error) {
-	info, infoErr := s.manager.Info(ctx, request.Name)
+	project := request.Project
+	if request.AllProjects {
+		if request.Name != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "all_projects can't be combined with a name")
+		}
+		project = node.AllProjects
+	}
+	info, infoErr := s.manager.Info(ctx, project, request.Name)
 	if infoErr != nil {
 		return nil, status.Errorf(codes.Internal, "failed to retrieve info")
 	}
@@ -78,7 +118,128 @@ func (s *Server) Info(ctx context.Context, request *controllerv1.InfoRequest) (*
 	}, nil
 }
 
-func NewController(settings *settingsv1.ControllerConfig, eventPublisher *events.Publisher) (controllerv1.ControllerServiceServer, error) {
+func (s *Server) SetDnsRecords(ctx context.Context, request *controllerv1.SetDnsRecordsRequest) (*emptypb.Empty, error) {
+	if setErr := s.manager.SetDNSRecords(ctx, request.Records); setErr != nil {
+		slog.ErrorContext(ctx, "failed to set dns records", "error", setErr)
+		return nil, status.Errorf(codes.Internal, "failed to set dns records: %v", setErr)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ListLeases(ctx context.Context, _ *emptypb.Empty) (*controllerv1.ListLeasesResponse, error) {
+	resp, err := s.manager.ListLeases(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list leases", "error", err)
+		return nil, status.Errorf(status.Code(err), "failed to list leases: %v", err)
+	}
+
+	return resp, nil
+}
+
+func (s *Server) NodeInfo(ctx context.Context, _ *emptypb.Empty) (*settingsv1.NodeInfo, error) {
+	info, err := s.manager.NodeInfo(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get node info", "error", err)
+		return nil, status.Errorf(status.Code(err), "failed to get node info: %v", err)
+	}
+
+	return info, nil
+}
+
+func (s *Server) ListAuditRecords(ctx context.Context, q *settingsv1.AuditQuery) (*controllerv1.ListAuditRecordsResponse, error) {
+	records, err := s.audit.List(q)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list audit records", "error", err)
+		return nil, status.Errorf(codes.Internal, "failed to list audit records: %v", err)
+	}
+
+	return &controllerv1.ListAuditRecordsResponse{Records: records}, nil
+}
+
+func (s *Server) SetNetworkLimits(ctx context.Context, req *controllerv1.SetNetworkLimitsRequest) (*emptypb.Empty, error) {
+	if setErr := s.manager.SetNetworkLimits(ctx, req.Project, req.Name, req.Limits); setErr != nil {
+		slog.ErrorContext(ctx, "failed to set network limits", "error", setErr)
+		return nil, status.Errorf(codes.Internal, "failed to set network limits: %v", setErr)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) SetMetadata(ctx context.Context, req *controllerv1.SetMetadataRequest) (*emptypb.Empty, error) {
+	if setErr := s.manager.SetMetadata(ctx, req.Project, req.Name, req.Labels, req.Annotations); setErr != nil {
+		slog.ErrorContext(ctx, "failed to set metadata", "error", setErr)
+		return nil, status.Errorf(codes.Internal, "failed to set metadata: %v", setErr)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) SetOverlayNetworks(ctx context.Context, req *controllerv1.SetOverlayNetworksRequest) (*emptypb.Empty, error) {
+	if setErr := s.manager.SetOverlayNetworks(ctx, req.Networks, req.Peers); setErr != nil {
+		slog.ErrorContext(ctx, "failed to set overlay networks", "error", setErr)
+		return nil, status.Errorf(status.Code(setErr), "failed to set overlay networks: %v", setErr)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// diskErrorCode maps disk transfer errors to a status code, keeping the
+// QEMU service's code (e.g. NotFound for a missing disk).
+func diskErrorCode(err error) codes.Code {
+	if errors.Is(err, vm.ErrNotStopped) {
+		return codes.FailedPrecondition
+	}
+	return status.Code(err)
+}
+
+func (s *Server) ExportDisk(req *controllerv1.ExportDiskRequest, stream grpc.ServerStreamingServer[controllerv1.DiskChunk]) error {
+	disk, size, exportErr := s.manager.ExportDisk(stream.Context(), req.Project, req.Name)
+	if exportErr != nil {
+		return status.Errorf(diskErrorCode(exportErr), "failed to export disk: %v", exportErr)
+	}
+	defer func() { _ = disk.Close() }()
+
+	if err := stream.Send(&controllerv1.DiskChunk{Size: size}); err != nil {
+		return err
+	}
+	if err := grpcutil.SendChunks(disk, func(chunk []byte) error {
+		return stream.Send(&controllerv1.DiskChunk{Chunk: chunk})
+	}); err != nil {
+		slog.ErrorContext(stream.Context(), "failed to export disk", "instance", req.Name, "error", err)
+		return status.Errorf(codes.Internal, "failed to export disk: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) ImportDisk(stream grpc.ClientStreamingServer[controllerv1.ImportDiskRequest, emptypb.Empty]) error {
+	first, recvErr := stream.Recv()
+	if errors.Is(recvErr, io.EOF) {
+		return status.Error(codes.InvalidArgument, "no data received")
+	}
+	if recvErr != nil {
+		return recvErr
+	}
+	if first.Name == "" {
+		return status.Error(codes.InvalidArgument, "name must be provided in the first message")
+	}
+
+	disk := io.MultiReader(bytes.NewReader(first.Chunk), grpcutil.NewChunkReader(func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return req.Chunk, nil
+	}))
+	if importErr := s.manager.ImportDisk(stream.Context(), first.Project, first.Name, disk); importErr != nil {
+		slog.ErrorContext(stream.Context(), "failed to import disk", "instance", first.Name, "error", importErr)
+		return status.Errorf(diskErrorCode(importErr), "failed to import disk: %v", importErr)
+	}
+
+	return stream.SendAndClose(&emptypb.Empty{})
+}
+
+func NewController(settings *settingsv1.ControllerConfig, eventPublisher *events.Publisher, auditLog *audit.Log) (controllerv1.ControllerServiceServer, error) {
 	if mkdirErr := os.MkdirAll(filepath.Join(settings.Root, "db"), 0700); mkdirErr != nil {
 		return nil, mkdirErr
 	}
@@ -96,6 +257,7 @@ func NewController(settings *settingsv1.ControllerConfig, eventPublisher *events
 	server := &Server{
 		Config:  settings,
 		manager: manager,
+		audit:   auditLog,
 	}
 
 	return server, nil
@@ -0,0 +1,85 @@
+package protos
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// qemuBinary returns the configured qemu-system binary, or the one for the
+// host architecture looked up in PATH.
+func qemuBinary(config *settingsv1.QemuConfig) string {
+	if b := config.GetBinaries().GetQemu(); b != "" {
+		return b
+	}
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "arm64":
+		arch = "aarch64"
+	}
+	return "qemu-system-" + arch
+}
+
+// qemuVersion runs "qemu --version" and returns the version it reports.
+func qemuVersion(ctx context.Context, binary string) (string, error) {
+	out, err := exec.CommandContext(ctx, binary, "--version").Output() //nolint:gosec // G204: binary comes from the config
+	if err != nil {
+		return "", err
+	}
+	// QEMU emulator version 8.2.2 (Debian 1:8.2.2+ds-0ubuntu1)
+	line, _, _ := strings.Cut(string(out), "\n")
+	line = strings.TrimSpace(line)
+	if v, ok := strings.CutPrefix(line, "QEMU emulator version "); ok {
+		line = v
+	}
+	return line, nil
+}
+
+// diskUsage returns the size and free space of the filesystem holding path.
+func diskUsage(path string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize) //nolint:gosec // G115: block sizes are positive
+	return st.Blocks * bsize, st.Bavail * bsize, nil
+}
+
+// HostResources reports the host's CPUs, memory, disk under the root, the
+// QEMU version and usable accelerators. Parts that can't be determined are
+// logged and left empty.
+func (q *QemuServer) HostResources(ctx context.Context, _ *emptypb.Empty) (*settingsv1.HostResources, error) {
+	res := &settingsv1.HostResources{
+		Cpus:         uint32(runtime.NumCPU()), //nolint:gosec // G115: CPU counts fit
+		Accelerators: append(accelerators(), "tcg"),
+	}
+
+	var memErr error
+	res.MemoryTotal, res.MemoryAvailable, memErr = memoryInfo()
+	if memErr != nil {
+		slog.WarnContext(ctx, "Failed to read host memory", "error", memErr)
+	}
+
+	var diskErr error
+	res.DiskTotal, res.DiskFree, diskErr = diskUsage(q.config.Root)
+	if diskErr != nil {
+		slog.WarnContext(ctx, "Failed to read disk usage", "root", q.config.Root, "error", diskErr)
+	}
+
+	binary := qemuBinary(q.config)
+	version, versionErr := qemuVersion(ctx, binary)
+	if versionErr != nil {
+		slog.WarnContext(ctx, "Failed to read QEMU version", "binary", binary, "error", versionErr)
+	}
+	res.QemuVersion = version
+
+	return res, nil
+}
@@ -0,0 +1,28 @@
+package protos
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// memoryInfo returns the total memory from sysctl. Available memory isn't
+// reported on macOS.
+func memoryInfo() (uint64, uint64, error) {
+	raw, err := syscall.Sysctl("hw.memsize")
+	if err != nil {
+		return 0, 0, err
+	}
+	// syscall.Sysctl strips a trailing NUL, which here is part of the
+	// little-endian integer.
+	buf := make([]byte, 8)
+	copy(buf, raw)
+	return binary.LittleEndian.Uint64(buf), 0, nil
+}
+
+// accelerators returns "hvf" when the Hypervisor framework is supported.
+func accelerators() []string {
+	if v, err := syscall.SysctlUint32("kern.hv_support"); err == nil && v == 1 {
+		return []string{"hvf"}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package protos
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQemuVersion(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "qemu-system-test")
+	script := "#!/bin/sh\necho 'QEMU emulator version 8.2.2 (Debian 1:8.2.2+ds-0ubuntu1)'\necho 'Copyright (c) 2003-2023 Fabrice Bellard and the QEMU Project developers'\n"
+	if err := os.WriteFile(binary, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := qemuVersion(context.Background(), binary)
+	if err != nil {
+		t.Fatalf("qemuVersion failed: %v", err)
+	}
+	if want := "8.2.2 (Debian 1:8.2.2+ds-0ubuntu1)"; version != want {
+		t.Errorf("expected %q, got %q", want, version)
+	}
+
+	if _, err := qemuVersion(context.Background(), filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing binary")
+	}
+}
+
+func TestDiskAndMemoryUsage(t *testing.T) {
+	total, free, err := diskUsage(t.TempDir())
+	if err != nil {
+		t.Fatalf("diskUsage failed: %v", err)
+	}
+	if total == 0 || free > total {
+		t.Errorf("unexpected disk usage: total %d, free %d", total, free)
+	}
+
+	memTotal, memAvailable, err := memoryInfo()
+	if err != nil {
+		t.Fatalf("memoryInfo failed: %v", err)
+	}
+	if memTotal == 0 || memAvailable > memTotal {
+		t.Errorf("unexpected memory: total %d, available %d", memTotal, memAvailable)
+	}
+}
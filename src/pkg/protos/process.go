@@ -1,11 +1,14 @@
 package protos
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -17,11 +20,16 @@ import (
 	processv1 "github.com/q-controller/qcontroller/src/generated/services/process/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
 	runtimev1 "github.com/q-controller/qcontroller/src/generated/vm/runtime/v1"
+	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
 	"github.com/q-controller/qcontroller/src/pkg/images"
 	"github.com/q-controller/qcontroller/src/pkg/qemu/process"
 	"github.com/q-controller/qcontroller/src/pkg/utils/network"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/dns"
 	"github.com/q-controller/qcontroller/src/pkg/utils/network/ip"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/leases"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/overlay"
 	"github.com/q-controller/qemu-client/pkg/qemu"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
@@ -163,9 +171,24 @@ func (q *QemuServer) instanceDir(id string) string {
 	return filepath.Join(q.instancesDir, id)
 }
 
+// validateID checks id names a directory directly under the instances
+// directory, so it can't reach outside it.
+func validateID(id string) error {
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "id must be provided")
+	}
+	if id == "." || id == ".." || filepath.Base(id) != id {
+		return status.Errorf(codes.InvalidArgument, "invalid instance id %q", id)
+	}
+	return nil
+}
+
 func (q *QemuServer) Start(ctx context.Context,
 	req *processv1.StartRequest) (*processv1.StartResponse, error) {
-	id := req.Config.Id
+	id := req.GetConfig().GetId()
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
 
 	q.startingMu.Lock()
 	if _, ok := q.starting[id]; ok {
@@ -213,9 +236,27 @@ func (q *QemuServer) Start(ctx context.Context,
 		if removeErr := q.nm.RemoveInterface(id); removeErr != nil {
 			slog.WarnContext(ctx, "Failed to remove existing interface", "instance", id, "error", removeErr)
 		}
-		if ifcErr := q.nm.CreateInterface(id); ifcErr != nil {
+		ifcOpts := []network.InterfaceOption{
+			network.WithLimits(req.Config.NetworkLimits),
+			network.WithVlan(uint16(req.Config.Network.GetVlanId())), //nolint:gosec // G115: validated by the controller
+			network.WithMAC(req.Config.Network.GetMac()),
+		}
+		if name := req.Config.Network.GetOverlayNetwork(); name != "" {
+			bridge, bridgeErr := q.overlayBridge(name)
+			if bridgeErr != nil {
+				return nil, bridgeErr
+			}
+			ifcOpts = append(ifcOpts, network.WithBridge(bridge))
+		}
+		if ifcErr := q.nm.CreateInterface(id, ifcOpts...); ifcErr != nil {
 			return nil, status.Errorf(codes.Internal, "method Start failed: %v", ifcErr)
 		}
+	} else if network.HasLimits(req.Config.NetworkLimits) {
+		return nil, status.Errorf(codes.FailedPrecondition, "network limits are not supported on this platform")
+	} else if req.Config.Network.GetVlanId() != 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "vlans are not supported on this platform")
+	} else if req.Config.Network.GetOverlayNetwork() != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "overlay networks are not supported on this platform")
 	}
 
 	cloudInit := qemu.CloudInitConfig{}
@@ -376,21 +417,26 @@ func (q *QemuServer) getMacAddressForInstance(ctx context.Context, id string) (s
 	return macAddress, nil
 }
 
-// getIPAddressesForInstance retrieves IP addresses for a VM by looking up its MAC in the ARP cache.
+// getIPAddressesForInstance retrieves the IPv4 and IPv6 addresses for a VM
+// by looking up its MAC in the neighbor cache.
 func (q *QemuServer) getIPAddressesForInstance(ctx context.Context, id string) ([]string, error) {
 	mac, err := q.getMacAddressForInstance(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	ipaddr, err := q.addressResolver.LookupIP(mac)
+	ipaddrs, err := q.addressResolver.LookupIPs(mac)
 	if err != nil {
-		// MAC not found in ARP cache yet - not an error, just no IP available
-		slog.DebugContext(ctx, "MAC not found in ARP cache", "instance", id, "mac", mac, "error", err)
+		// MAC not found in the neighbor cache yet - not an error, just no IP available
+		slog.DebugContext(ctx, "MAC not found in neighbor cache", "instance", id, "mac", mac, "error", err)
 		return nil, nil
 	}
 
-	return []string{ipaddr.String()}, nil
+	res := make([]string, 0, len(ipaddrs))
+	for _, ipaddr := range ipaddrs {
+		res = append(res, ipaddr.String())
+	}
+	return res, nil
 }
 
 func parseGuestStats(data []byte) *settingsv1.MemoryStats {
@@ -517,10 +563,13 @@ func (q *QemuServer) List(ctx context.Context, req *processv1.ListRequest) (*pro
 }
 
 func (q *QemuServer) Remove(ctx context.Context, req *processv1.RemoveRequest) (*emptypb.Empty, error) {
+	if err := validateID(req.Id); err != nil {
+		return nil, err
+	}
 	dir := q.instanceDir(req.Id)
 
 	// Refuse to remove if process is still alive
-	if pid, err := qemu.ReadPidfile(dir); err == nil && qemu.ProcessAlive(pid) {
+	if q.instanceRunning(req.Id) {
 		return nil, status.Errorf(codes.FailedPrecondition, "instance %s is still running", req.Id)
 	}
 
@@ -531,6 +580,218 @@ func (q *QemuServer) Remove(ctx context.Context, req *processv1.RemoveRequest) (
 	return &emptypb.Empty{}, nil
 }
 
+// dnsZone returns the zone served by this node's DNS front-end.
+func (q *QemuServer) dnsZone() string {
+	if linuxSettings := q.config.GetLinuxSettings(); linuxSettings != nil {
+		return linuxSettings.GetNetwork().GetDns().GetZone()
+	}
+	return q.config.GetMacosSettings().GetDns().GetZone()
+}
+
+// SetDnsRecords writes the user-defined records to the records file watched
+// by the DNS front-end. Records outside this node's zone are rejected as a
+// whole so a misconfigured orchestrator doesn't leave a partial set behind.
+func (q *QemuServer) SetDnsRecords(ctx context.Context, req *processv1.SetDnsRecordsRequest) (*emptypb.Empty, error) {
+	records := make([]dns.Record, 0, len(req.Records))
+	for _, r := range req.Records {
+		rec := dns.Normalize(dns.FromProto(r))
+		if err := dns.Validate(rec, q.dnsZone()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid dns record: %v", err)
+		}
+		records = append(records, rec)
+	}
+
+	if err := dns.WriteRecordsFile(dns.RecordsFile(q.config.Root), records); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to write dns records: %v", err)
+	}
+	slog.DebugContext(ctx, "Updated DNS records", "count", len(records))
+
+	return &emptypb.Empty{}, nil
+}
+
+// ListLeases returns the leases in the DHCP server's lease file. Only the
+// Linux network runs its own DHCP server; on macOS vmnet hands out addresses.
+func (q *QemuServer) ListLeases(ctx context.Context, _ *emptypb.Empty) (*processv1.ListLeasesResponse, error) {
+	network := q.config.GetLinuxSettings().GetNetwork()
+	if network == nil {
+		return nil, status.Errorf(codes.Unimplemented, "DHCP leases are only available on Linux")
+	}
+
+	entries, err := leases.ReadFile(network.GetDhcp().GetLeaseFile())
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read leases", "error", err)
+		return nil, status.Errorf(codes.Internal, "failed to read leases: %v", err)
+	}
+
+	res := make([]*settingsv1.DhcpLease, 0, len(entries))
+	for _, l := range entries {
+		res = append(res, &settingsv1.DhcpLease{
+			Mac:      l.MAC,
+			Ip:       l.IP.String(),
+			Hostname: l.Hostname,
+			Expiry:   l.Expiry.Unix(),
+		})
+	}
+
+	return &processv1.ListLeasesResponse{Network: network.GetName(), Leases: res}, nil
+}
+
+// SetNetworkLimits replaces the limits on a running instance's interface.
+func (q *QemuServer) SetNetworkLimits(ctx context.Context, req *processv1.SetNetworkLimitsRequest) (*emptypb.Empty, error) {
+	if q.nm == nil {
+		return nil, status.Errorf(codes.Unimplemented, "network limits are not supported on this platform")
+	}
+
+	if err := q.nm.SetLimits(req.Id, req.Limits); err != nil {
+		slog.ErrorContext(ctx, "failed to set network limits", "instance", req.Id, "error", err)
+		return nil, status.Errorf(codes.Internal, "failed to set network limits: %v", err)
+	}
+	slog.InfoContext(ctx, "Updated network limits", "instance", req.Id, "limits", req.Limits)
+
+	return &emptypb.Empty{}, nil
+}
+
+// SetOverlayNetworks writes the overlay networks to the config file the
+// parent process (in the host namespace) reconciles VXLAN devices from.
+func (q *QemuServer) SetOverlayNetworks(ctx context.Context, req *processv1.SetOverlayNetworksRequest) (*emptypb.Empty, error) {
+	if q.nm == nil {
+		return nil, status.Errorf(codes.Unimplemented, "overlay networks are not supported on this platform")
+	}
+
+	cfg := overlay.Config{Peers: req.Peers}
+	for _, n := range req.Networks {
+		if err := overlay.ValidateName(n.Name); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if err := overlay.ValidateVNI(n.Vni); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "overlay network %s: %v", n.Name, err)
+		}
+		cfg.Networks = append(cfg.Networks, overlay.Network{Name: n.Name, VNI: n.Vni, Subnet: n.Subnet})
+	}
+	for _, peer := range req.Peers {
+		if net.ParseIP(peer) == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid overlay peer address %q", peer)
+		}
+	}
+
+	if err := overlay.WriteConfigFile(overlay.ConfigFile(q.config.Root), cfg); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to write overlay config: %v", err)
+	}
+	slog.DebugContext(ctx, "Updated overlay networks", "networks", len(cfg.Networks), "peers", len(cfg.Peers))
+
+	return &emptypb.Empty{}, nil
+}
+
+// overlayBridge returns the bridge of the overlay network called name.
+func (q *QemuServer) overlayBridge(name string) (string, error) {
+	cfg, err := overlay.ReadConfigFile(overlay.ConfigFile(q.config.Root))
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to read overlay config: %v", err)
+	}
+	n, ok := cfg.Lookup(name)
+	if !ok {
+		return "", status.Errorf(codes.FailedPrecondition, "overlay network %s is not configured on this node", name)
+	}
+	return overlay.BridgeName(n.VNI), nil
+}
+
+// instanceRunning reports whether the instance's QEMU process is alive.
+func (q *QemuServer) instanceRunning(id string) bool {
+	pid, err := qemu.ReadPidfile(q.instanceDir(id))
+	return err == nil && qemu.ProcessAlive(pid)
+}
+
+// ExportDisk streams a stopped instance's disk: its size first, then the
+// contents.
+func (q *QemuServer) ExportDisk(req *processv1.ExportDiskRequest, stream grpc.ServerStreamingServer[processv1.DiskChunk]) error {
+	if err := validateID(req.Id); err != nil {
+		return err
+	}
+	if q.instanceRunning(req.Id) {
+		return status.Errorf(codes.FailedPrecondition, "instance %s is still running", req.Id)
+	}
+
+	file, openErr := os.Open(qemu.ImagePath(q.instanceDir(req.Id)))
+	if os.IsNotExist(openErr) {
+		return status.Errorf(codes.NotFound, "instance %s has no disk", req.Id)
+	}
+	if openErr != nil {
+		return status.Errorf(codes.Internal, "failed to open disk: %v", openErr)
+	}
+	defer func() { _ = file.Close() }()
+
+	stat, statErr := file.Stat()
+	if statErr != nil {
+		return status.Errorf(codes.Internal, "failed to stat disk: %v", statErr)
+	}
+	if err := stream.Send(&processv1.DiskChunk{Size: stat.Size()}); err != nil {
+		return err
+	}
+
+	return grpcutil.SendChunks(file, func(chunk []byte) error {
+		return stream.Send(&processv1.DiskChunk{Chunk: chunk})
+	})
+}
+
+// ImportDisk replaces a stopped instance's disk with the streamed contents.
+// The disk is written next to the old one and renamed into place once
+// complete, so a broken stream leaves the old disk untouched.
+func (q *QemuServer) ImportDisk(stream grpc.ClientStreamingServer[processv1.ImportDiskRequest, emptypb.Empty]) error {
+	first, recvErr := stream.Recv()
+	if errors.Is(recvErr, io.EOF) {
+		return status.Error(codes.InvalidArgument, "no data received")
+	}
+	if recvErr != nil {
+		return recvErr
+	}
+	id := first.Id
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "id must be provided in the first message")
+	}
+	if err := validateID(id); err != nil {
+		return err
+	}
+	if q.instanceRunning(id) {
+		return status.Errorf(codes.FailedPrecondition, "instance %s is still running", id)
+	}
+
+	dir := q.instanceDir(id)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return status.Errorf(codes.Internal, "failed to create instance dir: %v", err)
+	}
+	imagePath := qemu.ImagePath(dir)
+	tmpPath := imagePath + ".import"
+	file, createErr := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(createErr) {
+		return status.Errorf(codes.AlreadyExists, "a disk is already being imported for instance %s", id)
+	}
+	if createErr != nil {
+		return status.Errorf(codes.Internal, "failed to create disk: %v", createErr)
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	_, copyErr := io.Copy(file, io.MultiReader(bytes.NewReader(first.Chunk), grpcutil.NewChunkReader(func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return req.Chunk, nil
+	})))
+	if closeErr := file.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		return status.Errorf(codes.Internal, "failed to write disk: %v", copyErr)
+	}
+
+	if err := os.Rename(tmpPath, imagePath); err != nil {
+		return status.Errorf(codes.Internal, "failed to finalize disk: %v", err)
+	}
+	slog.Info("Imported disk", "instance", id)
+
+	return stream.SendAndClose(&emptypb.Empty{})
+}
+
 func (q *QemuServer) reattachOnStartup() {
 	entries, err := os.ReadDir(q.instancesDir)
 	if err != nil {
@@ -593,7 +854,15 @@ func NewQemuService(monitor *process.InstanceMonitor, addressResolver ip.Address
 	}
 
 	if linuxSettings := config.GetLinuxSettings(); linuxSettings != nil {
-		nm, nmErr := network.NewNetworkManager(linuxSettings.Network.Name, linuxSettings.Network.BridgeIp)
+		trunkVlans := make([]uint16, 0, len(linuxSettings.Network.TrunkVlans))
+		for _, vid := range linuxSettings.Network.TrunkVlans {
+			if vlanErr := network.ValidateVlanID(vid); vlanErr != nil {
+				return nil, fmt.Errorf("invalid trunk vlan: %w", vlanErr)
+			}
+			trunkVlans = append(trunkVlans, uint16(vid))
+		}
+		nm, nmErr := network.NewNetworkManager(linuxSettings.Network.Name, linuxSettings.Network.BridgeIp,
+			network.WithTrunk(linuxSettings.Network.TrunkInterface, trunkVlans))
 		if nmErr != nil {
 			return nil, nmErr
 		}
@@ -264,3 +264,16 @@ func TestParseBlockInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"vm1", "db.team-a", "web_2"} {
+		if err := validateID(id); err != nil {
+			t.Errorf("validateID(%q) = %v, want nil", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "../etc", "a/b", "/abs"} {
+		if err := validateID(id); err == nil {
+			t.Errorf("validateID(%q) = nil, want an error", id)
+		}
+	}
+}
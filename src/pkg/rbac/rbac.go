@@ -0,0 +1,280 @@
+// Package rbac decides what authenticated users may do, based on the role
+// bindings and projects in the orchestrator config.
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/q-controller/qcontroller/src/pkg/labels"
+)
+
+// AnySubject is the RoleBinding subject that matches every subject.
+const AnySubject = "*"
+
+// ErrPermissionDenied is wrapped by the errors of denied checks.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Resource is what an action is checked against: an instance on a node, a
+// node, or, with neither set, the cluster.
+type Resource struct {
+	Node     string
+	Instance string
+	Labels   map[string]string
+	// Project of the instance; empty for the shared default project.
+	Project string
+}
+
+func (r Resource) String() string {
+	switch {
+	case r.Instance != "":
+		return fmt.Sprintf("instance %s on %s", r.Instance, r.Node)
+	case r.Node != "":
+		return "node " + r.Node
+	default:
+		return "the cluster"
+	}
+}
+
+type binding struct {
+	*settingsv1.RoleBinding
+	selector labels.Selector
+}
+
+// grants reports whether the binding applies to id.
+func (b binding) grants(id *auth.Identity) bool {
+	if b.Group != "" {
+		return slices.Contains(id.Groups, b.Group)
+	}
+	return b.Subject == AnySubject || b.Subject == id.Subject
+}
+
+// covers reports whether res is in the binding's scope.
+func (b binding) covers(res Resource) bool {
+	if len(b.Nodes) > 0 && !slices.Contains(b.Nodes, res.Node) {
+		return false
+	}
+	if !b.selector.Empty() {
+		return res.Instance != "" && b.selector.Matches(res.Labels)
+	}
+	return true
+}
+
+var projectNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateProjectName checks the name is a DNS label, as it becomes part of
+// the names of the project's instances.
+func ValidateProjectName(name string) error {
+	if !projectNameRe.MatchString(name) {
+		return fmt.Errorf("project name %q must be a lowercase DNS label", name)
+	}
+	return nil
+}
+
+// Policy checks identities against role bindings and project membership.
+// A nil or empty Policy allows everything.
+type Policy struct {
+	bindings []binding
+	projects map[string]*settingsv1.Project
+}
+
+// NewPolicy validates the role bindings and projects.
+func NewPolicy(bindings []*settingsv1.RoleBinding, projects []*settingsv1.Project) (*Policy, error) {
+	p := &Policy{projects: make(map[string]*settingsv1.Project, len(projects))}
+	for _, project := range projects {
+		if err := ValidateProjectName(project.Name); err != nil {
+			return nil, err
+		}
+		if _, dup := p.projects[project.Name]; dup {
+			return nil, fmt.Errorf("project %s is defined twice", project.Name)
+		}
+		p.projects[project.Name] = project
+	}
+	for i, rb := range bindings {
+		if (rb.Subject == "") == (rb.Group == "") {
+			return nil, fmt.Errorf("role binding %d needs exactly one of subject and group", i)
+		}
+		if rb.Role == settingsv1.Role_ROLE_UNSPECIFIED {
+			return nil, fmt.Errorf("role binding %d needs a role", i)
+		}
+		selector, err := labels.Parse(rb.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("role binding %d: %w", i, err)
+		}
+		p.bindings = append(p.bindings, binding{RoleBinding: rb, selector: selector})
+	}
+	return p, nil
+}
+
+// Enabled reports whether the policy restricts anything.
+func (p *Policy) Enabled() bool {
+	return p != nil && (len(p.bindings) > 0 || len(p.projects) > 0)
+}
+
+// Allowed reports whether id has role on res. Instances in a project are
+// limited to its members and to admins of the cluster. Callers without an
+// identity are treated as anonymous.
+func (p *Policy) Allowed(id *auth.Identity, role settingsv1.Role, res Resource) bool {
+	if !p.Enabled() {
+		return true
+	}
+	id = orAnonymous(id)
+	if !p.inProject(id, res.Project) {
+		return false
+	}
+	return len(p.bindings) == 0 || p.bound(id, role, res)
+}
+
+// inProject reports whether id may access the project's instances.
+func (p *Policy) inProject(id *auth.Identity, project string) bool {
+	return project == "" || p.Member(id, project) || p.bound(id, settingsv1.Role_ROLE_ADMIN, Resource{})
+}
+
+// bound reports whether a role binding gives id role on res.
+func (p *Policy) bound(id *auth.Identity, role settingsv1.Role, res Resource) bool {
+	for _, b := range p.bindings {
+		if b.Role >= role && b.grants(id) && b.covers(res) {
+			return true
+		}
+	}
+	return false
+}
+
+// Member reports whether id is a member of the project.
+func (p *Policy) Member(id *auth.Identity, project string) bool {
+	if p == nil {
+		return false
+	}
+	proj, ok := p.projects[project]
+	if !ok {
+		return false
+	}
+	id = orAnonymous(id)
+	return slices.Contains(proj.Subjects, id.Subject) || slices.ContainsFunc(proj.Groups, func(g string) bool {
+		return slices.Contains(id.Groups, g)
+	})
+}
+
+// Projects returns the projects id may see, ordered by name.
+func (p *Policy) Projects(id *auth.Identity) []*settingsv1.Project {
+	if p == nil {
+		return nil
+	}
+	id = orAnonymous(id)
+	var out []*settingsv1.Project
+	for _, name := range slices.Sorted(maps.Keys(p.projects)) {
+		if p.inProject(id, name) {
+			out = append(out, p.projects[name])
+		}
+	}
+	return out
+}
+
+// HasProject reports whether the project is defined.
+func (p *Policy) HasProject(project string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.projects[project]
+	return ok
+}
+
+// AllowedAnywhere reports whether id has role on anything at all. It's for
+// cluster-wide resources everyone working in the cluster needs to read,
+// such as images.
+func (p *Policy) AllowedAnywhere(id *auth.Identity, role settingsv1.Role) bool {
+	if p == nil || len(p.bindings) == 0 {
+		return true
+	}
+	id = orAnonymous(id)
+	for _, b := range p.bindings {
+		if b.Role >= role && b.grants(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check is Allowed returning an error wrapping ErrPermissionDenied.
+func (p *Policy) Check(id *auth.Identity, role settingsv1.Role, res Resource) error {
+	if p.Allowed(id, role, res) {
+		return nil
+	}
+	id = orAnonymous(id)
+	if !p.inProject(id, res.Project) {
+		return fmt.Errorf("%w: %s isn't a member of project %s", ErrPermissionDenied, id.Subject, res.Project)
+	}
+	return fmt.Errorf("%w: %s needs the %s role on %s", ErrPermissionDenied, id.Subject, RoleName(role), res)
+}
+
+// CheckAnywhere is AllowedAnywhere returning an error wrapping
+// ErrPermissionDenied.
+func (p *Policy) CheckAnywhere(id *auth.Identity, role settingsv1.Role) error {
+	if p.AllowedAnywhere(id, role) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s needs the %s role", ErrPermissionDenied, orAnonymous(id).Subject, RoleName(role))
+}
+
+// CanSee reports whether id may receive event: instance events need the
+// viewer role on the instance, node events on the node, image events
+// anywhere, and audit events the admin role on the cluster.
+func (p *Policy) CanSee(id *auth.Identity, event *orchestratorv1.Event) bool {
+	update := event.GetUpdate()
+	switch {
+	case update.GetVmEvent() != nil:
+		info := update.GetVmEvent().GetInfo()
+		return p.Allowed(id, settingsv1.Role_ROLE_VIEWER, Resource{
+			Node:     event.Node,
+			Instance: info.GetName(),
+			Labels:   info.GetSpec().GetLabels(),
+			Project:  info.GetSpec().GetProject(),
+		})
+	case update.GetAuditEvent() != nil:
+		return p.Allowed(id, settingsv1.Role_ROLE_ADMIN, Resource{})
+	case update.GetImageEvent() != nil || event.Node == "":
+		return p.AllowedAnywhere(id, settingsv1.Role_ROLE_VIEWER)
+	default:
+		return p.Allowed(id, settingsv1.Role_ROLE_VIEWER, Resource{Node: event.Node})
+	}
+}
+
+// Images guards the image REST handlers: listing images needs the viewer
+// role anywhere, uploading and removing them the operator role on the
+// cluster.
+func (p *Policy) Images(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := auth.FromContext(r.Context())
+		var err error
+		if r.Method == http.MethodGet {
+			err = p.CheckAnywhere(id, settingsv1.Role_ROLE_VIEWER)
+		} else {
+			err = p.Check(id, settingsv1.Role_ROLE_OPERATOR, Resource{})
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RoleName returns the role's lowercase name, e.g. "operator".
+func RoleName(role settingsv1.Role) string {
+	return strings.ToLower(strings.TrimPrefix(role.String(), "ROLE_"))
+}
+
+func orAnonymous(id *auth.Identity) *auth.Identity {
+	if id == nil {
+		return auth.Anonymous()
+	}
+	return id
+}
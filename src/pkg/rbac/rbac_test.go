@@ -0,0 +1,189 @@
+package rbac
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = &auth.Identity{Subject: "alice", Groups: []string{"ops"}}
+	bob   = &auth.Identity{Subject: "bob", Groups: []string{"web"}}
+	carol = &auth.Identity{Subject: "carol"}
+)
+
+func testPolicy(t *testing.T) *Policy {
+	t.Helper()
+	p, err := NewPolicy([]*settingsv1.RoleBinding{
+		{Group: "ops", Role: settingsv1.Role_ROLE_ADMIN},
+		{Group: "web", Role: settingsv1.Role_ROLE_OPERATOR, LabelSelector: "team=web"},
+		{Subject: "bob", Role: settingsv1.Role_ROLE_VIEWER, Nodes: []string{"a"}},
+	}, nil)
+	require.NoError(t, err)
+	return p
+}
+
+func TestNewPolicy_Validates(t *testing.T) {
+	for _, rb := range []*settingsv1.RoleBinding{
+		{Role: settingsv1.Role_ROLE_VIEWER},
+		{Subject: "bob", Group: "web", Role: settingsv1.Role_ROLE_VIEWER},
+		{Subject: "bob"},
+		{Subject: "bob", Role: settingsv1.Role_ROLE_VIEWER, LabelSelector: "team in (web"},
+	} {
+		_, err := NewPolicy([]*settingsv1.RoleBinding{rb}, nil)
+		assert.Error(t, err, "%v", rb)
+	}
+}
+
+func TestPolicy_Disabled(t *testing.T) {
+	var nilPolicy *Policy
+	empty, err := NewPolicy(nil, nil)
+	require.NoError(t, err)
+	for _, p := range []*Policy{nilPolicy, empty} {
+		assert.False(t, p.Enabled())
+		assert.True(t, p.Allowed(nil, settingsv1.Role_ROLE_ADMIN, Resource{}))
+		assert.NoError(t, p.CheckAnywhere(carol, settingsv1.Role_ROLE_ADMIN))
+	}
+}
+
+func TestPolicy_Allowed(t *testing.T) {
+	p := testPolicy(t)
+	web := Resource{Node: "b", Instance: "vm1", Labels: map[string]string{"team": "web"}}
+	db := Resource{Node: "a", Instance: "vm2", Labels: map[string]string{"team": "db"}}
+
+	for _, tc := range []struct {
+		id   *auth.Identity
+		role settingsv1.Role
+		res  Resource
+		want bool
+	}{
+		{alice, settingsv1.Role_ROLE_ADMIN, Resource{}, true},
+		{alice, settingsv1.Role_ROLE_VIEWER, db, true},
+		// Label scopes cover matching instances on any node, and nothing else.
+		{bob, settingsv1.Role_ROLE_OPERATOR, web, true},
+		{bob, settingsv1.Role_ROLE_OPERATOR, db, false},
+		{bob, settingsv1.Role_ROLE_OPERATOR, Resource{Node: "b"}, false},
+		// Node scopes cover the node and its instances.
+		{bob, settingsv1.Role_ROLE_VIEWER, db, true},
+		{bob, settingsv1.Role_ROLE_VIEWER, Resource{Node: "a"}, true},
+		{bob, settingsv1.Role_ROLE_VIEWER, Resource{Node: "b"}, false},
+		{bob, settingsv1.Role_ROLE_VIEWER, Resource{}, false},
+		{carol, settingsv1.Role_ROLE_VIEWER, web, false},
+		{nil, settingsv1.Role_ROLE_VIEWER, web, false},
+	} {
+		assert.Equal(t, tc.want, p.Allowed(tc.id, tc.role, tc.res), "%v %v on %v", tc.id, tc.role, tc.res)
+	}
+
+	err := p.Check(bob, settingsv1.Role_ROLE_OPERATOR, db)
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	assert.Equal(t, "permission denied: bob needs the operator role on instance vm2 on a", err.Error())
+
+	assert.True(t, p.AllowedAnywhere(bob, settingsv1.Role_ROLE_OPERATOR))
+	assert.False(t, p.AllowedAnywhere(bob, settingsv1.Role_ROLE_ADMIN))
+	assert.False(t, p.AllowedAnywhere(carol, settingsv1.Role_ROLE_VIEWER))
+
+	everyone, err := NewPolicy([]*settingsv1.RoleBinding{{Subject: AnySubject, Role: settingsv1.Role_ROLE_VIEWER}}, nil)
+	require.NoError(t, err)
+	assert.True(t, everyone.Allowed(carol, settingsv1.Role_ROLE_VIEWER, Resource{}))
+	assert.True(t, everyone.Allowed(nil, settingsv1.Role_ROLE_VIEWER, Resource{}))
+	assert.False(t, everyone.Allowed(carol, settingsv1.Role_ROLE_OPERATOR, Resource{}))
+}
+
+func TestPolicy_Projects(t *testing.T) {
+	p, err := NewPolicy([]*settingsv1.RoleBinding{
+		{Group: "ops", Role: settingsv1.Role_ROLE_ADMIN},
+		{Subject: AnySubject, Role: settingsv1.Role_ROLE_OPERATOR},
+	}, []*settingsv1.Project{
+		{Name: "web", Groups: []string{"web"}},
+		{Name: "db", Subjects: []string{"carol"}},
+	})
+	require.NoError(t, err)
+	db := Resource{Node: "a", Instance: "vm1.db", Project: "db"}
+
+	assert.True(t, p.Member(carol, "db"))
+	assert.False(t, p.Member(bob, "db"))
+	assert.True(t, p.Allowed(carol, settingsv1.Role_ROLE_OPERATOR, db))
+	assert.True(t, p.Allowed(alice, settingsv1.Role_ROLE_OPERATOR, db))
+	assert.True(t, p.Allowed(bob, settingsv1.Role_ROLE_OPERATOR, Resource{Node: "a", Instance: "vm2"}))
+	err = p.Check(bob, settingsv1.Role_ROLE_VIEWER, db)
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	assert.Equal(t, "permission denied: bob isn't a member of project db", err.Error())
+
+	names := func(projects []*settingsv1.Project) (out []string) {
+		for _, project := range projects {
+			out = append(out, project.Name)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"web"}, names(p.Projects(bob)))
+	assert.Equal(t, []string{"db", "web"}, names(p.Projects(alice)))
+	assert.Empty(t, p.Projects(nil))
+
+	for _, projects := range [][]*settingsv1.Project{
+		{{Name: "Web"}},
+		{{Name: "a.b"}},
+		{{Name: "web"}, {Name: "web"}},
+	} {
+		_, err := NewPolicy(nil, projects)
+		assert.Error(t, err, "%v", projects)
+	}
+}
+
+func TestPolicy_CanSee(t *testing.T) {
+	p := testPolicy(t)
+	vmEvent := func(node string, labels map[string]string) *orchestratorv1.Event {
+		return &orchestratorv1.Event{Node: node, Update: &eventv1.Update{Payload: &eventv1.Update_VmEvent{
+			VmEvent: &eventv1.VMEvent{Info: &controllerv1.Info{Name: "vm1", Spec: &controllerv1.VMSpec{Labels: labels}}},
+		}}}
+	}
+	nodeEvent := &orchestratorv1.Event{Node: "b", Update: &eventv1.Update{Payload: &eventv1.Update_NodeEvent{NodeEvent: &eventv1.NodeEvent{}}}}
+	imageEvent := &orchestratorv1.Event{Update: &eventv1.Update{Payload: &eventv1.Update_ImageEvent{ImageEvent: &eventv1.ImageEvent{}}}}
+
+	assert.True(t, p.CanSee(bob, vmEvent("b", map[string]string{"team": "web"})))
+	assert.False(t, p.CanSee(bob, vmEvent("b", map[string]string{"team": "db"})))
+	assert.True(t, p.CanSee(bob, vmEvent("a", nil)))
+	assert.False(t, p.CanSee(bob, nodeEvent))
+	assert.True(t, p.CanSee(alice, nodeEvent))
+	assert.True(t, p.CanSee(bob, imageEvent))
+	assert.False(t, p.CanSee(carol, imageEvent))
+
+	auditEvent := &orchestratorv1.Event{Node: "a", Update: &eventv1.Update{Payload: &eventv1.Update_AuditEvent{AuditEvent: &eventv1.AuditEvent{}}}}
+	assert.True(t, p.CanSee(alice, auditEvent))
+	assert.False(t, p.CanSee(bob, auditEvent))
+}
+
+func TestPolicy_Images(t *testing.T) {
+	p, err := NewPolicy([]*settingsv1.RoleBinding{
+		{Subject: "alice", Role: settingsv1.Role_ROLE_OPERATOR},
+		{Subject: "bob", Role: settingsv1.Role_ROLE_OPERATOR, Nodes: []string{"a"}},
+	}, nil)
+	require.NoError(t, err)
+	handler := p.Images(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, tc := range []struct {
+		id     *auth.Identity
+		method string
+		want   int
+	}{
+		{alice, http.MethodPost, http.StatusOK},
+		{bob, http.MethodGet, http.StatusOK},
+		// Images are cluster-wide, so node-scoped operators can't change them.
+		{bob, http.MethodDelete, http.StatusForbidden},
+		{carol, http.MethodGet, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, "/v1/images", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(auth.WithIdentity(req.Context(), tc.id)))
+		assert.Equal(t, tc.want, rec.Code, "%s %s", tc.id.Subject, tc.method)
+	}
+}
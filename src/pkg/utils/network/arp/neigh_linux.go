@@ -0,0 +1,414 @@
+//go:build linux
+
+package arp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/ip"
+	"github.com/vishvananda/netlink"
+)
+
+// NeighborResolver resolves MAC addresses from the kernel neighbor table of
+// a single interface. It follows netlink neighbor updates (RTM_NEWNEIGH and
+// RTM_DELNEIGH), so a VM shows up as soon as it talks to the bridge instead
+// of on the next subnet scan. A lookup for an unknown MAC triggers an ARP
+// probe of the address it was last seen at or was hinted at (see HintIP),
+// backing off while it doesn't answer; the subnet is never swept for it. If
+// the netlink subscription is unavailable or breaks, the resolver falls back
+// to periodic scanning with the configured Scanner.
+//
+// With an IPv6 prefix configured, IPv6 neighbors in that prefix are tracked
+// too. VMs configure their address via SLAAC, so an unknown MAC is probed by
+// sending a datagram to its EUI-64 address, which makes the kernel run NDP
+// and report the result as a neighbor update.
+//
+// NeighborResolver implements the ip.AddressResolver and ip.AddressHinter
+// interfaces.
+type NeighborResolver struct {
+	cfg       *ScannerConfig
+	subnet    *net.IPNet
+	linkIndex int
+	ctx       context.Context
+	cancel    context.CancelFunc
+	probeCh   chan string
+
+	// ndpProbe triggers neighbor discovery for an IPv6 address.
+	ndpProbe func(addr net.IP) error
+
+	mu         sync.RWMutex
+	hosts      map[string]net.IP     // MAC <-> IP from the neighbor table
+	hosts6     map[string][]net.IP   // MAC <-> IPv6 addresses in Prefix6
+	hints      map[string]net.IP     // MAC <-> last seen or hinted IP, kept after the entry is gone
+	probes     map[string]probeState // MAC <-> backoff of the ARP probes
+	lastProbe6 map[string]time.Time  // MAC <-> time of the last NDP probe
+	fallback   ip.AddressResolver
+}
+
+// maxProbeBackoff bounds how long a silent MAC waits between ARP probes.
+const maxProbeBackoff = 5 * time.Minute
+
+// probeState tracks the ARP probes of a MAC that doesn't answer.
+type probeState struct {
+	next    time.Time     // no probe before then
+	backoff time.Duration // wait after the last unanswered probe
+}
+
+// NewNeighborResolver creates a resolver for the neighbors of ifcName in
+// subnet. The options are the same as for NewResolver; the Scanner is used
+// for targeted probes (when it implements Prober) and as the fallback, and
+// Interval is the shortest wait between probes of the same MAC.
+func NewNeighborResolver(ctx context.Context, ifcName string, subnet *net.IPNet, opts ...ScannerOption) (ip.AddressResolver, error) {
+	cfg := &ScannerConfig{
+		Interval: 5 * time.Second,
+		Timeout:  2 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.Scanner == nil {
+		return nil, errors.New("scanner is required for neighbor resolver")
+	}
+	if subnet == nil {
+		return nil, errors.New("subnet is required")
+	}
+
+	iface, ifaceErr := net.InterfaceByName(ifcName)
+	if ifaceErr != nil {
+		slog.Warn("Neighbor tracking unavailable, falling back to ARP scanning", "interface", ifcName, "error", ifaceErr)
+		return NewResolver(ctx, opts...)
+	}
+
+	r := newNeighborResolver(cfg, subnet, iface.Index)
+	r.ctx, r.cancel = context.WithCancel(ctx)
+
+	updates := make(chan netlink.NeighUpdate, 64)
+	if subscribeErr := netlink.NeighSubscribeWithOptions(updates, r.ctx.Done(), netlink.NeighSubscribeOptions{
+		ListExisting: true,
+		ErrorCallback: func(err error) {
+			slog.Debug("Neighbor subscription error", "interface", ifcName, "error", err)
+		},
+	}); subscribeErr != nil {
+		r.cancel()
+		slog.Warn("Neighbor tracking unavailable, falling back to ARP scanning", "interface", ifcName, "error", subscribeErr)
+		return NewResolver(ctx, opts...)
+	}
+
+	go r.run(updates)
+	go r.probeLoop()
+
+	return r, nil
+}
+
+func newNeighborResolver(cfg *ScannerConfig, subnet *net.IPNet, linkIndex int) *NeighborResolver {
+	return &NeighborResolver{
+		cfg:        cfg,
+		subnet:     subnet,
+		linkIndex:  linkIndex,
+		ctx:        context.Background(),
+		cancel:     func() {},
+		probeCh:    make(chan string, 16),
+		ndpProbe:   touchNeighbor,
+		hosts:      make(map[string]net.IP),
+		hosts6:     make(map[string][]net.IP),
+		hints:      make(map[string]net.IP),
+		probes:     make(map[string]probeState),
+		lastProbe6: make(map[string]time.Time),
+	}
+}
+
+// run applies neighbor updates until the context is done. If the kernel
+// closes the subscription, it switches to periodic scanning.
+func (r *NeighborResolver) run(updates <-chan netlink.NeighUpdate) {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				if r.ctx.Err() != nil {
+					return
+				}
+				slog.Warn("Neighbor subscription closed, falling back to ARP scanning")
+				r.startFallback()
+				return
+			}
+			r.handleUpdate(update)
+		}
+	}
+}
+
+func (r *NeighborResolver) startFallback() {
+	fallback, err := NewResolver(r.ctx, WithScanner(r.cfg.Scanner),
+		WithInterval(r.cfg.Interval), WithTimeout(r.cfg.Timeout))
+	if err != nil {
+		slog.Error("Failed to start fallback ARP resolver", "error", err)
+		return
+	}
+	r.mu.Lock()
+	r.fallback = fallback
+	r.mu.Unlock()
+}
+
+// handleUpdate applies a single netlink neighbor update.
+func (r *NeighborResolver) handleUpdate(update netlink.NeighUpdate) {
+	if update.LinkIndex != r.linkIndex {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unusable := update.State&(netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0
+	gone := update.Type == syscall.RTM_DELNEIGH || unusable || len(update.HardwareAddr) == 0
+
+	addr := update.IP.To4()
+	if addr == nil {
+		if r.cfg.Prefix6 != nil && r.cfg.Prefix6.Contains(update.IP) {
+			r.handleUpdate6(update, gone)
+		}
+		return
+	}
+	if !r.subnet.Contains(addr) {
+		return
+	}
+
+	if gone {
+		r.forgetIP(addr)
+		return
+	}
+
+	mac := update.HardwareAddr.String()
+	// The address may have moved to a different VM.
+	r.forgetIP(addr)
+	r.hosts[mac] = addr
+	r.hints[mac] = addr
+	delete(r.probes, mac)
+	slog.Debug("Neighbor updated", "mac", mac, "ip", addr)
+}
+
+// handleUpdate6 applies an IPv6 neighbor update. Callers must hold r.mu.
+func (r *NeighborResolver) handleUpdate6(update netlink.NeighUpdate, gone bool) {
+	r.forgetIP6(update.IP)
+	if gone {
+		return
+	}
+	mac := update.HardwareAddr.String()
+	r.hosts6[mac] = append(r.hosts6[mac], update.IP)
+	slog.Debug("IPv6 neighbor updated", "mac", mac, "ip", update.IP)
+}
+
+// forgetIP6 drops addr from whichever MAC owns it. Callers must hold r.mu.
+func (r *NeighborResolver) forgetIP6(addr net.IP) {
+	for mac, addrs := range r.hosts6 {
+		kept := slices.DeleteFunc(addrs, func(known net.IP) bool { return known.Equal(addr) })
+		if len(kept) == 0 {
+			delete(r.hosts6, mac)
+		} else {
+			r.hosts6[mac] = kept
+		}
+	}
+}
+
+// forgetIP drops the entry owning addr. Callers must hold r.mu.
+func (r *NeighborResolver) forgetIP(addr net.IP) {
+	for mac, known := range r.hosts {
+		if known.Equal(addr) {
+			delete(r.hosts, mac)
+		}
+	}
+}
+
+// requestProbe schedules a targeted probe for mac without blocking.
+func (r *NeighborResolver) requestProbe(mac string) {
+	select {
+	case r.probeCh <- mac:
+	default:
+	}
+}
+
+func (r *NeighborResolver) probeLoop() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case mac := <-r.probeCh:
+			r.probe(mac)
+		}
+	}
+}
+
+// HintIP records addr as where mac is likely to be found, e.g. from its
+// DHCP lease. It's probed when mac is looked up without a neighbor entry.
+func (r *NeighborResolver) HintIP(mac string, addr net.IP) {
+	hw, macErr := net.ParseMAC(mac)
+	addr = addr.To4()
+	if macErr != nil || addr == nil || !r.subnet.Contains(addr) {
+		return
+	}
+	key := hw.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if known, ok := r.hints[key]; ok && known.Equal(addr) {
+		return
+	}
+	r.hints[key] = addr
+	// A new address is worth probing straight away.
+	delete(r.probes, key)
+}
+
+// probe sends an ARP request for an unknown MAC to its last seen or hinted
+// address. MACs without one aren't probed: sweeping the subnet for them
+// would flood it with broadcasts. Each unanswered probe doubles the wait
+// before the next, from Interval up to maxProbeBackoff.
+func (r *NeighborResolver) probe(mac string) {
+	prober, ok := r.cfg.Scanner.(Prober)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	state := r.probes[mac]
+	target, hinted := r.hints[mac]
+	now := time.Now()
+	if !hinted || now.Before(state.next) {
+		r.mu.Unlock()
+		return
+	}
+	state.backoff = max(min(2*state.backoff, maxProbeBackoff), r.cfg.Interval)
+	state.next = now.Add(state.backoff)
+	r.probes[mac] = state
+	r.mu.Unlock()
+
+	found, err := prober.Probe([]net.IP{target}, r.cfg.Timeout)
+	if err != nil {
+		slog.Debug("Targeted ARP probe failed", "mac", mac, "ip", target, "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for foundMAC, addr := range found {
+		// A known MAC answering from another address has moved there.
+		if known, ok := r.hosts[foundMAC]; ok && known.Equal(addr) {
+			continue
+		}
+		r.forgetIP(addr)
+		r.hosts[foundMAC] = addr
+		r.hints[foundMAC] = addr
+		delete(r.probes, foundMAC)
+	}
+}
+
+func (r *NeighborResolver) Close() {
+	r.cancel()
+	r.mu.RLock()
+	fallback := r.fallback
+	r.mu.RUnlock()
+	if fallback != nil {
+		fallback.Close()
+	}
+}
+
+// LookupIP returns the IP address associated with the given MAC address.
+// A miss schedules a targeted probe, so a later lookup may succeed.
+func (r *NeighborResolver) LookupIP(mac string) (net.IP, error) {
+	macAddr, macErr := net.ParseMAC(mac)
+	if macErr != nil {
+		return nil, macErr
+	}
+
+	r.mu.RLock()
+	fallback := r.fallback
+	val, exists := r.hosts[macAddr.String()]
+	r.mu.RUnlock()
+
+	if fallback != nil {
+		return fallback.LookupIP(mac)
+	}
+	if exists {
+		return val, nil
+	}
+
+	r.requestProbe(macAddr.String())
+	return nil, fmt.Errorf("MAC address %s not found", mac)
+}
+
+// LookupIPs returns the IPv4 address followed by the IPv6 addresses known
+// for the MAC. Missing address families are probed in the background.
+func (r *NeighborResolver) LookupIPs(mac string) ([]net.IP, error) {
+	macAddr, macErr := net.ParseMAC(mac)
+	if macErr != nil {
+		return nil, macErr
+	}
+	key := macAddr.String()
+
+	r.mu.RLock()
+	fallback := r.fallback
+	var addrs []net.IP
+	if addr, ok := r.hosts[key]; ok {
+		addrs = append(addrs, addr)
+	}
+	has6 := len(r.hosts6[key]) > 0
+	addrs = append(addrs, r.hosts6[key]...)
+	r.mu.RUnlock()
+
+	if fallback != nil {
+		return fallback.LookupIPs(mac)
+	}
+	if len(addrs) == 0 || addrs[0].To4() == nil {
+		r.requestProbe(key)
+	}
+	if !has6 {
+		r.probe6(macAddr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("MAC address %s not found", mac)
+	}
+	return addrs, nil
+}
+
+// probe6 triggers NDP for the EUI-64 address of mac, at most once per
+// Interval.
+func (r *NeighborResolver) probe6(mac net.HardwareAddr) {
+	if r.cfg.Prefix6 == nil {
+		return
+	}
+	addr, err := ip.EUI64(r.cfg.Prefix6, mac)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	if last, ok := r.lastProbe6[mac.String()]; ok && time.Since(last) < r.cfg.Interval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastProbe6[mac.String()] = time.Now()
+	r.mu.Unlock()
+
+	if probeErr := r.ndpProbe(addr); probeErr != nil {
+		slog.Debug("NDP probe failed", "mac", mac, "ip", addr, "error", probeErr)
+	}
+}
+
+// touchNeighbor sends an empty datagram to addr. The kernel resolves the
+// address via NDP first; the outcome arrives as a netlink neighbor update.
+func touchNeighbor(addr net.IP) error {
+	conn, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: addr, Port: 9})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write(nil)
+	return err
+}
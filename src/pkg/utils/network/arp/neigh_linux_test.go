@@ -0,0 +1,254 @@
+//go:build linux
+
+package arp
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+type mockProber struct {
+	mockScanner
+	probeMu sync.Mutex
+	probed  [][]net.IP
+}
+
+func (m *mockProber) Probe(targets []net.IP, timeout time.Duration) (map[string]net.IP, error) {
+	m.probeMu.Lock()
+	m.probed = append(m.probed, targets)
+	m.probeMu.Unlock()
+	return m.Scan(timeout)
+}
+
+func (m *mockProber) getProbed() [][]net.IP {
+	m.probeMu.Lock()
+	defer m.probeMu.Unlock()
+	return m.probed
+}
+
+func newTestNeighborResolver(t *testing.T, scanner Scanner) *NeighborResolver {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/29")
+	require.NoError(t, err)
+	return newNeighborResolver(&ScannerConfig{
+		Scanner:  scanner,
+		Interval: time.Hour,
+		Timeout:  10 * time.Millisecond,
+	}, subnet, 7)
+}
+
+func neighUpdate(msgType uint16, state int, mac, addr string) netlink.NeighUpdate {
+	hw, _ := net.ParseMAC(mac)
+	return netlink.NeighUpdate{
+		Type: msgType,
+		Neigh: netlink.Neigh{
+			LinkIndex:    7,
+			State:        state,
+			IP:           net.ParseIP(addr),
+			HardwareAddr: hw,
+		},
+	}
+}
+
+func TestNeighborResolver_NewNeigh(t *testing.T) {
+	r := newTestNeighborResolver(t, &mockScanner{})
+
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "00:11:22:33:44:5A", "192.168.1.2"))
+
+	ip, err := r.LookupIP("00:11:22:33:44:5a")
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.ParseIP("192.168.1.2")), "expected 192.168.1.2, got %s", ip)
+}
+
+func TestNeighborResolver_IgnoresOtherLinksAndSubnets(t *testing.T) {
+	r := newTestNeighborResolver(t, &mockScanner{})
+
+	other := neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "00:11:22:33:44:55", "192.168.1.2")
+	other.LinkIndex = 8
+	r.handleUpdate(other)
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "00:11:22:33:44:66", "10.0.0.2"))
+
+	assert.Empty(t, r.hosts)
+}
+
+func TestNeighborResolver_DelNeigh(t *testing.T) {
+	r := newTestNeighborResolver(t, &mockScanner{})
+
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_STALE, "00:11:22:33:44:55", "192.168.1.2"))
+	r.handleUpdate(neighUpdate(syscall.RTM_DELNEIGH, netlink.NUD_STALE, "00:11:22:33:44:55", "192.168.1.2"))
+
+	_, err := r.LookupIP("00:11:22:33:44:55")
+	assert.Error(t, err)
+	assert.True(t, r.hints["00:11:22:33:44:55"].Equal(net.ParseIP("192.168.1.2")))
+}
+
+func TestNeighborResolver_FailedEntryDropsAddress(t *testing.T) {
+	r := newTestNeighborResolver(t, &mockScanner{})
+
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "00:11:22:33:44:55", "192.168.1.2"))
+	failed := neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_FAILED, "00:11:22:33:44:55", "192.168.1.2")
+	failed.HardwareAddr = nil
+	r.handleUpdate(failed)
+
+	assert.Empty(t, r.hosts)
+}
+
+func TestNeighborResolver_AddressMovesToNewMAC(t *testing.T) {
+	r := newTestNeighborResolver(t, &mockScanner{})
+
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "00:11:22:33:44:55", "192.168.1.2"))
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "aa:bb:cc:dd:ee:ff", "192.168.1.2"))
+
+	_, err := r.LookupIP("00:11:22:33:44:55")
+	assert.Error(t, err)
+	ip, err := r.LookupIP("aa:bb:cc:dd:ee:ff")
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.ParseIP("192.168.1.2")))
+}
+
+func TestNeighborResolver_ProbesHintedAddress(t *testing.T) {
+	prober := &mockProber{mockScanner: mockScanner{results: map[string]net.IP{
+		"aa:bb:cc:dd:ee:ff": net.ParseIP("192.168.1.4"),
+	}}}
+	r := newTestNeighborResolver(t, prober)
+	r.HintIP("AA:BB:CC:DD:EE:FF", net.ParseIP("192.168.1.4"))
+	r.HintIP("00:11:22:33:44:55", net.ParseIP("10.0.0.4"))
+
+	r.probe("aa:bb:cc:dd:ee:ff")
+	r.probe("00:11:22:33:44:55")
+
+	probed := prober.getProbed()
+	require.Len(t, probed, 1, "hints outside the subnet are ignored")
+	require.Len(t, probed[0], 1)
+	assert.True(t, probed[0][0].Equal(net.ParseIP("192.168.1.4")))
+
+	ip, err := r.LookupIP("aa:bb:cc:dd:ee:ff")
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.ParseIP("192.168.1.4")))
+}
+
+func TestNeighborResolver_ProbesLastSeenAddress(t *testing.T) {
+	prober := &mockProber{}
+	r := newTestNeighborResolver(t, prober)
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_STALE, "00:11:22:33:44:55", "192.168.1.3"))
+	r.handleUpdate(neighUpdate(syscall.RTM_DELNEIGH, netlink.NUD_STALE, "00:11:22:33:44:55", "192.168.1.3"))
+
+	r.probe("00:11:22:33:44:55")
+
+	probed := prober.getProbed()
+	require.Len(t, probed, 1)
+	require.Len(t, probed[0], 1)
+	assert.True(t, probed[0][0].Equal(net.ParseIP("192.168.1.3")))
+}
+
+func TestNeighborResolver_ProbeReplyMovesKnownMAC(t *testing.T) {
+	prober := &mockProber{mockScanner: mockScanner{results: map[string]net.IP{
+		"00:11:22:33:44:55": net.ParseIP("192.168.1.4"),
+	}}}
+	r := newTestNeighborResolver(t, prober)
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_STALE, "00:11:22:33:44:55", "192.168.1.2"))
+	r.HintIP("aa:bb:cc:dd:ee:ff", net.ParseIP("192.168.1.4"))
+
+	r.probe("aa:bb:cc:dd:ee:ff")
+
+	ip, err := r.LookupIP("00:11:22:33:44:55")
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.ParseIP("192.168.1.4")), "expected 192.168.1.4, got %s", ip)
+	assert.True(t, r.hints["00:11:22:33:44:55"].Equal(net.ParseIP("192.168.1.4")))
+}
+
+func TestNeighborResolver_NeverSweepsSubnet(t *testing.T) {
+	prober := &mockProber{}
+	r := newTestNeighborResolver(t, prober)
+
+	r.probe("00:11:22:33:44:55")
+
+	assert.Empty(t, prober.getProbed(), "a MAC never seen nor hinted isn't probed")
+}
+
+func TestNeighborResolver_BacksOffWhenSilent(t *testing.T) {
+	prober := &mockProber{}
+	r := newTestNeighborResolver(t, prober)
+	r.cfg.Interval = time.Second
+	mac := "00:11:22:33:44:55"
+	r.HintIP(mac, net.ParseIP("192.168.1.3"))
+
+	r.probe(mac)
+	r.probe(mac)
+	assert.Len(t, prober.getProbed(), 1, "rate limited")
+	assert.Equal(t, r.cfg.Interval, r.probes[mac].backoff)
+
+	for range 20 {
+		r.probes[mac] = probeState{backoff: r.probes[mac].backoff}
+		r.probe(mac)
+	}
+	assert.Len(t, prober.getProbed(), 21)
+	assert.Equal(t, maxProbeBackoff, r.probes[mac].backoff)
+	assert.True(t, r.hints[mac].Equal(net.ParseIP("192.168.1.3")), "the hint is kept")
+
+	// A new hint is probed straight away.
+	r.HintIP(mac, net.ParseIP("192.168.1.5"))
+	r.probe(mac)
+	probed := prober.getProbed()
+	require.Len(t, probed, 22)
+	assert.True(t, probed[21][0].Equal(net.ParseIP("192.168.1.5")))
+}
+
+func newTestDualStackResolver(t *testing.T) (*NeighborResolver, *[]net.IP) {
+	r := newTestNeighborResolver(t, &mockScanner{})
+	_, prefix, err := net.ParseCIDR("fd00:42::/64")
+	require.NoError(t, err)
+	r.cfg.Prefix6 = prefix
+
+	var probed []net.IP
+	r.ndpProbe = func(addr net.IP) error {
+		probed = append(probed, addr)
+		return nil
+	}
+	return r, &probed
+}
+
+func TestNeighborResolver_DualStackLookup(t *testing.T) {
+	r, _ := newTestDualStackResolver(t)
+
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "52:54:00:12:34:56", "192.168.1.2"))
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "52:54:00:12:34:56", "fd00:42::5054:ff:fe12:3456"))
+	// Link-local and other prefixes are ignored.
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "52:54:00:12:34:56", "fe80::5054:ff:fe12:3456"))
+
+	addrs, err := r.LookupIPs("52:54:00:12:34:56")
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+	assert.True(t, addrs[0].Equal(net.ParseIP("192.168.1.2")))
+	assert.True(t, addrs[1].Equal(net.ParseIP("fd00:42::5054:ff:fe12:3456")))
+}
+
+func TestNeighborResolver_IPv6Removal(t *testing.T) {
+	r, _ := newTestDualStackResolver(t)
+
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "52:54:00:12:34:56", "fd00:42::10"))
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "52:54:00:12:34:56", "fd00:42::11"))
+	r.handleUpdate(neighUpdate(syscall.RTM_DELNEIGH, netlink.NUD_STALE, "52:54:00:12:34:56", "fd00:42::10"))
+
+	require.Len(t, r.hosts6["52:54:00:12:34:56"], 1)
+	assert.True(t, r.hosts6["52:54:00:12:34:56"][0].Equal(net.ParseIP("fd00:42::11")))
+}
+
+func TestNeighborResolver_ProbesEUI64Address(t *testing.T) {
+	r, probed := newTestDualStackResolver(t)
+	r.handleUpdate(neighUpdate(syscall.RTM_NEWNEIGH, netlink.NUD_REACHABLE, "52:54:00:12:34:56", "192.168.1.2"))
+
+	addrs, err := r.LookupIPs("52:54:00:12:34:56")
+	require.NoError(t, err)
+	assert.Len(t, addrs, 1)
+	_, _ = r.LookupIPs("52:54:00:12:34:56")
+
+	require.Len(t, *probed, 1, "NDP probe is rate limited")
+	assert.True(t, (*probed)[0].Equal(net.ParseIP("fd00:42::5054:ff:fe12:3456")))
+}
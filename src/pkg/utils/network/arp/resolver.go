@@ -104,3 +104,13 @@ func (r *Resolver) LookupIP(mac string) (net.IP, error) {
 	}
 	return nil, fmt.Errorf("MAC address %s not found", mac)
 }
+
+// LookupIPs returns the single IPv4 address known for the MAC; ARP scanning
+// doesn't discover IPv6 addresses.
+func (r *Resolver) LookupIPs(mac string) ([]net.IP, error) {
+	addr, err := r.LookupIP(mac)
+	if err != nil {
+		return nil, err
+	}
+	return []net.IP{addr}, nil
+}
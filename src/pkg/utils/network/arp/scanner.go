@@ -3,6 +3,7 @@ package arp
 import (
 	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
 	"net"
 	"syscall"
@@ -43,6 +44,25 @@ func (s *scannerImpl) resolveInterface() (*net.Interface, error) {
 // MAC addresses to IP addresses. The timeout parameter controls how long
 // to wait for ARP responses after sending all requests.
 func (s *scannerImpl) Scan(timeout time.Duration) (map[string]net.IP, error) {
+	return s.probe(ip.SubnetHosts(s.subnet), timeout)
+}
+
+// Probe sends ARP requests to the given addresses only. Addresses outside
+// the configured subnet are skipped.
+func (s *scannerImpl) Probe(targets []net.IP, timeout time.Duration) (map[string]net.IP, error) {
+	inSubnet := func(yield func(net.IP) bool) {
+		for _, target := range targets {
+			if target4 := target.To4(); target4 != nil && s.subnet.Contains(target4) {
+				if !yield(target4) {
+					return
+				}
+			}
+		}
+	}
+	return s.probe(inSubnet, timeout)
+}
+
+func (s *scannerImpl) probe(targets iter.Seq[net.IP], timeout time.Duration) (map[string]net.IP, error) {
 	// Dynamically resolve interface on each scan
 	iface, ifaceErr := s.resolveInterface()
 	if ifaceErr != nil {
@@ -86,7 +106,7 @@ func (s *scannerImpl) Scan(timeout time.Duration) (map[string]net.IP, error) {
 	// Send all requests quickly but read replies in between batches
 	batchSize := 10
 	currentBatch := 0
-	for targetIP := range ip.SubnetHosts(s.subnet) {
+	for targetIP := range targets {
 		frame := buildARPRequest(srcMAC, srcIP, targetIP)
 		if err := conn.Send(frame); err != nil {
 			slog.Debug("Failed to send ARP request", "target", targetIP, "error", err)
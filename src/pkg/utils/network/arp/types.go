@@ -12,10 +12,19 @@ type Scanner interface {
 	Scan(timeout time.Duration) (map[string]net.IP, error)
 }
 
+// Prober sends ARP requests to specific addresses instead of the whole subnet.
+type Prober interface {
+	// Probe returns a map of MAC addresses to IP addresses for the targets
+	// that answered within timeout.
+	Probe(targets []net.IP, timeout time.Duration) (map[string]net.IP, error)
+}
+
 type ScannerConfig struct {
 	Scanner  Scanner
 	Interval time.Duration
 	Timeout  time.Duration
+	// Prefix6 enables IPv6 neighbor tracking for resolvers that support it.
+	Prefix6 *net.IPNet
 }
 
 type ScannerOption func(*ScannerConfig)
@@ -32,6 +41,12 @@ func WithTimeout(d time.Duration) ScannerOption {
 	}
 }
 
+func WithIPv6Prefix(prefix *net.IPNet) ScannerOption {
+	return func(s *ScannerConfig) {
+		s.Prefix6 = prefix
+	}
+}
+
 func WithScanner(scanner Scanner) ScannerOption {
 	return func(s *ScannerConfig) {
 		s.Scanner = scanner
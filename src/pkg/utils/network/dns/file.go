@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// RecordsFile returns the path of the records file under the qemu service
+// root. The qemu gRPC service (inside the network namespace) writes it and
+// the DNS front-end (in the host namespace) watches it.
+func RecordsFile(root string) string {
+	return filepath.Join(root, "dns", "records.json")
+}
+
+// WriteRecordsFile atomically replaces the records file at path.
+func WriteRecordsFile(path string, records []Record) error {
+	if records == nil {
+		records = []Record{}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadRecordsFile reads the records file at path. A missing file yields no
+// records and no error.
+func ReadRecordsFile(path string) ([]Record, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// WatchRecordsFile loads path into table and keeps it in sync until ctx is
+// done. The parent directory is watched (not the file) because
+// WriteRecordsFile replaces the file via rename.
+func WatchRecordsFile(ctx context.Context, path string, table *Table) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	watcher, watcherErr := fsnotify.NewWatcher()
+	if watcherErr != nil {
+		return watcherErr
+	}
+	if addErr := watcher.Add(dir); addErr != nil {
+		_ = watcher.Close()
+		return addErr
+	}
+
+	reload := func() {
+		records, err := ReadRecordsFile(path)
+		if err != nil {
+			slog.Warn("Failed to read DNS records file", "path", path, "error", err)
+			return
+		}
+		table.Replace(records)
+		slog.Info("Loaded DNS records", "path", path, "names", table.Len())
+	}
+	reload()
+
+	go func() {
+		defer func() { _ = watcher.Close() }()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) {
+					continue
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) != 0 {
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("DNS records watcher error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
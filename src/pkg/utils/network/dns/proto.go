@@ -0,0 +1,33 @@
+package dns
+
+import (
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+)
+
+var protoTypes = map[settingsv1.DnsRecord_Type]Type{
+	settingsv1.DnsRecord_TYPE_A:     TypeA,
+	settingsv1.DnsRecord_TYPE_AAAA:  TypeAAAA,
+	settingsv1.DnsRecord_TYPE_CNAME: TypeCNAME,
+}
+
+// FromProto converts an API record. An unspecified type maps to an empty
+// Type, which Validate rejects.
+func FromProto(rec *settingsv1.DnsRecord) Record {
+	return Record{
+		Name:  rec.GetName(),
+		Type:  protoTypes[rec.GetType()],
+		Value: rec.GetValue(),
+		TTL:   rec.GetTtl(),
+	}
+}
+
+// ToProto converts rec into its API representation.
+func ToProto(rec Record) *settingsv1.DnsRecord {
+	out := &settingsv1.DnsRecord{Name: rec.Name, Value: rec.Value, Ttl: rec.TTL}
+	for pt, t := range protoTypes {
+		if t == rec.Type {
+			out.Type = pt
+		}
+	}
+	return out
+}
@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Type is the kind of a user-defined DNS record.
+type Type string
+
+const (
+	TypeA     Type = "A"
+	TypeAAAA  Type = "AAAA"
+	TypeCNAME Type = "CNAME"
+)
+
+// DefaultTTL is used for records that don't specify a TTL.
+const DefaultTTL uint32 = 60
+
+// Record is a single user-defined DNS record served by the node's DNS
+// front-end. Name and, for CNAMEs, Value are fully-qualified (trailing dot).
+type Record struct {
+	Name  string `json:"name"`
+	Type  Type   `json:"type"`
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl,omitempty"`
+}
+
+// Fqdn lowercases name and makes sure it ends with a dot.
+func Fqdn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// InZone reports whether name is equal to or below zone. The root zone "."
+// (or an empty zone) contains every name.
+func InZone(name, zone string) bool {
+	zone = Fqdn(zone)
+	if zone == "." {
+		return true
+	}
+	name = Fqdn(name)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// Normalize returns a copy of rec with the name (and CNAME target)
+// fully-qualified and the TTL defaulted.
+func Normalize(rec Record) Record {
+	rec.Name = Fqdn(rec.Name)
+	rec.Type = Type(strings.ToUpper(string(rec.Type)))
+	if rec.Type == TypeCNAME {
+		rec.Value = Fqdn(rec.Value)
+	} else {
+		rec.Value = strings.TrimSpace(rec.Value)
+	}
+	if rec.TTL == 0 {
+		rec.TTL = DefaultTTL
+	}
+	return rec
+}
+
+// Validate checks a normalized record against zone: the name must be a valid
+// hostname inside the zone and the value must match the record type.
+func Validate(rec Record, zone string) error {
+	if rec.Name == "" || rec.Name == "." {
+		return errors.New("record name is required")
+	}
+	if err := ValidateHostname(rec.Name); err != nil {
+		return fmt.Errorf("invalid record name %q: %w", rec.Name, err)
+	}
+	if !InZone(rec.Name, zone) {
+		return fmt.Errorf("record name %q is outside of zone %q", rec.Name, Fqdn(zone))
+	}
+
+	switch rec.Type {
+	case TypeA:
+		if ip := net.ParseIP(rec.Value); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("A record value %q is not an IPv4 address", rec.Value)
+		}
+	case TypeAAAA:
+		if ip := net.ParseIP(rec.Value); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("AAAA record value %q is not an IPv6 address", rec.Value)
+		}
+	case TypeCNAME:
+		if err := ValidateHostname(rec.Value); err != nil {
+			return fmt.Errorf("invalid CNAME target %q: %w", rec.Value, err)
+		}
+		if rec.Value == rec.Name {
+			return errors.New("CNAME record cannot point to itself")
+		}
+	default:
+		return fmt.Errorf("unsupported record type %q", rec.Type)
+	}
+	return nil
+}
+
+// ValidateHostname checks a lowercase name, fully-qualified or not, against
+// RFC 1123 label rules. Underscores are allowed so SRV-style service labels
+// work.
+func ValidateHostname(name string) error {
+	trimmed := strings.TrimSuffix(name, ".")
+	if len(trimmed) > 253 {
+		return errors.New("name longer than 253 characters")
+	}
+	for _, label := range strings.Split(trimmed, ".") {
+		if label == "" {
+			return errors.New("empty label")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q longer than 63 characters", label)
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("label %q contains invalid character %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Table is a concurrency-safe set of records indexed by name. A name holds
+// either a single CNAME or any number of A/AAAA records.
+type Table struct {
+	mu      sync.RWMutex
+	records map[string][]Record
+}
+
+func NewTable() *Table {
+	return &Table{records: make(map[string][]Record)}
+}
+
+// Replace swaps the full contents of the table.
+func (t *Table) Replace(records []Record) {
+	next := make(map[string][]Record, len(records))
+	for _, rec := range records {
+		rec = Normalize(rec)
+		next[rec.Name] = append(next[rec.Name], rec)
+	}
+	t.mu.Lock()
+	t.records = next
+	t.mu.Unlock()
+}
+
+// Lookup returns all records for name (of any type).
+func (t *Table) Lookup(name string) []Record {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	recs := t.records[Fqdn(name)]
+	out := make([]Record, len(recs))
+	copy(out, recs)
+	return out
+}
+
+// Len returns the number of distinct names in the table.
+func (t *Table) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.records)
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInZone(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want bool
+	}{
+		{"db.test.zone", "test.zone", true},
+		{"db.test.zone.", "test.zone.", true},
+		{"test.zone", "test.zone", true},
+		{"DB.Test.Zone", "test.zone", true},
+		{"db.other.zone", "test.zone", false},
+		{"dbtest.zone", "test.zone", false},
+		{"anything.example", ".", true},
+		{"anything.example", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"_"+tt.zone, func(t *testing.T) {
+			assert.Equal(t, tt.want, InZone(tt.name, tt.zone))
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	rec := Normalize(Record{Name: " DB.Test.Zone ", Type: "cname", Value: "VM1.test.zone"})
+	assert.Equal(t, "db.test.zone.", rec.Name)
+	assert.Equal(t, TypeCNAME, rec.Type)
+	assert.Equal(t, "vm1.test.zone.", rec.Value)
+	assert.Equal(t, DefaultTTL, rec.TTL)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		desc    string
+		rec     Record
+		wantErr bool
+	}{
+		{"valid A", Record{Name: "db.test.zone", Type: TypeA, Value: "10.0.0.5"}, false},
+		{"valid AAAA", Record{Name: "db.test.zone", Type: TypeAAAA, Value: "fd00::5"}, false},
+		{"valid CNAME", Record{Name: "db.test.zone", Type: TypeCNAME, Value: "vm1.test.zone"}, false},
+		{"service label", Record{Name: "_pg._tcp.test.zone", Type: TypeCNAME, Value: "vm1.test.zone"}, false},
+		{"outside zone", Record{Name: "db.other.zone", Type: TypeA, Value: "10.0.0.5"}, true},
+		{"A with IPv6", Record{Name: "db.test.zone", Type: TypeA, Value: "fd00::5"}, true},
+		{"AAAA with IPv4", Record{Name: "db.test.zone", Type: TypeAAAA, Value: "10.0.0.5"}, true},
+		{"bad A value", Record{Name: "db.test.zone", Type: TypeA, Value: "not-an-ip"}, true},
+		{"self CNAME", Record{Name: "db.test.zone", Type: TypeCNAME, Value: "db.test.zone"}, true},
+		{"bad label", Record{Name: "-db.test.zone", Type: TypeA, Value: "10.0.0.5"}, true},
+		{"invalid char", Record{Name: "d!b.test.zone", Type: TypeA, Value: "10.0.0.5"}, true},
+		{"unknown type", Record{Name: "db.test.zone", Type: "MX", Value: "10 mail"}, true},
+		{"empty name", Record{Name: "", Type: TypeA, Value: "10.0.0.5"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := Validate(Normalize(tt.rec), "test.zone")
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTable_ReplaceAndLookup(t *testing.T) {
+	table := NewTable()
+	table.Replace([]Record{
+		{Name: "db.test.zone", Type: TypeA, Value: "10.0.0.5"},
+		{Name: "db.test.zone", Type: TypeA, Value: "10.0.0.6"},
+		{Name: "web.test.zone", Type: TypeCNAME, Value: "vm1.test.zone"},
+	})
+
+	assert.Equal(t, 2, table.Len())
+	assert.Len(t, table.Lookup("DB.test.zone"), 2)
+	assert.Len(t, table.Lookup("web.test.zone."), 1)
+	assert.Empty(t, table.Lookup("missing.test.zone"))
+
+	table.Replace(nil)
+	assert.Equal(t, 0, table.Len())
+}
+
+func TestRecordsFile_RoundTrip(t *testing.T) {
+	path := RecordsFile(t.TempDir())
+
+	missing, err := ReadRecordsFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	want := []Record{{Name: "db.test.zone.", Type: TypeA, Value: "10.0.0.5", TTL: 30}}
+	require.NoError(t, WriteRecordsFile(path, want))
+
+	got, err := ReadRecordsFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, "records.json", filepath.Base(path))
+}
@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// maxCNAMEChain bounds CNAME chasing inside the local table.
+const maxCNAMEChain = 8
+
+// Server answers queries for names in its Table and forwards everything
+// else to an upstream resolver (the node's failover forwarder). It sits in
+// front of the forwarder on the address handed out to VMs via DHCP.
+type Server struct {
+	table    *Table
+	upstream string
+	client   *mdns.Client
+	udp      *mdns.Server
+	tcp      *mdns.Server
+}
+
+// NewServer creates a server listening on addr (host:port) that forwards
+// unknown names to upstream (host:port).
+func NewServer(addr, upstream string, table *Table, timeout time.Duration) *Server {
+	s := &Server{
+		table:    table,
+		upstream: upstream,
+		client:   &mdns.Client{Timeout: timeout},
+	}
+	s.udp = &mdns.Server{Addr: addr, Net: "udp", Handler: s}
+	s.tcp = &mdns.Server{Addr: addr, Net: "tcp", Handler: s}
+	return s
+}
+
+// Serve starts the UDP and TCP listeners and returns a function that stops
+// them.
+func (s *Server) Serve() (func(), error) {
+	started := make(chan error, 2)
+	for _, srv := range []*mdns.Server{s.udp, s.tcp} {
+		srv.NotifyStartedFunc = func() { started <- nil }
+		go func(srv *mdns.Server) {
+			if err := srv.ListenAndServe(); err != nil {
+				started <- fmt.Errorf("dns %s listener on %s: %w", srv.Net, srv.Addr, err)
+			}
+		}(srv)
+	}
+	for range 2 {
+		if err := <-started; err != nil {
+			s.shutdown()
+			return nil, err
+		}
+	}
+	slog.Info("DNS front-end listening", "address", s.udp.Addr, "upstream", s.upstream)
+	return s.shutdown, nil
+}
+
+func (s *Server) shutdown() {
+	_ = s.udp.Shutdown()
+	_ = s.tcp.Shutdown()
+}
+
+// ServeDNS implements mdns.Handler.
+func (s *Server) ServeDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	resp := s.resolve(req, w.RemoteAddr())
+	if err := w.WriteMsg(resp); err != nil {
+		slog.Debug("Failed to write DNS response", "error", err)
+	}
+}
+
+func (s *Server) resolve(req *mdns.Msg, remote net.Addr) *mdns.Msg {
+	if len(req.Question) != 1 {
+		return s.forward(req, remote)
+	}
+	q := req.Question[0]
+	if q.Qclass != mdns.ClassINET {
+		return s.forward(req, remote)
+	}
+
+	answers, target, found := s.answerLocal(q.Name, q.Qtype)
+	if !found {
+		return s.forward(req, remote)
+	}
+
+	resp := new(mdns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+	resp.Answer = answers
+
+	// The chain ended in a CNAME whose target isn't local: let the
+	// upstream resolve the rest.
+	if target != "" {
+		sub := new(mdns.Msg)
+		sub.SetQuestion(target, q.Qtype)
+		sub.RecursionDesired = true
+		if upstream := s.forward(sub, remote); upstream.Rcode == mdns.RcodeSuccess {
+			resp.Answer = append(resp.Answer, upstream.Answer...)
+		}
+	}
+	return resp
+}
+
+// answerLocal builds the answer section for name/qtype from the table,
+// chasing CNAMEs. found is false when name isn't in the table at all. When
+// the chain leaves the table, the unresolved target is returned so the
+// caller can forward it. A local name without records of qtype yields an
+// empty (NODATA) answer rather than leaking the query upstream.
+func (s *Server) answerLocal(name string, qtype uint16) (answers []mdns.RR, target string, found bool) {
+	current := name
+	for range maxCNAMEChain {
+		recs := s.table.Lookup(current)
+		if len(recs) == 0 {
+			if !found {
+				return nil, "", false
+			}
+			return answers, current, true
+		}
+		found = true
+
+		if recs[0].Type == TypeCNAME {
+			answers = append(answers, toRR(current, recs[0]))
+			if qtype == mdns.TypeCNAME {
+				return answers, "", true
+			}
+			current = recs[0].Value
+			continue
+		}
+
+		for _, rec := range recs {
+			if rr := toRR(current, rec); rr != nil && rr.Header().Rrtype == qtype {
+				answers = append(answers, rr)
+			}
+		}
+		return answers, "", true
+	}
+	return answers, "", found
+}
+
+func toRR(owner string, rec Record) mdns.RR {
+	hdr := mdns.RR_Header{Name: mdns.Fqdn(owner), Class: mdns.ClassINET, Ttl: rec.TTL}
+	switch rec.Type {
+	case TypeA:
+		hdr.Rrtype = mdns.TypeA
+		return &mdns.A{Hdr: hdr, A: net.ParseIP(rec.Value).To4()}
+	case TypeAAAA:
+		hdr.Rrtype = mdns.TypeAAAA
+		return &mdns.AAAA{Hdr: hdr, AAAA: net.ParseIP(rec.Value)}
+	case TypeCNAME:
+		hdr.Rrtype = mdns.TypeCNAME
+		return &mdns.CNAME{Hdr: hdr, Target: rec.Value}
+	}
+	return nil
+}
+
+func (s *Server) forward(req *mdns.Msg, remote net.Addr) *mdns.Msg {
+	client := s.client
+	if _, isTCP := remote.(*net.TCPAddr); isTCP {
+		client = &mdns.Client{Net: "tcp", Timeout: s.client.Timeout}
+	}
+	resp, _, err := client.Exchange(req, s.upstream)
+	if err == nil && resp.Truncated && client.Net != "tcp" {
+		tcp := &mdns.Client{Net: "tcp", Timeout: s.client.Timeout}
+		resp, _, err = tcp.Exchange(req, s.upstream)
+	}
+	if err != nil || resp == nil {
+		if err == nil {
+			err = errors.New("empty response")
+		}
+		slog.Debug("DNS upstream exchange failed", "upstream", s.upstream, "error", err)
+		fail := new(mdns.Msg)
+		fail.SetRcode(req, mdns.RcodeServerFailure)
+		return fail
+	}
+	return resp
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startUpstream runs a fake upstream resolver that answers every A query
+// with 192.0.2.1 and returns its address.
+func startUpstream(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &mdns.Server{
+		PacketConn: pc,
+		Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, req *mdns.Msg) {
+			resp := new(mdns.Msg)
+			resp.SetReply(req)
+			q := req.Question[0]
+			if q.Qtype == mdns.TypeA {
+				resp.Answer = append(resp.Answer, &mdns.A{
+					Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 10},
+					A:   net.ParseIP("192.0.2.1").To4(),
+				})
+			}
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func newTestServer(t *testing.T, records []Record) *Server {
+	table := NewTable()
+	table.Replace(records)
+	return NewServer("127.0.0.1:0", startUpstream(t), table, time.Second)
+}
+
+func query(s *Server, name string, qtype uint16) *mdns.Msg {
+	req := new(mdns.Msg)
+	req.SetQuestion(mdns.Fqdn(name), qtype)
+	return s.resolve(req, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+}
+
+func TestServer_AnswersLocalRecord(t *testing.T) {
+	s := newTestServer(t, []Record{{Name: "db.test.zone", Type: TypeA, Value: "10.0.0.5"}})
+
+	resp := query(s, "db.test.zone", mdns.TypeA)
+	require.Len(t, resp.Answer, 1)
+	assert.True(t, resp.Authoritative)
+	assert.Equal(t, "10.0.0.5", resp.Answer[0].(*mdns.A).A.String())
+}
+
+func TestServer_ForwardsUnknownName(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	resp := query(s, "example.com", mdns.TypeA)
+	require.Len(t, resp.Answer, 1)
+	assert.False(t, resp.Authoritative)
+	assert.Equal(t, "192.0.2.1", resp.Answer[0].(*mdns.A).A.String())
+}
+
+func TestServer_ChasesLocalCNAME(t *testing.T) {
+	s := newTestServer(t, []Record{
+		{Name: "db.test.zone", Type: TypeCNAME, Value: "vm1.test.zone"},
+		{Name: "vm1.test.zone", Type: TypeA, Value: "10.0.0.7"},
+	})
+
+	resp := query(s, "db.test.zone", mdns.TypeA)
+	require.Len(t, resp.Answer, 2)
+	assert.Equal(t, "vm1.test.zone.", resp.Answer[0].(*mdns.CNAME).Target)
+	assert.Equal(t, "10.0.0.7", resp.Answer[1].(*mdns.A).A.String())
+}
+
+func TestServer_ForwardsCNAMETargetOutsideTable(t *testing.T) {
+	s := newTestServer(t, []Record{{Name: "db.test.zone", Type: TypeCNAME, Value: "db.example.com"}})
+
+	resp := query(s, "db.test.zone", mdns.TypeA)
+	require.Len(t, resp.Answer, 2)
+	assert.Equal(t, "db.example.com.", resp.Answer[0].(*mdns.CNAME).Target)
+	assert.Equal(t, "192.0.2.1", resp.Answer[1].(*mdns.A).A.String())
+}
+
+func TestServer_NoDataForOtherType(t *testing.T) {
+	s := newTestServer(t, []Record{{Name: "db.test.zone", Type: TypeA, Value: "10.0.0.5"}})
+
+	resp := query(s, "db.test.zone", mdns.TypeAAAA)
+	assert.Equal(t, mdns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+	assert.True(t, resp.Authoritative)
+}
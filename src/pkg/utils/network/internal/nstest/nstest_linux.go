@@ -0,0 +1,47 @@
+// Package nstest runs network tests in their own network namespaces.
+package nstest
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netns"
+)
+
+// New creates an anonymous network namespace, skipping the test when that's
+// not permitted.
+func New(t *testing.T) netns.NsHandle {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, err := netns.Get()
+	require.NoError(t, err)
+	defer func() { _ = orig.Close() }()
+	defer func() { _ = netns.Set(orig) }()
+
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("cannot create network namespace: %v", err)
+	}
+	t.Cleanup(func() { _ = ns.Close() })
+	return ns
+}
+
+// Run runs f with the calling thread in ns.
+func Run(t *testing.T, ns netns.NsHandle, f func()) {
+	t.Helper()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, err := netns.Get()
+	require.NoError(t, err)
+	defer func() { _ = orig.Close() }()
+	require.NoError(t, netns.Set(ns))
+	defer func() { require.NoError(t, netns.Set(orig)) }()
+	f()
+}
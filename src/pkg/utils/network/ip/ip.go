@@ -1,21 +1,27 @@
 package ip
 
 import (
+	"errors"
+	"fmt"
 	"iter"
 	"net"
 )
 
 // SubnetHosts returns an iterator over all usable host IP addresses in the given subnet.
-// It excludes the network address (first IP) and broadcast address (last IP).
+// It excludes the network address (first IP) and, for IPv4, the broadcast
+// address (last IP). IPv6 has no broadcast; the first address is the
+// subnet-router anycast address and is skipped the same way.
 //
 // Note: For /31 networks (point-to-point links per RFC 3021), this returns 0 hosts
 // because both addresses are considered network/broadcast. If you need to support
-// /31 networks, handle them separately.
+// /31 networks, handle them separately. IPv6 subnets are usually /64, so callers
+// should stop early rather than exhaust the iterator.
 func SubnetHosts(subnet *net.IPNet) iter.Seq[net.IP] {
+	v4 := subnet.IP.To4() != nil
 	return func(yield func(net.IP) bool) {
 		start := subnet.IP.Mask(subnet.Mask)
 		for ip := nextIP(start); subnet.Contains(ip); ip = nextIP(ip) {
-			if isBroadcast(ip, subnet) {
+			if v4 && isBroadcast(ip, subnet) {
 				continue
 			}
 			if !yield(cloneIP(ip)) {
@@ -50,3 +56,28 @@ func isBroadcast(ip net.IP, subnet *net.IPNet) bool {
 	}
 	return ip.Equal(broadcast)
 }
+
+// EUI64 returns the SLAAC address a host with the given MAC configures in
+// prefix (RFC 4291, Appendix A). prefix must be an IPv6 /64.
+func EUI64(prefix *net.IPNet, mac net.HardwareAddr) (net.IP, error) {
+	if prefix.IP.To4() != nil || len(prefix.IP) != net.IPv6len {
+		return nil, fmt.Errorf("prefix %s is not IPv6", prefix)
+	}
+	if ones, _ := prefix.Mask.Size(); ones != 64 {
+		return nil, fmt.Errorf("prefix %s is not a /64", prefix)
+	}
+	if len(mac) != 6 {
+		return nil, errors.New("only 48-bit MAC addresses are supported")
+	}
+
+	addr := cloneIP(prefix.IP.Mask(prefix.Mask))
+	addr[8] = mac[0] ^ 0x02 // flip the universal/local bit
+	addr[9] = mac[1]
+	addr[10] = mac[2]
+	addr[11] = 0xff
+	addr[12] = 0xfe
+	addr[13] = mac[3]
+	addr[14] = mac[4]
+	addr[15] = mac[5]
+	return addr, nil
+}
@@ -123,3 +123,40 @@ func TestCloneIP(t *testing.T) {
 	// Original should be unchanged
 	assert.Equal(t, byte(1), original[3], "cloneIP did not create independent copy")
 }
+
+func TestSubnetHosts_IPv6KeepsLastAddress(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("fd00:42::/126")
+	require.NoError(t, err, "failed to parse CIDR")
+
+	var hosts []net.IP
+	for ip := range SubnetHosts(subnet) {
+		hosts = append(hosts, ip)
+	}
+
+	// No broadcast in IPv6: ::1, ::2 and ::3 are all usable.
+	require.Len(t, hosts, 3)
+	assert.True(t, hosts[2].Equal(net.ParseIP("fd00:42::3")), "last host should be fd00:42::3, got %s", hosts[2])
+}
+
+func TestEUI64(t *testing.T) {
+	_, prefix, err := net.ParseCIDR("fd00:42::/64")
+	require.NoError(t, err)
+	mac, err := net.ParseMAC("52:54:00:12:34:56")
+	require.NoError(t, err)
+
+	addr, err := EUI64(prefix, mac)
+	require.NoError(t, err)
+	assert.True(t, addr.Equal(net.ParseIP("fd00:42::5054:ff:fe12:3456")), "got %s", addr)
+}
+
+func TestEUI64_RejectsNon64Prefix(t *testing.T) {
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+
+	_, prefix48, _ := net.ParseCIDR("fd00:42::/48")
+	_, err := EUI64(prefix48, mac)
+	assert.Error(t, err)
+
+	_, prefix4, _ := net.ParseCIDR("192.168.1.0/24")
+	_, err = EUI64(prefix4, mac)
+	assert.Error(t, err)
+}
@@ -9,5 +9,15 @@ type AddressResolver interface {
 	// LookupIP returns the IP address associated with the given MAC address.
 	// Returns an error if the MAC address is not found or is invalid.
 	LookupIP(mac string) (net.IP, error)
+	// LookupIPs returns every known address (IPv4 and IPv6) for the MAC,
+	// IPv4 first. Returns an error if none is known or the MAC is invalid.
+	LookupIPs(mac string) ([]net.IP, error)
 	Close()
 }
+
+// AddressHinter is implemented by resolvers that probe for MACs they don't
+// know. HintIP tells them an address the MAC is likely to have, e.g. from
+// a DHCP lease, as they won't search the whole subnet for it.
+type AddressHinter interface {
+	HintIP(mac string, addr net.IP)
+}
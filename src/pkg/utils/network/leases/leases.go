@@ -0,0 +1,101 @@
+// Package leases reads the DHCP server's lease file. The server stores
+// leases in a SQLite database (table leases4), keyed by MAC and IP, with
+// the expiry as unix seconds.
+package leases
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+)
+
+// Lease is a single DHCP lease.
+type Lease struct {
+	MAC      string
+	IP       net.IP
+	Hostname string
+	Expiry   time.Time
+}
+
+// Expired reports whether the lease has expired at now.
+func (l Lease) Expired(now time.Time) bool {
+	return !l.Expiry.After(now)
+}
+
+// ReadFile returns every lease in the lease file at path, sorted by IP.
+// A missing file yields no leases and no error, since the DHCP server only
+// creates it once it starts.
+func ReadFile(path string) ([]Lease, error) {
+	if _, statErr := os.Stat(path); errors.Is(statErr, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	// Read-only so the DHCP server stays the only writer.
+	dsn := (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}).String()
+	db, openErr := sql.Open("sqlite3", dsn)
+	if openErr != nil {
+		return nil, fmt.Errorf("failed to open lease file %s: %w", path, openErr)
+	}
+	defer func() { _ = db.Close() }()
+
+	rows, queryErr := db.Query("SELECT mac, ip, expiry, hostname FROM leases4")
+	if queryErr != nil {
+		return nil, fmt.Errorf("failed to query leases: %w", queryErr)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var leases []Lease
+	for rows.Next() {
+		var (
+			mac, addr, hostname string
+			expiry              int64
+		)
+		if scanErr := rows.Scan(&mac, &addr, &expiry, &hostname); scanErr != nil {
+			return nil, fmt.Errorf("failed to read lease: %w", scanErr)
+		}
+		hw, macErr := net.ParseMAC(mac)
+		if macErr != nil {
+			continue
+		}
+		leaseIP := net.ParseIP(addr)
+		if leaseIP == nil {
+			continue
+		}
+		if v4 := leaseIP.To4(); v4 != nil {
+			leaseIP = v4
+		}
+		leases = append(leases, Lease{
+			MAC:      hw.String(),
+			IP:       leaseIP,
+			Hostname: hostname,
+			Expiry:   time.Unix(expiry, 0),
+		})
+	}
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, fmt.Errorf("failed to read leases: %w", rowsErr)
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return bytesLess(leases[i].IP, leases[j].IP)
+	})
+	return leases, nil
+}
+
+func bytesLess(a, b net.IP) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package leases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLeases creates a lease file with the DHCP server's schema.
+func writeLeases(t *testing.T, path string, leases ...Lease) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS leases4 (mac string not null, ip string not null, expiry int, hostname string not null, primary key (mac, ip))")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM leases4")
+	require.NoError(t, err)
+	for _, l := range leases {
+		_, err = db.Exec("INSERT INTO leases4 (mac, ip, expiry, hostname) VALUES (?, ?, ?, ?)",
+			l.MAC, l.IP.String(), l.Expiry.Unix(), l.Hostname)
+		require.NoError(t, err)
+	}
+}
+
+type mockResolver struct {
+	ips    map[string][]net.IP
+	closed bool
+
+	mu    sync.Mutex
+	hints map[string]net.IP
+}
+
+func (m *mockResolver) HintIP(mac string, addr net.IP) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hints == nil {
+		m.hints = make(map[string]net.IP)
+	}
+	m.hints[mac] = addr
+}
+
+func (m *mockResolver) hint(mac string) net.IP {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hints[mac]
+}
+
+func (m *mockResolver) LookupIP(mac string) (net.IP, error) {
+	ips, err := m.LookupIPs(mac)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+func (m *mockResolver) LookupIPs(mac string) ([]net.IP, error) {
+	if ips, ok := m.ips[mac]; ok {
+		return ips, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockResolver) Close() { m.closed = true }
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	expiry := time.Unix(1_900_000_000, 0)
+	writeLeases(t, path,
+		Lease{MAC: "52:54:00:00:00:02", IP: net.ParseIP("10.0.0.20"), Hostname: "vm2", Expiry: expiry},
+		Lease{MAC: "52:54:00:00:00:01", IP: net.ParseIP("10.0.0.10"), Hostname: "vm1", Expiry: expiry},
+	)
+
+	leases, err := ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, leases, 2)
+	assert.Equal(t, "52:54:00:00:00:01", leases[0].MAC)
+	assert.Equal(t, "10.0.0.10", leases[0].IP.String())
+	assert.Equal(t, "vm1", leases[0].Hostname)
+	assert.Equal(t, expiry, leases[0].Expiry)
+	assert.Equal(t, "10.0.0.20", leases[1].IP.String())
+}
+
+func TestReadFile_Missing(t *testing.T) {
+	leases, err := ReadFile(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, leases)
+}
+
+func TestResolver_PrefersActiveLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	writeLeases(t, path,
+		Lease{MAC: "52:54:00:00:00:01", IP: net.ParseIP("10.0.0.10"), Expiry: time.Now().Add(time.Hour)},
+		Lease{MAC: "52:54:00:00:00:02", IP: net.ParseIP("10.0.0.20"), Expiry: time.Now().Add(-time.Hour)},
+	)
+	fallback := &mockResolver{ips: map[string][]net.IP{
+		"52:54:00:00:00:01": {net.ParseIP("10.0.0.99"), net.ParseIP("fd00::1")},
+		"52:54:00:00:00:02": {net.ParseIP("10.0.0.21")},
+	}}
+
+	r, err := NewResolver(context.Background(), path, fallback)
+	require.NoError(t, err)
+
+	addr, err := r.LookupIP("52:54:00:00:00:01")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.10", addr.String())
+
+	addrs, err := r.LookupIPs("52:54:00:00:00:01")
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+	assert.Equal(t, "10.0.0.10", addrs[0].String())
+	assert.Equal(t, "fd00::1", addrs[1].String())
+
+	// Expired lease falls back to ARP.
+	addr, err = r.LookupIP("52:54:00:00:00:02")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.21", addr.String())
+
+	_, err = r.LookupIP("52:54:00:00:00:03")
+	require.Error(t, err)
+
+	// Every lease hints the fallback where to probe.
+	assert.Equal(t, "10.0.0.10", fallback.hint("52:54:00:00:00:01").String())
+	assert.Equal(t, "10.0.0.20", fallback.hint("52:54:00:00:00:02").String())
+
+	r.Close()
+	assert.True(t, fallback.closed)
+}
+
+func TestResolver_ReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	r, err := NewResolver(context.Background(), path, &mockResolver{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.LookupIP("52:54:00:00:00:01")
+	require.Error(t, err)
+
+	writeLeases(t, path,
+		Lease{MAC: "52:54:00:00:00:01", IP: net.ParseIP("10.0.0.10"), Expiry: time.Now().Add(time.Hour)},
+	)
+	assert.Eventually(t, func() bool {
+		addr, lookupErr := r.LookupIP("52:54:00:00:00:01")
+		return lookupErr == nil && addr.String() == "10.0.0.10"
+	}, 2*time.Second, 20*time.Millisecond)
+}
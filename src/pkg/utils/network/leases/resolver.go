@@ -0,0 +1,166 @@
+package leases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/ip"
+)
+
+// Resolver resolves MAC addresses from the DHCP lease file and falls back
+// to another resolver (typically ARP) for MACs without an active lease,
+// e.g. VMs with static addresses or an expired lease. IPv6 addresses always
+// come from the fallback since DHCP only hands out IPv4. Every lease, expired
+// or not, is passed to the fallback as a hint if it's an ip.AddressHinter.
+// Resolver implements the ip.AddressResolver interface.
+type Resolver struct {
+	path     string
+	fallback ip.AddressResolver
+	now      func() time.Time
+	cancel   context.CancelFunc
+
+	mu     sync.RWMutex
+	leases map[string]Lease // MAC -> most recent lease
+}
+
+// NewResolver loads the lease file at path and reloads it whenever it
+// changes. The fallback is owned by the resolver and closed with it.
+func NewResolver(ctx context.Context, path string, fallback ip.AddressResolver) (*Resolver, error) {
+	if fallback == nil {
+		return nil, errors.New("fallback resolver is required")
+	}
+
+	r := &Resolver{
+		path:     path,
+		fallback: fallback,
+		now:      time.Now,
+		cancel:   func() {},
+		leases:   make(map[string]Lease),
+	}
+
+	watcher, watcherErr := fsnotify.NewWatcher()
+	if watcherErr != nil {
+		return nil, fmt.Errorf("failed to create lease file watcher: %w", watcherErr)
+	}
+	// The directory is watched so the file may be created later, and so
+	// writes to SQLite's journal files are noticed too.
+	if addErr := watcher.Add(filepath.Dir(path)); addErr != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch lease file directory: %w", addErr)
+	}
+
+	r.reload()
+
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	go r.watch(ctx, watcher)
+
+	return r, nil
+}
+
+func (r *Resolver) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer func() { _ = watcher.Close() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(filepath.Clean(event.Name), filepath.Clean(r.path)) {
+				continue
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) != 0 {
+				r.reload()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("Lease file watcher error", "error", err)
+		}
+	}
+}
+
+func (r *Resolver) reload() {
+	leases, err := ReadFile(r.path)
+	if err != nil {
+		slog.Warn("Failed to read lease file", "path", r.path, "error", err)
+		return
+	}
+
+	byMAC := make(map[string]Lease, len(leases))
+	for _, l := range leases {
+		if prev, ok := byMAC[l.MAC]; ok && prev.Expiry.After(l.Expiry) {
+			continue
+		}
+		byMAC[l.MAC] = l
+	}
+
+	r.mu.Lock()
+	r.leases = byMAC
+	r.mu.Unlock()
+	if hinter, ok := r.fallback.(ip.AddressHinter); ok {
+		for mac, l := range byMAC {
+			hinter.HintIP(mac, l.IP)
+		}
+	}
+	slog.Debug("Loaded DHCP leases", "path", r.path, "count", len(byMAC))
+}
+
+// lookupLease returns the active lease's address for mac, if any.
+func (r *Resolver) lookupLease(mac string) (net.IP, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	l, ok := r.leases[mac]
+	if !ok || l.Expired(r.now()) {
+		return nil, false
+	}
+	return l.IP, true
+}
+
+func (r *Resolver) LookupIP(mac string) (net.IP, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address %s: %w", mac, err)
+	}
+	if leaseIP, ok := r.lookupLease(hw.String()); ok {
+		return leaseIP, nil
+	}
+	return r.fallback.LookupIP(mac)
+}
+
+func (r *Resolver) LookupIPs(mac string) ([]net.IP, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address %s: %w", mac, err)
+	}
+
+	leaseIP, hasLease := r.lookupLease(hw.String())
+	fallbackIPs, fallbackErr := r.fallback.LookupIPs(mac)
+	if !hasLease {
+		return fallbackIPs, fallbackErr
+	}
+
+	res := []net.IP{leaseIP}
+	for _, addr := range fallbackIPs {
+		if addr.To4() == nil {
+			res = append(res, addr)
+		}
+	}
+	return res, nil
+}
+
+func (r *Resolver) Close() {
+	r.cancel()
+	r.fallback.Close()
+}
@@ -0,0 +1,11 @@
+package network
+
+import (
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+)
+
+// HasLimits reports whether any network limit is set.
+func HasLimits(limits *settingsv1.NetworkLimits) bool {
+	return limits.GetIngressKbps() != 0 || limits.GetEgressKbps() != 0 ||
+		limits.GetIngressPps() != 0 || limits.GetEgressPps() != 0
+}
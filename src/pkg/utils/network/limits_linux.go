@@ -0,0 +1,158 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"syscall"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	// Bursts allow ~100ms worth of traffic above the rate, with floors
+	// so low limits still pass full-sized frames.
+	minBurstBytes   = 16 * 1024
+	minBurstPackets = 16
+
+	// Police attributes for packet rates, which netlink.PoliceAction
+	// doesn't support yet (linux/pkt_cls.h).
+	tcaPolicePktRate64  = 10
+	tcaPolicePktBurst64 = 11
+)
+
+// policer drops the traffic on one hook of a TAP device above a byte rate
+// (bps, in bytes per second) or a packet rate (pps). The kernel doesn't
+// allow both rates on a single police action, so each gets its own
+// matchall filter. Traffic within the rate continues to the next filter.
+type policer struct {
+	parent   uint32 // netlink.HANDLE_MIN_INGRESS or netlink.HANDLE_MIN_EGRESS
+	priority uint16
+	bps      uint64
+	burst    uint64 // bytes or packets, like the rate
+	pps      uint64
+}
+
+// policers returns the policers that apply limits. The TAP's ingress hook
+// sees what the VM sends (its egress) and the egress hook what it
+// receives.
+func policers(limits *settingsv1.NetworkLimits) []policer {
+	var out []policer
+	for _, hook := range []struct {
+		parent    uint32
+		kbps, pps uint64
+	}{
+		{netlink.HANDLE_MIN_INGRESS, limits.GetEgressKbps(), limits.GetEgressPps()},
+		{netlink.HANDLE_MIN_EGRESS, limits.GetIngressKbps(), limits.GetIngressPps()},
+	} {
+		if hook.kbps != 0 {
+			bps := hook.kbps * 1000 / 8
+			out = append(out, policer{parent: hook.parent, priority: 1, bps: bps, burst: max(bps/10, minBurstBytes)})
+		}
+		if hook.pps != 0 {
+			out = append(out, policer{parent: hook.parent, priority: 2, pps: hook.pps, burst: max(hook.pps/10, minBurstPackets)})
+		}
+	}
+	return out
+}
+
+// byteRateFilter returns the matchall filter of a byte-rate policer.
+func (p policer) byteRateFilter(linkIndex int) (*netlink.MatchAll, error) {
+	if p.bps > math.MaxUint32 || p.burst > math.MaxUint32 {
+		return nil, fmt.Errorf("rate of %d bytes/s is too high", p.bps)
+	}
+	police := netlink.NewPoliceAction()
+	police.Rate = uint32(p.bps)
+	police.Burst = uint32(p.burst)
+	police.ExceedAction = netlink.TC_POLICE_SHOT
+	police.NotExceedAction = netlink.TC_POLICE_UNSPEC
+	return &netlink.MatchAll{
+		FilterAttrs: p.attrs(linkIndex),
+		Actions:     []netlink.Action{police},
+	}, nil
+}
+
+func (p policer) attrs(linkIndex int) netlink.FilterAttrs {
+	return netlink.FilterAttrs{
+		LinkIndex: linkIndex,
+		Parent:    p.parent,
+		Priority:  p.priority,
+		Protocol:  syscall.ETH_P_ALL,
+	}
+}
+
+// add attaches the policer's filter to the link.
+func (p policer) add(linkIndex int) error {
+	if p.pps == 0 {
+		filter, err := p.byteRateFilter(linkIndex)
+		if err != nil {
+			return err
+		}
+		return netlink.FilterAdd(filter)
+	}
+	return p.addPacketRateFilter(linkIndex)
+}
+
+// addPacketRateFilter builds the matchall filter of a packet-rate policer
+// by hand, the way netlink.FilterAdd builds the others.
+func (p policer) addPacketRateFilter(linkIndex int) error {
+	attrs := p.attrs(linkIndex)
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWTFILTER, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(&nl.TcMsg{
+		Family:  nl.FAMILY_ALL,
+		Ifindex: int32(attrs.LinkIndex),
+		Parent:  attrs.Parent,
+		Info:    netlink.MakeHandle(attrs.Priority, nl.Swap16(attrs.Protocol)),
+	})
+	req.AddData(nl.NewRtAttr(nl.TCA_KIND, nl.ZeroTerminated("matchall")))
+
+	options := nl.NewRtAttr(nl.TCA_OPTIONS, nil)
+	action := options.AddRtAttr(nl.TCA_MATCHALL_ACT, nil).AddRtAttr(nl.TCA_ACT_TAB, nil)
+	action.AddRtAttr(nl.TCA_ACT_KIND, nl.ZeroTerminated("police"))
+	police := action.AddRtAttr(nl.TCA_ACT_OPTIONS, nil)
+	tbf := nl.TcPolice{Action: int32(netlink.TC_POLICE_SHOT)}
+	notExceed := netlink.TC_POLICE_UNSPEC
+	police.AddRtAttr(nl.TCA_POLICE_TBF, tbf.Serialize())
+	police.AddRtAttr(tcaPolicePktRate64, nl.Uint64Attr(p.pps))
+	police.AddRtAttr(tcaPolicePktBurst64, nl.Uint64Attr(p.burst))
+	police.AddRtAttr(nl.TCA_POLICE_RESULT, nl.Uint32Attr(uint32(notExceed)))
+	req.AddData(options)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// applyLimits polices the traffic of the TAP device ifname with matchall
+// filters on a clsact qdisc. Deleting the qdisc first makes this idempotent
+// and clears removed limits.
+func applyLimits(ifname string, limits *settingsv1.NetworkLimits) error {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", ifname, err)
+	}
+	clsact := &netlink.Clsact{QdiscAttrs: netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xffff, 0),
+		Parent:    netlink.HANDLE_CLSACT,
+	}}
+	// The delete fails when no limits were applied yet.
+	if delErr := netlink.QdiscDel(clsact); delErr != nil &&
+		!errors.Is(delErr, syscall.ENOENT) && !errors.Is(delErr, syscall.EINVAL) {
+		return fmt.Errorf("failed to clear limits on %s: %w", ifname, delErr)
+	}
+	if !HasLimits(limits) {
+		return nil
+	}
+
+	if addErr := netlink.QdiscAdd(clsact); addErr != nil {
+		return fmt.Errorf("failed to add clsact qdisc to %s: %w", ifname, addErr)
+	}
+	for _, p := range policers(limits) {
+		if addErr := p.add(link.Attrs().Index); addErr != nil {
+			return fmt.Errorf("failed to add policer to %s: %w", ifname, addErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package network
+
+import (
+	"testing"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestPolicers_None(t *testing.T) {
+	assert.Empty(t, policers(nil))
+	assert.Empty(t, policers(&settingsv1.NetworkLimits{}))
+}
+
+func TestPolicers_Bandwidth(t *testing.T) {
+	assert.Equal(t, []policer{
+		{parent: netlink.HANDLE_MIN_EGRESS, priority: 1, bps: 12500000, burst: 1250000},
+	}, policers(&settingsv1.NetworkLimits{IngressKbps: 100000}))
+}
+
+func TestPolicers_BandwidthAndPacketRate(t *testing.T) {
+	assert.Equal(t, []policer{
+		{parent: netlink.HANDLE_MIN_INGRESS, priority: 1, bps: 8000, burst: 16384},
+		{parent: netlink.HANDLE_MIN_INGRESS, priority: 2, pps: 50, burst: 16},
+	}, policers(&settingsv1.NetworkLimits{EgressKbps: 64, EgressPps: 50}))
+}
+
+func TestPolicer_ByteRateFilter(t *testing.T) {
+	filter, err := policer{parent: netlink.HANDLE_MIN_EGRESS, priority: 1, bps: 8000, burst: 16384}.byteRateFilter(3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, filter.LinkIndex)
+	assert.Equal(t, uint32(netlink.HANDLE_MIN_EGRESS), filter.Parent)
+	require.Len(t, filter.Actions, 1)
+	police := filter.Actions[0].(*netlink.PoliceAction)
+	assert.Equal(t, uint32(8000), police.Rate)
+	assert.Equal(t, uint32(16384), police.Burst)
+	assert.Equal(t, netlink.TC_POLICE_SHOT, police.ExceedAction)
+	assert.Equal(t, netlink.TC_POLICE_UNSPEC, police.NotExceedAction)
+
+	_, err = policer{bps: 1 << 40, burst: 1 << 37}.byteRateFilter(3)
+	assert.Error(t, err)
+}
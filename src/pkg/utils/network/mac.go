@@ -0,0 +1,64 @@
+package network
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultMACPrefix is QEMU's OUI, which instance MACs are allocated from
+// unless configured otherwise.
+const DefaultMACPrefix = "52:54:00"
+
+// ParseMACPrefix parses a MAC prefix of 1 to 5 colon-separated octets.
+// The prefix must leave the multicast bit clear.
+func ParseMACPrefix(prefix string) ([]byte, error) {
+	parts := strings.Split(prefix, ":")
+	if prefix == "" || len(parts) > 5 {
+		return nil, fmt.Errorf("mac prefix %q must have 1 to 5 octets", prefix)
+	}
+	out := make([]byte, len(parts))
+	for i, p := range parts {
+		var b byte
+		if _, err := fmt.Sscanf(p, "%02x", &b); err != nil || len(p) != 2 {
+			return nil, fmt.Errorf("invalid octet %q in mac prefix %q", p, prefix)
+		}
+		out[i] = b
+	}
+	if out[0]&0x01 != 0 {
+		return nil, fmt.Errorf("mac prefix %q is multicast", prefix)
+	}
+	return out, nil
+}
+
+// RandomMAC returns a MAC starting with prefix and random remaining octets.
+func RandomMAC(prefix []byte) (string, error) {
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, prefix)
+	if _, err := rand.Read(mac[len(prefix):]); err != nil {
+		return "", err
+	}
+	return mac.String(), nil
+}
+
+// NormalizeMAC validates a user-supplied instance MAC and returns it in
+// lowercase colon-separated form. Only unicast, non-zero 48-bit addresses
+// are accepted.
+func NormalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", err
+	}
+	if len(hw) != 6 {
+		return "", fmt.Errorf("mac %q is not a 48-bit address", mac)
+	}
+	if hw[0]&0x01 != 0 {
+		return "", fmt.Errorf("mac %q is multicast", mac)
+	}
+	if hw.String() == "00:00:00:00:00:00" {
+		return "", errors.New("mac must not be all zeros")
+	}
+	return hw.String(), nil
+}
@@ -0,0 +1,41 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMACPrefix(t *testing.T) {
+	prefix, err := ParseMACPrefix(DefaultMACPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x52, 0x54, 0x00}, prefix)
+
+	for _, bad := range []string{"", "52:54:00:00:00:00", "zz", "5:54", "01:00:5e"} {
+		_, err := ParseMACPrefix(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestRandomMAC(t *testing.T) {
+	prefix, err := ParseMACPrefix("02:aa:bb:cc")
+	require.NoError(t, err)
+
+	mac, err := RandomMAC(prefix)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(mac, "02:aa:bb:cc:"), mac)
+	assert.Len(t, mac, 17)
+}
+
+func TestNormalizeMAC(t *testing.T) {
+	mac, err := NormalizeMAC("52-54-00-AB-CD-EF")
+	require.NoError(t, err)
+	assert.Equal(t, "52:54:00:ab:cd:ef", mac)
+
+	for _, bad := range []string{"", "nope", "01:00:5e:00:00:01", "00:00:00:00:00:00", "00:00:00:00:fe:80:00:00:00:00:00:00:00:00:00:00:00:00:00:00"} {
+		_, err := NormalizeMAC(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,8 @@
+package network
+
+import settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+
+// IsNAT reports whether mode masquerades VM traffic, which is the default.
+func IsNAT(mode settingsv1.Network_Mode) bool {
+	return mode == settingsv1.Network_MODE_UNSPECIFIED || mode == settingsv1.Network_MODE_NAT
+}
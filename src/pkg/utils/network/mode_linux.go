@@ -0,0 +1,177 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"syscall"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+)
+
+const ipv4ForwardingSysctl = "/proc/sys/net/ipv4/ip_forward"
+
+var invalidTableChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// modeTable is the nftables table holding network's mode rules.
+func modeTable(network string) string {
+	return "qcontroller_" + invalidTableChars.ReplaceAllString(network, "_")
+}
+
+// modeChain is a base chain of a mode table, with its rules.
+type modeChain struct {
+	name  string
+	hook  *nftables.ChainHook
+	rules [][]expr.Any
+}
+
+// modeChains returns the chains that put the traffic of ifname, the host
+// side of the VM network, in line with mode. NAT mode needs none.
+//
+// Drop verdicts are final across all base chains, so isolated and host-only
+// hold even if other tools install forwarding or masquerade rules. Accept
+// verdicts aren't, so routed mode still depends on the host's other
+// forwarding policy.
+func modeChains(mode settingsv1.Network_Mode, ifname string) []modeChain {
+	switch mode {
+	case settingsv1.Network_MODE_ISOLATED, settingsv1.Network_MODE_HOST_ONLY:
+		chains := []modeChain{{name: "forward", hook: nftables.ChainHookForward, rules: [][]expr.Any{
+			rule(iifname(ifname), verdict(expr.VerdictDrop)),
+			rule(oifname(ifname), verdict(expr.VerdictDrop)),
+		}}}
+		if mode == settingsv1.Network_MODE_ISOLATED {
+			// Only the node's DNS, DHCP and neighbor discovery answer VMs.
+			chains = append(chains, modeChain{name: "input", hook: nftables.ChainHookInput, rules: [][]expr.Any{
+				rule(iifname(ifname), establishedOrRelated(), verdict(expr.VerdictAccept)),
+				rule(iifname(ifname), l4proto(syscall.IPPROTO_UDP), dport(53), verdict(expr.VerdictAccept)),
+				rule(iifname(ifname), l4proto(syscall.IPPROTO_UDP), dport(67), verdict(expr.VerdictAccept)),
+				rule(iifname(ifname), l4proto(syscall.IPPROTO_TCP), dport(53), verdict(expr.VerdictAccept)),
+				rule(iifname(ifname), l4proto(syscall.IPPROTO_ICMPV6), verdict(expr.VerdictAccept)),
+				rule(iifname(ifname), verdict(expr.VerdictDrop)),
+			}})
+		}
+		return chains
+	case settingsv1.Network_MODE_ROUTED:
+		return []modeChain{{name: "forward", hook: nftables.ChainHookForward, rules: [][]expr.Any{
+			rule(iifname(ifname), verdict(expr.VerdictAccept)),
+			rule(oifname(ifname), verdict(expr.VerdictAccept)),
+		}}}
+	}
+	return nil
+}
+
+// rule joins matches and a verdict into a rule's expressions.
+func rule(parts ...[]expr.Any) []expr.Any {
+	var out []expr.Any
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// ifnameData is name as the kernel compares interface names: NUL-padded to
+// IFNAMSIZ.
+func ifnameData(name string) []byte {
+	data := make([]byte, syscall.IFNAMSIZ)
+	copy(data, name)
+	return data
+}
+
+func iifname(name string) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifnameData(name)},
+	}
+}
+
+func oifname(name string) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifnameData(name)},
+	}
+}
+
+func establishedOrRelated() []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Key: expr.CtKeySTATE, Register: 1},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+	}
+}
+
+func l4proto(proto byte) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+	}
+}
+
+// dport matches the destination port of TCP and UDP, which both keep it
+// at offset 2.
+func dport(port uint16) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(port)},
+	}
+}
+
+func verdict(kind expr.VerdictKind) []expr.Any {
+	return []expr.Any{&expr.Verdict{Kind: kind}}
+}
+
+// ApplyMode installs the firewall rules for network's mode on ifname, the
+// host side of the VM network, replacing any earlier ones. In NAT mode it
+// only removes them; forwarding and masquerade are set up separately.
+func ApplyMode(network string, mode settingsv1.Network_Mode, ifname string) error {
+	if mode == settingsv1.Network_MODE_ROUTED {
+		if err := os.WriteFile(ipv4ForwardingSysctl, []byte("1"), 0600); err != nil {
+			return fmt.Errorf("failed to enable forwarding: %w", err)
+		}
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("nftables: %w", err)
+	}
+	return applyModeRules(conn, network, mode, ifname)
+}
+
+// applyModeRules replaces network's table with the chains for mode in a
+// single transaction. The table is added before it's deleted, so the
+// deletion can't fail when there's none yet.
+func applyModeRules(conn *nftables.Conn, network string, mode settingsv1.Network_Mode, ifname string) error {
+	table := &nftables.Table{Family: nftables.TableFamilyINet, Name: modeTable(network)}
+	conn.AddTable(table)
+	conn.DelTable(table)
+
+	if chains := modeChains(mode, ifname); len(chains) > 0 {
+		conn.AddTable(table)
+		accept := nftables.ChainPolicyAccept
+		for _, mc := range chains {
+			chain := conn.AddChain(&nftables.Chain{
+				Name:     mc.name,
+				Table:    table,
+				Type:     nftables.ChainTypeFilter,
+				Hooknum:  mc.hook,
+				Priority: nftables.ChainPriorityRef(*nftables.ChainPriorityFilter - 1),
+				Policy:   &accept,
+			})
+			for _, exprs := range mc.rules {
+				conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: exprs})
+			}
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to apply nftables rules: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package network
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/internal/nstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chainNames returns the names of chains.
+func chainNames(chains []modeChain) []string {
+	var names []string
+	for _, c := range chains {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// lastVerdict returns the verdict ending exprs.
+func lastVerdict(exprs []expr.Any) expr.VerdictKind {
+	return exprs[len(exprs)-1].(*expr.Verdict).Kind
+}
+
+func TestModeChains_NATHasNone(t *testing.T) {
+	assert.Empty(t, modeChains(settingsv1.Network_MODE_NAT, "veth0"))
+	assert.Empty(t, modeChains(settingsv1.Network_MODE_UNSPECIFIED, "veth0"))
+}
+
+func TestModeChains_HostOnly(t *testing.T) {
+	chains := modeChains(settingsv1.Network_MODE_HOST_ONLY, "veth0")
+	require.Equal(t, []string{"forward"}, chainNames(chains))
+	assert.Equal(t, [][]expr.Any{
+		rule(iifname("veth0"), verdict(expr.VerdictDrop)),
+		rule(oifname("veth0"), verdict(expr.VerdictDrop)),
+	}, chains[0].rules)
+}
+
+func TestModeChains_Isolated(t *testing.T) {
+	chains := modeChains(settingsv1.Network_MODE_ISOLATED, "veth0")
+	require.Equal(t, []string{"forward", "input"}, chainNames(chains))
+	input := chains[1].rules
+	assert.Contains(t, input, rule(iifname("veth0"), l4proto(syscall.IPPROTO_UDP), dport(67), verdict(expr.VerdictAccept)))
+	assert.Equal(t, rule(iifname("veth0"), verdict(expr.VerdictDrop)), input[len(input)-1])
+}
+
+func TestModeChains_Routed(t *testing.T) {
+	chains := modeChains(settingsv1.Network_MODE_ROUTED, "veth0")
+	require.Equal(t, []string{"forward"}, chainNames(chains))
+	for _, r := range chains[0].rules {
+		assert.Equal(t, expr.VerdictAccept, lastVerdict(r))
+	}
+}
+
+func TestApplyModeRules(t *testing.T) {
+	ns := nstest.New(t)
+	conn, err := nftables.New(nftables.WithNetNSFd(int(ns)))
+	require.NoError(t, err)
+	table := &nftables.Table{Family: nftables.TableFamilyINet, Name: modeTable("qemu-net")}
+
+	// listRules reads the table back as chain names and rule verdicts.
+	listRules := func() map[string][]expr.VerdictKind {
+		chains, listErr := conn.ListChainsOfTableFamily(nftables.TableFamilyINet)
+		require.NoError(t, listErr)
+		out := make(map[string][]expr.VerdictKind)
+		for _, c := range chains {
+			if c.Table.Name != table.Name {
+				continue
+			}
+			assert.Equal(t, nftables.ChainPriorityRef(-1), c.Priority)
+			rules, rulesErr := conn.GetRules(table, c)
+			require.NoError(t, rulesErr)
+			out[c.Name] = []expr.VerdictKind{}
+			for _, r := range rules {
+				out[c.Name] = append(out[c.Name], lastVerdict(r.Exprs))
+			}
+		}
+		return out
+	}
+
+	// NAT with no table yet is a no-op.
+	if applyErr := applyModeRules(conn, "qemu-net", settingsv1.Network_MODE_NAT, "veth0"); applyErr != nil {
+		t.Skipf("nftables unavailable: %v", applyErr)
+	}
+	assert.Empty(t, listRules())
+
+	accept, drop := expr.VerdictAccept, expr.VerdictDrop
+	require.NoError(t, applyModeRules(conn, "qemu-net", settingsv1.Network_MODE_ISOLATED, "veth0"))
+	// Applying again replaces the rules rather than adding to them.
+	require.NoError(t, applyModeRules(conn, "qemu-net", settingsv1.Network_MODE_ISOLATED, "veth0"))
+	assert.Equal(t, map[string][]expr.VerdictKind{
+		"forward": {drop, drop},
+		"input":   {accept, accept, accept, accept, accept, drop},
+	}, listRules())
+
+	require.NoError(t, applyModeRules(conn, "qemu-net", settingsv1.Network_MODE_ROUTED, "veth0"))
+	assert.Equal(t, map[string][]expr.VerdictKind{"forward": {accept, accept}}, listRules())
+
+	require.NoError(t, applyModeRules(conn, "qemu-net", settingsv1.Network_MODE_NAT, "veth0"))
+	assert.Empty(t, listRules())
+}
+
+func TestIsNAT(t *testing.T) {
+	assert.True(t, IsNAT(settingsv1.Network_MODE_UNSPECIFIED))
+	assert.True(t, IsNAT(settingsv1.Network_MODE_NAT))
+	assert.False(t, IsNAT(settingsv1.Network_MODE_ISOLATED))
+	assert.False(t, IsNAT(settingsv1.Network_MODE_ROUTED))
+}
@@ -0,0 +1,182 @@
+package ndp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// DefaultInterval is the period between unsolicited advertisements.
+	DefaultInterval = 60 * time.Second
+	// minDelayBetweenRAs rate-limits solicited advertisements (RFC 4861).
+	minDelayBetweenRAs = 3 * time.Second
+)
+
+var allNodes = net.ParseIP("ff02::1")
+var allRouters = net.ParseIP("ff02::2")
+
+// Advertiser sends router advertisements on a single interface: every
+// interval and in response to router solicitations.
+type Advertiser struct {
+	iface    *net.Interface
+	ra       RouterAdvertisement
+	interval time.Duration
+	conn     *icmp.PacketConn
+	pc       *ipv6.PacketConn
+
+	mu       sync.Mutex
+	lastSent time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewAdvertiser opens a raw ICMPv6 socket for iface. Zero lifetimes in ra
+// are filled with defaults suitable for a single router on the link.
+func NewAdvertiser(iface *net.Interface, ra RouterAdvertisement, interval time.Duration) (*Advertiser, error) {
+	if err := ra.validate(); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if ra.RouterLifetime == 0 {
+		ra.RouterLifetime = 3 * interval
+	}
+	if ra.ValidLifetime == 0 {
+		ra.ValidLifetime = 24 * time.Hour
+	}
+	if ra.PreferredLifetime == 0 {
+		ra.PreferredLifetime = 4 * time.Hour
+	}
+	if ra.SourceMAC == nil {
+		ra.SourceMAC = iface.HardwareAddr
+	}
+
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMPv6 socket: %w", err)
+	}
+	pc := conn.IPv6PacketConn()
+
+	setup := []error{
+		pc.SetMulticastInterface(iface),
+		pc.SetMulticastHopLimit(255),
+		pc.SetHopLimit(255),
+		pc.SetMulticastLoopback(false),
+		pc.JoinGroup(iface, &net.IPAddr{IP: allRouters}),
+		pc.SetControlMessage(ipv6.FlagInterface, true),
+	}
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterSolicitation)
+	setup = append(setup, pc.SetICMPFilter(&filter))
+	if setupErr := errors.Join(setup...); setupErr != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to configure ICMPv6 socket on %s: %w", iface.Name, setupErr)
+	}
+
+	return &Advertiser{
+		iface:    iface,
+		ra:       ra,
+		interval: interval,
+		conn:     conn,
+		pc:       pc,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Start begins advertising until Stop is called.
+func (a *Advertiser) Start() {
+	a.wg.Add(2)
+	go a.periodic()
+	go a.solicitations()
+	slog.Info("Sending IPv6 router advertisements", "interface", a.iface.Name, "prefix", a.ra.Prefix)
+}
+
+// Stop withdraws the router (lifetime 0) and closes the socket.
+func (a *Advertiser) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		withdraw := a.ra
+		withdraw.RouterLifetime = 0
+		if err := a.send(withdraw); err != nil {
+			slog.Debug("Failed to withdraw router advertisement", "interface", a.iface.Name, "error", err)
+		}
+		_ = a.conn.Close()
+		a.wg.Wait()
+	})
+}
+
+func (a *Advertiser) periodic() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.send(a.ra); err != nil {
+			slog.Warn("Failed to send router advertisement", "interface", a.iface.Name, "error", err)
+		}
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Advertiser) solicitations() {
+	defer a.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		_, cm, _, err := a.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-a.stop:
+				return
+			default:
+			}
+			slog.Debug("Failed to read router solicitation", "interface", a.iface.Name, "error", err)
+			continue
+		}
+		if cm != nil && cm.IfIndex != a.iface.Index {
+			continue
+		}
+
+		a.mu.Lock()
+		recent := time.Since(a.lastSent) < minDelayBetweenRAs
+		a.mu.Unlock()
+		if recent {
+			continue
+		}
+		if sendErr := a.send(a.ra); sendErr != nil {
+			slog.Debug("Failed to answer router solicitation", "interface", a.iface.Name, "error", sendErr)
+		}
+	}
+}
+
+func (a *Advertiser) send(ra RouterAdvertisement) error {
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeRouterAdvertisement,
+		Body: &icmp.RawBody{Data: ra.body()},
+	}
+	// The kernel fills in the ICMPv6 checksum on raw sockets.
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := a.pc.WriteTo(b, nil, &net.IPAddr{IP: allNodes, Zone: a.iface.Name}); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.lastSent = time.Now()
+	a.mu.Unlock()
+	return nil
+}
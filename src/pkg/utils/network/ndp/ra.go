@@ -0,0 +1,70 @@
+// Package ndp implements the router side of IPv6 Neighbor Discovery needed
+// for SLAAC: periodic and solicited router advertisements on a single link.
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	optSourceLinkLayerAddr = 1
+	optPrefixInformation   = 3
+
+	prefixFlagOnLink     = 0x80
+	prefixFlagAutonomous = 0x40
+)
+
+// RouterAdvertisement describes the advertisement sent on the link.
+type RouterAdvertisement struct {
+	// Prefix is advertised for on-link determination and SLAAC. Must be a /64.
+	Prefix *net.IPNet
+	// SourceMAC is announced in the source link-layer address option.
+	SourceMAC net.HardwareAddr
+	// RouterLifetime is how long hosts may use the sender as default router.
+	RouterLifetime time.Duration
+	// ValidLifetime and PreferredLifetime apply to addresses formed from Prefix.
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+func (ra *RouterAdvertisement) validate() error {
+	if ra.Prefix == nil || ra.Prefix.IP.To4() != nil {
+		return errors.New("an IPv6 prefix is required")
+	}
+	if ones, _ := ra.Prefix.Mask.Size(); ones != 64 {
+		return fmt.Errorf("prefix %s must be a /64 for SLAAC", ra.Prefix)
+	}
+	return nil
+}
+
+// body returns the ICMPv6 message body following the type, code and
+// checksum fields (RFC 4861, section 4.2).
+func (ra *RouterAdvertisement) body() []byte {
+	b := make([]byte, 12, 12+8+32)
+	b[0] = 64 // Cur Hop Limit
+	// b[1]: M and O flags unset, addresses come from SLAAC only.
+	binary.BigEndian.PutUint16(b[2:4], uint16(ra.RouterLifetime/time.Second))
+	// Reachable Time and Retrans Timer left unspecified (0).
+
+	if len(ra.SourceMAC) == 6 {
+		opt := make([]byte, 8)
+		opt[0] = optSourceLinkLayerAddr
+		opt[1] = 1 // length in units of 8 octets
+		copy(opt[2:], ra.SourceMAC)
+		b = append(b, opt...)
+	}
+
+	opt := make([]byte, 32)
+	opt[0] = optPrefixInformation
+	opt[1] = 4
+	opt[2] = 64
+	opt[3] = prefixFlagOnLink | prefixFlagAutonomous
+	binary.BigEndian.PutUint32(opt[4:8], uint32(ra.ValidLifetime/time.Second))
+	binary.BigEndian.PutUint32(opt[8:12], uint32(ra.PreferredLifetime/time.Second))
+	copy(opt[16:32], ra.Prefix.IP.Mask(ra.Prefix.Mask).To16())
+	return append(b, opt...)
+}
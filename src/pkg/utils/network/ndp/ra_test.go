@@ -0,0 +1,61 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRA(t *testing.T, cidr string) RouterAdvertisement {
+	_, prefix, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	mac, err := net.ParseMAC("52:54:00:aa:bb:cc")
+	require.NoError(t, err)
+	return RouterAdvertisement{
+		Prefix:            prefix,
+		SourceMAC:         mac,
+		RouterLifetime:    180 * time.Second,
+		ValidLifetime:     24 * time.Hour,
+		PreferredLifetime: 4 * time.Hour,
+	}
+}
+
+func TestRouterAdvertisement_Body(t *testing.T) {
+	ra := testRA(t, "fd00:42::/64")
+	b := ra.body()
+
+	require.Len(t, b, 12+8+32)
+	assert.Equal(t, byte(64), b[0], "cur hop limit")
+	assert.Equal(t, byte(0), b[1], "no managed/other flags")
+	assert.Equal(t, uint16(180), binary.BigEndian.Uint16(b[2:4]), "router lifetime")
+
+	sll := b[12:20]
+	assert.Equal(t, []byte{optSourceLinkLayerAddr, 1}, sll[:2])
+	assert.Equal(t, []byte(ra.SourceMAC), sll[2:])
+
+	pio := b[20:]
+	assert.Equal(t, byte(optPrefixInformation), pio[0])
+	assert.Equal(t, byte(4), pio[1])
+	assert.Equal(t, byte(64), pio[2], "prefix length")
+	assert.Equal(t, byte(prefixFlagOnLink|prefixFlagAutonomous), pio[3])
+	assert.Equal(t, uint32(86400), binary.BigEndian.Uint32(pio[4:8]))
+	assert.Equal(t, uint32(14400), binary.BigEndian.Uint32(pio[8:12]))
+	assert.True(t, net.IP(pio[16:32]).Equal(net.ParseIP("fd00:42::")))
+}
+
+func TestRouterAdvertisement_Validate(t *testing.T) {
+	valid := testRA(t, "fd00:42::/64")
+	assert.NoError(t, valid.validate())
+
+	wide := testRA(t, "fd00:42::/48")
+	assert.Error(t, wide.validate())
+
+	v4 := testRA(t, "192.168.1.0/24")
+	assert.Error(t, v4.validate())
+
+	assert.Error(t, (&RouterAdvertisement{}).validate())
+}
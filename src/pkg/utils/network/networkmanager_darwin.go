@@ -4,13 +4,33 @@ import (
 	"errors"
 	"os/exec"
 	"strings"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
 )
 
 type defaultNetworkManager struct{}
 
 func (m *defaultNetworkManager) Close() {}
 
-func (m *defaultNetworkManager) CreateInterface(interfaceName string) error {
+// ErrLimitsUnsupported is returned when network limits are requested on a
+// platform that can't enforce them. On macOS, vmnet owns the interfaces.
+var ErrLimitsUnsupported = errors.New("network limits are not supported on macOS")
+
+func (m *defaultNetworkManager) CreateInterface(interfaceName string, opts ...InterfaceOption) error {
+	cfg := &InterfaceConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if HasLimits(cfg.Limits) {
+		return ErrLimitsUnsupported
+	}
+	return nil
+}
+
+func (m *defaultNetworkManager) SetLimits(interfaceName string, limits *settingsv1.NetworkLimits) error {
+	if HasLimits(limits) {
+		return ErrLimitsUnsupported
+	}
 	return nil
 }
 
@@ -18,7 +38,7 @@ func (m *defaultNetworkManager) RemoveInterface(interfaceName string) error {
 	return nil
 }
 
-func NewNetworkManager(bridgeName, subnet string) (NetworkManager, error) {
+func NewNetworkManager(bridgeName, subnet string, opts ...ManagerOption) (NetworkManager, error) {
 	return &defaultNetworkManager{}, nil
 }
 
@@ -4,10 +4,13 @@ import (
 	"sync"
 
 	"github.com/q-controller/network-utils/src/utils/network/ifc"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/overlay"
 )
 
 type linuxNetworkManager struct {
 	bridgeName string
+	trunk      string
 	mu         sync.RWMutex
 	done       chan struct{}
 	events     chan Event
@@ -17,17 +20,51 @@ func (m *linuxNetworkManager) Close() {
 	m.done <- struct{}{}
 }
 
-func (m *linuxNetworkManager) CreateInterface(interfaceName string) error {
+func (m *linuxNetworkManager) CreateInterface(interfaceName string, opts ...InterfaceOption) error {
+	cfg := &InterfaceConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if tapErr := ifc.CreateTap(interfaceName, m.bridgeName); tapErr != nil {
+	bridgeName := m.bridgeName
+	if cfg.Bridge != "" {
+		if _, bridgeErr := overlay.EnsureBridge(cfg.Bridge); bridgeErr != nil {
+			return bridgeErr
+		}
+		bridgeName = cfg.Bridge
+	}
+
+	if tapErr := ifc.CreateTap(interfaceName, bridgeName); tapErr != nil {
 		return tapErr
 	}
 
+	if cfg.VlanID != 0 {
+		if vlanErr := setAccessVlan(interfaceName, m.trunk, cfg.VlanID, cfg.MAC); vlanErr != nil {
+			_ = ifc.DeleteLink(interfaceName)
+			return vlanErr
+		}
+	}
+
+	if HasLimits(cfg.Limits) {
+		if limitsErr := applyLimits(interfaceName, cfg.Limits); limitsErr != nil {
+			_ = ifc.DeleteLink(interfaceName)
+			return limitsErr
+		}
+	}
+
 	return nil
 }
 
+func (m *linuxNetworkManager) SetLimits(interfaceName string, limits *settingsv1.NetworkLimits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return applyLimits(interfaceName, limits)
+}
+
 func (m *linuxNetworkManager) RemoveInterface(interfaceName string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -39,13 +76,23 @@ func (m *linuxNetworkManager) RemoveInterface(interfaceName string) error {
 	return nil
 }
 
-func NewNetworkManager(bridgeName, gateway string) (NetworkManager, error) {
+func NewNetworkManager(bridgeName, gateway string, opts ...ManagerOption) (NetworkManager, error) {
+	cfg := &ManagerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	if bridgeErr := ifc.CreateBridge(bridgeName, gateway, true); bridgeErr != nil {
 		return nil, bridgeErr
 	}
 
+	if trunkErr := setupTrunk(bridgeName, cfg.TrunkInterface, cfg.TrunkVlans); trunkErr != nil {
+		return nil, trunkErr
+	}
+
 	nm := &linuxNetworkManager{
 		bridgeName: bridgeName,
+		trunk:      cfg.TrunkInterface,
 		done:       make(chan struct{}),
 		events:     make(chan Event, 10),
 	}
@@ -0,0 +1,181 @@
+// Package overlay manages cross-node L2 networks carried over VXLAN. Each
+// overlay has its own bridge inside the VM network namespace, with a VXLAN
+// device enslaved to it that floods to every peer node.
+//
+// The QEMU service (inside the namespace) receives the overlays from the
+// orchestrator and writes them to a config file. Its parent (in the host
+// namespace) watches the file and creates the VXLAN devices there, so they
+// use the host's underlay, before moving them into the namespace.
+package overlay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultPort is the IANA-assigned VXLAN UDP port.
+	DefaultPort = 4789
+	// MaxVNI is the highest 24-bit VXLAN network identifier.
+	MaxVNI = 1<<24 - 1
+)
+
+// Network is an overlay network present on the node.
+type Network struct {
+	Name   string `json:"name"`
+	VNI    uint32 `json:"vni"`
+	Subnet string `json:"subnet,omitempty"`
+}
+
+// Config is the node's overlay configuration.
+type Config struct {
+	Networks []Network `json:"networks"`
+	// Peers are the underlay addresses of the other nodes.
+	Peers []string `json:"peers"`
+}
+
+// Lookup returns the network called name.
+func (c Config) Lookup(name string) (Network, bool) {
+	for _, n := range c.Networks {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return Network{}, false
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateName checks name is a lowercase DNS label.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid overlay network name %q: must be a lowercase DNS label", name)
+	}
+	return nil
+}
+
+// ValidateVNI checks vni is a usable VXLAN network identifier.
+func ValidateVNI(vni uint32) error {
+	if vni == 0 || vni > MaxVNI {
+		return fmt.Errorf("vni %d out of range (1-%d)", vni, MaxVNI)
+	}
+	return nil
+}
+
+// BridgeName returns the name of the overlay's bridge.
+func BridgeName(vni uint32) string {
+	return fmt.Sprintf("qob%d", vni)
+}
+
+// VxlanName returns the name of the overlay's VXLAN device.
+func VxlanName(vni uint32) string {
+	return fmt.Sprintf("qov%d", vni)
+}
+
+// ConfigFile returns the path of the overlay config under the qemu service
+// root.
+func ConfigFile(root string) string {
+	return filepath.Join(root, "overlay", "networks.json")
+}
+
+// WriteConfigFile atomically replaces the config file at path.
+func WriteConfigFile(path string, cfg Config) error {
+	if cfg.Networks == nil {
+		cfg.Networks = []Network{}
+	}
+	if cfg.Peers == nil {
+		cfg.Peers = []string{}
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadConfigFile reads the config file at path. A missing file yields an
+// empty config and no error.
+func ReadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// WatchConfigFile calls apply with the config at path, and again whenever
+// it changes, until ctx is done. The parent directory is watched because
+// WriteConfigFile replaces the file via rename.
+func WatchConfigFile(ctx context.Context, path string, apply func(Config)) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	watcher, watcherErr := fsnotify.NewWatcher()
+	if watcherErr != nil {
+		return watcherErr
+	}
+	if addErr := watcher.Add(dir); addErr != nil {
+		_ = watcher.Close()
+		return addErr
+	}
+
+	reload := func() {
+		cfg, err := ReadConfigFile(path)
+		if err != nil {
+			slog.Warn("Failed to read overlay config", "path", path, "error", err)
+			return
+		}
+		apply(cfg)
+	}
+
+	go func() {
+		defer func() { _ = watcher.Close() }()
+		reload()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) {
+					continue
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) != 0 {
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Overlay config watcher error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,159 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// zeroMAC is the FDB address of entries that flood broadcast, unknown
+// unicast and multicast traffic to a peer.
+var zeroMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+// EnsureBridge returns the bridge called name, creating it if needed.
+func EnsureBridge(name string) (netlink.Link, error) {
+	if link, err := netlink.LinkByName(name); err == nil {
+		return link, nil
+	}
+
+	bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := netlink.LinkAdd(bridge); err != nil && !errors.Is(err, syscall.EEXIST) {
+		return nil, fmt.Errorf("failed to create bridge %s: %w", name, err)
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find bridge %s: %w", name, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to bring bridge %s up: %w", name, err)
+	}
+	return link, nil
+}
+
+// CreateVxlan creates the VXLAN device for vni in the current namespace,
+// which is the one its UDP socket lives in even after the device is moved.
+func CreateVxlan(vni uint32) (netlink.Link, error) {
+	name := VxlanName(vni)
+	if link, err := netlink.LinkByName(name); err == nil {
+		return link, nil
+	}
+
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		VxlanId:   int(vni),
+		Port:      DefaultPort,
+		Learning:  true,
+	}
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return nil, fmt.Errorf("failed to create vxlan %s: %w", name, err)
+	}
+	return netlink.LinkByName(name)
+}
+
+// Attach enslaves vni's VXLAN device to the overlay bridge and floods to
+// exactly peers. It's idempotent.
+func Attach(vni uint32, peers []net.IP) error {
+	bridge, bridgeErr := EnsureBridge(BridgeName(vni))
+	if bridgeErr != nil {
+		return bridgeErr
+	}
+
+	link, linkErr := netlink.LinkByName(VxlanName(vni))
+	if linkErr != nil {
+		return fmt.Errorf("failed to find vxlan for vni %d: %w", vni, linkErr)
+	}
+	if link.Attrs().MasterIndex != bridge.Attrs().Index {
+		if err := netlink.LinkSetMaster(link, bridge); err != nil {
+			return fmt.Errorf("failed to attach %s to %s: %w", link.Attrs().Name, bridge.Attrs().Name, err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring %s up: %w", link.Attrs().Name, err)
+	}
+
+	return syncPeers(link, peers)
+}
+
+// syncPeers makes the device's flood entries match peers.
+func syncPeers(link netlink.Link, peers []net.IP) error {
+	wanted := make(map[string]net.IP, len(peers))
+	for _, p := range peers {
+		wanted[p.String()] = p
+	}
+
+	entries, err := netlink.NeighList(link.Attrs().Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return fmt.Errorf("failed to list fdb of %s: %w", link.Attrs().Name, err)
+	}
+	for _, entry := range entries {
+		if entry.IP == nil || entry.HardwareAddr.String() != zeroMAC.String() {
+			continue
+		}
+		if _, ok := wanted[entry.IP.String()]; ok {
+			delete(wanted, entry.IP.String())
+			continue
+		}
+		if delErr := netlink.NeighDel(&entry); delErr != nil {
+			return fmt.Errorf("failed to remove peer %s from %s: %w", entry.IP, link.Attrs().Name, delErr)
+		}
+	}
+
+	for _, peer := range wanted {
+		if addErr := netlink.NeighAppend(&netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			Family:       syscall.AF_BRIDGE,
+			State:        netlink.NUD_PERMANENT | netlink.NUD_NOARP,
+			Flags:        netlink.NTF_SELF,
+			IP:           peer,
+			HardwareAddr: zeroMAC,
+		}); addErr != nil {
+			return fmt.Errorf("failed to add peer %s to %s: %w", peer, link.Attrs().Name, addErr)
+		}
+	}
+	return nil
+}
+
+// Detach removes vni's VXLAN device and bridge. Instance interfaces still
+// on the bridge are released from it.
+func Detach(vni uint32) error {
+	for _, name := range []string{VxlanName(vni), BridgeName(vni)} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			continue
+		}
+		if delErr := netlink.LinkDel(link); delErr != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, delErr)
+		}
+	}
+	return nil
+}
+
+// ListVNIs returns the VNIs of the overlay VXLAN devices in the current
+// namespace.
+func ListVNIs() ([]uint32, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	var vnis []uint32
+	for _, link := range links {
+		if link.Type() != "vxlan" {
+			continue
+		}
+		suffix, ok := strings.CutPrefix(link.Attrs().Name, "qov")
+		if !ok {
+			continue
+		}
+		vni, parseErr := strconv.ParseUint(suffix, 10, 32)
+		if parseErr != nil {
+			continue
+		}
+		vnis = append(vnis, uint32(vni))
+	}
+	return vnis, nil
+}
@@ -0,0 +1,143 @@
+package overlay
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/internal/nstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// testNode mimics a node: the VXLAN device is created in host, which holds
+// the underlay, and moved into vm, which holds the bridges.
+type testNode struct {
+	host, vm netns.NsHandle
+}
+
+func addAddr(t *testing.T, name, cidr string) {
+	t.Helper()
+	link, err := netlink.LinkByName(name)
+	require.NoError(t, err)
+	addr, err := netlink.ParseAddr(cidr)
+	require.NoError(t, err)
+	require.NoError(t, netlink.AddrAdd(link, addr))
+	require.NoError(t, netlink.LinkSetUp(link))
+}
+
+// setupUnderlay connects a.host and b.host with a veth pair.
+func setupUnderlay(t *testing.T, a, b testNode) {
+	nstest.Run(t, a.host, func() {
+		require.NoError(t, netlink.LinkAdd(&netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: "under0"},
+			PeerName:  "under1",
+		}))
+		peer, err := netlink.LinkByName("under1")
+		require.NoError(t, err)
+		require.NoError(t, netlink.LinkSetNsFd(peer, int(b.host)))
+		addAddr(t, "under0", "10.99.0.1/24")
+	})
+	nstest.Run(t, b.host, func() {
+		addAddr(t, "under1", "10.99.0.2/24")
+	})
+}
+
+// setupOverlay brings vni up on n with peer, addressing its bridge.
+func setupOverlay(t *testing.T, n testNode, vni uint32, peer, cidr string) {
+	nstest.Run(t, n.host, func() {
+		link, err := CreateVxlan(vni)
+		require.NoError(t, err)
+		require.NoError(t, netlink.LinkSetNsFd(link, int(n.vm)))
+	})
+	nstest.Run(t, n.vm, func() {
+		require.NoError(t, Attach(vni, []net.IP{net.ParseIP(peer)}))
+		addAddr(t, BridgeName(vni), cidr)
+	})
+}
+
+func floodPeers(t *testing.T, vni uint32) []string {
+	t.Helper()
+	link, err := netlink.LinkByName(VxlanName(vni))
+	require.NoError(t, err)
+	entries, err := netlink.NeighList(link.Attrs().Index, syscall.AF_BRIDGE)
+	require.NoError(t, err)
+	var peers []string
+	for _, e := range entries {
+		if e.IP != nil && e.HardwareAddr.String() == zeroMAC.String() {
+			peers = append(peers, e.IP.String())
+		}
+	}
+	return peers
+}
+
+func TestOverlay_CrossNodeTraffic(t *testing.T) {
+	a := testNode{host: nstest.New(t), vm: nstest.New(t)}
+	b := testNode{host: nstest.New(t), vm: nstest.New(t)}
+	setupUnderlay(t, a, b)
+
+	const vni = 4242
+	setupOverlay(t, a, vni, "10.99.0.2", "10.200.0.1/24")
+	setupOverlay(t, b, vni, "10.99.0.1", "10.200.0.2/24")
+
+	var listener net.PacketConn
+	nstest.Run(t, b.vm, func() {
+		var err error
+		listener, err = net.ListenPacket("udp4", "10.200.0.2:9999")
+		require.NoError(t, err)
+	})
+	defer func() { _ = listener.Close() }()
+
+	var conn net.Conn
+	nstest.Run(t, a.vm, func() {
+		var err error
+		conn, err = net.Dial("udp4", "10.200.0.2:9999")
+		require.NoError(t, err)
+	})
+	defer func() { _ = conn.Close() }()
+
+	// The first datagrams may be lost while neighbors resolve.
+	buf := make([]byte, 16)
+	received := false
+	for i := 0; i < 20 && !received; i++ {
+		_, err := conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, listener.SetReadDeadline(time.Now().Add(250*time.Millisecond)))
+		n, _, readErr := listener.ReadFrom(buf)
+		received = readErr == nil && string(buf[:n]) == "hello"
+	}
+	assert.True(t, received, "datagram didn't cross the overlay")
+}
+
+func TestOverlay_AttachSyncsPeersAndDetach(t *testing.T) {
+	n := testNode{host: nstest.New(t), vm: nstest.New(t)}
+	const vni = 7
+	setupOverlay(t, n, vni, "192.0.2.1", "10.200.0.1/24")
+
+	nstest.Run(t, n.vm, func() {
+		assert.ElementsMatch(t, []string{"192.0.2.1"}, floodPeers(t, vni))
+
+		require.NoError(t, Attach(vni, []net.IP{net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")}))
+		assert.ElementsMatch(t, []string{"192.0.2.2", "192.0.2.3"}, floodPeers(t, vni))
+
+		link, err := netlink.LinkByName(VxlanName(vni))
+		require.NoError(t, err)
+		bridge, err := netlink.LinkByName(BridgeName(vni))
+		require.NoError(t, err)
+		assert.Equal(t, bridge.Attrs().Index, link.Attrs().MasterIndex)
+
+		vnis, err := ListVNIs()
+		require.NoError(t, err)
+		assert.Equal(t, []uint32{vni}, vnis)
+
+		require.NoError(t, Detach(vni))
+		vnis, err = ListVNIs()
+		require.NoError(t, err)
+		assert.Empty(t, vnis)
+		_, err = netlink.LinkByName(BridgeName(vni))
+		assert.Error(t, err)
+	})
+}
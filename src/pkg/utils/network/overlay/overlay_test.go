@@ -0,0 +1,55 @@
+package overlay
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFile_RoundTrip(t *testing.T) {
+	path := ConfigFile(t.TempDir())
+
+	cfg, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Networks)
+
+	want := Config{
+		Networks: []Network{{Name: "blue", VNI: 1000, Subnet: "10.10.0.0/24"}},
+		Peers:    []string{"192.0.2.2"},
+	}
+	require.NoError(t, WriteConfigFile(path, want))
+
+	got, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	n, ok := got.Lookup("blue")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1000), n.VNI)
+	_, ok = got.Lookup("red")
+	assert.False(t, ok)
+	assert.Equal(t, "networks.json", filepath.Base(path))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, ValidateName("blue"))
+	assert.NoError(t, ValidateName("team-a1"))
+	assert.Error(t, ValidateName(""))
+	assert.Error(t, ValidateName("Blue"))
+	assert.Error(t, ValidateName("-blue"))
+	assert.Error(t, ValidateName("blue_net"))
+
+	assert.NoError(t, ValidateVNI(1))
+	assert.NoError(t, ValidateVNI(MaxVNI))
+	assert.Error(t, ValidateVNI(0))
+	assert.Error(t, ValidateVNI(MaxVNI+1))
+}
+
+func TestNames(t *testing.T) {
+	// Interface names are limited to 15 characters.
+	assert.LessOrEqual(t, len(BridgeName(MaxVNI)), 15)
+	assert.LessOrEqual(t, len(VxlanName(MaxVNI)), 15)
+	assert.NotEqual(t, BridgeName(7), VxlanName(7))
+}
@@ -1,9 +1,75 @@
 package network
 
+import (
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+)
+
 type NetworkManager interface {
 	Close()
-	CreateInterface(interfaceName string) error
+	// CreateInterface creates the instance's interface configured by opts.
+	CreateInterface(interfaceName string, opts ...InterfaceOption) error
 	RemoveInterface(interfaceName string) error
+	// SetLimits replaces the limits on an existing interface.
+	SetLimits(interfaceName string, limits *settingsv1.NetworkLimits) error
+}
+
+// ManagerConfig holds node-wide network settings.
+type ManagerConfig struct {
+	// TrunkInterface is enslaved to the bridge and carries tagged traffic
+	// of every instance VLAN.
+	TrunkInterface string
+	TrunkVlans     []uint16
+}
+
+type ManagerOption func(*ManagerConfig)
+
+// WithTrunk makes ifname the bridge's VLAN trunk, allowing vlans on it in
+// addition to the ones instances use.
+func WithTrunk(ifname string, vlans []uint16) ManagerOption {
+	return func(c *ManagerConfig) {
+		c.TrunkInterface = ifname
+		c.TrunkVlans = vlans
+	}
+}
+
+// InterfaceConfig holds per-instance interface settings.
+type InterfaceConfig struct {
+	Limits *settingsv1.NetworkLimits
+	// VlanID makes the interface an untagged access port on that VLAN;
+	// 0 leaves it on the bridge's default VLAN.
+	VlanID uint16
+	// MAC is the instance's address. Without a trunk, VLAN instances get
+	// the node's traffic through a static forwarding entry for it.
+	MAC string
+	// Bridge attaches the interface to another bridge than the node's,
+	// e.g. an overlay network's. It's created if missing.
+	Bridge string
+}
+
+type InterfaceOption func(*InterfaceConfig)
+
+func WithLimits(limits *settingsv1.NetworkLimits) InterfaceOption {
+	return func(c *InterfaceConfig) {
+		c.Limits = limits
+	}
+}
+
+func WithVlan(id uint16) InterfaceOption {
+	return func(c *InterfaceConfig) {
+		c.VlanID = id
+	}
+}
+
+func WithMAC(mac string) InterfaceOption {
+	return func(c *InterfaceConfig) {
+		c.MAC = mac
+	}
+}
+
+func WithBridge(name string) InterfaceOption {
+	return func(c *InterfaceConfig) {
+		c.Bridge = name
+	}
 }
 
 type Event interface {
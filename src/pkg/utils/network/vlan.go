@@ -0,0 +1,14 @@
+package network
+
+import "fmt"
+
+// MaxVlanID is the highest usable 802.1Q VLAN ID.
+const MaxVlanID = 4094
+
+// ValidateVlanID checks id is a usable VLAN ID.
+func ValidateVlanID(id uint32) error {
+	if id == 0 || id > MaxVlanID {
+		return fmt.Errorf("vlan id %d out of range (1-%d)", id, MaxVlanID)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// defaultVlanID is the bridge's default PVID: untagged traffic of ports
+// without a VLAN, including the bridge itself (gateway, DHCP, DNS).
+const defaultVlanID = 1
+
+// setupTrunk enables VLAN filtering on the bridge and enslaves trunk, if
+// any, to it as a tagged port for vlans. The default VLAN is removed from
+// the trunk so the node's own VM network doesn't leak onto the uplink.
+func setupTrunk(bridgeName, trunk string, vlans []uint16) error {
+	bridge, bridgeErr := netlink.LinkByName(bridgeName)
+	if bridgeErr != nil {
+		return fmt.Errorf("failed to find bridge %s: %w", bridgeName, bridgeErr)
+	}
+	if err := netlink.BridgeSetVlanFiltering(bridge, true); err != nil {
+		return fmt.Errorf("failed to enable vlan filtering on %s: %w", bridgeName, err)
+	}
+
+	if trunk == "" {
+		return nil
+	}
+
+	link, linkErr := netlink.LinkByName(trunk)
+	if linkErr != nil {
+		return fmt.Errorf("failed to find trunk interface %s: %w", trunk, linkErr)
+	}
+	if err := netlink.LinkSetMaster(link, bridge); err != nil {
+		return fmt.Errorf("failed to attach trunk %s to %s: %w", trunk, bridgeName, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring trunk %s up: %w", trunk, err)
+	}
+	if err := netlink.BridgeVlanDel(link, defaultVlanID, true, true, false, true); err != nil {
+		return fmt.Errorf("failed to remove default vlan from trunk %s: %w", trunk, err)
+	}
+	for _, vid := range vlans {
+		if err := netlink.BridgeVlanAdd(link, vid, false, false, false, true); err != nil {
+			return fmt.Errorf("failed to allow vlan %d on trunk %s: %w", vid, trunk, err)
+		}
+	}
+	return nil
+}
+
+// setAccessVlan makes the bridge port ifname an untagged access port on
+// vid. With a trunk, vid is allowed tagged on it and the VLAN's network is
+// upstream. Without one, the node serves the VLAN: the bridge joins vid to
+// hear the instance, and the port stays an untagged member of the default
+// VLAN, which carries what the node sends (gateway, DHCP, DNS). A static
+// forwarding entry for mac keeps the node's unicast to the instance from
+// flooding the default VLAN.
+func setAccessVlan(ifname, trunk string, vid uint16, mac string) error {
+	link, linkErr := netlink.LinkByName(ifname)
+	if linkErr != nil {
+		return fmt.Errorf("failed to find interface %s: %w", ifname, linkErr)
+	}
+
+	if trunk == "" {
+		return shareNodeVlan(link, vid, mac)
+	}
+
+	if err := netlink.BridgeVlanDel(link, defaultVlanID, true, true, false, true); err != nil {
+		return fmt.Errorf("failed to remove default vlan from %s: %w", ifname, err)
+	}
+	if err := netlink.BridgeVlanAdd(link, vid, true, true, false, true); err != nil {
+		return fmt.Errorf("failed to add vlan %d to %s: %w", vid, ifname, err)
+	}
+	trunkLink, trunkErr := netlink.LinkByName(trunk)
+	if trunkErr != nil {
+		return fmt.Errorf("failed to find trunk interface %s: %w", trunk, trunkErr)
+	}
+	if err := netlink.BridgeVlanAdd(trunkLink, vid, false, false, false, true); err != nil {
+		return fmt.Errorf("failed to allow vlan %d on trunk %s: %w", vid, trunk, err)
+	}
+	return nil
+}
+
+func shareNodeVlan(link netlink.Link, vid uint16, mac string) error {
+	ifname := link.Attrs().Name
+	hwaddr, macErr := net.ParseMAC(mac)
+	if macErr != nil {
+		return fmt.Errorf("invalid mac address of %s: %w", ifname, macErr)
+	}
+	bridge, bridgeErr := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if bridgeErr != nil {
+		return fmt.Errorf("failed to find bridge of %s: %w", ifname, bridgeErr)
+	}
+
+	// Making vid the PVID leaves the default VLAN untagged on the port.
+	if err := netlink.BridgeVlanAdd(link, vid, true, true, false, true); err != nil {
+		return fmt.Errorf("failed to add vlan %d to %s: %w", vid, ifname, err)
+	}
+	if err := netlink.BridgeVlanAdd(bridge, vid, false, true, true, false); err != nil {
+		return fmt.Errorf("failed to add vlan %d to %s: %w", vid, bridge.Attrs().Name, err)
+	}
+	if err := netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		Flags:        netlink.NTF_MASTER,
+		State:        netlink.NUD_NOARP,
+		HardwareAddr: hwaddr,
+		Vlan:         defaultVlanID,
+	}); err != nil {
+		return fmt.Errorf("failed to add forwarding entry for %s: %w", ifname, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package network
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/internal/nstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// listenUDP listens on port of the device ifname, which lets it send
+// broadcasts without an address or a route.
+func listenUDP(t *testing.T, ifname string, port int) *net.UDPConn {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	require.NoError(t, syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname))
+	require.NoError(t, syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1))
+	require.NoError(t, syscall.Bind(fd, &syscall.SockaddrInet4{Port: port}))
+	f := os.NewFile(uintptr(fd), ifname)
+	defer func() { _ = f.Close() }()
+	conn, err := net.FilePacketConn(f)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.(*net.UDPConn)
+}
+
+func exchange(t *testing.T, from, to *net.UDPConn, dst *net.UDPAddr, msg string) *net.UDPAddr {
+	t.Helper()
+	_, err := from.WriteToUDP([]byte(msg), dst)
+	require.NoError(t, err)
+	require.NoError(t, to.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 64)
+	n, src, err := to.ReadFromUDP(buf)
+	require.NoError(t, err, msg)
+	assert.Equal(t, msg, string(buf[:n]))
+	return src
+}
+
+// TestVlan_InstanceGetsLease runs a DHCP-like exchange between a VM on a
+// VLAN and the node: broadcasts both ways, then unicast once the VM has
+// its address.
+func TestVlan_InstanceGetsLease(t *testing.T) {
+	node, vm := nstest.New(t), nstest.New(t)
+
+	var vmMAC string
+	nstest.Run(t, node, func() {
+		bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br-test"}}
+		require.NoError(t, netlink.LinkAdd(bridge))
+		if err := setupTrunk("br-test", "", nil); err != nil {
+			t.Skipf("vlan filtering isn't supported: %v", err)
+		}
+		addr, err := netlink.ParseAddr("10.98.0.1/24")
+		require.NoError(t, err)
+		require.NoError(t, netlink.AddrAdd(bridge, addr))
+		require.NoError(t, netlink.LinkSetUp(bridge))
+
+		require.NoError(t, netlink.LinkAdd(&netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: "vm0", MasterIndex: bridge.Index},
+			PeerName:  "eth0",
+		}))
+		port, err := netlink.LinkByName("vm0")
+		require.NoError(t, err)
+		require.NoError(t, netlink.LinkSetUp(port))
+		peer, err := netlink.LinkByName("eth0")
+		require.NoError(t, err)
+		vmMAC = peer.Attrs().HardwareAddr.String()
+		require.NoError(t, netlink.LinkSetNsFd(peer, int(vm)))
+
+		require.NoError(t, setAccessVlan("vm0", "", 10, vmMAC))
+	})
+
+	var client, server *net.UDPConn
+	nstest.Run(t, vm, func() {
+		link, err := netlink.LinkByName("eth0")
+		require.NoError(t, err)
+		require.NoError(t, netlink.LinkSetUp(link))
+		client = listenUDP(t, "eth0", 68)
+	})
+	nstest.Run(t, node, func() {
+		server = listenUDP(t, "br-test", 67)
+	})
+
+	exchange(t, client, server, &net.UDPAddr{IP: net.IPv4bcast, Port: 67}, "discover")
+	exchange(t, server, client, &net.UDPAddr{IP: net.IPv4bcast, Port: 68}, "offer")
+
+	nstest.Run(t, vm, func() {
+		link, err := netlink.LinkByName("eth0")
+		require.NoError(t, err)
+		addr, err := netlink.ParseAddr("10.98.0.2/24")
+		require.NoError(t, err)
+		require.NoError(t, netlink.AddrAdd(link, addr))
+	})
+	src := exchange(t, client, server, &net.UDPAddr{IP: net.ParseIP("10.98.0.1"), Port: 67}, "renew")
+	assert.Equal(t, "10.98.0.2", src.IP.String())
+	exchange(t, server, client, src, "ack")
+}
@@ -0,0 +1,14 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateVlanID(t *testing.T) {
+	assert.Error(t, ValidateVlanID(0))
+	assert.NoError(t, ValidateVlanID(1))
+	assert.NoError(t, ValidateVlanID(MaxVlanID))
+	assert.Error(t, ValidateVlanID(MaxVlanID+1))
+}
@@ -15,6 +15,10 @@ type LinuxConfig struct {
 	StartIP   net.IP
 	EndIP     net.IP
 	Subnet    *net.IPNet
+	// IPv6 settings; nil unless the network is dual-stack.
+	BridgeIP6  net.IP
+	GatewayIP6 net.IP
+	Subnet6    *net.IPNet
 }
 
 func NewLinuxConfig(settings *settingsv1.LinuxSettings) (*LinuxConfig, error) {
@@ -58,12 +62,53 @@ func NewLinuxConfig(settings *settingsv1.LinuxSettings) (*LinuxConfig, error) {
 		return nil, fmt.Errorf("dhcp_end (%s) and network (%s) subnets are not same", endNet.String(), hostNet.String())
 	}
 
-	return &LinuxConfig{
+	config := &LinuxConfig{
 		BridgeIP:  bridgeIP,
 		GatewayIP: hostIP,
 		Subnet:    hostNet,
 		Name:      settings.Network.Name,
 		StartIP:   startIP,
 		EndIP:     endIP,
-	}, nil
+	}
+
+	if settings.Network.GatewayIp6 != "" || settings.Network.BridgeIp6 != "" {
+		if ipv6Err := config.parseIPv6(settings.Network); ipv6Err != nil {
+			return nil, ipv6Err
+		}
+	}
+
+	return config, nil
+}
+
+func (c *LinuxConfig) parseIPv6(network *settingsv1.Network) error {
+	hostIP, hostNet, hostErr := net.ParseCIDR(network.GatewayIp6)
+	if hostErr != nil {
+		return fmt.Errorf("failed to parse gateway_ip6 %s: %w", network.GatewayIp6, hostErr)
+	}
+
+	bridgeIP, bridgeNet, bridgeErr := net.ParseCIDR(network.BridgeIp6)
+	if bridgeErr != nil {
+		return fmt.Errorf("failed to parse bridge_ip6 %s: %w", network.BridgeIp6, bridgeErr)
+	}
+
+	if hostIP.To4() != nil || bridgeIP.To4() != nil {
+		return errors.New("gateway_ip6 and bridge_ip6 must be IPv6 addresses")
+	}
+
+	if ones, _ := hostNet.Mask.Size(); ones != 64 {
+		return fmt.Errorf("gateway_ip6 prefix (%s) must be a /64 for SLAAC", hostNet.String())
+	}
+
+	if bridgeNet.String() != hostNet.String() {
+		return fmt.Errorf("bridge (%s) and host (%s) IPv6 prefixes are not same", bridgeNet.String(), hostNet.String())
+	}
+
+	if bridgeIP.Equal(hostIP) {
+		return fmt.Errorf("bridge_ip6 (%s) cannot be same as gateway_ip6 (%s)", bridgeIP.String(), hostIP.String())
+	}
+
+	c.GatewayIP6 = hostIP
+	c.BridgeIP6 = bridgeIP
+	c.Subnet6 = hostNet
+	return nil
 }
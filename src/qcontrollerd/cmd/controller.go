@@ -4,14 +4,19 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"os"
+	"path/filepath"
 
 	controllerv1 "github.com/q-controller/qcontroller/src/generated/services/controller/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
 	"github.com/q-controller/qcontroller/src/pkg/events"
 	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
+	"github.com/q-controller/qcontroller/src/pkg/pki"
 	"github.com/q-controller/qcontroller/src/pkg/protos"
 	"github.com/q-controller/qcontroller/src/pkg/utils"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
 )
 
 var controllerCmd = &cobra.Command{
@@ -34,17 +39,35 @@ var controllerCmd = &cobra.Command{
 			return fmt.Errorf("failed to listen: %w", lisErr)
 		}
 
-		s, sErr := grpcutil.NewServer(grpcutil.WithTLS(config.Tls))
-		if sErr != nil {
-			return fmt.Errorf("failed to create grpc server: %w", sErr)
-		}
-
 		eventPublisher, eventPublisherErr := events.NewEventPublisher(cmd.Context(), config.EventsEndpoint, config.EventsTls)
 		if eventPublisherErr != nil {
 			return fmt.Errorf("failed to create event publisher: %w", eventPublisherErr)
 		}
 
-		contr, contrErr := protos.NewController(config, eventPublisher)
+		if mkdirErr := os.MkdirAll(filepath.Join(config.Root, "db"), 0700); mkdirErr != nil {
+			return fmt.Errorf("failed to create state dir: %w", mkdirErr)
+		}
+		auditLog, auditErr := audit.Open(filepath.Join(config.Root, "db", "audit.db"),
+			audit.FromConfig(config.Audit, func(record *settingsv1.AuditRecord) {
+				if err := eventPublisher.PublishAudit(record); err != nil {
+					slog.Warn("Failed to publish audit event", "error", err)
+				}
+			})...)
+		if auditErr != nil {
+			return fmt.Errorf("failed to open audit log: %w", auditErr)
+		}
+		defer func() { _ = auditLog.Close() }()
+
+		serverTLS, tlsErr := controllerTLS(config.Tls)
+		if tlsErr != nil {
+			return tlsErr
+		}
+		s, sErr := grpcutil.NewServer(grpcutil.WithTLS(serverTLS), grpcutil.WithAudit(auditLog))
+		if sErr != nil {
+			return fmt.Errorf("failed to create grpc server: %w", sErr)
+		}
+
+		contr, contrErr := protos.NewController(config, eventPublisher, auditLog)
 		if contrErr != nil {
 			return fmt.Errorf("failed to create server %w", contrErr)
 		}
@@ -57,6 +80,33 @@ var controllerCmd = &cobra.Command{
 	},
 }
 
+// controllerTLS returns the controller's server TLS config. Callers pass
+// along the user they act for, so on nodes whose certificates come from the
+// orchestrator's built-in CA, only the orchestrator, which checks that
+// user's access, may call unless the config says otherwise. Other nodes
+// keep accepting any certificate their CA issued, as before allowedClients
+// existed.
+func controllerTLS(cfg *settingsv1.TLSConfig) (*settingsv1.TLSConfig, error) {
+	if cfg == nil || len(cfg.AllowedClients) > 0 {
+		return cfg, nil
+	}
+	caPEM, readErr := os.ReadFile(cfg.Ca)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read CA cert: %w", readErr)
+	}
+	builtin, builtinErr := pki.IsBuiltinCA(caPEM)
+	if builtinErr != nil {
+		return nil, fmt.Errorf("CA cert %s: %w", cfg.Ca, builtinErr)
+	}
+	if !builtin {
+		slog.Warn("Controller accepts any client certificate from its CA; set tls.allowedClients to limit it to the orchestrator")
+		return cfg, nil
+	}
+	cfg = proto.CloneOf(cfg)
+	cfg.AllowedClients = []string{pki.OrchestratorName}
+	return cfg, nil
+}
+
 func init() {
 	rootCmd.AddCommand(controllerCmd)
 
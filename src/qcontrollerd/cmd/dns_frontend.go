@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	dnsresolver "github.com/q-controller/network-utils/src/utils/network/dns"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/dns"
+)
+
+// dnsUpstreamPort is where the failover forwarder listens. Port 53 on the
+// same address belongs to the records front-end, which forwards to it.
+const dnsUpstreamPort = 10053
+
+// recordsFrontend wraps a failover forwarder listening on dnsUpstreamPort
+// with a dns.Server on port 53 that answers user-defined records and
+// forwards everything else to the forwarder.
+type recordsFrontend struct {
+	dnsresolver.DNSForwarder
+	server *dns.Server
+}
+
+func newRecordsFrontend(forwarder dnsresolver.DNSForwarder, listenIP string, table *dns.Table) *recordsFrontend {
+	return &recordsFrontend{
+		DNSForwarder: forwarder,
+		server: dns.NewServer(
+			net.JoinHostPort(listenIP, "53"),
+			upstreamAddress(listenIP),
+			table,
+			2*time.Second,
+		),
+	}
+}
+
+func upstreamAddress(listenIP string) string {
+	return net.JoinHostPort(listenIP, strconv.Itoa(dnsUpstreamPort))
+}
+
+func (f *recordsFrontend) Serve() (func(), error) {
+	stopForwarder, forwarderErr := f.DNSForwarder.Serve()
+	if forwarderErr != nil {
+		return nil, forwarderErr
+	}
+	stopServer, serverErr := f.server.Serve()
+	if serverErr != nil {
+		stopForwarder()
+		return nil, serverErr
+	}
+	return func() {
+		stopServer()
+		stopForwarder()
+	}, nil
+}
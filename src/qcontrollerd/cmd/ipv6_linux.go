@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/ndp"
+	"github.com/vishvananda/netlink"
+)
+
+const ipv6ForwardingSysctl = "/proc/sys/net/ipv6/conf/all/forwarding"
+
+// addIPv6Address assigns addr (in prefix) to the named link without
+// duplicate address detection, so it's usable immediately.
+func addIPv6Address(linkName string, addr net.IP, prefix *net.IPNet) error {
+	link, linkErr := netlink.LinkByName(linkName)
+	if linkErr != nil {
+		return fmt.Errorf("failed to find link %s: %w", linkName, linkErr)
+	}
+	if err := netlink.AddrReplace(link, &netlink.Addr{
+		IPNet: &net.IPNet{IP: addr, Mask: prefix.Mask},
+		Flags: syscall.IFA_F_NODAD,
+	}); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", addr, linkName, err)
+	}
+	return nil
+}
+
+// startIPv6Gateway makes the host side of the VM network an IPv6 router:
+// it assigns the IPv6 gateway address, enables forwarding and sends router
+// advertisements so VMs configure addresses via SLAAC. The host interface
+// is created during network setup, so it's looked up by its IPv4 gateway
+// address once it exists. Runs until done is closed.
+func startIPv6Gateway(config *LinuxConfig, done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var iface *net.Interface
+		for iface == nil {
+			found, findErr := interfaceWithIP(config.GatewayIP)
+			if findErr == nil {
+				iface = found
+				break
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+
+		if err := addIPv6Address(iface.Name, config.GatewayIP6, config.Subnet6); err != nil {
+			slog.Error("Failed to configure IPv6 gateway", "error", err)
+			return
+		}
+		if err := os.WriteFile(ipv6ForwardingSysctl, []byte("1"), 0644); err != nil { //nolint:gosec // G306: procfs
+			slog.Warn("Failed to enable IPv6 forwarding", "error", err)
+		}
+
+		advertiser, advertiserErr := ndp.NewAdvertiser(iface, ndp.RouterAdvertisement{Prefix: config.Subnet6}, ndp.DefaultInterval)
+		if advertiserErr != nil {
+			slog.Error("Failed to start router advertisements", "interface", iface.Name, "error", advertiserErr)
+			return
+		}
+		advertiser.Start()
+		<-done
+		advertiser.Stop()
+	}()
+}
+
+// interfaceWithIP returns the interface holding addr.
+func interfaceWithIP(addr net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		addrs, addrsErr := iface.Addrs()
+		if addrsErr != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr) {
+				return &iface, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface has address %s", addr)
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/pki"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var joinOpts struct {
+	orchestrator         string
+	token                string
+	caHash               string
+	orchestratorCA       string
+	out                  string
+	name                 string
+	endpoint             string
+	fileRegistryEndpoint string
+	eventsEndpoint       string
+	overlayAddress       string
+	labels               map[string]string
+}
+
+// joinService is a node service a certificate is issued for.
+type joinService struct {
+	name string
+	key  []byte
+	csr  []byte
+	cert string
+}
+
+var joinCmd = &cobra.Command{
+	Use:   "join",
+	Short: "Enrolls this node with an orchestrator using a join token",
+	Long: `Enrolls this node with an orchestrator using a join token.
+
+Keys for the node's controller, file registry and event service, and for the
+client the node's services call each other with, are generated locally; the
+orchestrator signs them with its built-in CA and registers the node. The keys
+and certificates are written to --out, and the TLS settings to add to each
+service's config are printed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		node := &settingsv1.Node{
+			Name:                 joinOpts.name,
+			Endpoint:             joinOpts.endpoint,
+			FileRegistryEndpoint: joinOpts.fileRegistryEndpoint,
+			EventsEndpoint:       joinOpts.eventsEndpoint,
+			OverlayAddress:       joinOpts.overlayAddress,
+			Labels:               joinOpts.labels,
+		}
+
+		services := []*joinService{{name: "controller"}, {name: "fileregistry"}, {name: "eventservice"}, {name: "client"}}
+		for _, svc := range services {
+			key, keyPEM, keyErr := pki.GenerateKey()
+			if keyErr != nil {
+				return keyErr
+			}
+			csr, csrErr := pki.CreateCSR(key, node.Name+"-"+svc.name)
+			if csrErr != nil {
+				return csrErr
+			}
+			svc.key, svc.csr = keyPEM, csr
+		}
+
+		client, clientErr := joinHTTPClient(joinOpts.orchestratorCA)
+		if clientErr != nil {
+			return clientErr
+		}
+		resp, joinErr := requestJoin(client, joinOpts.orchestrator, &orchestratorv1.JoinRequest{
+			Token:           joinOpts.token,
+			Node:            node,
+			ControllerCsr:   string(services[0].csr),
+			FileRegistryCsr: string(services[1].csr),
+			EventsCsr:       string(services[2].csr),
+			ClientCsr:       string(services[3].csr),
+		})
+		if joinErr != nil {
+			return joinErr
+		}
+		services[0].cert, services[1].cert, services[2].cert, services[3].cert = resp.ControllerCert, resp.FileRegistryCert, resp.EventsCert, resp.ClientCert
+
+		if joinOpts.caHash != "" {
+			got, hashErr := pki.Hash([]byte(resp.CaCert))
+			if hashErr != nil {
+				return fmt.Errorf("invalid CA certificate: %w", hashErr)
+			}
+			if got != joinOpts.caHash {
+				return fmt.Errorf("CA hash %s doesn't match the expected %s", got, joinOpts.caHash)
+			}
+		}
+
+		dir, absErr := filepath.Abs(joinOpts.out)
+		if absErr != nil {
+			return absErr
+		}
+		if mkdirErr := os.MkdirAll(dir, 0o700); mkdirErr != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, mkdirErr)
+		}
+		caPath := filepath.Join(dir, "ca.pem")
+		if writeErr := os.WriteFile(caPath, []byte(resp.CaCert), 0o644); writeErr != nil {
+			return fmt.Errorf("failed to write CA cert: %w", writeErr)
+		}
+		paths := make(map[string]*settingsv1.TLSConfig, len(services))
+		for _, svc := range services {
+			cfg := &settingsv1.TLSConfig{
+				Ca:   caPath,
+				Cert: filepath.Join(dir, svc.name+".pem"),
+				Key:  filepath.Join(dir, svc.name+"-key.pem"),
+			}
+			if writeErr := os.WriteFile(cfg.Key, svc.key, 0o600); writeErr != nil {
+				return fmt.Errorf("failed to write %s key: %w", svc.name, writeErr)
+			}
+			if writeErr := os.WriteFile(cfg.Cert, []byte(svc.cert), 0o644); writeErr != nil {
+				return fmt.Errorf("failed to write %s cert: %w", svc.name, writeErr)
+			}
+			paths[svc.name] = cfg
+		}
+
+		// Servers only accept the clients that call them: the controller
+		// the orchestrator, the qemu service the node's own client, and the
+		// file registry and event service both.
+		nodeClient := node.Name + "-client"
+		controller, controllerErr := tlsBlock(paths["controller"], pki.OrchestratorName)
+		qemu, qemuErr := tlsBlock(paths["controller"], nodeClient)
+		fileRegistry, fileRegistryErr := tlsBlock(paths["fileregistry"], pki.OrchestratorName, nodeClient)
+		eventService, eventServiceErr := tlsBlock(paths["eventservice"], pki.OrchestratorName, nodeClient)
+		clientBlock, clientErr := tlsBlock(paths["client"])
+		if err := errors.Join(controllerErr, qemuErr, fileRegistryErr, eventServiceErr, clientErr); err != nil {
+			return err
+		}
+
+		fmt.Printf("Node %s joined. Certificates were written to %s.\n\n", node.Name, dir)
+		fmt.Printf("Add to the controller config:\n  \"tls\": %s,\n  \"qemuTls\": %s,\n  \"eventsTls\": %[2]s\n\n", controller, clientBlock)
+		// The QEMU service runs alongside the controller and shares its
+		// certificate.
+		fmt.Printf("Add to the qemu config:\n  \"tls\": %s,\n  \"fileRegistryTls\": %s\n\n", qemu, clientBlock)
+		fmt.Printf("Add to the fileregistry config:\n  \"tls\": %s,\n  \"eventsTls\": %s\n\n", fileRegistry, clientBlock)
+		fmt.Printf("Add to the eventservice config:\n  \"tls\": %s\n", eventService)
+		return nil
+	},
+}
+
+// tlsBlock renders cfg as a config's JSON TLS block, accepting the allowed
+// clients.
+func tlsBlock(cfg *settingsv1.TLSConfig, allowed ...string) (string, error) {
+	block := map[string]any{"ca": cfg.Ca, "cert": cfg.Cert, "key": cfg.Key}
+	if len(allowed) > 0 {
+		block["allowedClients"] = allowed
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// joinHTTPClient trusts the PEM CA file caPath for the orchestrator's HTTPS
+// certificate, or the system roots if it's empty.
+func joinHTTPClient(caPath string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caPath == "" {
+		return client, nil
+	}
+	caBytes, readErr := os.ReadFile(caPath)
+	if readErr != nil {
+		return nil, fmt.Errorf("read orchestrator CA %s: %w", caPath, readErr)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("failed to parse orchestrator CA %s", caPath)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	return client, nil
+}
+
+func requestJoin(client *http.Client, orchestratorURL string, req *orchestratorv1.JoinRequest) (*orchestratorv1.JoinResponse, error) {
+	body, marshalErr := protojson.Marshal(req)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	httpReq, reqErr := http.NewRequest(http.MethodPost, strings.TrimSuffix(orchestratorURL, "/")+"/v1/nodes:join", bytes.NewReader(body))
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	// Satisfies the orchestrator's CSRF check; the token authenticates.
+	httpReq.Header.Set("X-Requested-With", "qcontrollerd")
+
+	httpResp, doErr := client.Do(httpReq)
+	if doErr != nil {
+		return nil, fmt.Errorf("failed to reach orchestrator: %w", doErr)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	respBody, readErr := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read orchestrator response: %w", readErr)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var rpcErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &rpcErr) == nil && rpcErr.Message != "" {
+			return nil, fmt.Errorf("join failed: %s", rpcErr.Message)
+		}
+		return nil, fmt.Errorf("join failed: %s", httpResp.Status)
+	}
+	resp := &orchestratorv1.JoinResponse{}
+	if unmarshalErr := protojson.Unmarshal(respBody, resp); unmarshalErr != nil {
+		return nil, fmt.Errorf("invalid orchestrator response: %w", unmarshalErr)
+	}
+	return resp, nil
+}
+
+func init() {
+	rootCmd.AddCommand(joinCmd)
+
+	flags := joinCmd.Flags()
+	flags.StringVar(&joinOpts.orchestrator, "orchestrator", "", "Orchestrator URL, e.g. https://orchestrator:8080")
+	flags.StringVar(&joinOpts.token, "token", "", "Join token created on the orchestrator")
+	flags.StringVar(&joinOpts.caHash, "ca-hash", "", "Expected hash of the orchestrator's CA (sha256:...), as returned with the token")
+	flags.StringVar(&joinOpts.orchestratorCA, "orchestrator-ca", "", "PEM CA file to verify the orchestrator's HTTPS certificate with; defaults to the system roots")
+	flags.StringVar(&joinOpts.name, "name", "", "Name to register the node under")
+	flags.StringVar(&joinOpts.endpoint, "endpoint", "", "Controller endpoint the orchestrator reaches the node at (host:port)")
+	flags.StringVar(&joinOpts.fileRegistryEndpoint, "file-registry-endpoint", "", "File registry endpoint (host:port)")
+	flags.StringVar(&joinOpts.eventsEndpoint, "events-endpoint", "", "Event service endpoint (host:port)")
+	flags.StringVar(&joinOpts.overlayAddress, "overlay-address", "", "Underlay address for overlay traffic; defaults to the endpoint's host")
+	flags.StringToStringVar(&joinOpts.labels, "label", nil, "Node label used by placement rules, as key=value; may be repeated")
+	flags.StringVar(&joinOpts.out, "out", "certs", "Directory to write keys and certificates to")
+	for _, name := range []string{"orchestrator", "token", "name", "endpoint", "file-registry-endpoint", "events-endpoint"} {
+		if err := joinCmd.MarkFlagRequired(name); err != nil {
+			panic(fmt.Errorf("failed to mark flag `%s` as required: %w", name, err))
+		}
+	}
+}
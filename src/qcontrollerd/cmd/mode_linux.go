@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network"
+)
+
+// applyNetworkMode installs the firewall rules for the VM network's mode on
+// the host interface holding gatewayIP.
+func applyNetworkMode(name string, mode settingsv1.Network_Mode, gatewayIP net.IP) error {
+	iface, ifaceErr := interfaceWithIP(gatewayIP)
+	if ifaceErr != nil {
+		return ifaceErr
+	}
+	if err := network.ApplyMode(name, mode, iface.Name); err != nil {
+		return fmt.Errorf("failed to apply network mode %s: %w", mode, err)
+	}
+	return nil
+}
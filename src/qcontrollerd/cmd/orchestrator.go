@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
@@ -14,14 +16,19 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	authv1 "github.com/q-controller/qcontroller/src/generated/services/auth/v1"
+	eventv1 "github.com/q-controller/qcontroller/src/generated/services/event/v1"
 	fileregistryv1 "github.com/q-controller/qcontroller/src/generated/services/fileregistry/v1"
 	orchestratorv1 "github.com/q-controller/qcontroller/src/generated/services/orchestrator/v1"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
+	"github.com/q-controller/qcontroller/src/pkg/audit"
 	"github.com/q-controller/qcontroller/src/pkg/auth"
 	"github.com/q-controller/qcontroller/src/pkg/frontend"
 	"github.com/q-controller/qcontroller/src/pkg/grpcutil"
 	"github.com/q-controller/qcontroller/src/pkg/images"
 	"github.com/q-controller/qcontroller/src/pkg/orchestrator"
+	orchestratorDb "github.com/q-controller/qcontroller/src/pkg/orchestrator/db"
+	"github.com/q-controller/qcontroller/src/pkg/pki"
+	"github.com/q-controller/qcontroller/src/pkg/rbac"
 	"github.com/q-controller/qcontroller/src/pkg/utils"
 	qUtils "github.com/q-controller/qcontroller/src/qcontrollerd/cmd/utils"
 	"github.com/spf13/cobra"
@@ -44,10 +51,16 @@ var orchestratorCmd = &cobra.Command{
 		}
 		slog.Debug("Read config", "config", config)
 
+		policy, policyErr := rbac.NewPolicy(config.RoleBindings, config.Projects)
+		if policyErr != nil {
+			return fmt.Errorf("invalid access config: %w", policyErr)
+		}
+
 		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 		defer stop()
 
-		// Broadcaster: node subscribers write events, WebSocket clients read them.
+		// Broadcaster: the orchestrator relays node events into it, WebSocket
+		// clients read them.
 		bc := orchestrator.NewBroadcaster(100)
 		go bc.Run(ctx)
 
@@ -63,18 +76,58 @@ var orchestratorCmd = &cobra.Command{
 			return fmt.Errorf("failed to create image client: %w", imgErr)
 		}
 
+		root := config.Root
+		if root == "" {
+			root = filepath.Dir(configPath)
+		}
+		if mkdirErr := os.MkdirAll(filepath.Join(root, "db"), 0700); mkdirErr != nil {
+			return fmt.Errorf("failed to create state dir: %w", mkdirErr)
+		}
+		state, stateErr := orchestratorDb.NewDatabase(filepath.Join(root, "db", "orchestrator.db"))
+		if stateErr != nil {
+			return fmt.Errorf("failed to open orchestrator state: %w", stateErr)
+		}
+
+		auditLog, auditErr := audit.Open(filepath.Join(root, "db", "audit.db"),
+			audit.FromConfig(config.Audit, func(record *settingsv1.AuditRecord) {
+				bc.Send(&orchestratorv1.Event{Update: &eventv1.Update{
+					Timestamp: record.Time,
+					Payload:   &eventv1.Update_AuditEvent{AuditEvent: &eventv1.AuditEvent{Record: record}},
+				}})
+			})...)
+		if auditErr != nil {
+			return fmt.Errorf("failed to open audit log: %w", auditErr)
+		}
+		defer func() { _ = auditLog.Close() }()
+
+		// Built-in CA: issues certificates to nodes joining with a token, and
+		// the client certificate the orchestrator reaches them with.
+		ca, caErr := pki.LoadOrCreateCA(filepath.Join(root, "ca"))
+		if caErr != nil {
+			return fmt.Errorf("failed to load CA: %w", caErr)
+		}
+		clientTLS, clientTLSErr := ca.ClientTLS(pki.OrchestratorName)
+		if clientTLSErr != nil {
+			return fmt.Errorf("failed to issue orchestrator certificate: %w", clientTLSErr)
+		}
+		ownHosts, hostsErr := orchestratorHosts(config)
+		if hostsErr != nil {
+			return hostsErr
+		}
+
 		// Create OrchestratorService server.
-		orchServer, orchErr := orchestrator.NewServer(config.Nodes, imageClient, bc)
+		orchServer, orchErr := orchestrator.NewServer(config.Nodes, imageClient, bc, state, config.DnsZone,
+			orchestrator.WithMACPrefix(config.MacPrefix),
+			orchestrator.WithCA(ca, clientTLS, ownHosts),
+			orchestrator.WithQuotas(config.Quotas),
+			orchestrator.WithPolicy(policy),
+			orchestrator.WithAudit(auditLog),
+		)
 		if orchErr != nil {
 			return fmt.Errorf("failed to create orchestrator server: %w", orchErr)
 		}
 		defer orchServer.Close()
 
-		// Subscribe to each node's EventService.
-		for _, n := range config.Nodes {
-			go subscribeToNodeEvents(ctx, n, bc)
-		}
-
 		// gRPC-gateway: REST API (in-process, no network hop). The
 		// ForwardSetCookie option lets handlers (currently AuthService.Logout)
 		// set/clear browser cookies via grpc.SendHeader.
@@ -110,12 +163,13 @@ var orchestratorCmd = &cobra.Command{
 		}
 
 		httpMux := http.NewServeMux()
-		_ = images.CreateHandler(imageClient, httpMux)
+		_ = images.CreateHandler(imageClient, httpMux, policy.Images)
 		httpMux.Handle("/", mux)
 		httpMux.HandleFunc("/ui/", frontend.Handler("/ui/"))
 
 		var verifiers []auth.Verifier
 		var oidcV *auth.OIDCVerifier
+		var tokenV *auth.TokenVerifier
 		allowedOrigin := ""
 		if config.Auth != nil && len(config.Auth.Issuers) > 0 {
 			secretPath := filepath.Join(filepath.Dir(configPath), "session.key")
@@ -129,7 +183,10 @@ var orchestratorCmd = &cobra.Command{
 				return fmt.Errorf("init oidc verifier: %w", oidcErr)
 			}
 			oidcV.RegisterRoutes(httpMux)
-			verifiers = append(verifiers, oidcV)
+			// API tokens go first: the OIDC verifier rejects any bearer
+			// token that isn't one of its JWTs.
+			tokenV = auth.NewTokenVerifier(state)
+			verifiers = append(verifiers, tokenV, oidcV)
 			// Origin is scheme+host only — strip any path/query from
 			// external_url (e.g. behind a reverse proxy at /app) so the
 			// WebSocket origin check actually matches.
@@ -137,13 +194,16 @@ var orchestratorCmd = &cobra.Command{
 				allowedOrigin = u.Scheme + "://" + u.Host
 			}
 		}
-		// AuthService (GetConfig/GetMe/Logout) goes via the gRPC-gateway, same
+		// AuthService (GetConfig/GetMe/Logout and the API tokens) goes via the gRPC-gateway, same
 		// as OrchestratorService. /auth/login and /auth/callback stay on
 		// httpMux above because they're HTTP-redirect handlers, not JSON RPCs.
-		if err := authv1.RegisterAuthServiceHandlerServer(ctx, mux, auth.NewAuthServer(oidcV)); err != nil {
+		if err := authv1.RegisterAuthServiceHandlerServer(ctx, mux, auth.NewAuthServer(oidcV, tokenV)); err != nil {
 			return fmt.Errorf("failed to register auth gateway: %w", err)
 		}
-		httpMux.HandleFunc("/ws", orchWsHandler(bc, allowedOrigin))
+		if policy.Enabled() && len(verifiers) == 0 {
+			slog.Warn("Auth is disabled, so role bindings only see anonymous callers")
+		}
+		httpMux.HandleFunc("/ws", orchWsHandler(bc, allowedOrigin, policy))
 		httpMux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 			_, _ = w.Write([]byte("ok\n"))
 		})
@@ -152,8 +212,10 @@ var orchestratorCmd = &cobra.Command{
 			http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
 		})
 
-		exempt := append(auth.PublicPaths(), "/ui/", "/ui", "/healthz")
-		var inner http.Handler = httpMux
+		// Joining nodes authenticate with their join token instead.
+		exempt := append(auth.PublicPaths(), "/ui/", "/ui", "/healthz", "/v1/nodes:join")
+		// Records the mutating requests, image uploads and removals included.
+		inner := audit.Middleware(auditLog)(httpMux)
 		if len(verifiers) > 0 {
 			inner = auth.RequireCSRFHeader(inner)
 		}
@@ -191,6 +253,39 @@ var orchestratorCmd = &cobra.Command{
 	},
 }
 
+// orchestratorHosts returns the names the orchestrator and its file
+// registry go by, which joining nodes mustn't get certificates for.
+func orchestratorHosts(config *settingsv1.OrchestratorConfig) ([]string, error) {
+	var hosts []string
+	if config.FileRegistryEndpoint != "" {
+		host, _, splitErr := net.SplitHostPort(config.FileRegistryEndpoint)
+		if splitErr != nil {
+			host = config.FileRegistryEndpoint
+		}
+		hosts = append(hosts, host)
+	}
+	if external := config.GetAuth().GetExternalUrl(); external != "" {
+		if u, parseErr := url.Parse(external); parseErr == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	if config.Tls != nil {
+		certPEM, readErr := os.ReadFile(config.Tls.Cert)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read orchestrator certificate: %w", readErr)
+		}
+		cert, parseErr := pki.ParseCertificate(certPEM)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid orchestrator certificate %s: %w", config.Tls.Cert, parseErr)
+		}
+		hosts = append(hosts, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+	}
+	return hosts, nil
+}
+
 func init() {
 	rootCmd.AddCommand(orchestratorCmd)
 
@@ -200,7 +295,8 @@ func init() {
 	}
 }
 
-func orchWsHandler(bc *orchestrator.Broadcaster, allowedOrigin string) http.HandlerFunc {
+// orchWsHandler streams the events the caller may see to WebSocket clients.
+func orchWsHandler(bc *orchestrator.Broadcaster, allowedOrigin string, policy *rbac.Policy) http.HandlerFunc {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			if allowedOrigin == "" {
@@ -235,6 +331,9 @@ func orchWsHandler(bc *orchestrator.Broadcaster, allowedOrigin string) http.Hand
 				if !ok {
 					return
 				}
+				if !policy.CanSee(auth.FromContext(r.Context()), event) {
+					continue
+				}
 				b, err := proto.Marshal(event)
 				if err != nil {
 					slog.ErrorContext(r.Context(), "Failed to marshal event", "error", err)
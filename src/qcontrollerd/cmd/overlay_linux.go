@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/q-controller/network-utils/src/utils/network/network"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/overlay"
+)
+
+// startOverlayReconciler keeps the overlay VXLAN devices in netw's
+// namespace in line with the config file the QEMU service writes under
+// root.
+func startOverlayReconciler(ctx context.Context, netw *network.Network, root string) error {
+	return overlay.WatchConfigFile(ctx, overlay.ConfigFile(root), func(cfg overlay.Config) {
+		if err := reconcileOverlays(netw, cfg); err != nil {
+			slog.Warn("Failed to reconcile overlay networks", "error", err)
+		}
+	})
+}
+
+func reconcileOverlays(netw *network.Network, cfg overlay.Config) error {
+	peers := make([]net.IP, 0, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return fmt.Errorf("invalid peer address %q", p)
+		}
+		peers = append(peers, ip)
+	}
+
+	wanted := make(map[uint32]bool, len(cfg.Networks))
+	for _, n := range cfg.Networks {
+		wanted[n.VNI] = true
+	}
+
+	var present []uint32
+	if err := netw.Execute(func() error {
+		vnis, err := overlay.ListVNIs()
+		present = vnis
+		return err
+	}); err != nil {
+		return err
+	}
+
+	existing := make(map[uint32]bool, len(present))
+	for _, vni := range present {
+		existing[vni] = true
+		if wanted[vni] {
+			continue
+		}
+		if err := netw.Execute(func() error { return overlay.Detach(vni) }); err != nil {
+			return fmt.Errorf("failed to remove overlay vni %d: %w", vni, err)
+		}
+		slog.Info("Removed overlay network", "vni", vni)
+	}
+
+	for _, n := range cfg.Networks {
+		if !existing[n.VNI] {
+			// Created in the host namespace so the VXLAN socket uses the
+			// host's underlay, then handed to the VM namespace.
+			if _, err := overlay.CreateVxlan(n.VNI); err != nil {
+				return fmt.Errorf("failed to create overlay %s: %w", n.Name, err)
+			}
+			if err := moveToNamespace(netw, overlay.VxlanName(n.VNI)); err != nil {
+				return fmt.Errorf("failed to create overlay %s: %w", n.Name, err)
+			}
+			slog.Info("Created overlay network", "name", n.Name, "vni", n.VNI)
+		}
+		if err := netw.Execute(func() error { return overlay.Attach(n.VNI, peers) }); err != nil {
+			return fmt.Errorf("failed to attach overlay %s: %w", n.Name, err)
+		}
+	}
+	return nil
+}
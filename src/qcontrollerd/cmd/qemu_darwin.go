@@ -16,6 +16,7 @@ import (
 	"github.com/q-controller/qcontroller/src/pkg/utils"
 	"github.com/q-controller/qcontroller/src/pkg/utils/network"
 	"github.com/q-controller/qcontroller/src/pkg/utils/network/arp"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/dns"
 
 	"github.com/spf13/cobra"
 )
@@ -109,10 +110,15 @@ var qemuCmd = &cobra.Command{
 		defer resolver.Close()
 
 		if dnsCfg := macosSettings.GetDns(); dnsCfg != nil {
+			table := dns.NewTable()
+			if watchErr := dns.WatchRecordsFile(cmd.Context(), dns.RecordsFile(config.Root), table); watchErr != nil {
+				return fmt.Errorf("failed to watch dns records: %w", watchErr)
+			}
+
 			var forwarder dnsresolver.DNSForwarder
 			if dnsListenIP != nil {
 				// Bridged mode: host IP is known, create forwarder directly
-				f, fErr := newDNSForwarder(cmd.Context(), dnsListenIP.String(), dnsCfg)
+				f, fErr := newDNSForwarder(cmd.Context(), dnsListenIP.String(), dnsCfg, table)
 				if fErr != nil {
 					return fmt.Errorf("failed to create dns forwarder: %w", fErr)
 				}
@@ -124,7 +130,7 @@ var qemuCmd = &cobra.Command{
 					cmd.Context(),
 					2*time.Second,
 					&subnetProber{subnet: subnet},
-					&dnsForwarderFactory{dnsCfg: dnsCfg},
+					&dnsForwarderFactory{dnsCfg: dnsCfg, table: table},
 				)
 			}
 			stop, serveErr := forwarder.Serve()
@@ -152,10 +158,11 @@ func init() {
 	}
 }
 
-// newDNSForwarder creates a DNS forwarder configured for the given address.
-func newDNSForwarder(ctx context.Context, listenAddr string, dnsCfg *settingsv1.Dns) (dnsresolver.DNSForwarder, error) {
+// newDNSForwarder creates a DNS forwarder configured for the given address,
+// fronted by the user-defined records in table.
+func newDNSForwarder(ctx context.Context, listenAddr string, dnsCfg *settingsv1.Dns, table *dns.Table) (dnsresolver.DNSForwarder, error) {
 	opts := []dnsresolver.DNSForwarderOption{
-		dnsresolver.WithForwarderAddress(listenAddr + ":53"),
+		dnsresolver.WithForwarderAddress(upstreamAddress(listenAddr)),
 		dnsresolver.WithForwarderTimeout(2 * time.Second),
 		dnsresolver.WithReusePort(),
 	}
@@ -165,7 +172,11 @@ func newDNSForwarder(ctx context.Context, listenAddr string, dnsCfg *settingsv1.
 	case *settingsv1.Dns_Static:
 		opts = append(opts, dnsresolver.WithUpstreams(v.Static.GetEndpoints()))
 	}
-	return dnsresolver.NewDNSFailoverForwarder(ctx, opts...)
+	forwarder, forwarderErr := dnsresolver.NewDNSFailoverForwarder(ctx, opts...)
+	if forwarderErr != nil {
+		return nil, forwarderErr
+	}
+	return newRecordsFrontend(forwarder, listenAddr, table), nil
 }
 
 // subnetProber implements dnsresolver.InterfaceProber by scanning for a host
@@ -182,10 +193,11 @@ func (p *subnetProber) Probe() (net.IP, error) {
 // project's DNS config.
 type dnsForwarderFactory struct {
 	dnsCfg *settingsv1.Dns
+	table  *dns.Table
 }
 
 func (f *dnsForwarderFactory) NewForwarder(ctx context.Context, addr string) (dnsresolver.DNSForwarder, error) {
-	return newDNSForwarder(ctx, addr, f.dnsCfg)
+	return newDNSForwarder(ctx, addr, f.dnsCfg, f.table)
 }
 
 // findHostIPForSubnet finds the host's IPv4 address on the interface matching the given subnet.
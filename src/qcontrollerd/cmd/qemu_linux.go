@@ -16,7 +16,11 @@ import (
 	"github.com/q-controller/network-utils/src/utils/network/network"
 	settingsv1 "github.com/q-controller/qcontroller/src/generated/settings/v1"
 	"github.com/q-controller/qcontroller/src/pkg/utils"
+	qnetwork "github.com/q-controller/qcontroller/src/pkg/utils/network"
 	"github.com/q-controller/qcontroller/src/pkg/utils/network/arp"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/dns"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/ip"
+	"github.com/q-controller/qcontroller/src/pkg/utils/network/leases"
 	"github.com/vishvananda/netlink"
 
 	dnsresolver "github.com/q-controller/network-utils/src/utils/network/dns"
@@ -69,14 +73,39 @@ var qemuCmd = &cobra.Command{
 				return fmt.Errorf("failed to create arp scanner: %w", scannerErr)
 			}
 
-			resolver, resolverErr := arp.NewResolver(
-				cmd.Context(),
-				arp.WithInterval(2*time.Second),
-				arp.WithTimeout(2*time.Second),
+			resolverOpts := []arp.ScannerOption{
+				arp.WithInterval(2 * time.Second),
+				arp.WithTimeout(2 * time.Second),
 				arp.WithScanner(scanner),
+			}
+			if linuxConfig.Subnet6 != nil {
+				// The bridge needs an address in the prefix for NDP probes.
+				if ipv6Err := addIPv6Address(linuxConfig.Name, linuxConfig.BridgeIP6, linuxConfig.Subnet6); ipv6Err != nil {
+					return fmt.Errorf("failed to configure bridge IPv6 address: %w", ipv6Err)
+				}
+				resolverOpts = append(resolverOpts, arp.WithIPv6Prefix(linuxConfig.Subnet6))
+			}
+
+			neighResolver, neighResolverErr := arp.NewNeighborResolver(
+				cmd.Context(),
+				linuxConfig.Name,
+				linuxConfig.Subnet,
+				resolverOpts...,
 			)
-			if resolverErr != nil {
-				return fmt.Errorf("failed to create arp resolver: %w", resolverErr)
+			if neighResolverErr != nil {
+				return fmt.Errorf("failed to create neighbor resolver: %w", neighResolverErr)
+			}
+
+			// DHCP leases are the primary source; the neighbor table covers
+			// static addresses, expired leases and IPv6.
+			var resolver ip.AddressResolver = neighResolver
+			if leaseFile := config.GetLinuxSettings().Network.Dhcp.GetLeaseFile(); leaseFile != "" {
+				leaseResolver, leaseResolverErr := leases.NewResolver(cmd.Context(), leaseFile, neighResolver)
+				if leaseResolverErr != nil {
+					neighResolver.Close()
+					return fmt.Errorf("failed to create lease resolver: %w", leaseResolverErr)
+				}
+				resolver = leaseResolver
 			}
 			defer resolver.Close()
 
@@ -111,7 +140,7 @@ var qemuCmd = &cobra.Command{
 
 			if dnsCfg := config.GetLinuxSettings().Network.Dns; dnsCfg != nil {
 				opts := []dnsresolver.DNSForwarderOption{
-					dnsresolver.WithForwarderAddress(ip.String() + ":53"),
+					dnsresolver.WithForwarderAddress(upstreamAddress(ip.String())),
 					dnsresolver.WithForwarderTimeout(2 * time.Second),
 				}
 				switch v := dnsCfg.Upstream.(type) {
@@ -124,13 +153,34 @@ var qemuCmd = &cobra.Command{
 				if forwarderErr != nil {
 					return fmt.Errorf("failed to create dns forwarder: %w", forwarderErr)
 				}
-				stop, serveErr := forwarder.Serve()
+
+				// User-defined records are written by the QEMU service
+				// inside the namespace and picked up here.
+				table := dns.NewTable()
+				if watchErr := dns.WatchRecordsFile(cmd.Context(), dns.RecordsFile(config.Root), table); watchErr != nil {
+					return fmt.Errorf("failed to watch dns records: %w", watchErr)
+				}
+				stop, serveErr := newRecordsFrontend(forwarder, ip.String(), table).Serve()
 				if serveErr != nil {
 					return fmt.Errorf("failed to start dns forwarder: %w", serveErr)
 				}
 				defer stop()
 			}
 
+			if linuxConfig.Subnet6 != nil {
+				startIPv6Gateway(linuxConfig, done)
+			}
+
+			// Non-NAT modes must hold before any VM starts. In NAT mode this
+			// only clears rules left by an earlier mode.
+			mode := config.GetLinuxSettings().Network.GetMode()
+			if modeErr := applyNetworkMode(linuxConfig.Name, mode, linuxConfig.GatewayIP); modeErr != nil {
+				if !qnetwork.IsNAT(mode) {
+					return modeErr
+				}
+				slog.Warn("Failed to clear network mode rules", "error", modeErr)
+			}
+
 			subscription, subscribeErr := ifc.SubscribeDefaultInterfaceChanges()
 			if subscribeErr != nil {
 				return fmt.Errorf("failed to subscribe to default interface changes: %w", subscribeErr)
@@ -141,7 +191,9 @@ var qemuCmd = &cobra.Command{
 			// can flush the standard FORWARD/POSTROUTING chains on reload,
 			// removing our rules. A single goroutine owns the iface state
 			// and re-applies on default-iface changes, link changes, and a
-			// periodic safety tick. netw.Connect is idempotent.
+			// periodic safety tick. netw.Connect and applyNetworkMode are
+			// idempotent. Only NAT mode connects the bridge to the default
+			// interface; the other modes re-apply their own rules.
 			go func() {
 				linkCh := make(chan netlink.LinkUpdate, 16)
 				linkDone := make(chan struct{})
@@ -183,6 +235,12 @@ var qemuCmd = &cobra.Command{
 						}
 					case <-ticker.C:
 					}
+					if !qnetwork.IsNAT(mode) {
+						if err := applyNetworkMode(linuxConfig.Name, mode, linuxConfig.GatewayIP); err != nil {
+							slog.Warn("Apply network mode failed", "mode", mode, "error", err)
+						}
+						continue
+					}
 					if iface == "" {
 						continue
 					}
@@ -192,6 +250,16 @@ var qemuCmd = &cobra.Command{
 				}
 			}()
 
+			if trunk := config.GetLinuxSettings().Network.TrunkInterface; trunk != "" {
+				if trunkErr := moveToNamespace(netw, trunk); trunkErr != nil {
+					return fmt.Errorf("failed to set up trunk interface: %w", trunkErr)
+				}
+			}
+
+			if overlayErr := startOverlayReconciler(cmd.Context(), netw, config.Root); overlayErr != nil {
+				return fmt.Errorf("failed to watch overlay networks: %w", overlayErr)
+			}
+
 			args := append([]string(nil), os.Args[1:]...) // clone without program name
 			args = append(args, "--in-namespace", linuxConfig.Name)
 
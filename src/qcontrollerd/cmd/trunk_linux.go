@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/q-controller/network-utils/src/utils/network/network"
+	"github.com/vishvananda/netlink"
+)
+
+// moveToNamespace moves the host interface ifname into netw's namespace so
+// the QEMU service can enslave it to the VM bridge. The namespace is
+// referenced by a handle opened from inside it.
+func moveToNamespace(netw *network.Network, ifname string) error {
+	link, linkErr := netlink.LinkByName(ifname)
+	if linkErr != nil {
+		return fmt.Errorf("failed to find interface %s: %w", ifname, linkErr)
+	}
+
+	var ns *os.File
+	if execErr := netw.Execute(func() error {
+		f, openErr := os.Open("/proc/thread-self/ns/net")
+		if openErr != nil {
+			return fmt.Errorf("failed to open network namespace: %w", openErr)
+		}
+		ns = f
+		return nil
+	}); execErr != nil {
+		return execErr
+	}
+	defer func() { _ = ns.Close() }()
+
+	if err := netlink.LinkSetNsFd(link, int(ns.Fd())); err != nil { //nolint:gosec // G115: fd fits in int
+		return fmt.Errorf("failed to move %s into namespace: %w", ifname, err)
+	}
+	return nil
+}
@@ -176,7 +176,7 @@ if [[ "${USE_CERTS}" == "true" ]]; then
     mkdir -p "${CERTDIR}"
     openssl genrsa -out "${CERTDIR}/ca-key.pem" 4096 2>/dev/null
     openssl req -new -x509 -days 365 -key "${CERTDIR}/ca-key.pem" \
-        -out "${CERTDIR}/ca.pem" -subj "/CN=qcontroller-ca" 2>/dev/null
+        -out "${CERTDIR}/ca.pem" -subj "/CN=qcontroller-dev-ca" 2>/dev/null
 
     # SANs match the address each server is actually dialed at.
     if [[ "$OS_TYPE" == "Linux" ]]; then